@@ -0,0 +1,507 @@
+// internal/addpatch.go
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// ADD_EDIT_FILE holds a hunk being edited by hand during add -p
+const ADD_EDIT_FILE = "ADD_EDIT.patch"
+
+// patchContext is the number of unchanged lines shown around each hunk, the
+// same as diff uses
+const patchContext = 2
+
+// patchLine is one line of a hunk: ' ' for context, '-' for a line only in
+// the index and '+' for a line only in the working tree. Text keeps its line
+// ending.
+type patchLine struct {
+	op   byte
+	text string
+}
+
+// patchHunk is a change between the index and the working tree. The
+// positions are 0-based offsets into the index and working tree versions.
+type patchHunk struct {
+	oldStart int
+	newStart int
+	lines    []patchLine
+	staged   bool
+}
+
+// patchHelp explains the answers to the add -p prompt
+const patchHelp = `y - stage this hunk
+n - do not stage this hunk
+q - quit; do not stage this hunk or any of the remaining ones
+a - stage this hunk and all later hunks in the file
+d - do not stage this hunk or any of the later hunks in the file
+s - split the current hunk into smaller hunks
+e - manually edit the current hunk
+? - print help`
+
+// AddPatch interactively stages parts of the changes to tracked files under
+// the given paths, or all tracked files when none are given. Each hunk of the
+// difference between the index and the working tree is shown and answered
+// from in, one line per answer. The index gets a blob holding just the
+// accepted hunks; the working tree is left alone.
+func AddPatch(paths []string, in io.Reader) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+
+	var prefixes []string
+	for _, path := range paths {
+		rel, err := repoRelative(repo, path)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, rel)
+	}
+
+	filters, err := loadWorkingFilters(repo)
+	if err != nil {
+		return err
+	}
+
+	answers := bufio.NewReader(in)
+	changed := false
+	for i := 0; i < len(index); i++ {
+		entry := index[i]
+		if !matchesPathspec(entry.FilePath, prefixes) {
+			continue
+		}
+
+		staged, quit, err := patchFile(repo, filters, entry, answers)
+		if err != nil {
+			return err
+		}
+		if staged != nil {
+			changed = true
+			index = stageEntry(index, entry.FilePath, staged.Hash, staged.FileMode)
+		}
+		if quit {
+			break
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return SaveIndex(index)
+}
+
+// matchesPathspec reports whether path is one of prefixes or inside one of
+// them; no prefixes match everything
+func matchesPathspec(path string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if prefix == "." || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// patchFile walks through the hunks of one file. It returns the entry to
+// stage, or nil when nothing was accepted, and whether the user quit.
+func patchFile(repo *Repository, filters *workingFilters, entry IndexEntry, answers *bufio.Reader) (*IndexEntry, bool, error) {
+	working, info, err := readWorkingBlob(filters, entry.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil // deletions are staged with add
+		}
+		return nil, false, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil, false, nil // a link target has no hunks to pick from
+	}
+	if _, large := parseLFSPointer(working); large {
+		return nil, false, nil // nor has a large file; stage it with add
+	}
+	hash := CalculateHash(string(working))
+	newMode := fileModeOf(info)
+	if hash == entry.Hash && newMode == entryMode(entry) {
+		return nil, false, nil
+	}
+
+	stagedEntry := entry
+	modeStaged := false
+	fmt.Printf("--- a/%s\n+++ b/%s\n", entry.FilePath, entry.FilePath)
+
+	if newMode != entryMode(entry) {
+		fmt.Printf("old mode %06o\nnew mode %06o\n", entryMode(entry), newMode)
+		answer, err := promptPatch(answers, "Stage mode change [y,n,q,?]? ")
+		if err != nil {
+			return nil, false, err
+		}
+		switch answer {
+		case "y":
+			stagedEntry.FileMode, modeStaged = newMode, true
+		case "q", "":
+			return nil, true, nil
+		}
+	}
+
+	if hash == entry.Hash {
+		if modeStaged {
+			return &stagedEntry, false, nil
+		}
+		return nil, false, nil
+	}
+
+	stored, err := readObject(repo, entry.Hash)
+	if err != nil {
+		return nil, false, err
+	}
+	old := splitLines(string(stored))
+	hunks := diffPatchHunks(old, splitLines(string(working)))
+
+	quit, err := selectHunks(repo, old, &hunks, answers)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var accepted []patchHunk
+	for _, h := range hunks {
+		if h.staged {
+			accepted = append(accepted, h)
+		}
+	}
+	if len(accepted) == 0 {
+		if modeStaged {
+			return &stagedEntry, quit, nil
+		}
+		return nil, quit, nil
+	}
+
+	lines, err := applyPatchHunks(old, accepted)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %v", entry.FilePath, err)
+	}
+	if stagedEntry.Hash, err = writeBlob(repo, []byte(strings.Join(lines, ""))); err != nil {
+		return nil, false, err
+	}
+	return &stagedEntry, quit, nil
+}
+
+// selectHunks asks about every hunk in turn, splitting and editing hunks in
+// place, and reports whether the user quit
+func selectHunks(repo *Repository, old []string, hunks *[]patchHunk, answers *bufio.Reader) (bool, error) {
+	for i := 0; i < len(*hunks); i++ {
+		h := &(*hunks)[i]
+		fmt.Print(h.String())
+
+		options := "y,n,q,a,d"
+		canSplit := len(splitHunk(*h)) > 1
+		if canSplit {
+			options += ",s"
+		}
+		options += ",e,?"
+		answer, err := promptPatch(answers, fmt.Sprintf("(%d/%d) Stage this hunk [%s]? ", i+1, len(*hunks), options))
+		if err != nil {
+			return false, err
+		}
+
+		switch answer {
+		case "y":
+			h.staged = true
+		case "n":
+		case "q", "":
+			return true, nil
+		case "a", "d":
+			for j := i; j < len(*hunks); j++ {
+				(*hunks)[j].staged = answer == "a"
+			}
+			return false, nil
+		case "s":
+			if !canSplit {
+				fmt.Println("Sorry, cannot split this hunk")
+				i--
+				continue
+			}
+			parts := splitHunk(*h)
+			fmt.Printf("Split into %d hunks.\n", len(parts))
+			rest := append(parts, (*hunks)[i+1:]...)
+			*hunks = append((*hunks)[:i], rest...)
+			i--
+		case "e":
+			edited, err := editHunk(repo, *h)
+			if err != nil {
+				return false, err
+			}
+			if edited == nil {
+				i--
+				continue
+			}
+			if _, err := applyPatchHunks(old, []patchHunk{*edited}); err != nil {
+				fmt.Println("Your edited hunk does not apply.")
+				i--
+				continue
+			}
+			edited.staged = true
+			(*hunks)[i] = *edited
+		default:
+			fmt.Println(patchHelp)
+			i--
+		}
+	}
+	return false, nil
+}
+
+// promptPatch prints a prompt and reads the answer, lower-cased and trimmed.
+// The end of the input reads as "" so that scripts may stop early.
+func promptPatch(answers *bufio.Reader, prompt string) (string, error) {
+	fmt.Print(prompt)
+	line, err := answers.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if err == io.EOF && line == "" {
+		fmt.Println()
+		return "", nil
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	if len(answer) > 1 {
+		answer = answer[:1]
+	}
+	if answer == "" {
+		answer = "?"
+	}
+	return answer, nil
+}
+
+// diffPatchHunks splits the difference between the index and working tree
+// versions of a file into hunks with patchContext lines of context
+func diffPatchHunks(old, new []string) []patchHunk {
+	var hunks []patchHunk
+	matcher := difflib.NewMatcher(old, new)
+	for _, group := range matcher.GetGroupedOpCodes(patchContext) {
+		h := patchHunk{oldStart: group[0].I1, newStart: group[0].J1}
+		changed := false
+		for _, op := range group {
+			if op.Tag == 'e' {
+				for _, line := range old[op.I1:op.I2] {
+					h.lines = append(h.lines, patchLine{' ', line})
+				}
+				continue
+			}
+			changed = true
+			if op.Tag == 'r' || op.Tag == 'd' {
+				for _, line := range old[op.I1:op.I2] {
+					h.lines = append(h.lines, patchLine{'-', line})
+				}
+			}
+			if op.Tag == 'r' || op.Tag == 'i' {
+				for _, line := range new[op.J1:op.J2] {
+					h.lines = append(h.lines, patchLine{'+', line})
+				}
+			}
+		}
+		if changed {
+			hunks = append(hunks, h)
+		}
+	}
+	return hunks
+}
+
+// counts returns how many index and working tree lines a hunk covers
+func (h patchHunk) counts() (int, int) {
+	oldCount, newCount := 0, 0
+	for _, line := range h.lines {
+		if line.op != '+' {
+			oldCount++
+		}
+		if line.op != '-' {
+			newCount++
+		}
+	}
+	return oldCount, newCount
+}
+
+// String formats a hunk as in a unified diff
+func (h patchHunk) String() string {
+	var b strings.Builder
+	oldCount, newCount := h.counts()
+	fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(h.oldStart, oldCount), hunkRange(h.newStart, newCount))
+	for _, line := range h.lines {
+		b.WriteByte(line.op)
+		b.WriteString(line.text)
+		if !strings.HasSuffix(line.text, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return b.String()
+}
+
+// hunkRange formats the 1-based start and length of a hunk side, where an
+// empty side names the line before it
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitHunk breaks a hunk at every run of context lines between changes.
+// Neighbouring parts share that context, like in git.
+func splitHunk(h patchHunk) []patchHunk {
+	// Find the runs of changed lines
+	type block struct{ start, end int }
+	var blocks []block
+	for i := 0; i < len(h.lines); i++ {
+		if h.lines[i].op == ' ' {
+			continue
+		}
+		j := i
+		for j < len(h.lines) && h.lines[j].op != ' ' {
+			j++
+		}
+		blocks = append(blocks, block{i, j})
+		i = j
+	}
+	if len(blocks) < 2 {
+		return []patchHunk{h}
+	}
+
+	// Positions of every line in the index and working tree versions
+	oldPos := make([]int, len(h.lines)+1)
+	newPos := make([]int, len(h.lines)+1)
+	oldPos[0], newPos[0] = h.oldStart, h.newStart
+	for i, line := range h.lines {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if line.op != '+' {
+			oldPos[i+1]++
+		}
+		if line.op != '-' {
+			newPos[i+1]++
+		}
+	}
+
+	parts := make([]patchHunk, len(blocks))
+	for k := range blocks {
+		start, end := 0, len(h.lines)
+		if k > 0 {
+			start = blocks[k-1].end
+		}
+		if k < len(blocks)-1 {
+			end = blocks[k+1].start
+		}
+		parts[k] = patchHunk{
+			oldStart: oldPos[start],
+			newStart: newPos[start],
+			lines:    append([]patchLine{}, h.lines[start:end]...),
+		}
+	}
+	return parts
+}
+
+// editHunk lets the user rewrite a hunk in the editor. It returns nil when
+// the edit was abandoned by deleting every line.
+func editHunk(repo *Repository, h patchHunk) (*patchHunk, error) {
+	var b strings.Builder
+	b.WriteString("# Manual hunk edit mode -- see bottom for a quick guide.\n")
+	b.WriteString(h.String())
+	b.WriteString(`# ---
+# To remove '-' lines, make them ' ' lines (context).
+# To remove '+' lines, delete them.
+# Lines starting with # will be removed.
+# If the patch applies cleanly, the edited hunk will immediately be
+# marked for staging.
+`)
+
+	path := filepath.Join(repo.GitDir, ADD_EDIT_FILE)
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+	if err := launchEditor(path); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	edited := patchHunk{oldStart: h.oldStart, newStart: h.newStart}
+	for _, line := range splitLines(string(data)) {
+		switch {
+		case strings.HasPrefix(line, "#"), strings.HasPrefix(line, "@@"):
+		case strings.HasPrefix(line, "\\"):
+			// The previous line has no newline at the end of the file
+			if n := len(edited.lines); n > 0 {
+				edited.lines[n-1].text = strings.TrimSuffix(edited.lines[n-1].text, "\n")
+			}
+		case line == "\n":
+			// Editors tend to strip the space of empty context lines
+			edited.lines = append(edited.lines, patchLine{' ', line})
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			edited.lines = append(edited.lines, patchLine{line[0], line[1:]})
+		default:
+			fmt.Printf("Your edited hunk has an unexpected line: %s", line)
+			return nil, nil
+		}
+	}
+	if len(edited.lines) == 0 {
+		return nil, nil
+	}
+	return &edited, nil
+}
+
+// applyPatchHunks applies hunks, sorted by position, to the index version of
+// a file. Split hunks may overlap on the context they share.
+func applyPatchHunks(old []string, hunks []patchHunk) ([]string, error) {
+	var out []string
+	pos := 0
+	for _, h := range hunks {
+		lines := h.lines
+		if h.oldStart < pos {
+			// Skip the context already copied for the previous hunk
+			skip := pos - h.oldStart
+			for skip > 0 && len(lines) > 0 && lines[0].op == ' ' {
+				lines = lines[1:]
+				skip--
+			}
+			if skip > 0 {
+				return nil, fmt.Errorf("hunks overlap")
+			}
+		} else {
+			out = append(out, old[pos:h.oldStart]...)
+			pos = h.oldStart
+		}
+
+		for _, line := range lines {
+			switch line.op {
+			case ' ', '-':
+				if pos >= len(old) || old[pos] != line.text {
+					return nil, fmt.Errorf("hunk at line %d does not apply", h.oldStart+1)
+				}
+				if line.op == ' ' {
+					out = append(out, line.text)
+				}
+				pos++
+			case '+':
+				out = append(out, line.text)
+			}
+		}
+	}
+	return append(out, old[pos:]...), nil
+}
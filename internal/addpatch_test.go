@@ -0,0 +1,218 @@
+// internal/addpatch_test.go
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns lines "1\n" to "n\n" with the given replacements
+func numberedLines(n int, replace map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if line, ok := replace[i]; ok {
+			b.WriteString(line + "\n")
+		} else {
+			fmt.Fprintf(&b, "%d\n", i)
+		}
+	}
+	return b.String()
+}
+
+// stagedContent returns the contents of a file as staged in the index
+func stagedContent(t *testing.T, path string) string {
+	t.Helper()
+	repo, _ := FindGitterRepo()
+	data, err := readObject(repo, indexEntry(t, path).Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAddPatch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test editor is a shell script")
+	}
+
+	// Lines 2, 15 and 17, and 28 change: three hunks, the middle one splittable
+	changed := map[int]string{2: "two", 15: "fifteen", 17: "seventeen", 28: "x"}
+	tests := []struct {
+		name    string
+		answers string
+		editor  string
+		want    map[int]string // changes that end up staged
+		output  string
+	}{
+		{
+			name:    "yes and no",
+			answers: "y\nn\nn\n",
+			want:    map[int]string{2: "two"},
+			output:  "(1/3) Stage this hunk [y,n,q,a,d,e,?]? ",
+		},
+		{
+			name:    "split",
+			answers: "n\ns\nn\ny\nn\n",
+			want:    map[int]string{17: "seventeen"},
+			output:  "Split into 2 hunks.",
+		},
+		{
+			name:    "split parts sharing context both staged",
+			answers: "n\ns\ny\ny\nn\n",
+			want:    map[int]string{15: "fifteen", 17: "seventeen"},
+		},
+		{
+			name:    "quit keeps earlier answers",
+			answers: "y\nq\n",
+			want:    map[int]string{2: "two"},
+		},
+		{
+			name:    "end of input quits",
+			answers: "n\ny\n",
+			want:    map[int]string{15: "fifteen", 17: "seventeen"},
+		},
+		{
+			name:    "all and done",
+			answers: "n\na\n",
+			want:    map[int]string{15: "fifteen", 17: "seventeen", 28: "x"},
+		},
+		{
+			name:    "help and unknown answers ask again",
+			answers: "?\nwhat\nd\n",
+			want:    map[int]string{},
+			output:  "s - split the current hunk into smaller hunks",
+		},
+		{
+			name:    "edit",
+			answers: "n\nn\ne\n",
+			editor:  `sed -i.bak 's/^+x$/+X/' "$1"`,
+			want:    map[int]string{28: "X"},
+		},
+		{
+			name:    "edit turning a removal into context",
+			answers: "e\nn\nn\n",
+			editor:  `sed -i.bak -e 's/^-2$/ 2/' -e '/^+two$/d' "$1"`,
+			want:    map[int]string{},
+		},
+		{
+			name:    "edit that does not apply is asked again",
+			answers: "n\nn\ne\ny\n",
+			editor:  `sed -i.bak 's/^ 27$/ 99/' "$1"`,
+			want:    map[int]string{28: "x"},
+			output:  "Your edited hunk does not apply.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cleanup := setupTestRepo(t)
+			defer cleanup()
+
+			if err := InitRepository(); err != nil {
+				t.Fatal(err)
+			}
+			captureOutput(t, func() { commitFile(t, "f.txt", numberedLines(30, nil), "Add f.txt") })
+			working := numberedLines(30, changed)
+			if err := ioutil.WriteFile("f.txt", []byte(working), 0644); err != nil {
+				t.Fatal(err)
+			}
+			setEditor(t, tt.editor)
+
+			output := captureOutput(t, func() {
+				if err := AddPatch(nil, strings.NewReader(tt.answers)); err != nil {
+					t.Errorf("AddPatch() error = %v", err)
+				}
+			})
+			if !strings.Contains(output, tt.output) {
+				t.Errorf("AddPatch() output = %q, want %q", output, tt.output)
+			}
+			if got, want := stagedContent(t, "f.txt"), numberedLines(30, tt.want); got != want {
+				t.Errorf("staged f.txt = %q, want %q", got, want)
+			}
+			if got := readWorkingFile(t, "f.txt"); got != working {
+				t.Errorf("working f.txt was changed to %q", got)
+			}
+		})
+	}
+}
+
+func TestAddPatchStatus(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		commitFile(t, "a.txt", numberedLines(20, nil), "Add a.txt")
+		commitFile(t, "b.txt", "b\n", "Add b.txt")
+	})
+	for path, content := range map[string]string{
+		"a.txt": numberedLines(20, map[int]string{1: "one", 20: "twenty"}),
+		"b.txt": "changed\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only files under the given paths are offered
+	output := captureOutput(t, func() {
+		if err := AddPatch([]string{"a.txt"}, strings.NewReader("y\nn\n")); err != nil {
+			t.Errorf("AddPatch() error = %v", err)
+		}
+	})
+	if strings.Contains(output, "b.txt") {
+		t.Errorf("AddPatch(a.txt) asked about b.txt: %q", output)
+	}
+
+	// A file staged in part is both staged and not staged
+	repo, _ := FindGitterRepo()
+	index, err := LoadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := collectStatus(repo, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(report.Staged, " ") != "a.txt" || strings.Join(report.NotStaged, " ") != "a.txt b.txt" {
+		t.Errorf("status staged %q, not staged %q", report.Staged, report.NotStaged)
+	}
+
+	captureOutput(t, func() {
+		if err := CommitChanges("Change the first line", CommitOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	head, _ := GetCurrentHead()
+	snapshot, err := loadSnapshot(repo, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readObject(repo, snapshot["a.txt"].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := numberedLines(20, map[int]string{1: "one"}); string(data) != want {
+		t.Errorf("committed a.txt = %q, want %q", data, want)
+	}
+}
+
+func TestApplyPatchHunksNoNewline(t *testing.T) {
+	old := splitLines("a\nb")
+	hunks := diffPatchHunks(old, splitLines("a\nc"))
+	if len(hunks) != 1 {
+		t.Fatalf("diffPatchHunks() = %d hunks, want 1", len(hunks))
+	}
+	if want := "-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"; !strings.HasSuffix(hunks[0].String(), want) {
+		t.Errorf("hunk = %q, want suffix %q", hunks[0].String(), want)
+	}
+	lines, err := applyPatchHunks(old, hunks)
+	if err != nil || strings.Join(lines, "") != "a\nc" {
+		t.Errorf("applyPatchHunks() = %q, %v", lines, err)
+	}
+}
@@ -0,0 +1,287 @@
+// internal/attributes.go
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ATTRIBUTES_FILE gives paths attributes, one pattern per line followed by
+// the attributes: "attr" sets one, "-attr" unsets it and "attr=value" gives
+// it a value. The patterns are those of the ignore file, without negation;
+// later lines win. It lives in the working tree root.
+const ATTRIBUTES_FILE = ".gitterattributes"
+
+// Attributes that change how files are stored and shown
+//
+//	text      normalise line endings to LF when adding; text=auto only does
+//	          so for files that are not binary
+//	eol       crlf or lf: the line endings files get on checkout; sets text
+//	filter    <driver>: pipe contents through filter.<driver>.clean when
+//	          adding and filter.<driver>.smudge on checkout; filter=lfs
+//	          keeps the file in large file storage
+//	diff      unset, diff prints that binary files differ instead of lines
+//	binary    the same as -diff -text
+const (
+	attrText   = "text"
+	attrEOL    = "eol"
+	attrFilter = "filter"
+	attrDiff   = "diff"
+	attrBinary = "binary"
+)
+
+// Values of attributes that are set or unset rather than given a value
+const (
+	attrSet   = "set"
+	attrUnset = "unset"
+)
+
+// configEOL is the setting for the line endings of text files with no eol
+// attribute, lf by default
+const configEOL = "core.eol"
+
+// attrRule is one line of the attributes file
+type attrRule struct {
+	pattern ignoreRule
+	attrs   map[string]string
+}
+
+// attrRules are the lines of the attributes file, in order
+type attrRules []attrRule
+
+// loadAttributes reads the attributes file, returning no rules when there
+// is none
+func loadAttributes(repo *Repository) (attrRules, error) {
+	file, err := os.Open(filepath.Join(repo.WorkingDir, ATTRIBUTES_FILE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules attrRules
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "!") {
+			continue
+		}
+		pattern, ok := parsePattern(fields[0])
+		if !ok {
+			continue
+		}
+		rule := attrRule{pattern: pattern, attrs: make(map[string]string)}
+		for _, field := range fields[1:] {
+			switch {
+			case strings.HasPrefix(field, "-"):
+				rule.attrs[field[1:]] = attrUnset
+			case strings.Contains(field, "="):
+				parts := strings.SplitN(field, "=", 2)
+				rule.attrs[parts[0]] = parts[1]
+			default:
+				rule.attrs[field] = attrSet
+			}
+		}
+		if rule.attrs[attrBinary] == attrSet {
+			rule.attrs[attrDiff] = attrUnset
+			rule.attrs[attrText] = attrUnset
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// of returns the attributes of a path relative to the working tree root
+func (rules attrRules) of(rel string) map[string]string {
+	attrs := make(map[string]string)
+	for _, rule := range rules {
+		if !(ignoreRules{rule.pattern}).match(rel, false) {
+			continue
+		}
+		for name, value := range rule.attrs {
+			attrs[name] = value
+		}
+	}
+	return attrs
+}
+
+// looksBinary reports whether contents are binary rather than text, which
+// is taken to be when a NUL byte appears near the start
+func looksBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// workingFilters turn working tree files into the blobs stored for them and
+// back, as the large file patterns, the attributes and the config say
+type workingFilters struct {
+	repo   *Repository
+	lfs    ignoreRules
+	attrs  attrRules
+	config map[string]string
+
+	mu     sync.Mutex      // guards warned, as files are hashed in parallel
+	warned map[string]bool // files whose optional filter failed, warned about once
+}
+
+// loadWorkingFilters reads what decides how the working tree's files are
+// stored
+func loadWorkingFilters(repo *Repository) (*workingFilters, error) {
+	lfs, err := loadPatternFile(filepath.Join(repo.WorkingDir, LFS_FILE))
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := loadAttributes(repo)
+	if err != nil {
+		return nil, err
+	}
+	config, err := loadConfig(repo)
+	if err != nil {
+		return nil, err
+	}
+	return &workingFilters{repo: repo, lfs: lfs, attrs: attrs, config: config}, nil
+}
+
+// largeFile reports whether a file is kept in large file storage
+func (f *workingFilters) largeFile(rel string) bool {
+	return f.lfs.ignored(rel, false) || f.attrs.of(rel)[attrFilter] == "lfs"
+}
+
+// converts reports whether a file's contents are changed on their way in or
+// out of the repository, other than by large file storage
+func (f *workingFilters) converts(rel string) bool {
+	attrs := f.attrs.of(rel)
+	return f.driver(attrs) != "" || textFile(attrs) != attrUnset
+}
+
+// diffable reports whether diff shows a file's lines
+func (f *workingFilters) diffable(rel string) bool {
+	return f.attrs.of(rel)[attrDiff] != attrUnset
+}
+
+// driver returns the filter driver of a file, "" when it has none or it is
+// large file storage
+func (f *workingFilters) driver(attrs map[string]string) string {
+	driver := attrs[attrFilter]
+	if driver == "lfs" || driver == attrSet || driver == attrUnset {
+		return ""
+	}
+	return driver
+}
+
+// textFile says whether a file's line endings are normalised: attrSet,
+// attrUnset, or "auto" to decide by its contents
+func textFile(attrs map[string]string) string {
+	switch text := attrs[attrText]; text {
+	case attrSet, attrUnset, "auto":
+		return text
+	}
+	if eol := attrs[attrEOL]; eol == "lf" || eol == "crlf" {
+		return attrSet
+	}
+	return attrUnset
+}
+
+// clean turns the contents of a working tree file into the blob stored for
+// it: the filter driver's clean command runs first, then line endings are
+// normalised
+func (f *workingFilters) clean(rel string, data []byte) ([]byte, error) {
+	attrs := f.attrs.of(rel)
+	data, err := f.runFilter(attrs, "clean", rel, data)
+	if err != nil {
+		return nil, err
+	}
+	if f.normalises(attrs, data) {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	}
+	return data, nil
+}
+
+// smudge turns a blob into the contents of its working tree file: line
+// endings are converted first, then the filter driver's smudge command runs
+func (f *workingFilters) smudge(rel string, data []byte) ([]byte, error) {
+	attrs := f.attrs.of(rel)
+	if f.normalises(attrs, data) && f.eol(attrs) == "crlf" {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+		data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+	}
+	return f.runFilter(attrs, "smudge", rel, data)
+}
+
+// normalises reports whether line endings are converted for contents
+func (f *workingFilters) normalises(attrs map[string]string, data []byte) bool {
+	switch textFile(attrs) {
+	case attrSet:
+		return true
+	case "auto":
+		return !looksBinary(data)
+	}
+	return false
+}
+
+// eol returns the line endings a text file gets on checkout
+func (f *workingFilters) eol(attrs map[string]string) string {
+	if eol := attrs[attrEOL]; eol != "" {
+		return eol
+	}
+	if eol := f.config[configEOL]; eol != "" {
+		return eol
+	}
+	return "lf"
+}
+
+// runFilter pipes contents through the clean or smudge command of a file's
+// filter driver, run by the shell from the working tree root with %f
+// replaced by the path. Contents pass unchanged when there is no command or
+// it fails, unless filter.<driver>.required is true.
+func (f *workingFilters) runFilter(attrs map[string]string, direction, rel string, data []byte) ([]byte, error) {
+	driver := f.driver(attrs)
+	if driver == "" {
+		return data, nil
+	}
+	command := f.config["filter."+driver+"."+direction]
+	required := f.config["filter."+driver+".required"] == "true"
+	if command == "" {
+		if required {
+			return nil, fmt.Errorf("%s: filter '%s' is required but has no %s command", rel, driver, direction)
+		}
+		return data, nil
+	}
+
+	var out, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", strings.ReplaceAll(command, "%f", shellQuote(rel)))
+	cmd.Dir = f.repo.WorkingDir
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		if required {
+			return nil, fmt.Errorf("%s: %s filter '%s' failed: %s", rel, direction, driver, message)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if !f.warned[rel] {
+			if f.warned == nil {
+				f.warned = make(map[string]bool)
+			}
+			f.warned[rel] = true
+			fmt.Fprintf(os.Stderr, "warning: %s: %s filter '%s' failed: %s\n", rel, direction, driver, message)
+		}
+		return data, nil
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,183 @@
+// internal/attributes_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAttributesOf(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	content := "# comment\n" +
+		"*.txt text\n" +
+		"*.bat eol=crlf\n" +
+		"docs/*.txt -text diff=prose\n" +
+		"*.png binary\n" +
+		"!*.md text\n"
+	if err := ioutil.WriteFile(ATTRIBUTES_FILE, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := FindGitterRepo()
+	rules, err := loadAttributes(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"a.txt", map[string]string{"text": "set"}},
+		{"src/b.txt", map[string]string{"text": "set"}},
+		{"docs/c.txt", map[string]string{"text": "unset", "diff": "prose"}},
+		{"run.bat", map[string]string{"eol": "crlf"}},
+		{"logo.png", map[string]string{"binary": "set", "diff": "unset", "text": "unset"}},
+		{"README.md", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := rules.of(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("of(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := FindGitterRepo()
+	attributes := "*.txt text\n*.bat eol=crlf\n*.dat text=auto\n"
+	captureOutput(t, func() {
+		commitFile(t, ATTRIBUTES_FILE, attributes, "Add attributes")
+	})
+
+	// Adding normalises to LF
+	var head string
+	captureOutput(t, func() {
+		if err := ioutil.WriteFile("run.bat", []byte("echo one\r\necho two\r\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile("bin.dat", []byte("a\r\n\x00b\r\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := AddFile("run.bat"); err != nil {
+			t.Fatal(err)
+		}
+		if err := AddFile("bin.dat"); err != nil {
+			t.Fatal(err)
+		}
+		head = commitFile(t, "notes.txt", "one\r\ntwo\n", "Add files")
+	})
+	snapshot, err := loadSnapshot(repo, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"notes.txt": "one\ntwo\n",
+		"run.bat":   "echo one\necho two\n",
+		"bin.dat":   "a\r\n\x00b\r\n", // binary, so left alone
+	} {
+		if data, _ := readObject(repo, snapshot[path].Hash); string(data) != want {
+			t.Errorf("%s stored as %q, want %q", path, data, want)
+		}
+	}
+	if output := captureOutput(t, func() { ShowStatus() }); !strings.Contains(output, "nothing to commit") {
+		t.Errorf("status with CRLF files = %q", output)
+	}
+
+	// Checkout gives eol=crlf files CRLF, and core.eol the other text files
+	if err := Config(configEOL, "crlf", ConfigOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutCommit(repo, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutCommit(repo, head); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWorkingFile(t, "run.bat"); got != "echo one\r\necho two\r\n" {
+		t.Errorf("run.bat = %q after checkout", got)
+	}
+	if got := readWorkingFile(t, "notes.txt"); got != "one\r\ntwo\r\n" {
+		t.Errorf("notes.txt = %q after checkout with core.eol=crlf", got)
+	}
+	if output := captureOutput(t, func() { ShowStatus() }); !strings.Contains(output, "nothing to commit") {
+		t.Errorf("status after checkout = %q", output)
+	}
+}
+
+func TestFilterDriver(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("filters are run by sh")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := FindGitterRepo()
+	Config("filter.kw.clean", `sed 's/\$Id:[^$]*\$/$Id$/'`, ConfigOptions{})
+	Config("filter.kw.smudge", `sed 's/\$Id\$/$Id: %f $/'`, ConfigOptions{})
+	var head string
+	captureOutput(t, func() {
+		commitFile(t, ATTRIBUTES_FILE, "*.c filter=kw\n*.png -diff\n", "Add attributes")
+		head = commitFile(t, "main.c", "/* $Id$ */\n", "Add main.c")
+	})
+
+	// Smudged on checkout, cleaned again on add
+	if err := checkoutCommit(repo, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutCommit(repo, head); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWorkingFile(t, "main.c"); got != "/* $Id: main.c $ */\n" {
+		t.Errorf("main.c = %q after checkout", got)
+	}
+	if output := captureOutput(t, func() { ShowStatus() }); !strings.Contains(output, "nothing to commit") {
+		t.Errorf("status of a smudged file = %q", output)
+	}
+	snapshot, _ := loadSnapshot(repo, head)
+	if data, _ := readObject(repo, snapshot["main.c"].Hash); string(data) != "/* $Id$ */\n" {
+		t.Errorf("main.c stored as %q", data)
+	}
+
+	// A failing filter passes contents through unless it is required
+	Config("filter.kw.clean", "exit 3", ConfigOptions{})
+	if err := ioutil.WriteFile("other.c", []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("other.c"); err != nil {
+		t.Errorf("AddFile() with a failing optional filter error = %v", err)
+	}
+	Config("filter.kw.required", "true", ConfigOptions{})
+	if err := AddFile("other.c"); err == nil || !strings.Contains(err.Error(), "clean filter 'kw' failed") {
+		t.Errorf("AddFile() with a failing required filter error = %v", err)
+	}
+	Config("filter.kw.required", "", ConfigOptions{Unset: true})
+
+	// -diff only says that files differ
+	captureOutput(t, func() {
+		commitFile(t, "logo.png", "one\n", "Add logo")
+	})
+	if err := ioutil.WriteFile("logo.png", []byte("two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := captureOutput(t, func() { ShowDiff("logo.png", DiffOptions{}) })
+	if output != "Binary files a/logo.png and b/logo.png differ\n" {
+		t.Errorf("diff of a -diff file = %q", output)
+	}
+	output = captureOutput(t, func() { Show("HEAD") })
+	if !strings.Contains(output, "Binary files /dev/null and b/logo.png differ\n") {
+		t.Errorf("show of a -diff file = %q", output)
+	}
+}
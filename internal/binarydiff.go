@@ -0,0 +1,134 @@
+// internal/binarydiff.go
+package internal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// base85Alphabet encodes the data of binary patches, 4 bytes to 5 characters
+const base85Alphabet = "0123456789" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"!#$%&()*+-;<=>?@^_`{|}~"
+
+// binaryPatchLine is the most data one line of a binary patch carries
+const binaryPatchLine = 52
+
+// binaryDiff reports whether two versions of a file are compared as binary,
+// which they are when the file's attributes unset diff or either looks binary
+func binaryDiff(filters *workingFilters, rel string, oldData, newData []byte) bool {
+	return !filters.diffable(rel) || looksBinary(oldData) || looksBinary(newData)
+}
+
+// gitBlobHash returns the name git gives a blob. Binary patches carry it so
+// that git apply can check that it is patching the contents they were made
+// from.
+func gitBlobHash(data []byte) string {
+	hasher := sha1.New()
+	fmt.Fprintf(hasher, "blob %d\x00", len(data))
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// writeBinaryPatch writes a patch turning oldData into newData in the format
+// of git diff --binary, which git apply applies. A mode of 0 stands for a
+// file that does not exist on that side.
+func writeBinaryPatch(w io.Writer, path string, oldData, newData []byte, oldMode, newMode uint32) error {
+	oldHash, newHash := strings.Repeat("0", 40), strings.Repeat("0", 40)
+	if oldMode != 0 {
+		oldHash = gitBlobHash(oldData)
+	}
+	if newMode != 0 {
+		newHash = gitBlobHash(newData)
+	}
+
+	var header strings.Builder
+	fmt.Fprintf(&header, "diff --git a/%s b/%s\n", path, path)
+	index := fmt.Sprintf("index %s..%s", oldHash, newHash)
+	switch {
+	case oldMode == 0:
+		fmt.Fprintf(&header, "new file mode %06o\n", newMode)
+	case newMode == 0:
+		fmt.Fprintf(&header, "deleted file mode %06o\n", oldMode)
+	case oldMode != newMode:
+		fmt.Fprintf(&header, "old mode %06o\nnew mode %06o\n", oldMode, newMode)
+	default:
+		index += fmt.Sprintf(" %06o", newMode)
+	}
+	fmt.Fprintf(&header, "%s\nGIT binary patch\n", index)
+	if _, err := io.WriteString(w, header.String()); err != nil {
+		return err
+	}
+
+	// The forward hunk, then the reverse one so that the patch can be undone
+	if err := writeLiteralHunk(w, newData); err != nil {
+		return err
+	}
+	return writeLiteralHunk(w, oldData)
+}
+
+// writeLiteralHunk writes the whole of data deflated and base85 encoded,
+// each line starting with a letter for how many bytes it carries: A-Z for 1
+// to 26 and a-z for 27 to 52
+func writeLiteralHunk(w io.Writer, data []byte) error {
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "literal %d\n", len(data))
+	for chunk := deflated.Bytes(); len(chunk) > 0; {
+		n := len(chunk)
+		if n > binaryPatchLine {
+			n = binaryPatchLine
+		}
+		if n <= 26 {
+			out.WriteByte(byte('A' + n - 1))
+		} else {
+			out.WriteByte(byte('a' + n - 27))
+		}
+		out.WriteString(encode85(chunk[:n]))
+		out.WriteByte('\n')
+		chunk = chunk[n:]
+	}
+	out.WriteByte('\n')
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// encode85 encodes data 4 bytes at a time, most significant digit first,
+// padding the last group with zeros
+func encode85(data []byte) string {
+	var out strings.Builder
+	for len(data) > 0 {
+		var acc uint32
+		for i := 0; i < 4; i++ {
+			acc <<= 8
+			if i < len(data) {
+				acc |= uint32(data[i])
+			}
+		}
+		var group [5]byte
+		for i := 4; i >= 0; i-- {
+			group[i] = base85Alphabet[acc%85]
+			acc /= 85
+		}
+		out.Write(group[:])
+		if len(data) < 4 {
+			break
+		}
+		data = data[4:]
+	}
+	return out.String()
+}
@@ -0,0 +1,108 @@
+// internal/binarydiff_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncode85(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"\x00\x00\x00\x00", "00000"},
+		{"\xff\xff\xff\xff", "|NsC0"},
+		{"\x00\x00\x00\x01", "00001"},
+		{"\x00\x00\x00\x00\x00", "0000000000"},
+	}
+	for _, tt := range tests {
+		if got := encode85([]byte(tt.data)); got != tt.want {
+			t.Errorf("encode85(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestGitBlobHash(t *testing.T) {
+	// The names git gives the empty blob and "hello\n"
+	if got := gitBlobHash(nil); got != "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391" {
+		t.Errorf("gitBlobHash(empty) = %s", got)
+	}
+	if got := gitBlobHash([]byte("hello\n")); got != "ce013625030ba8dba906f756967f9e9ca394464a" {
+		t.Errorf("gitBlobHash(hello) = %s", got)
+	}
+}
+
+func TestBinaryDiff(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	old := "GIF89a\x00\x01" + strings.Repeat("\x00\xfe", 100)
+	updated := "GIF89a\x00\x02" + strings.Repeat("\x01\xfd", 300)
+	captureOutput(t, func() {
+		commitFile(t, "logo.gif", old, "Add logo")
+	})
+	if err := ioutil.WriteFile("logo.gif", []byte(updated), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Binary contents are not diffed line by line
+	output := captureOutput(t, func() { ShowDiff("", DiffOptions{}) })
+	if output != "Binary files a/logo.gif and b/logo.gif differ\n" {
+		t.Errorf("diff of a binary file = %q", output)
+	}
+	output = captureOutput(t, func() { Show("HEAD") })
+	if !strings.Contains(output, "Binary files /dev/null and b/logo.gif differ\n") || strings.Contains(output, "GIF89a") {
+		t.Errorf("show of a binary file = %q", output)
+	}
+
+	// --binary prints a patch
+	patch := captureOutput(t, func() { ShowDiff("", DiffOptions{Binary: true}) })
+	wantHeader := "diff --git a/logo.gif b/logo.gif\n" +
+		"index " + gitBlobHash([]byte(old)) + ".." + gitBlobHash([]byte(updated)) + " 100644\n" +
+		"GIT binary patch\n" +
+		"literal 608\n"
+	if !strings.HasPrefix(patch, wantHeader) || !strings.Contains(patch, "\nliteral 208\n") {
+		t.Fatalf("diff --binary = %q", patch)
+	}
+
+	// A new binary file
+	if err := ioutil.WriteFile("new.bin", []byte("\x00new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	newPatch := captureOutput(t, func() { ShowDiff("new.bin", DiffOptions{Binary: true}) })
+	if !strings.HasPrefix(newPatch, "diff --git a/new.bin b/new.bin\nnew file mode 100644\nindex "+strings.Repeat("0", 40)+"..") {
+		t.Errorf("diff --binary of a new file = %q", newPatch)
+	}
+
+	// Which git applies, to the old contents and to nothing
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "logo.gif"), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{"logo.patch": patch, "new.patch": newPatch} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command("git", "apply", name)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_CEILING_DIRECTORIES="+filepath.Dir(dir))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git apply %s: %v\n%s", name, err, out)
+		}
+	}
+	for name, want := range map[string]string{"logo.gif": updated, "new.bin": "\x00new"} {
+		if got, _ := ioutil.ReadFile(filepath.Join(dir, name)); string(got) != want {
+			t.Errorf("%s after git apply = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+// internal/bisect.go
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// BISECT_FILE holds the bisect state while a bisection is running
+const BISECT_FILE = "BISECT_STATE"
+
+// Bisect terms
+const (
+	BisectGood = "good"
+	BisectBad  = "bad"
+	BisectSkip = "skip"
+)
+
+// bisectSkipExitCode tells `bisect run` that the commit cannot be tested
+const bisectSkipExitCode = 125
+
+// BisectState is persisted between bisect invocations
+type BisectState struct {
+	HeadName string   `json:"head_name"` // branch to return to, empty when started detached
+	OrigHead string   `json:"orig_head"`
+	Bad      string   `json:"bad"`
+	Good     []string `json:"good"`
+	Skip     []string `json:"skip"`
+	Log      []string `json:"log"`
+}
+
+// loadBisectState returns the saved bisect state, or nil when not bisecting
+func loadBisectState(repo *Repository) (*BisectState, error) {
+	data, err := ioutil.ReadFile(filepath.Join(repo.GitDir, BISECT_FILE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var state BisectState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// saveBisectState persists the bisect state
+func saveBisectState(repo *Repository, state *BisectState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(repo.GitDir, BISECT_FILE), data, 0644)
+}
+
+// requireBisectState loads the bisect state and fails when not bisecting
+func requireBisectState(repo *Repository) (*BisectState, error) {
+	state, err := loadBisectState(repo)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("you need to start by \"gitter bisect start\"")
+	}
+	return state, nil
+}
+
+// BisectStart begins a bisection, optionally marking a bad and good commits
+func BisectStart(bad string, goods []string) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	if state, err := loadBisectState(repo); err != nil {
+		return err
+	} else if state != nil {
+		return fmt.Errorf("a bisect is already in progress; run \"gitter bisect reset\" first")
+	}
+
+	head, err := GetCurrentHead()
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("no commits yet")
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+	if dirty, err := hasLocalChanges(repo, index); err != nil {
+		return err
+	} else if dirty {
+		return fmt.Errorf("cannot bisect: you have uncommitted changes, please commit them first")
+	}
+
+	branch, err := currentBranch(repo)
+	if err != nil {
+		return err
+	}
+
+	state := &BisectState{HeadName: branch, OrigHead: head, Good: []string{}, Skip: []string{}}
+	startLine := "gitter bisect start"
+
+	if bad != "" {
+		hash, err := resolveRevision(repo, bad)
+		if err != nil {
+			return err
+		}
+		state.Bad = hash
+		startLine += " " + hash
+	}
+	for _, good := range goods {
+		if bad == "" {
+			return fmt.Errorf("a bad revision is needed before good ones")
+		}
+		hash, err := resolveRevision(repo, good)
+		if err != nil {
+			return err
+		}
+		state.Good = append(state.Good, hash)
+		startLine += " " + hash
+	}
+	state.Log = append(state.Log, startLine)
+
+	if err := saveBisectState(repo, state); err != nil {
+		return err
+	}
+	_, err = bisectNext(repo, state)
+	return err
+}
+
+// BisectMark records a commit (HEAD when rev is empty) as good, bad or
+// skipped and checks out the next commit to test
+func BisectMark(term, rev string) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	state, err := requireBisectState(repo)
+	if err != nil {
+		return err
+	}
+
+	if rev == "" {
+		rev = HEAD_FILE
+	}
+	hash, err := resolveRevision(repo, rev)
+	if err != nil {
+		return err
+	}
+
+	if err := markBisect(repo, state, term, hash); err != nil {
+		return err
+	}
+	_, err = bisectNext(repo, state)
+	return err
+}
+
+// markBisect records a term for a commit in the state and log
+func markBisect(repo *Repository, state *BisectState, term, hash string) error {
+	switch term {
+	case BisectGood:
+		state.Good = append(state.Good, hash)
+	case BisectBad:
+		state.Bad = hash
+	case BisectSkip:
+		state.Skip = append(state.Skip, hash)
+	default:
+		return fmt.Errorf("unknown bisect term '%s'", term)
+	}
+
+	commit, err := readCommit(repo, hash)
+	if err != nil {
+		return err
+	}
+	state.Log = append(state.Log,
+		fmt.Sprintf("# %s: [%s] %s", term, hash, commitSubject(commit.Message)),
+		fmt.Sprintf("gitter bisect %s %s", term, hash))
+	return saveBisectState(repo, state)
+}
+
+// bisectNext checks out the next commit to test. It reports true once the
+// first bad commit has been found (or only skipped commits remain).
+func bisectNext(repo *Repository, state *BisectState) (bool, error) {
+	switch {
+	case state.Bad == "" && len(state.Good) == 0:
+		return false, nil
+	case state.Bad == "":
+		fmt.Printf("status: waiting for bad commit, %d good commit(s) known\n", len(state.Good))
+		return false, nil
+	case len(state.Good) == 0:
+		fmt.Println("status: waiting for good commit(s), bad commit known")
+		return false, nil
+	}
+
+	// The suspects are the commits between the newest good commit and bad
+	goods := make(map[string]bool)
+	for _, good := range state.Good {
+		goods[good] = true
+	}
+
+	var suspects []Commit
+	foundGood := false
+	for hash := state.Bad; hash != ""; {
+		if goods[hash] {
+			foundGood = true
+			break
+		}
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return false, err
+		}
+		suspects = append(suspects, commit)
+		hash = commit.Parent
+	}
+	if !foundGood {
+		return false, fmt.Errorf("some good revisions are not ancestors of the bad revision %s", state.Bad[:7])
+	}
+
+	skipped := make(map[string]bool)
+	for _, skip := range state.Skip {
+		skipped[skip] = true
+	}
+
+	// suspects[0] is the known bad commit; pick the untested commit closest
+	// to the middle of the rest
+	candidates := suspects[1:]
+	mid := len(candidates) / 2
+	next := -1
+	for offset := 0; offset <= len(candidates); offset++ {
+		if i := mid - offset; i >= 0 && i < len(candidates) && !skipped[candidates[i].Hash] {
+			next = i
+			break
+		}
+		if i := mid + offset; i < len(candidates) && !skipped[candidates[i].Hash] {
+			next = i
+			break
+		}
+	}
+
+	if next < 0 {
+		if len(candidates) == 0 {
+			printFirstBad(suspects[0])
+			return true, nil
+		}
+		fmt.Println("There are only 'skip'ped commits left to test.")
+		fmt.Println("The first bad commit could be any of:")
+		for _, commit := range suspects {
+			fmt.Println(commit.Hash)
+		}
+		return true, fmt.Errorf("we cannot bisect more")
+	}
+
+	remaining := len(candidates) / 2
+	steps := 0
+	if remaining > 0 {
+		steps = int(math.Ceil(math.Log2(float64(remaining + 1))))
+	}
+	fmt.Printf("Bisecting: %d revisions left to test after this (roughly %d steps)\n", remaining, steps)
+
+	target := candidates[next]
+	oldHead, err := GetCurrentHead()
+	if err != nil {
+		return false, err
+	}
+	if err := checkoutCommit(repo, target.Hash); err != nil {
+		return false, err
+	}
+	if err := detachHead(repo, target.Hash); err != nil {
+		return false, err
+	}
+	runPostCheckout(repo, oldHead, target.Hash)
+	fmt.Printf("[%s] %s\n", target.Hash, commitSubject(target.Message))
+	return false, nil
+}
+
+// printFirstBad reports the result of the bisection
+func printFirstBad(commit Commit) {
+	fmt.Printf("%s is the first bad commit\n", commit.Hash)
+	fmt.Printf("commit %s\n", commit.Hash)
+	fmt.Printf("Author: %s\n", commit.Author)
+	fmt.Printf("Date: %s\n", commit.Date.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	fmt.Printf("\n%s\n\n", indentMessage(commit.Message))
+}
+
+// BisectReset ends the bisection and returns to the original branch, or to
+// rev when one is given
+func BisectReset(rev string) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	state, err := loadBisectState(repo)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		fmt.Println("We are not bisecting.")
+		return nil
+	}
+
+	target := state.OrigHead
+	if rev != "" {
+		if target, err = resolveRevision(repo, rev); err != nil {
+			return err
+		}
+	}
+	oldHead, err := GetCurrentHead()
+	if err != nil {
+		return err
+	}
+	if err := checkoutCommit(repo, target); err != nil {
+		return err
+	}
+
+	if rev == "" && state.HeadName != "" {
+		if err := attachHead(repo, state.HeadName); err != nil {
+			return err
+		}
+	} else if err := detachHead(repo, target); err != nil {
+		return err
+	}
+	runPostCheckout(repo, oldHead, target)
+
+	return os.Remove(filepath.Join(repo.GitDir, BISECT_FILE))
+}
+
+// BisectLog prints the marks made so far in a form that can be replayed
+func BisectLog() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	state, err := requireBisectState(repo)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range state.Log {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// BisectRun automates the bisection by running a command on every commit:
+// exit code 0 marks it good, 125 skips it and 1-127 mark it bad
+func BisectRun(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("bisect run requires a command")
+	}
+
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	state, err := requireBisectState(repo)
+	if err != nil {
+		return err
+	}
+	if state.Bad == "" || len(state.Good) == 0 {
+		return fmt.Errorf("bisect run needs a good and a bad commit to start from")
+	}
+
+	for {
+		head, err := GetCurrentHead()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("running '%s'\n", strings.Join(args, " "))
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = repo.WorkingDir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		term := BisectGood
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return fmt.Errorf("bisect run failed: %v", err)
+			}
+			switch code := exitErr.ExitCode(); {
+			case code == bisectSkipExitCode:
+				term = BisectSkip
+			case code > 0 && code < 128:
+				term = BisectBad
+			default:
+				return fmt.Errorf("bisect run failed: exit code %d from '%s' is < 0 or >= 128", code, strings.Join(args, " "))
+			}
+		}
+
+		if err := markBisect(repo, state, term, head); err != nil {
+			return err
+		}
+		done, err := bisectNext(repo, state)
+		if err != nil {
+			return err
+		}
+		if done {
+			fmt.Println("bisect found first bad commit")
+			return nil
+		}
+	}
+}
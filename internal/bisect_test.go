@@ -0,0 +1,200 @@
+// internal/bisect_test.go
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupBisectRepo creates ten commits where the bug appears in commit badAt
+// (0-based) and returns the commit hashes, oldest first
+func setupBisectRepo(t *testing.T, badAt int) []string {
+	t.Helper()
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	var commits []string
+	for i := 0; i < 10; i++ {
+		state := "ok"
+		if i >= badAt {
+			state = "bug"
+		}
+		commits = append(commits, commitFile(t, "state.txt", fmt.Sprintf("%s %d\n", state, i), fmt.Sprintf("Commit %d", i)))
+	}
+	return commits
+}
+
+func TestBisectManual(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commits := setupBisectRepo(t, 6)
+
+	output := captureOutput(t, func() {
+		if err := BisectStart("HEAD", []string{commits[0]}); err != nil {
+			t.Errorf("BisectStart() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Bisecting:") {
+		t.Fatalf("BisectStart() output = %q", output)
+	}
+
+	for step := 0; step < 10 && !strings.Contains(output, "is the first bad commit"); step++ {
+		term := BisectGood
+		if strings.HasPrefix(readWorkingFile(t, "state.txt"), "bug") {
+			term = BisectBad
+		}
+		output = captureOutput(t, func() {
+			if err := BisectMark(term, ""); err != nil {
+				t.Errorf("BisectMark(%s) error = %v", term, err)
+			}
+		})
+	}
+	if !strings.Contains(output, commits[6]+" is the first bad commit") {
+		t.Errorf("bisect did not find commit 6, last output:\n%s", output)
+	}
+
+	output = captureOutput(t, func() {
+		if err := BisectLog(); err != nil {
+			t.Errorf("BisectLog() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "gitter bisect start "+commits[9]+" "+commits[0]) || !strings.Contains(output, "gitter bisect bad "+commits[6]) {
+		t.Errorf("BisectLog() output = %q", output)
+	}
+
+	if err := BisectReset(""); err != nil {
+		t.Fatalf("BisectReset() error = %v", err)
+	}
+	if branch, _ := GetCurrentBranch(); branch != "main" {
+		t.Errorf("GetCurrentBranch() after reset = %q, want main", branch)
+	}
+	if head, _ := GetCurrentHead(); head != commits[9] {
+		t.Errorf("HEAD after reset = %s, want %s", head, commits[9])
+	}
+	if got := readWorkingFile(t, "state.txt"); got != "bug 9\n" {
+		t.Errorf("state.txt after reset = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(GITTER_DIR, BISECT_FILE)); !os.IsNotExist(err) {
+		t.Errorf("bisect state left behind")
+	}
+}
+
+func TestBisectWaitsForTerms(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commits := setupBisectRepo(t, 3)
+
+	output := captureOutput(t, func() {
+		if err := BisectStart("", nil); err != nil {
+			t.Errorf("BisectStart() error = %v", err)
+		}
+		if err := BisectMark(BisectBad, ""); err != nil {
+			t.Errorf("BisectMark(bad) error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "waiting for good commit") {
+		t.Errorf("output = %q, want waiting for good commit", output)
+	}
+
+	if err := BisectRun([]string{"true"}); err == nil {
+		t.Errorf("BisectRun() without a good commit succeeded")
+	}
+
+	output = captureOutput(t, func() {
+		if err := BisectMark(BisectGood, commits[0][:8]); err != nil {
+			t.Errorf("BisectMark(good) error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Bisecting:") {
+		t.Errorf("output = %q, want bisecting", output)
+	}
+
+	if err := BisectStart("", nil); err == nil {
+		t.Errorf("BisectStart() while bisecting succeeded")
+	}
+}
+
+func TestBisectRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		badAt    int
+		script   string
+		wantBad  int
+		wantSkip bool
+	}{
+		{
+			name:    "Exit codes",
+			badAt:   4,
+			script:  `grep -q '^ok' state.txt`,
+			wantBad: 4,
+		},
+		{
+			name:    "Bad at the first suspect",
+			badAt:   1,
+			script:  `grep -q '^ok' state.txt`,
+			wantBad: 1,
+		},
+		{
+			name:  "Skip untestable commits",
+			badAt: 5,
+			script: `case "$(cat state.txt)" in
+"ok 4"|"bug 5") exit 125 ;;
+esac
+grep -q '^ok' state.txt`,
+			wantSkip: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cleanup := setupTestRepo(t)
+			defer cleanup()
+
+			commits := setupBisectRepo(t, tt.badAt)
+			script := filepath.Join(t.TempDir(), "test.sh")
+			if err := ioutil.WriteFile(script, []byte("#!/bin/sh\n"+tt.script+"\n"), 0755); err != nil {
+				t.Fatalf("Failed to write script: %v", err)
+			}
+
+			var runErr error
+			output := captureOutput(t, func() {
+				if err := BisectStart(commits[9], []string{commits[0]}); err != nil {
+					t.Errorf("BisectStart() error = %v", err)
+				}
+				runErr = BisectRun([]string{script})
+			})
+
+			if tt.wantSkip {
+				if runErr == nil || !strings.Contains(output, "only 'skip'ped commits left") {
+					t.Errorf("BisectRun() error = %v, output:\n%s", runErr, output)
+				}
+				if !strings.Contains(output, commits[5]) || !strings.Contains(output, commits[6]) {
+					t.Errorf("BisectRun() did not list the candidates:\n%s", output)
+				}
+				return
+			}
+
+			if runErr != nil {
+				t.Fatalf("BisectRun() error = %v\n%s", runErr, output)
+			}
+			if !strings.Contains(output, commits[tt.wantBad]+" is the first bad commit") {
+				t.Errorf("BisectRun() did not find commit %d:\n%s", tt.wantBad, output)
+			}
+		})
+	}
+}
+
+func TestPrintFirstBadIndentsMessage(t *testing.T) {
+	commit := Commit{Hash: "abc", Author: "alice", Message: "Break things\n\nLonger story"}
+	output := captureOutput(t, func() { printFirstBad(commit) })
+	if !strings.Contains(output, "\n    Break things\n    \n    Longer story\n") {
+		t.Errorf("printFirstBad() output = %q", output)
+	}
+}
@@ -0,0 +1,213 @@
+// internal/blame.go
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// BlameOptions configures the blame command
+type BlameOptions struct {
+	Rev       string // commit to blame, defaults to HEAD
+	LineRange string // -L "start,end" or "start,+count"
+	JSON      bool
+}
+
+// BlameLine attributes one line of a file to the commit that last changed it
+type BlameLine struct {
+	Line    int       `json:"line"`
+	Commit  string    `json:"commit"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Content string    `json:"content"`
+}
+
+// Blame prints, for every line of a file, the commit that last touched it
+func Blame(path string, opts BlameOptions) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	rev := opts.Rev
+	if rev == "" {
+		rev = HEAD_FILE
+	}
+	commitHash, err := resolveRevision(repo, rev)
+	if err != nil {
+		return err
+	}
+
+	rel, err := repoRelative(repo, path)
+	if err != nil {
+		return err
+	}
+	lines, err := blameFile(repo, commitHash, rel)
+	if err != nil {
+		return err
+	}
+
+	if opts.LineRange != "" {
+		start, end, err := parseLineRange(opts.LineRange, len(lines))
+		if err != nil {
+			return err
+		}
+		lines = lines[start-1 : end]
+	}
+
+	if opts.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if lines == nil {
+			lines = []BlameLine{}
+		}
+		return encoder.Encode(lines)
+	}
+
+	width := 1
+	if len(lines) > 0 {
+		width = len(strconv.Itoa(lines[len(lines)-1].Line))
+	}
+	for _, line := range lines {
+		fmt.Printf("%s (%s %s %*d) %s\n",
+			line.Commit[:7],
+			line.Author,
+			line.Date.Format("2006-01-02 15:04:05 -0700"),
+			width, line.Line,
+			strings.TrimRight(line.Content, "\r\n"))
+	}
+	return nil
+}
+
+// blameFile attributes every line of path at commitHash by following the
+// lines backwards through the first-parent history
+func blameFile(repo *Repository, commitHash, path string) ([]BlameLine, error) {
+	// Collect the history, newest first
+	var chain []Commit
+	for hash := commitHash; hash != ""; {
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, commit)
+		hash = commit.Parent
+	}
+
+	// Work out which blob the file had at each commit, oldest first
+	blobs := make([]string, len(chain))
+	current := ""
+	for i := len(chain) - 1; i >= 0; i-- {
+		entries, err := readTree(repo, replayTree(chain[i]))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.FilePath == path {
+				current = entry.Hash
+			}
+		}
+		blobs[i] = current
+	}
+
+	if len(chain) == 0 || blobs[0] == "" {
+		return nil, fmt.Errorf("no such path '%s' in %s", path, commitHash[:7])
+	}
+
+	content, err := readObject(repo, blobs[0])
+	if err != nil {
+		return nil, err
+	}
+	fileLines := splitLines(string(content))
+
+	result := make([]BlameLine, len(fileLines))
+	for i, line := range fileLines {
+		result[i] = BlameLine{Line: i + 1, Content: line}
+	}
+
+	// pending maps a line of the commit's version to its final line number
+	pending := make(map[int]int, len(fileLines))
+	for i := range fileLines {
+		pending[i] = i
+	}
+	versionLines := fileLines
+
+	for i := 0; i < len(chain) && len(pending) > 0; i++ {
+		if i+1 < len(chain) && blobs[i+1] == blobs[i] {
+			continue // the commit did not touch the file
+		}
+
+		// Lines that survive unchanged from the parent are passed on to it
+		parentPending := make(map[int]int)
+		var parentLines []string
+		if i+1 < len(chain) && blobs[i+1] != "" {
+			parentContent, err := readObject(repo, blobs[i+1])
+			if err != nil {
+				return nil, err
+			}
+			parentLines = splitLines(string(parentContent))
+
+			matcher := difflib.NewMatcherWithJunk(parentLines, versionLines, false, nil)
+			for _, block := range matcher.GetMatchingBlocks() {
+				for k := 0; k < block.Size; k++ {
+					if final, ok := pending[block.B+k]; ok {
+						parentPending[block.A+k] = final
+						delete(pending, block.B+k)
+					}
+				}
+			}
+		}
+
+		// Everything else was introduced by this commit
+		for _, final := range pending {
+			result[final].Commit = chain[i].Hash
+			result[final].Author = chain[i].Author
+			result[final].Date = chain[i].Date
+		}
+
+		pending = parentPending
+		versionLines = parentLines
+	}
+
+	return result, nil
+}
+
+// parseLineRange parses a -L value of the form "start,end", "start,+count"
+// or "start" into an inclusive 1-based range clamped to the file length
+func parseLineRange(spec string, total int) (int, int, error) {
+	parts := strings.SplitN(spec, ",", 2)
+	start, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || start < 1 {
+		return 0, 0, fmt.Errorf("invalid line range '%s'", spec)
+	}
+
+	end := total
+	if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+		endSpec := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(endSpec, "+") {
+			count, err := strconv.Atoi(endSpec[1:])
+			if err != nil || count < 1 {
+				return 0, 0, fmt.Errorf("invalid line range '%s'", spec)
+			}
+			end = start + count - 1
+		} else {
+			end, err = strconv.Atoi(endSpec)
+			if err != nil || end < start {
+				return 0, 0, fmt.Errorf("invalid line range '%s'", spec)
+			}
+		}
+	}
+
+	if start > total {
+		return 0, 0, fmt.Errorf("file has only %d lines", total)
+	}
+	if end > total {
+		end = total
+	}
+	return start, end, nil
+}
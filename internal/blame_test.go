@@ -0,0 +1,173 @@
+// internal/blame_test.go
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBlame(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	first := commitFile(t, "a.txt", "one\ntwo\nthree\n", "First")
+	commitFile(t, "other.txt", "unrelated\n", "Unrelated")
+	second := commitFile(t, "a.txt", "one\nTWO\nthree\nfour\n", "Second")
+	third := commitFile(t, "a.txt", "zero\none\nTWO\nthree\nfour\n", "Third")
+
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatalf("FindGitterRepo() error = %v", err)
+	}
+
+	lines, err := blameFile(repo, third, "a.txt")
+	if err != nil {
+		t.Fatalf("blameFile() error = %v", err)
+	}
+
+	want := []struct {
+		commit  string
+		content string
+	}{
+		{third, "zero\n"},
+		{first, "one\n"},
+		{second, "TWO\n"},
+		{first, "three\n"},
+		{second, "four\n"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("blameFile() returned %d lines, want %d", len(lines), len(want))
+	}
+	for i, w := range want {
+		if lines[i].Line != i+1 || lines[i].Commit != w.commit || lines[i].Content != w.content {
+			t.Errorf("line %d = {%d %s %q}, want {%d %s %q}",
+				i+1, lines[i].Line, lines[i].Commit[:7], lines[i].Content, i+1, w.commit[:7], w.content)
+		}
+	}
+
+	// Blaming an older revision only sees that revision's lines
+	lines, err = blameFile(repo, second, "a.txt")
+	if err != nil {
+		t.Fatalf("blameFile() error = %v", err)
+	}
+	if len(lines) != 4 || lines[0].Commit != first || lines[1].Commit != second {
+		t.Errorf("blameFile(second) = %+v", lines)
+	}
+
+	if _, err := blameFile(repo, third, "missing.txt"); err == nil {
+		t.Errorf("blameFile() on a missing path succeeded")
+	}
+}
+
+func TestBlameOutput(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	first := commitFile(t, "a.txt", "one\ntwo\nthree\n", "First")
+	second := commitFile(t, "a.txt", "one\nTWO\nthree\n", "Second")
+
+	tests := []struct {
+		name       string
+		opts       BlameOptions
+		wantOutput []string
+		notOutput  []string
+		wantErr    bool
+	}{
+		{
+			name:       "Whole file",
+			opts:       BlameOptions{},
+			wantOutput: []string{first[:7] + " (user ", " 1) one", second[:7] + " (user ", " 2) TWO", " 3) three"},
+		},
+		{
+			name:       "Line range",
+			opts:       BlameOptions{LineRange: "2,+1"},
+			wantOutput: []string{" 2) TWO"},
+			notOutput:  []string{"one", "three"},
+		},
+		{
+			name:       "Older revision",
+			opts:       BlameOptions{Rev: "HEAD~1", LineRange: "2,3"},
+			wantOutput: []string{first[:7] + " (user ", " 2) two", " 3) three"},
+			notOutput:  []string{second[:7]},
+		},
+		{
+			name:    "Range past end of file",
+			opts:    BlameOptions{LineRange: "5,6"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			output := captureOutput(t, func() {
+				err = Blame("a.txt", tt.opts)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Blame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, expected := range tt.wantOutput {
+				if !strings.Contains(output, expected) {
+					t.Errorf("Blame() output missing %q\nGot: %s", expected, output)
+				}
+			}
+			for _, unexpected := range tt.notOutput {
+				if strings.Contains(output, unexpected) {
+					t.Errorf("Blame() output contains %q\nGot: %s", unexpected, output)
+				}
+			}
+		})
+	}
+
+	output := captureOutput(t, func() {
+		if err := Blame("a.txt", BlameOptions{JSON: true, LineRange: "1,2"}); err != nil {
+			t.Errorf("Blame() error = %v", err)
+		}
+	})
+	var lines []BlameLine
+	if err := json.Unmarshal([]byte(output), &lines); err != nil {
+		t.Fatalf("Blame() JSON output invalid: %v\n%s", err, output)
+	}
+	if len(lines) != 2 || lines[0].Commit != first || lines[1].Commit != second || lines[1].Content != "TWO\n" {
+		t.Errorf("Blame() JSON = %+v", lines)
+	}
+}
+
+func TestParseLineRange(t *testing.T) {
+	tests := []struct {
+		spec      string
+		wantStart int
+		wantEnd   int
+		wantErr   bool
+	}{
+		{spec: "2,4", wantStart: 2, wantEnd: 4},
+		{spec: "2,+2", wantStart: 2, wantEnd: 3},
+		{spec: "3", wantStart: 3, wantEnd: 10},
+		{spec: "8,20", wantStart: 8, wantEnd: 10},
+		{spec: "0,2", wantErr: true},
+		{spec: "4,2", wantErr: true},
+		{spec: "x", wantErr: true},
+		{spec: "11,12", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			start, end, err := parseLineRange(tt.spec, 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLineRange(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && (start != tt.wantStart || end != tt.wantEnd) {
+				t.Errorf("parseLineRange(%q) = %d,%d, want %d,%d", tt.spec, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+// internal/bundle.go
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A bundle carries history between repositories that cannot reach each
+// other, as one file:
+//
+//	# gitter bundle v1
+//	-<hash> <subject>      a commit the receiving repository must have
+//	<hash> <ref>           a ref the bundle brings, refs/heads/<branch> or HEAD
+//	                       (empty line)
+//	<pack>
+const bundleSignature = "# gitter bundle v1"
+
+// bundleSuffix is the usual extension of bundle files, dropped when naming a
+// clone of one
+const bundleSuffix = ".bundle"
+
+// bundleRef is a ref recorded in a bundle
+type bundleRef struct {
+	name string
+	hash string
+}
+
+// bundle is a bundle file read into memory
+type bundle struct {
+	prerequisites []string
+	refs          []bundleRef
+	pack          []byte
+}
+
+// isBundle reports whether path is a bundle file
+func isBundle(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	line, _ := bufio.NewReader(file).ReadString('\n')
+	return strings.TrimSpace(line) == bundleSignature
+}
+
+// readBundle loads a bundle and checks its header; the pack is checked when
+// it is unpacked
+func readBundle(path string) (*bundle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	end := bytes.Index(data, []byte("\n\n"))
+	if end < 0 || !bytes.HasPrefix(data, []byte(bundleSignature+"\n")) {
+		return nil, fmt.Errorf("'%s' does not look like a gitter bundle file", path)
+	}
+
+	b := &bundle{pack: data[end+2:]}
+	header := strings.Split(string(data[len(bundleSignature)+1:end]), "\n")
+	for _, line := range header {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "-") {
+			hash := strings.SplitN(line[1:], " ", 2)[0]
+			if !isHash(hash) {
+				return nil, fmt.Errorf("'%s' has a malformed prerequisite: %q", path, line)
+			}
+			b.prerequisites = append(b.prerequisites, hash)
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || !isHash(fields[0]) {
+			return nil, fmt.Errorf("'%s' has a malformed ref: %q", path, line)
+		}
+		b.refs = append(b.refs, bundleRef{name: fields[1], hash: fields[0]})
+	}
+	return b, nil
+}
+
+// missingPrerequisites returns the prerequisites repo does not have
+func (b *bundle) missingPrerequisites(repo *Repository) []string {
+	var missing []string
+	for _, hash := range b.prerequisites {
+		if !objectExists(repo, hash) {
+			missing = append(missing, hash)
+		}
+	}
+	return missing
+}
+
+// BundleCreateOptions configures BundleCreate
+type BundleCreateOptions struct {
+	All bool // bundle every branch and HEAD
+}
+
+// BundleCreate writes the history selected by revs to file, with a ref for
+// every branch or HEAD named. A rev may exclude history with ^<rev> or
+// <from>..<to>, making the bundle depend on the excluded commits.
+func BundleCreate(file string, revs []string, opts BundleCreateOptions) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	var refs []bundleRef
+	var excluded []string
+	addRef := func(rev string) error {
+		name, hash, err := bundleRefName(repo, rev)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			if ref.name == name {
+				return nil
+			}
+		}
+		refs = append(refs, bundleRef{name: name, hash: hash})
+		return nil
+	}
+	exclude := func(rev string) error {
+		hash, err := resolveRevision(repo, rev)
+		if err != nil {
+			return err
+		}
+		excluded = append(excluded, hash)
+		return nil
+	}
+
+	if opts.All {
+		branches, err := listBranches(repo)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(branches))
+		for name := range branches {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := addRef(name); err != nil {
+				return err
+			}
+		}
+		if _, err := resolveRevision(repo, HEAD_FILE); err == nil {
+			if err := addRef(HEAD_FILE); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rev := range revs {
+		switch {
+		case strings.HasPrefix(rev, "^"):
+			if err := exclude(rev[1:]); err != nil {
+				return err
+			}
+		case strings.Contains(rev, ".."):
+			parts := strings.SplitN(rev, "..", 2)
+			from, to := parts[0], parts[1]
+			if from == "" {
+				from = HEAD_FILE
+			}
+			if to == "" {
+				to = HEAD_FILE
+			}
+			if err := exclude(from); err != nil {
+				return err
+			}
+			if err := addRef(to); err != nil {
+				return err
+			}
+		default:
+			if err := addRef(rev); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Commits the bundle stops at must already be on the receiving side
+	known, err := knownCommits(repo, excluded)
+	if err != nil {
+		return err
+	}
+	var prerequisites, wants []string
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		wants = append(wants, ref.hash)
+		for hash := ref.hash; hash != ""; {
+			if known[hash] {
+				if !seen[hash] {
+					seen[hash] = true
+					prerequisites = append(prerequisites, hash)
+				}
+				break
+			}
+			commit, err := readCommit(repo, hash)
+			if err != nil {
+				return err
+			}
+			hash = commit.Parent
+		}
+	}
+	var bundled []bundleRef
+	for _, ref := range refs {
+		if !known[ref.hash] {
+			bundled = append(bundled, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("no branch or HEAD given to bundle")
+	}
+	if len(bundled) == 0 {
+		return fmt.Errorf("refusing to create empty bundle")
+	}
+
+	response, pack, err := uploadPack(repo, uploadRequest{Wants: wants, Haves: excluded})
+	if err != nil {
+		return err
+	}
+	if len(response.Shallow) > 0 {
+		return fmt.Errorf("cannot bundle history this shallow clone does not have; exclude it with ^<commit>")
+	}
+
+	var out bytes.Buffer
+	out.WriteString(bundleSignature + "\n")
+	for _, hash := range prerequisites {
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&out, "-%s %s\n", hash, commitSubject(commit.Message))
+	}
+	for _, ref := range bundled {
+		fmt.Fprintf(&out, "%s %s\n", ref.hash, ref.name)
+	}
+	out.WriteString("\n")
+	out.Write(pack)
+	if err := writeFileAtomic(file, out.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	hashes, _, err := decodePack(pack)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Bundled %d objects and %d refs into %s\n", len(hashes), len(bundled), file)
+	return nil
+}
+
+// bundleRefName turns a rev given to BundleCreate into the ref the bundle
+// records: HEAD, or refs/heads/<branch>
+func bundleRefName(repo *Repository, rev string) (string, string, error) {
+	name := rev
+	if name != HEAD_FILE && !strings.HasPrefix(name, branchRef("")) {
+		name = branchRef(name)
+	}
+	if name != HEAD_FILE && !validBranchName(strings.TrimPrefix(name, branchRef(""))) {
+		return "", "", fmt.Errorf("'%s' does not name a branch or HEAD; a bundle needs refs to fetch", rev)
+	}
+	hash, err := resolveRevision(repo, name)
+	if err != nil || hash == "" {
+		return "", "", fmt.Errorf("'%s' does not name a branch or HEAD; a bundle needs refs to fetch", rev)
+	}
+	return name, hash, nil
+}
+
+// BundleVerify checks that a bundle is intact and that the current
+// repository has the commits it needs, and lists its refs
+func BundleVerify(file string) error {
+	b, err := readBundle(file)
+	if err != nil {
+		return err
+	}
+	if _, _, err := decodePack(b.pack); err != nil {
+		return fmt.Errorf("'%s' is damaged: %v", file, err)
+	}
+
+	fmt.Printf("The bundle contains %s:\n", plural(len(b.refs), "this ref", "these %d refs"))
+	for _, ref := range b.refs {
+		fmt.Printf("%s %s\n", ref.hash, ref.name)
+	}
+	if len(b.prerequisites) == 0 {
+		fmt.Println("The bundle records a complete history.")
+	} else {
+		fmt.Printf("The bundle requires %s:\n", plural(len(b.prerequisites), "this ref", "these %d refs"))
+		for _, hash := range b.prerequisites {
+			fmt.Println(hash)
+		}
+		repo, err := FindGitterRepo()
+		if err != nil {
+			return err
+		}
+		if missing := b.missingPrerequisites(repo); len(missing) > 0 {
+			return fmt.Errorf("repository lacks these prerequisite commits: %s", strings.Join(missing, ", "))
+		}
+	}
+	fmt.Printf("%s is okay\n", file)
+	return nil
+}
+
+// plural picks the singular text for one and formats the plural with n
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return fmt.Sprintf(many, n)
+}
+
+// bundleTransport reads a bundle file as if it were a remote that can only
+// be fetched from
+type bundleTransport struct {
+	path   string
+	bundle *bundle
+}
+
+func openBundle(path string) (*bundleTransport, error) {
+	b, err := readBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	return &bundleTransport{path: path, bundle: b}, nil
+}
+
+// refs offers the bundle's branches. HEAD is attached to the branch it
+// points at when there is one, so clones check out a branch.
+func (t *bundleTransport) refs(forPush bool) (remoteRefs, error) {
+	refs := remoteRefs{Branches: make(map[string]string)}
+	var head string
+	for _, ref := range t.bundle.refs {
+		if ref.name == HEAD_FILE {
+			head = ref.hash
+		} else {
+			refs.Branches[strings.TrimPrefix(ref.name, branchRef(""))] = ref.hash
+		}
+	}
+
+	names := make([]string, 0, len(refs.Branches))
+	for name := range refs.Branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if refs.Branches[name] == head || (head == "" && (name == "main" || len(names) == 1)) {
+			refs.Head = headRefPrefix + branchRef(name)
+			return refs, nil
+		}
+	}
+	refs.Head = head
+	return refs, nil
+}
+
+// fetch unpacks the whole bundle, whichever commits are wanted
+func (t *bundleTransport) fetch(repo *Repository, request uploadRequest) (int, error) {
+	if len(request.LFS) > 0 {
+		return 0, fmt.Errorf("'%s' is a bundle, which carries no large files", t.path)
+	}
+	if request.Depth > 0 || request.Filter != "" || len(request.Blobs) > 0 {
+		return 0, fmt.Errorf("'%s' is a bundle, which can only be fetched from whole", t.path)
+	}
+	if missing := t.bundle.missingPrerequisites(repo); len(missing) > 0 {
+		return 0, fmt.Errorf("repository lacks these prerequisite commits: %s", strings.Join(missing, ", "))
+	}
+	n, err := unpackObjects(repo, t.bundle.pack)
+	if err != nil {
+		return n, fmt.Errorf("'%s' is damaged: %v", t.path, err)
+	}
+	return n, nil
+}
+
+func (t *bundleTransport) push(repo *Repository, ref, oldHash, newHash string) error {
+	return fmt.Errorf("cannot push to a bundle; write a new one with 'gitter bundle create'")
+}
+
+func (t *bundleTransport) close() {}
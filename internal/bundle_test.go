@@ -0,0 +1,258 @@
+// internal/bundle_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupBundleSource creates a repository src with two commits on main and
+// leaves the test in it
+func setupBundleSource(t *testing.T) (string, func()) {
+	t.Helper()
+	top, cleanup := setupTestRepo(t)
+	top, _ = filepath.EvalSymlinks(top)
+	src := filepath.Join(top, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(src); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "a.txt", "a\n", "Add a.txt")
+	commitFile(t, "b.txt", "b\n", "Add b.txt")
+	return top, cleanup
+}
+
+func TestBundleCreateAndClone(t *testing.T) {
+	top, cleanup := setupBundleSource(t)
+	defer cleanup()
+	head, _ := GetCurrentHead()
+	file := filepath.Join(top, "project.bundle")
+
+	output := captureOutput(t, func() {
+		if err := BundleCreate(file, []string{"main"}, BundleCreateOptions{}); err != nil {
+			t.Fatalf("BundleCreate() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "and 1 refs into "+file) {
+		t.Errorf("BundleCreate() output = %q", output)
+	}
+
+	output = captureOutput(t, func() {
+		if err := BundleVerify(file); err != nil {
+			t.Fatalf("BundleVerify() error = %v", err)
+		}
+	})
+	for _, want := range []string{
+		"The bundle contains this ref:\n" + head + " refs/heads/main\n",
+		"The bundle records a complete history.",
+		file + " is okay",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("BundleVerify() output = %q, want %q", output, want)
+		}
+	}
+
+	// The clone is named after the file without .bundle and checks out main
+	if err := os.Chdir(top); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		if err := Clone("project.bundle", "", CloneOptions{}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+	inDir(t, filepath.Join(top, "project"), func() {
+		repo, _ := FindGitterRepo()
+		if branch, _ := currentBranch(repo); branch != "main" {
+			t.Errorf("clone is on %q, want main", branch)
+		}
+		if got, _ := GetCurrentHead(); got != head {
+			t.Errorf("clone HEAD = %s, want %s", got, head)
+		}
+		if got := readWorkingFile(t, "b.txt"); got != "b\n" {
+			t.Errorf("b.txt = %q in the clone", got)
+		}
+
+		// A bundle is read-only
+		commitFile(t, "d.txt", "d\n", "Add d.txt")
+		var err error
+		captureOutput(t, func() { err = Push("", "", PushOptions{}) })
+		if err == nil || !strings.Contains(err.Error(), "cannot push to a bundle") {
+			t.Errorf("Push() to a bundle error = %v", err)
+		}
+	})
+}
+
+func TestBundleIncremental(t *testing.T) {
+	top, cleanup := setupBundleSource(t)
+	defer cleanup()
+	base, _ := GetCurrentHead()
+	captureOutput(t, func() {
+		if err := BundleCreate(filepath.Join(top, "full.bundle"), []string{"main"}, BundleCreateOptions{}); err != nil {
+			t.Fatalf("BundleCreate() error = %v", err)
+		}
+	})
+	tip := commitFile(t, "c.txt", "c\n", "Add c.txt")
+
+	update := filepath.Join(top, "update.bundle")
+	captureOutput(t, func() {
+		if err := BundleCreate(update, []string{base[:7] + "..main"}, BundleCreateOptions{}); err != nil {
+			t.Fatalf("BundleCreate() error = %v", err)
+		}
+	})
+	b, err := readBundle(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.prerequisites) != 1 || b.prerequisites[0] != base {
+		t.Errorf("prerequisites = %v, want [%s]", b.prerequisites, base)
+	}
+	hashes, _, err := decodePack(b.pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 3 {
+		t.Errorf("update bundle has %d objects, want the new commit, tree and blob", len(hashes))
+	}
+
+	// A repository without the prerequisite cannot use it
+	empty := filepath.Join(top, "empty")
+	os.Mkdir(empty, 0755)
+	inDir(t, empty, func() {
+		if err := InitRepository(); err != nil {
+			t.Fatal(err)
+		}
+		var verifyErr error
+		captureOutput(t, func() { verifyErr = BundleVerify(update) })
+		if verifyErr == nil || !strings.Contains(verifyErr.Error(), "lacks these prerequisite commits: "+base) {
+			t.Errorf("BundleVerify() without the prerequisite error = %v", verifyErr)
+		}
+		if err := RemoteAdd("usb", update); err != nil {
+			t.Fatal(err)
+		}
+		captureOutput(t, func() { verifyErr = Fetch("usb") })
+		if verifyErr == nil || !strings.Contains(verifyErr.Error(), "lacks these prerequisite commits") {
+			t.Errorf("Fetch() without the prerequisite error = %v", verifyErr)
+		}
+	})
+
+	// A clone of the full bundle can pull the update
+	if err := os.Chdir(top); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		if err := Clone("full.bundle", "", CloneOptions{}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+	inDir(t, filepath.Join(top, "full"), func() {
+		output := captureOutput(t, func() {
+			if err := BundleVerify(update); err != nil {
+				t.Errorf("BundleVerify() error = %v", err)
+			}
+		})
+		if !strings.Contains(output, "The bundle requires this ref:\n"+base) {
+			t.Errorf("BundleVerify() output = %q", output)
+		}
+		if err := RemoteAdd("usb", update); err != nil {
+			t.Fatal(err)
+		}
+		captureOutput(t, func() {
+			if err := Pull("usb", "main", PullOptions{}); err != nil {
+				t.Fatalf("Pull() error = %v", err)
+			}
+		})
+		if got, _ := GetCurrentHead(); got != tip {
+			t.Errorf("HEAD after pull = %s, want %s", got, tip)
+		}
+		if got := readWorkingFile(t, "c.txt"); got != "c\n" {
+			t.Errorf("c.txt = %q after pull", got)
+		}
+	})
+}
+
+func TestBundleAll(t *testing.T) {
+	top, cleanup := setupBundleSource(t)
+	defer cleanup()
+	repo, _ := FindGitterRepo()
+	head, _ := GetCurrentHead()
+	if err := writeRef(repo, branchRef("topic"), head); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(top, "all.bundle")
+	captureOutput(t, func() {
+		if err := BundleCreate(file, nil, BundleCreateOptions{All: true}); err != nil {
+			t.Fatalf("BundleCreate() error = %v", err)
+		}
+	})
+	b, err := readBundle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ref := range b.refs {
+		names = append(names, ref.name)
+	}
+	if got := strings.Join(names, " "); got != "refs/heads/main refs/heads/topic HEAD" {
+		t.Errorf("bundled refs = %s", got)
+	}
+
+	// HEAD is attached to the branch it matches, preferring main
+	transport, err := openBundle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs, _ := transport.refs(false)
+	if branch := refs.headBranch(); branch != "main" {
+		t.Errorf("bundle HEAD branch = %q, want main", branch)
+	}
+}
+
+func TestBundleRefusals(t *testing.T) {
+	top, cleanup := setupBundleSource(t)
+	defer cleanup()
+	file := filepath.Join(top, "x.bundle")
+
+	tests := []struct {
+		revs []string
+		want string
+	}{
+		{[]string{"main..main"}, "refusing to create empty bundle"},
+		{[]string{"HEAD~1"}, "does not name a branch or HEAD"},
+		{[]string{"nosuch"}, "does not name a branch or HEAD"},
+		{[]string{"^main"}, "no branch or HEAD given"},
+	}
+	for _, tt := range tests {
+		err := BundleCreate(file, tt.revs, BundleCreateOptions{})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("BundleCreate(%v) error = %v, want %q", tt.revs, err, tt.want)
+		}
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("a refused bundle was written")
+	}
+
+	// Damage is caught by verify
+	captureOutput(t, func() {
+		if err := BundleCreate(file, []string{"main"}, BundleCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	data, _ := ioutil.ReadFile(file)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(file, data, 0644)
+	if err := BundleVerify(file); err == nil || !strings.Contains(err.Error(), "is damaged") {
+		t.Errorf("BundleVerify() of a damaged bundle error = %v", err)
+	}
+	if err := BundleVerify("a.txt"); err == nil || !strings.Contains(err.Error(), "does not look like a gitter bundle") {
+		t.Errorf("BundleVerify() of a plain file error = %v", err)
+	}
+}
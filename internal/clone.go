@@ -0,0 +1,169 @@
+// internal/clone.go
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CloneOptions configures Clone
+type CloneOptions struct {
+	Bare   bool   // make a repository without a working tree, to push to
+	Depth  int    // copy only this many commits of each branch's history; 0 for all
+	Filter string // leave out blobs, blob:none or blob:limit=<size>, to fetch when needed
+}
+
+// Clone copies the repository at url into dir, which defaults to the last
+// part of url. The copy gets url as its origin remote and checks out the
+// branch the source has checked out. A bare clone takes the branches as they
+// are and has no working tree. A shallow clone (opts.Depth) stops the
+// history it copies at a depth; a partial one (opts.Filter) leaves out blobs
+// and keeps origin to fetch them from when they are needed.
+func Clone(url, dir string, opts CloneOptions) error {
+	if opts.Depth < 0 {
+		return fmt.Errorf("depth %d is not a positive number", opts.Depth)
+	}
+	if _, err := parseBlobFilter(opts.Filter); err != nil {
+		return err
+	}
+	source, err := openTransport(nil, url)
+	if err != nil {
+		return err
+	}
+	defer source.close()
+	advertised, err := source.refs(false)
+	if err != nil {
+		return err
+	}
+	advertised.Head = advertised.checkedHead(url)
+	if !isNetworkURL(url) {
+		if url, err = filepath.Abs(url); err != nil {
+			return err
+		}
+	}
+
+	if dir == "" {
+		// /srv/app, /srv/app/.gitter, /srv/app.gitter, app.bundle,
+		// http://host/app and host:app all clone into app
+		location := strings.TrimSuffix(remotePath(url), "/")
+		dir = path.Base(location)
+		if dir == GITTER_DIR {
+			dir = path.Base(path.Dir(location))
+		}
+		dir = strings.TrimSuffix(strings.TrimSuffix(dir, GITTER_DIR), bundleSuffix)
+		if dir == "" || dir == "." || dir == "/" {
+			return fmt.Errorf("could not work out a directory name from '%s'; please name one", displayURL(url))
+		}
+		if opts.Bare {
+			dir += GITTER_DIR
+		}
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination path '%s' already exists and is not an empty directory", dir)
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+
+	if opts.Bare {
+		fmt.Printf("Cloning into bare repository '%s'...\n", dir)
+		return cloneBare(source, advertised, dir, url, opts)
+	}
+
+	fmt.Printf("Cloning into '%s'...\n", dir)
+	repo := &Repository{WorkingDir: dir, GitDir: filepath.Join(dir, GITTER_DIR)}
+	if err := initGitDir(repo.GitDir); err != nil {
+		return err
+	}
+	origin := Remote{Name: defaultRemote, URL: url, Filter: opts.Filter}
+	if err := saveRemotes(repo, []Remote{origin}); err != nil {
+		return err
+	}
+	branches, err := fetchRemote(repo, origin, opts.Depth)
+	if err != nil {
+		return err
+	}
+
+	// Check out what the source has checked out
+	var head string
+	if branch := advertised.headBranch(); branch != "" {
+		if err := attachHead(repo, branch); err != nil {
+			return err
+		}
+		head = branches[branch]
+		if head != "" {
+			if err := writeRef(repo, branchRef(branch), head); err != nil {
+				return err
+			}
+		}
+	} else if advertised.Head != "" {
+		head = advertised.Head
+		request := uploadRequest{Wants: []string{head}, Depth: opts.Depth, Filter: opts.Filter}
+		if _, err := source.fetch(repo, request); err != nil {
+			return err
+		}
+		if err := detachHead(repo, head); err != nil {
+			return err
+		}
+	}
+	if head == "" {
+		fmt.Println("warning: You appear to have cloned an empty repository.")
+		return nil
+	}
+	if err := appendReflog(repo, "", head, "user", "clone: from "+displayURL(url)); err != nil {
+		return err
+	}
+
+	// Index and working tree helpers find the repository from the working
+	// directory, so check out from inside the clone
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(cwd)
+	return checkoutCommit(repo, head)
+}
+
+// cloneBare copies every branch of source into a new bare repository at dir,
+// with the same HEAD
+func cloneBare(source transport, advertised remoteRefs, dir, url string, opts CloneOptions) error {
+	repo := &Repository{GitDir: dir}
+	if err := initGitDir(dir); err != nil {
+		return err
+	}
+	if opts.Filter != "" {
+		// Only a partial clone needs to know where it came from
+		if err := saveRemotes(repo, []Remote{{Name: defaultRemote, URL: url, Filter: opts.Filter}}); err != nil {
+			return err
+		}
+	}
+	branches := advertised.checkedBranches(url)
+	wants := make([]string, 0, len(branches)+1)
+	for _, hash := range branches {
+		wants = append(wants, hash)
+	}
+	branch := advertised.headBranch()
+	if branch == "" && advertised.Head != "" {
+		wants = append(wants, advertised.Head)
+	}
+	request := uploadRequest{Wants: wants, Depth: opts.Depth, Filter: opts.Filter}
+	if _, err := source.fetch(repo, request); err != nil {
+		return err
+	}
+	for name, hash := range branches {
+		if err := writeRef(repo, branchRef(name), hash); err != nil {
+			return err
+		}
+	}
+
+	if branch == "" {
+		return detachHead(repo, advertised.Head)
+	}
+	return attachHead(repo, branch)
+}
@@ -0,0 +1,308 @@
+// internal/clone_test.go
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClone(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	hub := filepath.Join(top, "hub.gitter")
+	pushed := hubBranch(t, top, "main")
+	if err := os.Chdir(top); err != nil {
+		t.Fatal(err)
+	}
+
+	// The directory name comes from the url
+	output := captureOutput(t, func() {
+		if err := Clone("hub.gitter", "", CloneOptions{}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Cloning into '"+filepath.Join(top, "hub")+"'...") {
+		t.Errorf("Clone() output = %q", output)
+	}
+	if cwd, _ := os.Getwd(); cwd != top {
+		t.Errorf("Clone() left the working directory in %s", cwd)
+	}
+
+	inDir(t, filepath.Join(top, "hub"), func() {
+		repo, _ := FindGitterRepo()
+		if branch, _ := currentBranch(repo); branch != "main" {
+			t.Errorf("clone is on %q, want main", branch)
+		}
+		if head, _ := GetCurrentHead(); head != pushed {
+			t.Errorf("clone HEAD = %s, want %s", head, pushed)
+		}
+		if got := readWorkingFile(t, "a.txt"); got != "a\n" {
+			t.Errorf("a.txt = %q in the clone", got)
+		}
+		if remote, found, _ := findRemote(repo, "origin"); !found || remote.URL != hub {
+			t.Errorf("origin = %+v, want %s", remote, hub)
+		}
+		if tracking, _ := readRef(repo, remoteTrackingRef("origin", "main")); tracking != pushed {
+			t.Errorf("origin/main = %s, want %s", tracking, pushed)
+		}
+		if entries, _ := readReflog(repo); len(entries) != 1 || entries[0].Message != "clone: from "+hub {
+			t.Errorf("reflog = %+v", entries)
+		}
+		output := captureOutput(t, func() { ShowStatus() })
+		if !strings.Contains(output, "nothing to commit") {
+			t.Errorf("status of a fresh clone = %q", output)
+		}
+	})
+
+	// A non-empty destination is refused
+	if err := Clone(hub, "alice", CloneOptions{}); err == nil || !strings.Contains(err.Error(), "not an empty directory") {
+		t.Errorf("Clone(into alice) error = %v", err)
+	}
+	if err := Clone(filepath.Join(top, "nowhere"), "", CloneOptions{}); err == nil {
+		t.Errorf("Clone(missing) succeeded")
+	}
+}
+
+func TestCloneBare(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	pushed := hubBranch(t, top, "main")
+
+	captureOutput(t, func() {
+		if err := Clone(".", filepath.Join(top, "mirror.gitter"), CloneOptions{Bare: true}); err != nil {
+			t.Fatalf("Clone(bare) error = %v", err)
+		}
+	})
+	mirror := filepath.Join(top, "mirror.gitter")
+	if !isBareRepository(mirror) {
+		t.Fatalf("%s is not a bare repository", mirror)
+	}
+	if _, err := os.Stat(filepath.Join(mirror, GITTER_DIR)); err == nil {
+		t.Errorf("a bare clone has a %s directory", GITTER_DIR)
+	}
+	if got := hubBranch(t, top, "main"); got != pushed {
+		t.Errorf("hub main = %s", got)
+	}
+	repo := &Repository{GitDir: mirror}
+	if hash, _ := readRef(repo, branchRef("main")); hash != pushed {
+		t.Errorf("mirror main = %s, want %s", hash, pushed)
+	}
+	if branch, _ := currentBranch(repo); branch != "main" {
+		t.Errorf("mirror HEAD is on %q, want main", branch)
+	}
+
+	// It can be cloned in turn
+	if err := os.Chdir(top); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		if err := Clone("mirror.gitter", "", CloneOptions{}); err != nil {
+			t.Fatalf("Clone(mirror) error = %v", err)
+		}
+	})
+	if got := readWorkingFile(t, filepath.Join(top, "mirror", "a.txt")); got != "a\n" {
+		t.Errorf("a.txt = %q in a clone of the mirror", got)
+	}
+}
+
+func TestCloneEmpty(t *testing.T) {
+	top, cleanup := setupTestRepo(t)
+	defer cleanup()
+	if err := InitBareRepository(filepath.Join(top, "empty.gitter")); err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureOutput(t, func() {
+		if err := Clone("empty.gitter", "", CloneOptions{}); err != nil {
+			t.Errorf("Clone(empty) error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "You appear to have cloned an empty repository.") {
+		t.Errorf("Clone(empty) output = %q", output)
+	}
+
+	// The first commit can be pushed back
+	inDir(t, filepath.Join(top, "empty"), func() {
+		captureOutput(t, func() {
+			head := commitFile(t, "a.txt", "a\n", "Add a.txt")
+			if err := Push("", "", PushOptions{}); err != nil {
+				t.Fatalf("Push() error = %v", err)
+			}
+			empty := &Repository{GitDir: filepath.Join(top, "empty.gitter")}
+			if got, _ := readRef(empty, branchRef("main")); got != head {
+				t.Errorf("empty.gitter main = %q, want %s", got, head)
+			}
+		})
+	})
+}
+
+func TestCloneRefusesUnsafePaths(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	hub := &Repository{GitDir: filepath.Join(top, "hub.gitter")}
+	pushed := hubBranch(t, top, "main")
+	blob, err := writeBlob(hub, []byte("#!/bin/sh\necho owned\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, path := range []string{"../escape.txt", filepath.Join(top, "abs.txt"), ".gitter/hooks/post-checkout", "sub/../../escape.txt"} {
+		entries := []IndexEntry{{FilePath: path, Hash: blob, Modified: true, FileMode: MODE_EXECUTABLE}}
+		if err := writeRef(hub, branchRef("main"), craftCommit(t, hub, pushed, entries)); err != nil {
+			t.Fatal(err)
+		}
+		dest := filepath.Join(top, "victim", string(rune('a'+i)))
+		captureOutput(t, func() {
+			if err := Clone(hub.GitDir, dest, CloneOptions{}); err == nil || !strings.Contains(err.Error(), "unsafe path") {
+				t.Errorf("Clone() of a tree naming %s error = %v", path, err)
+			}
+		})
+	}
+	for _, path := range []string{
+		filepath.Join(top, "escape.txt"),
+		filepath.Join(top, "victim", "escape.txt"),
+		filepath.Join(top, "abs.txt"),
+		filepath.Join(top, "victim", "c", GITTER_DIR, HOOKS_DIR, "post-checkout"),
+	} {
+		if _, err := os.Lstat(path); err == nil {
+			t.Errorf("clone wrote %s", path)
+		}
+	}
+}
+
+func TestCloneRefusesWritingThroughSymlinks(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	hub := &Repository{GitDir: filepath.Join(top, "hub.gitter")}
+	pushed := hubBranch(t, top, "main")
+	outside := filepath.Join(top, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link, err := writeBlob(hub, []byte(outside))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := writeBlob(hub, []byte("owned\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	symlink := IndexEntry{FilePath: "evil", Hash: link, Modified: true, FileMode: MODE_SYMLINK}
+	below := IndexEntry{FilePath: "evil/pwn", Hash: blob, Modified: true}
+
+	// The symlink and the file below it in one tree
+	if err := writeRef(hub, branchRef("main"), craftCommit(t, hub, pushed, []IndexEntry{symlink, below})); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		if err := Clone(hub.GitDir, filepath.Join(top, "victim", "a"), CloneOptions{}); err == nil || !strings.Contains(err.Error(), "below a symlink") {
+			t.Errorf("Clone() of a tree with a file below a symlink error = %v", err)
+		}
+	})
+
+	// Or one commit after the other
+	first := craftCommit(t, hub, pushed, []IndexEntry{symlink})
+	if err := writeRef(hub, branchRef("main"), craftCommit(t, hub, first, []IndexEntry{below})); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		if err := Clone(hub.GitDir, filepath.Join(top, "victim", "b"), CloneOptions{}); err == nil || !strings.Contains(err.Error(), "through the symlink") {
+			t.Errorf("Clone() of a file below an earlier symlink error = %v", err)
+		}
+	})
+
+	if _, err := os.Lstat(filepath.Join(outside, "pwn")); err == nil {
+		t.Errorf("clone wrote through the symlink into %s", outside)
+	}
+}
+
+// craftCommit stores a commit on top of parent whose tree holds entries
+// exactly as given, as another repository might send
+func craftCommit(t *testing.T, repo *Repository, parent string, entries []IndexEntry) string {
+	t.Helper()
+	treeData, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := Commit{Author: "mallory", Date: time.Now(), Message: "Crafted", Parent: parent, TreeHash: CalculateHash(string(treeData))}
+	commitData, _ := json.Marshal(commit)
+	commit.Hash = CalculateHash(string(commitData))
+	commitData, _ = json.Marshal(commit)
+	if err := writeObject(repo, commit.TreeHash, treeData); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeObject(repo, commit.Hash, commitData); err != nil {
+		t.Fatal(err)
+	}
+	return commit.Hash
+}
+
+// serveAdvertising serves the hub over HTTP, but advertises refs instead of
+// the hub's own
+func serveAdvertising(t *testing.T, top string, refs remoteRefs) *httptest.Server {
+	t.Helper()
+	hub := newServer(&Repository{GitDir: filepath.Join(top, "hub.gitter")}, ServeOptions{})
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/info/refs" {
+			json.NewEncoder(w).Encode(refs)
+			return
+		}
+		hub.ServeHTTP(w, r)
+	}))
+}
+
+func TestCloneIgnoresInvalidAdvertisement(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	pushed := hubBranch(t, top, "main")
+
+	// A HEAD naming a path outside refs/heads is not attached or written
+	srv := serveAdvertising(t, top, remoteRefs{
+		Head:     headRefPrefix + branchRef("../../hooks/post-checkout"),
+		Branches: map[string]string{"main": pushed},
+	})
+	defer srv.Close()
+	bob := filepath.Join(top, "bob")
+	captureOutput(t, func() {
+		if err := Clone(srv.URL+"/", bob, CloneOptions{}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+	if _, err := os.Lstat(filepath.Join(bob, GITTER_DIR, HOOKS_DIR, "post-checkout")); err == nil {
+		t.Errorf("clone wrote the advertised HEAD branch into hooks")
+	}
+	if head, _ := ioutil.ReadFile(filepath.Join(bob, GITTER_DIR, HEAD_FILE)); strings.Contains(string(head), "..") {
+		t.Errorf("clone HEAD = %q", head)
+	}
+
+	// Nor is a branch in a bare clone
+	bad := remoteRefs{
+		Head:     headRefPrefix + branchRef("main"),
+		Branches: map[string]string{"main": pushed, "../../../escape": pushed, "side": "not a hash"},
+	}
+	srvBare := serveAdvertising(t, top, bad)
+	defer srvBare.Close()
+	bare := filepath.Join(top, "mirror", "repo.gitter")
+	captureOutput(t, func() {
+		if err := Clone(srvBare.URL+"/", bare, CloneOptions{Bare: true}); err != nil {
+			t.Fatalf("Clone(bare) error = %v", err)
+		}
+	})
+	if _, err := os.Lstat(filepath.Join(top, "mirror", "escape")); err == nil {
+		t.Errorf("bare clone wrote a branch outside the repository")
+	}
+	mirror := &Repository{GitDir: bare}
+	if got, _ := readRef(mirror, branchRef("main")); got != pushed {
+		t.Errorf("bare clone main = %q, want %s", got, pushed)
+	}
+	if got, _ := readRef(mirror, branchRef("side")); got != "" {
+		t.Errorf("bare clone side = %q, want none", got)
+	}
+}
@@ -0,0 +1,184 @@
+// internal/commitmsg.go
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// COMMIT_MSG_FILE holds the message of the commit being made, for hooks and
+// the editor
+const COMMIT_MSG_FILE = "COMMIT_EDITMSG"
+
+// commitTemplateEnv names a file whose contents start every message written
+// in the editor, unless commit is given a template of its own
+const commitTemplateEnv = "GITTER_COMMIT_TEMPLATE"
+
+// commitMessage works out the message of a new commit from -m, -F or the
+// editor, which starts from the message of the amended commit if there is
+// one. The message is written to COMMIT_EDITMSG, where the
+// prepare-commit-msg hook, the editor and, unless opts.NoVerify is set, the
+// commit-msg hook may change it before it is read back and cleaned up.
+func commitMessage(repo *Repository, index []IndexEntry, message string, opts CommitOptions, amended *Commit) (string, error) {
+	var source, template, previous string
+	useEditor := false
+	switch {
+	case message != "" && opts.MessageFile != "":
+		return "", fmt.Errorf("options -m and -F cannot be used together")
+	case opts.MessageFile != "":
+		data, err := readMessageFile(opts.MessageFile)
+		if err != nil {
+			return "", err
+		}
+		message, source = string(data), "message"
+	case message != "":
+		source = "message"
+	case amended != nil && opts.NoEdit:
+		message, source = amended.Message, "commit"
+	case amended != nil:
+		useEditor = true
+		previous, source = amended.Message+"\n", "commit"
+	default:
+		useEditor = true
+		var err error
+		if template, err = loadCommitTemplate(opts.Template); err != nil {
+			return "", err
+		}
+		if template != "" {
+			source = "template"
+		}
+	}
+
+	initial := strings.TrimRight(message, "\n") + "\n"
+	if useEditor {
+		summary, err := commitStatusComment(repo, index)
+		if err != nil {
+			return "", err
+		}
+		initial = template + previous
+		if template != "" && !strings.HasSuffix(template, "\n") {
+			initial += "\n"
+		}
+		initial += "\n" + commitMessageHelp + summary
+	}
+
+	msgPath := filepath.Join(repo.GitDir, COMMIT_MSG_FILE)
+	if err := ioutil.WriteFile(msgPath, []byte(initial), 0644); err != nil {
+		return "", err
+	}
+
+	args := []string{msgPath}
+	if source != "" {
+		args = append(args, source)
+	}
+	if source == "commit" {
+		args = append(args, amended.Hash)
+	}
+	if err := runHook(repo, hookPrepareCommitMsg, "", args...); err != nil {
+		return "", err
+	}
+	if useEditor {
+		if err := launchEditor(msgPath); err != nil {
+			return "", err
+		}
+	}
+	if !opts.NoVerify {
+		if err := runHook(repo, hookCommitMsg, "", msgPath); err != nil {
+			return "", err
+		}
+	}
+
+	data, err := ioutil.ReadFile(msgPath)
+	if err != nil {
+		return "", err
+	}
+
+	// Comments are only stripped from a message that went through the
+	// editor; a message given on the command line is kept as it is
+	if useEditor {
+		message = stripComments(string(data))
+	} else {
+		message = strings.TrimSpace(string(data))
+	}
+	if message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+	if template != "" && message == stripComments(template) {
+		return "", fmt.Errorf("aborting commit; you did not edit the message")
+	}
+	return message, nil
+}
+
+// readMessageFile reads a message given with -F, where "-" is stdin
+func readMessageFile(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read log file '%s': %v", path, err)
+	}
+	return data, nil
+}
+
+// loadCommitTemplate returns the contents of the commit template, which is
+// the given file or else the one named by $GITTER_COMMIT_TEMPLATE
+func loadCommitTemplate(path string) (string, error) {
+	if path == "" {
+		path = strings.TrimSpace(os.Getenv(commitTemplateEnv))
+	}
+	if path == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read commit message template '%s': %v", path, err)
+	}
+	return string(data), nil
+}
+
+// commitStatusComment describes what is about to be committed as '#'
+// comment lines for the editor
+func commitStatusComment(repo *Repository, index []IndexEntry) (string, error) {
+	report, err := collectStatus(repo, index)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("#\n")
+	branch, err := currentBranch(repo)
+	if err != nil {
+		return "", err
+	}
+	if branch == "" {
+		b.WriteString("# HEAD detached\n")
+	} else {
+		fmt.Fprintf(&b, "# On branch %s\n", branch)
+	}
+
+	section := func(title string, paths []string, label func(string) string) {
+		if len(paths) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "# %s:\n", title)
+		for _, path := range paths {
+			fmt.Fprintf(&b, "#\t%s\n", label(path))
+		}
+		b.WriteString("#\n")
+	}
+	section("Changes to be committed", report.Staged, func(path string) string {
+		return "modified:   " + path
+	})
+	section("Changes not staged for commit", report.NotStaged, func(path string) string {
+		if change, ok := report.ModeChanged[path]; ok {
+			return fmt.Sprintf("mode changed: %s (%s)", path, change)
+		}
+		return "modified:   " + path
+	})
+	section("Untracked files", report.Untracked, func(path string) string { return path })
+	return b.String(), nil
+}
@@ -0,0 +1,209 @@
+// internal/commitmsg_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCommitMessageSources(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test editor is a shell script")
+	}
+
+	tests := []struct {
+		name     string
+		message  string
+		opts     CommitOptions
+		file     string // contents of msg.txt, used with -F
+		template string // contents of template.txt, set as $GITTER_COMMIT_TEMPLATE
+		editor   string
+		wantMsg  string
+		wantErr  string
+	}{
+		{
+			name:    "message given",
+			message: "Subject\n\nBody",
+			editor:  "exit 1",
+			wantMsg: "Subject\n\nBody",
+		},
+		{
+			name:    "message kept with its hash lines",
+			message: "#123 fixed",
+			wantMsg: "#123 fixed",
+		},
+		{
+			name:    "message file",
+			opts:    CommitOptions{MessageFile: "msg.txt"},
+			file:    "From a file\n\n# not a comment here\n",
+			wantMsg: "From a file\n\n# not a comment here",
+		},
+		{
+			name:    "message and file together",
+			message: "Subject",
+			opts:    CommitOptions{MessageFile: "msg.txt"},
+			file:    "From a file\n",
+			wantErr: "-m and -F cannot be used together",
+		},
+		{
+			name:    "missing message file",
+			opts:    CommitOptions{MessageFile: "nope.txt"},
+			wantErr: "could not read log file",
+		},
+		{
+			name:    "editor with comments stripped",
+			editor:  `{ echo "Written in the editor"; echo; echo "# a comment"; cat "$1"; } > "$1.new" && mv "$1.new" "$1"`,
+			wantMsg: "Written in the editor",
+		},
+		{
+			name:    "editor left empty",
+			editor:  "true",
+			wantErr: "empty commit message",
+		},
+		{
+			name:     "template edited",
+			template: "Ticket: \n",
+			editor:   `sed -i.bak 's/^Ticket: $/Ticket: JIRA-7/' "$1"`,
+			wantMsg:  "Ticket: JIRA-7",
+		},
+		{
+			name:     "template not edited",
+			template: "Ticket: \n",
+			editor:   "true",
+			wantErr:  "you did not edit the message",
+		},
+		{
+			name:     "template given on the command line",
+			opts:     CommitOptions{Template: "other.txt"},
+			template: "unused\n",
+			editor:   `sed -i.bak 's/^Other$/Other edited/' "$1"`,
+			wantMsg:  "Other edited",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cleanup := setupTestRepo(t)
+			defer cleanup()
+
+			if err := InitRepository(); err != nil {
+				t.Fatal(err)
+			}
+			setEditor(t, tt.editor)
+			t.Setenv(commitTemplateEnv, "")
+			if tt.template != "" {
+				path, _ := filepath.Abs("template.txt")
+				if err := ioutil.WriteFile(path, []byte(tt.template), 0644); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv(commitTemplateEnv, path)
+			}
+			files := map[string]string{"a.txt": "a\n", "other.txt": "Other\n"}
+			if tt.file != "" {
+				files["msg.txt"] = tt.file
+			}
+			for path, content := range files {
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := AddFile("a.txt"); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			captureOutput(t, func() { err = CommitChanges(tt.message, tt.opts) })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("CommitChanges() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CommitChanges() error = %v", err)
+			}
+			if got := logMessages(t)[0]; got != tt.wantMsg {
+				t.Errorf("commit message = %q, want %q", got, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestCommitEditorShowsStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test editor is a shell script")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() { commitFile(t, "tracked.txt", "v1\n", "Add tracked.txt") })
+	for path, content := range map[string]string{"tracked.txt": "v2\n", "staged.txt": "s\n", "new.txt": "n\n"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AddFile("staged.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The editor keeps a copy of what it was shown and aborts the commit
+	seen := filepath.Join(t.TempDir(), "seen")
+	setEditor(t, `cp "$1" "`+seen+`"; : > "$1"`)
+	var err error
+	captureOutput(t, func() { err = CommitChanges("", CommitOptions{}) })
+	if err == nil || !strings.Contains(err.Error(), "empty commit message") {
+		t.Errorf("CommitChanges() error = %v, want an empty message", err)
+	}
+
+	data, err := ioutil.ReadFile(seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Please enter the commit message",
+		"# On branch main",
+		"# Changes to be committed:\n#\tmodified:   staged.txt",
+		"# Changes not staged for commit:\n#\tmodified:   tracked.txt",
+		"# Untracked files:\n#\tnew.txt",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("editor was shown %q, missing %q", data, want)
+		}
+	}
+}
+
+func TestCommitAmendEditor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test editor is a shell script")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	var head string
+	captureOutput(t, func() { head = commitFile(t, "a.txt", "a\n", "Fix teh parser") })
+
+	// The editor starts from the old message, and prepare-commit-msg learns
+	// which commit is being amended
+	writeHook(t, hookPrepareCommitMsg, `echo "$2 $3" > prepare-args`)
+	setEditor(t, `sed -i.bak 's/teh/the/' "$1"`)
+	var err error
+	captureOutput(t, func() { err = CommitChanges("", CommitOptions{Amend: true}) })
+	if err != nil {
+		t.Fatalf("CommitChanges(amend) error = %v", err)
+	}
+	if got := logMessages(t); len(got) != 1 || got[0] != "Fix the parser" {
+		t.Errorf("log = %q, want the reworded commit only", got)
+	}
+	if got, want := readWorkingFile(t, "prepare-args"), "commit "+head+"\n"; got != want {
+		t.Errorf("prepare-commit-msg got %q, want %q", got, want)
+	}
+}
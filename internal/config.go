@@ -0,0 +1,108 @@
+// internal/config.go
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CONFIG_FILE holds the repository's settings, a JSON object of dotted keys
+// such as filter.<driver>.clean
+const CONFIG_FILE = "config.json"
+
+// loadConfig returns the repository's settings, none when it has no config
+func loadConfig(repo *Repository) (map[string]string, error) {
+	config := make(map[string]string)
+	data, err := ioutil.ReadFile(filepath.Join(repo.GitDir, CONFIG_FILE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %v", CONFIG_FILE, err)
+	}
+	return config, nil
+}
+
+// saveConfig persists the settings, sorted by key
+func saveConfig(repo *Repository, config map[string]string) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(repo.GitDir, CONFIG_FILE), append(data, '\n'), 0644)
+}
+
+// validConfigKey reports whether key is a section and a name, with an
+// optional subsection between them, joined by dots
+func validConfigKey(key string) bool {
+	parts := strings.Split(key, ".")
+	if len(parts) < 2 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || strings.ContainsAny(part, " \t\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// ConfigOptions selects what Config does besides getting or setting a key
+type ConfigOptions struct {
+	List  bool // print every setting
+	Unset bool // remove the key
+}
+
+// Config prints the value of key, sets it when value is given, or lists or
+// removes settings as opts asks
+func Config(key, value string, opts ConfigOptions) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	config, err := loadConfig(repo)
+	if err != nil {
+		return err
+	}
+
+	if opts.List {
+		keys := make([]string, 0, len(config))
+		for k := range config {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s=%s\n", k, config[k])
+		}
+		return nil
+	}
+
+	if !validConfigKey(key) {
+		return fmt.Errorf("invalid key '%s'; use <section>.<name>", key)
+	}
+	_, exists := config[key]
+	switch {
+	case opts.Unset:
+		if !exists {
+			return fmt.Errorf("key '%s' is not set", key)
+		}
+		delete(config, key)
+		return saveConfig(repo, config)
+	case value != "":
+		config[key] = value
+		return saveConfig(repo, config)
+	case !exists:
+		return fmt.Errorf("key '%s' is not set", key)
+	default:
+		fmt.Println(config[key])
+		return nil
+	}
+}
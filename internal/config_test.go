@@ -0,0 +1,44 @@
+// internal/config_test.go
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Config("filter.kw.clean", "sed s/a/b/", ConfigOptions{}); err != nil {
+		t.Fatalf("Config(set) error = %v", err)
+	}
+	if err := Config("core.eol", "crlf", ConfigOptions{}); err != nil {
+		t.Fatalf("Config(set) error = %v", err)
+	}
+	if output := captureOutput(t, func() { Config("filter.kw.clean", "", ConfigOptions{}) }); output != "sed s/a/b/\n" {
+		t.Errorf("Config(get) output = %q", output)
+	}
+	output := captureOutput(t, func() { Config("", "", ConfigOptions{List: true}) })
+	if output != "core.eol=crlf\nfilter.kw.clean=sed s/a/b/\n" {
+		t.Errorf("Config(list) output = %q", output)
+	}
+
+	if err := Config("core.eol", "", ConfigOptions{Unset: true}); err != nil {
+		t.Fatalf("Config(unset) error = %v", err)
+	}
+	if err := Config("core.eol", "", ConfigOptions{}); err == nil || !strings.Contains(err.Error(), "not set") {
+		t.Errorf("Config(get unset key) error = %v", err)
+	}
+	if err := Config("core.eol", "", ConfigOptions{Unset: true}); err == nil {
+		t.Errorf("Config(unset twice) succeeded")
+	}
+	for _, key := range []string{"eol", "core.", ".eol", "core.e ol"} {
+		if err := Config(key, "x", ConfigOptions{}); err == nil {
+			t.Errorf("Config(%q) succeeded", key)
+		}
+	}
+}
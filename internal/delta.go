@@ -0,0 +1,188 @@
+// internal/delta.go
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Binary deltas describe a target buffer as a sequence of copies from a
+// source buffer and literal inserts. The encoding follows git's: two
+// uvarints with the source and target sizes, then instructions where a byte
+// with the high bit set is a copy (the low bits say which offset and size
+// bytes follow) and any other non-zero byte inserts that many literal bytes.
+const (
+	deltaBlockSize  = 16       // length of the blocks indexed in the source
+	deltaMaxInsert  = 0x7f     // literal bytes per insert instruction
+	deltaMaxCopy    = 0xffffff // bytes per copy instruction
+	deltaMaxOffsets = 8        // source offsets remembered per block
+)
+
+// createDelta computes a delta that turns source into target
+func createDelta(source, target []byte) []byte {
+	delta := binary.AppendUvarint(nil, uint64(len(source)))
+	delta = binary.AppendUvarint(delta, uint64(len(target)))
+
+	// Index the source in fixed blocks so matches can be found by lookup
+	blocks := make(map[string][]int)
+	for i := 0; i+deltaBlockSize <= len(source); i += deltaBlockSize {
+		key := string(source[i : i+deltaBlockSize])
+		if len(blocks[key]) < deltaMaxOffsets {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+
+	var insert []byte
+	flush := func() {
+		for len(insert) > 0 {
+			n := len(insert)
+			if n > deltaMaxInsert {
+				n = deltaMaxInsert
+			}
+			delta = append(delta, byte(n))
+			delta = append(delta, insert[:n]...)
+			insert = insert[n:]
+		}
+		insert = insert[:0]
+	}
+
+	for pos := 0; pos < len(target); {
+		bestOffset, bestLen := 0, 0
+		if pos+deltaBlockSize <= len(target) {
+			for _, offset := range blocks[string(target[pos:pos+deltaBlockSize])] {
+				length := 0
+				for offset+length < len(source) && pos+length < len(target) && source[offset+length] == target[pos+length] {
+					length++
+				}
+				if length > bestLen {
+					bestOffset, bestLen = offset, length
+				}
+			}
+		}
+
+		if bestLen < deltaBlockSize {
+			insert = append(insert, target[pos])
+			pos++
+			continue
+		}
+
+		// Grow the match backwards over bytes that were about to be inserted
+		for len(insert) > 0 && bestOffset > 0 && source[bestOffset-1] == insert[len(insert)-1] {
+			insert = insert[:len(insert)-1]
+			bestOffset--
+			bestLen++
+			pos--
+		}
+		flush()
+
+		for copied := 0; copied < bestLen; {
+			n := bestLen - copied
+			if n > deltaMaxCopy {
+				n = deltaMaxCopy
+			}
+			delta = appendCopy(delta, bestOffset+copied, n)
+			copied += n
+		}
+		pos += bestLen
+	}
+	flush()
+
+	return delta
+}
+
+// appendCopy encodes a copy instruction, leaving out zero offset and size bytes
+func appendCopy(delta []byte, offset, size int) []byte {
+	op := byte(0x80)
+	var args []byte
+	for i := 0; i < 4; i++ {
+		if b := byte(offset >> (8 * i)); b != 0 {
+			op |= 1 << i
+			args = append(args, b)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if b := byte(size >> (8 * i)); b != 0 {
+			op |= 1 << (4 + i)
+			args = append(args, b)
+		}
+	}
+	return append(append(delta, op), args...)
+}
+
+// applyDelta rebuilds a target buffer from its source and a delta
+func applyDelta(source, delta []byte) ([]byte, error) {
+	srcLen, n := binary.Uvarint(delta)
+	if n <= 0 || srcLen != uint64(len(source)) {
+		return nil, fmt.Errorf("delta does not match its base")
+	}
+	delta = delta[n:]
+	tgtLen, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, fmt.Errorf("corrupt delta header")
+	}
+	delta = delta[n:]
+
+	// Every instruction byte inserts at most one byte or copies at most the
+	// whole source, and the claimed size is only trusted as far as that
+	// bound: a target is grown as it is produced, not allocated up front
+	perByte := uint64(len(source))
+	if perByte < 1 {
+		perByte = 1
+	}
+	if tgtLen > uint64(len(delta))*perByte {
+		return nil, fmt.Errorf("delta claims %d bytes, more than it can produce", tgtLen)
+	}
+	capacity := uint64(len(source) + len(delta))
+	if tgtLen < capacity {
+		capacity = tgtLen
+	}
+	target := make([]byte, 0, capacity)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		if op&0x80 == 0 {
+			if op == 0 || int(op) > len(delta) {
+				return nil, fmt.Errorf("corrupt delta insert")
+			}
+			target = append(target, delta[:op]...)
+			delta = delta[op:]
+			continue
+		}
+
+		var offset, size int
+		for i := 0; i < 4; i++ {
+			if op&(1<<i) != 0 {
+				if len(delta) == 0 {
+					return nil, fmt.Errorf("corrupt delta copy")
+				}
+				offset |= int(delta[0]) << (8 * i)
+				delta = delta[1:]
+			}
+		}
+		for i := 0; i < 3; i++ {
+			if op&(1<<(4+i)) != 0 {
+				if len(delta) == 0 {
+					return nil, fmt.Errorf("corrupt delta copy")
+				}
+				size |= int(delta[0]) << (8 * i)
+				delta = delta[1:]
+			}
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > len(source) {
+			return nil, fmt.Errorf("delta copy out of range")
+		}
+		target = append(target, source[offset:offset+size]...)
+		if uint64(len(target)) > tgtLen {
+			return nil, fmt.Errorf("delta produced more than %d bytes", tgtLen)
+		}
+	}
+
+	if uint64(len(target)) != tgtLen {
+		return nil, fmt.Errorf("delta produced %d bytes, expected %d", len(target), tgtLen)
+	}
+	return target, nil
+}
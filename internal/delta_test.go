@@ -0,0 +1,98 @@
+// internal/delta_test.go
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	random := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(random)
+	edited := append(append(append([]byte{}, random[:50000]...), []byte("inserted in the middle")...), random[50100:]...)
+
+	lines := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 200)
+
+	tests := []struct {
+		name   string
+		source []byte
+		target []byte
+	}{
+		{name: "Empty", source: nil, target: nil},
+		{name: "From empty", source: nil, target: []byte("new file\n")},
+		{name: "To empty", source: []byte("old file\n"), target: nil},
+		{name: "Identical", source: []byte(lines), target: []byte(lines)},
+		{name: "Appended", source: []byte(lines), target: []byte(lines + "one more line\n")},
+		{name: "Prepended", source: []byte(lines), target: []byte("first line\n" + lines)},
+		{name: "Edited binary", source: random, target: edited},
+		{name: "Unrelated", source: []byte(lines), target: random[:5000]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := createDelta(tt.source, tt.target)
+			got, err := applyDelta(tt.source, delta)
+			if err != nil {
+				t.Fatalf("applyDelta() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.target) {
+				t.Errorf("applyDelta() produced %d bytes that differ from the %d byte target", len(got), len(tt.target))
+			}
+		})
+	}
+
+	// Small edits to a large buffer give a small delta
+	if delta := createDelta(random, edited); len(delta) > 200 {
+		t.Errorf("createDelta() for a small edit is %d bytes", len(delta))
+	}
+}
+
+func TestApplyDeltaRejectsBadInput(t *testing.T) {
+	source := []byte(strings.Repeat("abcdefghijklmnop", 4))
+	delta := createDelta(source, append(source, 'x'))
+
+	if _, err := applyDelta(source[1:], delta); err == nil {
+		t.Errorf("applyDelta() accepted a base of the wrong size")
+	}
+	if _, err := applyDelta(source, delta[:len(delta)-1]); err == nil {
+		t.Errorf("applyDelta() accepted a truncated delta")
+	}
+
+	// A target size the instructions cannot reach is refused before
+	// anything is allocated for it
+	huge := binary.AppendUvarint(nil, uint64(len(source)))
+	huge = binary.AppendUvarint(huge, 1<<62)
+	huge = append(huge, 0x90, byte(len(source)))
+	if _, err := applyDelta(source, huge); err == nil || !strings.Contains(err.Error(), "more than it can produce") {
+		t.Errorf("applyDelta() of an oversized target error = %v", err)
+	}
+
+	// As is a delta that produces more than it claims
+	short := binary.AppendUvarint(nil, uint64(len(source)))
+	short = binary.AppendUvarint(short, 4)
+	short = append(short, 0x90, byte(len(source)))
+	if _, err := applyDelta(source, short); err == nil {
+		t.Errorf("applyDelta() accepted a delta longer than its target")
+	}
+}
+
+func FuzzApplyDelta(f *testing.F) {
+	source := []byte(strings.Repeat("abcdefghijklmnop", 8))
+	f.Add(createDelta(source, append([]byte("new "), source...)))
+	f.Add(createDelta(source, source[10:90]))
+	f.Fuzz(func(t *testing.T, delta []byte) {
+		target, err := applyDelta(source, delta)
+		if err != nil {
+			return
+		}
+		// Whatever is accepted has the size the delta claims
+		_, n := binary.Uvarint(delta)
+		tgtLen, _ := binary.Uvarint(delta[n:])
+		if uint64(len(target)) != tgtLen {
+			t.Errorf("applyDelta() gave %d bytes, header says %d", len(target), tgtLen)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+// internal/editor.go
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultEditor is used when neither GITTER_EDITOR nor EDITOR is set
+const defaultEditor = "vi"
+
+// commitMessageHelp is appended to messages opened in the editor
+const commitMessageHelp = `# Please enter the commit message for your changes. Lines starting
+# with '#' will be ignored, and an empty message aborts the commit.
+`
+
+// editorCommand returns the configured editor command line
+func editorCommand() string {
+	for _, name := range []string{"GITTER_EDITOR", "VISUAL", "EDITOR"} {
+		if editor := strings.TrimSpace(os.Getenv(name)); editor != "" {
+			return editor
+		}
+	}
+	return defaultEditor
+}
+
+// launchEditor opens a file in the user's editor and waits for it to exit.
+// The editor is run through the shell so values like "code --wait" work.
+func launchEditor(path string) error {
+	editor := editorCommand()
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("there was a problem with the editor '%s': %v", editor, err)
+	}
+	return nil
+}
+
+// stripComments removes '#' comment lines and surrounding blank lines from
+// text written in the editor
+func stripComments(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t\r"))
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,183 @@
+// internal/fetch.go
+package internal
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PullOptions configures Pull
+type PullOptions struct {
+	Rebase bool // replay local commits on top of the fetched branch when they diverge
+}
+
+// Fetch downloads the branches of a remote, origin by default, and records
+// them as remote-tracking branches
+func Fetch(remoteName string) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	remote, found, err := resolveRemote(repo, remoteName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("'%s' is not a configured remote; add it with 'gitter remote add'", remote.Name)
+	}
+	_, err = fetchRemote(repo, remote, 0)
+	return err
+}
+
+// fetchRemote copies the history of every branch of a remote, depth commits
+// of it when depth is not 0, and points refs/remotes/<remote>/<branch> at
+// it. It returns the remote's branches.
+func fetchRemote(repo *Repository, remote Remote, depth int) (map[string]string, error) {
+	source, err := openTransport(repo, remote.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer source.close()
+	advertised, err := source.refs(false)
+	if err != nil {
+		return nil, err
+	}
+	branches := advertised.checkedBranches(remote.URL)
+
+	names := make([]string, 0, len(branches))
+	for name := range branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Ask for every branch that moved at once
+	old := make(map[string]string)
+	var changed, wants []string
+	for _, name := range names {
+		hash, err := readRef(repo, remoteTrackingRef(remote.Name, name))
+		if err != nil {
+			return nil, err
+		}
+		if hash == branches[name] {
+			continue
+		}
+		old[name] = hash
+		changed = append(changed, name)
+		wants = append(wants, branches[name])
+	}
+	if len(changed) == 0 {
+		return branches, nil
+	}
+	request := uploadRequest{Wants: wants, Depth: depth, Filter: remote.Filter}
+	if _, err := source.fetch(repo, request); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("From %s\n", displayURL(remote.URL))
+	for _, name := range changed {
+		hash := branches[name]
+		if err := writeRef(repo, remoteTrackingRef(remote.Name, name), hash); err != nil {
+			return nil, err
+		}
+
+		tracking := remote.Name + "/" + name
+		switch forward, err := isAncestor(repo, old[name], hash); {
+		case err != nil:
+			return nil, err
+		case old[name] == "":
+			fmt.Printf(" * [new branch]      %-10s -> %s\n", name, tracking)
+		case forward:
+			fmt.Printf("   %s..%s  %-10s -> %s\n", old[name][:7], hash[:7], name, tracking)
+		default:
+			fmt.Printf(" + %s...%s %-10s -> %s  (forced update)\n", old[name][:7], hash[:7], name, tracking)
+		}
+	}
+	return branches, nil
+}
+
+// Pull fetches a branch of a remote, by default the one named like the
+// current branch on origin, and brings the current branch up to date with
+// it. Commits have a single parent, so diverged histories are not merged:
+// they need opts.Rebase, which replays the local commits on top.
+func Pull(remoteName, branch string, opts PullOptions) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	remote, found, err := resolveRemote(repo, remoteName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("'%s' is not a configured remote; add it with 'gitter remote add'", remote.Name)
+	}
+
+	current, err := currentBranch(repo)
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		if current == "" {
+			return fmt.Errorf("you are not currently on a branch; name the branch to pull")
+		}
+		branch = current
+	}
+
+	branches, err := fetchRemote(repo, remote, 0)
+	if err != nil {
+		return err
+	}
+	upstream, ok := branches[branch]
+	if !ok {
+		return fmt.Errorf("couldn't find remote ref %s", branch)
+	}
+
+	head, err := GetCurrentHead()
+	if err != nil {
+		return err
+	}
+	if upToDate, err := isAncestor(repo, upstream, head); err != nil {
+		return err
+	} else if upToDate {
+		fmt.Println("Already up to date.")
+		return nil
+	}
+
+	// An unborn branch can always be fast-forwarded
+	forward := head == ""
+	if !forward {
+		if forward, err = isAncestor(repo, head, upstream); err != nil {
+			return err
+		}
+	}
+	if !forward {
+		if !opts.Rebase {
+			return fmt.Errorf("not possible to fast-forward: your branch and '%s/%s' have diverged; "+
+				"use 'gitter pull --rebase' to replay your commits on top of it", remote.Name, branch)
+		}
+		return Rebase(remoteTrackingRef(remote.Name, branch), RebaseOptions{})
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+	if dirty, err := hasLocalChanges(repo, index); err != nil {
+		return err
+	} else if dirty {
+		return fmt.Errorf("cannot pull: you have uncommitted changes, please commit them first")
+	}
+
+	if head == "" {
+		fmt.Printf("Fast-forward to %s\n", upstream[:7])
+	} else {
+		fmt.Printf("Updating %s..%s\nFast-forward\n", head[:7], upstream[:7])
+	}
+	if err := checkoutCommit(repo, upstream); err != nil {
+		return err
+	}
+	if err := UpdateHead(upstream); err != nil {
+		return err
+	}
+	return appendReflog(repo, head, upstream, "user", fmt.Sprintf("pull %s %s: Fast-forward", remote.Name, branch))
+}
@@ -0,0 +1,199 @@
+// internal/fetch_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	alice := filepath.Join(top, "alice")
+
+	cloneHub(t, top, "bob")
+	bob, _ := FindGitterRepo()
+	before, _ := GetCurrentHead()
+
+	var pushed string
+	inDir(t, alice, func() {
+		captureOutput(t, func() {
+			pushed = commitFile(t, "a.txt", "a2\n", "Change a.txt")
+			if err := Push("", "", PushOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := Push("", "main:topic", PushOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+
+	output := captureOutput(t, func() {
+		if err := Fetch(""); err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+	})
+	for _, want := range []string{"From " + filepath.Join(top, "hub.gitter"), " * [new branch]      topic      -> origin/topic", "main       -> origin/main"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Fetch() output = %q, want %q", output, want)
+		}
+	}
+	for _, branch := range []string{"main", "topic"} {
+		if got, _ := readRef(bob, remoteTrackingRef("origin", branch)); got != pushed {
+			t.Errorf("origin/%s = %s, want %s", branch, got, pushed)
+		}
+	}
+	if head, _ := GetCurrentHead(); head != before {
+		t.Errorf("Fetch() moved HEAD to %s", head)
+	}
+	if !objectExists(bob, pushed) {
+		t.Errorf("Fetch() did not copy %s", pushed)
+	}
+	if hash, err := ResolveRevision("origin/main"); err != nil || hash != pushed {
+		t.Errorf("ResolveRevision(origin/main) = %s, %v", hash, err)
+	}
+
+	// Nothing new prints nothing
+	output = captureOutput(t, func() { Fetch("origin") })
+	if output != "" {
+		t.Errorf("Fetch(again) output = %q", output)
+	}
+	if err := Fetch(top); err == nil || !strings.Contains(err.Error(), "not a configured remote") {
+		t.Errorf("Fetch(path) error = %v", err)
+	}
+}
+
+func TestFetchIgnoresInvalidBranches(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	repo, _ := FindGitterRepo()
+	pushed := hubBranch(t, top, "main")
+	srv := serveAdvertising(t, top, remoteRefs{Branches: map[string]string{
+		"main":               pushed,
+		"../../HEAD":         pushed,
+		"../../../hooks/x":   pushed,
+		"../../../../escape": pushed,
+	}})
+	defer srv.Close()
+	if err := RemoteAdd("evil", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	head, _ := ioutil.ReadFile(filepath.Join(repo.GitDir, HEAD_FILE))
+
+	captureOutput(t, func() {
+		if err := Fetch("evil"); err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+	})
+	if got, _ := readRef(repo, remoteTrackingRef("evil", "main")); got != pushed {
+		t.Errorf("evil/main = %q, want %s", got, pushed)
+	}
+	if after, _ := ioutil.ReadFile(filepath.Join(repo.GitDir, HEAD_FILE)); string(after) != string(head) {
+		t.Errorf("fetch rewrote HEAD to %q", after)
+	}
+	for _, path := range []string{
+		filepath.Join(repo.GitDir, HOOKS_DIR, "x"),
+		filepath.Join(repo.WorkingDir, "escape"),
+	} {
+		if _, err := os.Lstat(path); err == nil {
+			t.Errorf("fetch wrote %s", path)
+		}
+	}
+}
+
+func TestPull(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	alice := filepath.Join(top, "alice")
+
+	cloneHub(t, top, "bob")
+	bob := filepath.Join(top, "bob")
+	output := captureOutput(t, func() {
+		if err := Pull("", "", PullOptions{}); err != nil {
+			t.Errorf("Pull() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Already up to date.") {
+		t.Errorf("Pull(nothing new) output = %q", output)
+	}
+
+	// Fast-forward
+	var pushed string
+	inDir(t, alice, func() {
+		captureOutput(t, func() {
+			pushed = commitFile(t, "a.txt", "a2\n", "Change a.txt")
+			if err := Push("", "", PushOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+	output = captureOutput(t, func() {
+		if err := Pull("", "", PullOptions{}); err != nil {
+			t.Errorf("Pull() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Fast-forward") {
+		t.Errorf("Pull() output = %q", output)
+	}
+	if head, _ := GetCurrentHead(); head != pushed {
+		t.Errorf("HEAD = %s after a fast-forward, want %s", head, pushed)
+	}
+	if got := readWorkingFile(t, "a.txt"); got != "a2\n" {
+		t.Errorf("a.txt = %q after a fast-forward", got)
+	}
+
+	// Local changes block the checkout
+	inDir(t, alice, func() {
+		captureOutput(t, func() {
+			pushed = commitFile(t, "c.txt", "c\n", "Add c.txt")
+			if err := Push("", "", PushOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+	if err := ioutil.WriteFile("a.txt", []byte("dirty\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	captureOutput(t, func() { err = Pull("", "", PullOptions{}) })
+	if err == nil || !strings.Contains(err.Error(), "uncommitted changes") {
+		t.Errorf("Pull(dirty) error = %v", err)
+	}
+	if err := ioutil.WriteFile("a.txt", []byte("a2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Diverged history needs --rebase
+	var local string
+	captureOutput(t, func() { local = commitFile(t, "b.txt", "b\n", "Add b.txt") })
+	captureOutput(t, func() { err = Pull("", "", PullOptions{}) })
+	if err == nil || !strings.Contains(err.Error(), "gitter pull --rebase") {
+		t.Errorf("Pull(diverged) error = %v", err)
+	}
+	if head, _ := GetCurrentHead(); head != local {
+		t.Errorf("a refused pull moved HEAD")
+	}
+	captureOutput(t, func() {
+		if err := Pull("", "main", PullOptions{Rebase: true}); err != nil {
+			t.Fatalf("Pull(rebase) error = %v", err)
+		}
+	})
+	if got := strings.Join(logMessages(t), ", "); got != "Add b.txt, Add c.txt, Change a.txt, Add a.txt" {
+		t.Errorf("history after pull --rebase = %s", got)
+	}
+	for path, want := range map[string]string{"a.txt": "a2\n", "b.txt": "b\n", "c.txt": "c\n"} {
+		if got := readWorkingFile(t, filepath.Join(bob, path)); got != want {
+			t.Errorf("%s = %q after pull --rebase, want %q", path, got, want)
+		}
+	}
+
+	if err := Pull("", "nope", PullOptions{}); err == nil || !strings.Contains(err.Error(), "couldn't find remote ref nope") {
+		t.Errorf("Pull(unknown branch) error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(bob, GITTER_DIR, REBASE_DIR)); err == nil {
+		t.Errorf("pull --rebase left a rebase in progress")
+	}
+}
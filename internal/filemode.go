@@ -0,0 +1,104 @@
+// internal/filemode.go
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// File modes recorded in index and tree entries, using git's values. Entries
+// written before modes were tracked have none and are regular files.
+const (
+	MODE_FILE       = 0100644
+	MODE_EXECUTABLE = 0100755
+	MODE_SYMLINK    = 0120000
+)
+
+// fileModeOf returns the mode to record for a file in the working tree
+func fileModeOf(info os.FileInfo) uint32 {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return MODE_SYMLINK
+	case info.Mode().Perm()&0111 != 0:
+		return MODE_EXECUTABLE
+	default:
+		return MODE_FILE
+	}
+}
+
+// entryMode returns the mode of an index or tree entry
+func entryMode(entry IndexEntry) uint32 {
+	if entry.FileMode == 0 {
+		return MODE_FILE
+	}
+	return entry.FileMode
+}
+
+// fileChanged reports whether a working tree file with the given hash and
+// stat data differs from its entry in contents or mode
+func fileChanged(entry IndexEntry, hash string, info os.FileInfo) bool {
+	return hash != entry.Hash || fileModeOf(info) != entryMode(entry)
+}
+
+// sameVersion reports whether two entries record the same contents and mode;
+// a zero entry stands for a file that does not exist
+func sameVersion(a, b IndexEntry) bool {
+	return a.Hash == b.Hash && entryMode(a) == entryMode(b)
+}
+
+// readWorkingBlob returns what would be stored for a working tree file,
+// given relative to the working tree root: its contents as the filters clean
+// them, the link target for a symlink or the pointer for a large file
+func readWorkingBlob(filters *workingFilters, rel string) ([]byte, os.FileInfo, error) {
+	path := filepath.Join(filters.repo.WorkingDir, rel)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		return []byte(target), info, err
+	}
+	if large := filters.largeFile(rel); large || filters.converts(rel) {
+		data, err := cleanWorkingFile(filters, rel, path, large)
+		return data, info, err
+	}
+	data, err := ioutil.ReadFile(path)
+	return data, info, err
+}
+
+// writeWorkingFile puts a blob in the working tree with the given mode,
+// replacing whatever is there
+func writeWorkingFile(path string, data []byte, mode uint32) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Writing through an existing symlink would change its target instead
+	if info, err := os.Lstat(path); err == nil && (mode == MODE_SYMLINK || info.Mode()&os.ModeSymlink != 0) {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if mode == MODE_SYMLINK {
+		return os.Symlink(string(data), path)
+	}
+
+	perm := os.FileMode(0644)
+	if mode == MODE_EXECUTABLE {
+		perm = 0755
+	}
+	if err := ioutil.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	return os.Chmod(path, perm)
+}
+
+// printModeChange prints a line for a file whose mode changed
+func printModeChange(path string, oldMode, newMode uint32) {
+	if oldMode != newMode {
+		fmt.Printf("mode change %06o => %06o %s\n", oldMode, newMode, path)
+	}
+}
@@ -0,0 +1,154 @@
+// internal/filemode_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFileModesRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("executable bits and symlinks need a Unix file system")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir("bin", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("bin/run.sh", []byte("#!/bin/sh\necho hi\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("notes.txt", []byte("notes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bin", "tools"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing.txt", "dangling"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Symlinks are stored as links, whether or not their target exists
+	if err := AddFile("."); err != nil {
+		t.Fatalf("AddFile(.) error = %v", err)
+	}
+	captureOutput(t, func() {
+		if err := CommitChanges("Add files", CommitOptions{}); err != nil {
+			t.Fatalf("CommitChanges() error = %v", err)
+		}
+	})
+
+	want := map[string]uint32{
+		"bin/run.sh": MODE_EXECUTABLE,
+		"notes.txt":  MODE_FILE,
+		"tools":      MODE_SYMLINK,
+		"dangling":   MODE_SYMLINK,
+	}
+	for path, mode := range want {
+		if got := indexEntry(t, path).FileMode; got != mode {
+			t.Errorf("%s mode = %06o, want %06o", path, got, mode)
+		}
+	}
+	if entry := indexEntry(t, "tools"); entry.Hash != CalculateHash("bin") {
+		t.Errorf("symlink blob = %s, want the hash of its target", entry.Hash)
+	}
+
+	// Checking the commit out again restores modes and links faithfully
+	head, err := GetCurrentHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"bin/run.sh", "tools", "dangling"} {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile("tools", []byte("not a link\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutCommit(repo, head); err != nil {
+		t.Fatalf("checkoutCommit() error = %v", err)
+	}
+
+	if info, err := os.Stat("bin/run.sh"); err != nil || info.Mode().Perm()&0111 == 0 {
+		t.Errorf("bin/run.sh restored without its executable bit: %v", err)
+	}
+	for link, target := range map[string]string{"tools": "bin", "dangling": "missing.txt"} {
+		if got, err := os.Readlink(link); err != nil || got != target {
+			t.Errorf("Readlink(%s) = %q, %v, want %q", link, got, err, target)
+		}
+	}
+
+	output := captureOutput(t, func() {
+		if err := ShowStatus(); err != nil {
+			t.Errorf("ShowStatus() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "working tree clean") {
+		t.Errorf("status after checkout = %q, want a clean tree", output)
+	}
+}
+
+func TestModeOnlyChange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("executable bits need a Unix file system")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() { commitFile(t, "build.sh", "make\n", "Add build script") })
+	if err := os.Chmod("build.sh", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureOutput(t, func() {
+		if err := ShowStatus(); err != nil {
+			t.Errorf("ShowStatus() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "mode changed: build.sh (100644 -> 100755)") {
+		t.Errorf("ShowStatus() output = %q, want the mode change", output)
+	}
+
+	output = captureOutput(t, func() {
+		if err := ShowDiff("", DiffOptions{}); err != nil {
+			t.Errorf("ShowDiff() error = %v", err)
+		}
+	})
+	if strings.TrimSpace(output) != "mode change 100644 => 100755 build.sh" {
+		t.Errorf("ShowDiff() output = %q, want only the mode change", output)
+	}
+
+	// commit -a picks up the new mode although the contents are the same
+	captureOutput(t, func() {
+		if err := CommitChanges("Make build script executable", CommitOptions{All: true}); err != nil {
+			t.Fatalf("CommitChanges(-a) error = %v", err)
+		}
+	})
+	if got := indexEntry(t, "build.sh").FileMode; got != MODE_EXECUTABLE {
+		t.Errorf("build.sh mode after commit -a = %06o, want %06o", got, MODE_EXECUTABLE)
+	}
+
+	output = captureOutput(t, func() {
+		if err := Show("HEAD"); err != nil {
+			t.Errorf("Show() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "mode change 100644 => 100755 build.sh") {
+		t.Errorf("Show() output = %q, want the mode change", output)
+	}
+}
@@ -0,0 +1,345 @@
+// internal/fsck.go
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FsckResult collects what a repository check found
+type FsckResult struct {
+	Problems []string // corrupt, missing or malformed data; each one fails the check
+	Dangling []string // objects nothing refers to; reported but harmless
+
+	// Unreachable holds the hashes behind Dangling for pruning
+	Unreachable []string
+}
+
+// isHash reports whether s looks like a full SHA1 object name
+func isHash(s string) bool {
+	return len(s) == 40 && strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// commitContentHash recomputes the hash of a stored commit, which is taken
+// over its JSON encoding with the hash field left empty
+func commitContentHash(data []byte) (string, error) {
+	var commit Commit
+	if err := json.Unmarshal(data, &commit); err != nil {
+		return "", err
+	}
+	if commit.Hash == "" {
+		return CalculateHash(string(data)), nil
+	}
+	commit.Hash = ""
+	encoded, err := json.Marshal(commit)
+	if err != nil {
+		return "", err
+	}
+	return CalculateHash(string(encoded)), nil
+}
+
+// contentHash returns the hash an object is stored under, computed from
+// its contents
+func contentHash(data []byte) (string, error) {
+	if objectType(data) == ObjectCommit {
+		return commitContentHash(data)
+	}
+	return CalculateHash(string(data)), nil
+}
+
+// Fsck verifies the object store, refs, HEAD and index and prints what it
+// finds. It returns an error when the repository has problems.
+func Fsck() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	result, err := fsckRepository(repo)
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range result.Problems {
+		fmt.Println(problem)
+	}
+	for _, dangling := range result.Dangling {
+		fmt.Println(dangling)
+	}
+
+	if len(result.Problems) > 0 {
+		return fmt.Errorf("fsck found %d problem(s)", len(result.Problems))
+	}
+	return nil
+}
+
+// fsckRepository runs all checks on a repository
+func fsckRepository(repo *Repository) (*FsckResult, error) {
+	result := &FsckResult{}
+	problem := func(format string, args ...interface{}) {
+		result.Problems = append(result.Problems, fmt.Sprintf(format, args...))
+	}
+
+	// Packs must be intact before their objects can be trusted
+	packs, err := loadPacks(repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, pack := range packs {
+		if err := verifyPack(pack); err != nil {
+			problem("error: %v", err)
+		}
+	}
+
+	// Every object must hash to its name
+	hashes, err := listObjects(repo)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(hashes))
+	for _, hash := range hashes {
+		if !isHash(hash) {
+			problem("error: invalid object name '%s'", hash)
+			continue
+		}
+		data, err := readObject(repo, hash)
+		if err != nil {
+			problem("error: unable to read object %s: %v", hash, err)
+			continue
+		}
+
+		objType := objectType(data)
+		types[hash] = objType
+
+		actual, err := contentHash(data)
+		if err != nil {
+			problem("error: commit %s is corrupt: %v", hash, err)
+			continue
+		}
+		if actual != hash {
+			problem("error: hash mismatch for %s %s (contents hash to %s)", objType, hash, actual)
+		}
+	}
+
+	reachable := make(map[string]bool)
+	reported := make(map[string]bool)
+	_, partial := promisorRemote(repo)
+	missing := func(objType, hash, context string) {
+		// A partial clone fetches the blobs it left out when needed
+		if reported[hash] || (partial && objType == ObjectBlob) {
+			return
+		}
+		reported[hash] = true
+		if context != "" {
+			problem("missing %s %s (%s)", objType, hash, context)
+		} else {
+			problem("missing %s %s", objType, hash)
+		}
+	}
+
+	// Walk from every commit something points at
+	roots, err := fsckRoots(repo, problem)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		for hash, context := root.hash, root.name; hash != "" && !reachable[hash]; context = "" {
+			objType, ok := types[hash]
+			if !ok {
+				missing(ObjectCommit, hash, context)
+				break
+			}
+			reachable[hash] = true
+			if objType != ObjectCommit {
+				problem("error: %s points to %s %s, not a commit", root.name, objType, hash)
+				break
+			}
+
+			commit, err := readCommit(repo, hash)
+			if err != nil {
+				problem("error: commit %s is corrupt: %v", hash, err)
+				break
+			}
+			fsckTree(repo, commit, types, reachable, missing, problem)
+			if commit.Shallow != "" {
+				// The snapshot standing in for history a shallow clone left out
+				snapshot := commit
+				snapshot.TreeHash = commit.Shallow
+				fsckTree(repo, snapshot, types, reachable, missing, problem)
+			}
+			hash = commit.Parent
+		}
+	}
+
+	fsckIndex(repo, types, reachable, missing, problem)
+
+	for _, hash := range hashes {
+		if objType, ok := types[hash]; ok && !reachable[hash] {
+			result.Dangling = append(result.Dangling, fmt.Sprintf("dangling %s %s", objType, hash))
+			result.Unreachable = append(result.Unreachable, hash)
+		}
+	}
+
+	sort.Strings(result.Dangling)
+	return result, nil
+}
+
+// fsckRoot is a commit referenced from outside the object store
+type fsckRoot struct {
+	name string
+	hash string
+}
+
+// fsckRoots collects the commits referenced by HEAD, refs, the commit log, the
+// reflog and any rebase or bisect in progress, reporting malformed references
+func fsckRoots(repo *Repository, problem func(string, ...interface{})) ([]fsckRoot, error) {
+	var roots []fsckRoot
+
+	head, err := readHead(repo)
+	if err != nil {
+		problem("error: unable to read HEAD: %v", err)
+	} else if strings.HasPrefix(head, headRefPrefix) {
+		if ref := strings.TrimPrefix(head, headRefPrefix); !strings.HasPrefix(ref, REFS_DIR+"/") {
+			problem("error: HEAD points outside refs/: %s", ref)
+		}
+	} else if !isHash(head) {
+		problem("error: HEAD is malformed: %q", head)
+	} else {
+		roots = append(roots, fsckRoot{name: HEAD_FILE, hash: head})
+	}
+
+	refsDir := filepath.Join(repo.GitDir, REFS_DIR)
+	err = filepath.Walk(refsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(repo.GitDir, path)
+		if err != nil {
+			return err
+		}
+		ref := filepath.ToSlash(rel)
+		hash, err := readRef(repo, ref)
+		if err != nil {
+			return err
+		}
+		if !isHash(hash) {
+			problem("error: %s: invalid commit pointer %q", ref, hash)
+			return nil
+		}
+		roots = append(roots, fsckRoot{name: ref, hash: hash})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	logData, err := ioutil.ReadFile(filepath.Join(repo.GitDir, LOG_FILE))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for n, line := range strings.Split(string(logData), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !isHash(line) {
+			problem("error: log line %d is malformed: %q", n+1, line)
+			continue
+		}
+		roots = append(roots, fsckRoot{name: LOG_FILE, hash: line})
+	}
+
+	// Commits left behind by amend or rebase stay reachable from the reflog
+	reflog, err := readReflog(repo)
+	if err != nil {
+		problem("error: %v", err)
+	}
+	for i, entry := range reflog {
+		name := fmt.Sprintf("HEAD@{%d}", i)
+		roots = append(roots, fsckRoot{name: name, hash: entry.New})
+		if entry.Old != "" {
+			roots = append(roots, fsckRoot{name: name, hash: entry.Old})
+		}
+	}
+
+	if state, err := loadRebaseState(repo); err != nil {
+		problem("error: rebase state is corrupt: %v", err)
+	} else if state != nil {
+		roots = append(roots, fsckRoot{name: "rebase orig-head", hash: state.OrigHead})
+	}
+	if state, err := loadBisectState(repo); err != nil {
+		problem("error: bisect state is corrupt: %v", err)
+	} else if state != nil {
+		roots = append(roots, fsckRoot{name: "bisect orig-head", hash: state.OrigHead})
+	}
+
+	return roots, nil
+}
+
+// fsckTree checks the tree of a commit and the blobs it lists
+func fsckTree(repo *Repository, commit Commit, types map[string]string, reachable map[string]bool,
+	missing func(string, string, string), problem func(string, ...interface{})) {
+	objType, ok := types[commit.TreeHash]
+	if !ok {
+		missing(ObjectTree, commit.TreeHash, "tree of commit "+commit.Hash)
+		return
+	}
+	reachable[commit.TreeHash] = true
+	if objType != ObjectTree {
+		problem("error: tree of commit %s is a %s", commit.Hash, objType)
+		return
+	}
+
+	entries, err := readTree(repo, commit.TreeHash)
+	if err != nil {
+		problem("error: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if _, ok := types[entry.Hash]; !ok {
+			missing(ObjectBlob, entry.Hash, entry.FilePath+" in tree "+commit.TreeHash)
+			continue
+		}
+		reachable[entry.Hash] = true
+	}
+}
+
+// fsckIndex checks that the index parses and every entry has its blob
+func fsckIndex(repo *Repository, types map[string]string, reachable map[string]bool,
+	missing func(string, string, string), problem func(string, ...interface{})) {
+	data, err := ioutil.ReadFile(filepath.Join(repo.GitDir, INDEX_FILE))
+	if err != nil {
+		problem("error: unable to read index: %v", err)
+		return
+	}
+
+	index, _, err := decodeIndex(data)
+	if err != nil {
+		problem("error: %v", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i, entry := range index {
+		switch {
+		case entry.FilePath == "":
+			problem("error: index entry %d has no path", i)
+		case !isHash(entry.Hash):
+			problem("error: index entry '%s' has an invalid hash %q", entry.FilePath, entry.Hash)
+		case seen[entry.FilePath]:
+			problem("error: index entry '%s' appears more than once", entry.FilePath)
+		default:
+			if _, ok := types[entry.Hash]; !ok {
+				missing(ObjectBlob, entry.Hash, "index entry "+entry.FilePath)
+			} else {
+				reachable[entry.Hash] = true
+			}
+		}
+		seen[entry.FilePath] = true
+	}
+}
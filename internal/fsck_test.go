@@ -0,0 +1,145 @@
+// internal/fsck_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(t *testing.T, repo *Repository, head string)
+		wantProblems []string
+		wantDangling []string
+	}{
+		{
+			name:  "Healthy repository",
+			setup: func(t *testing.T, repo *Repository, head string) {},
+		},
+		{
+			name: "Dangling blob",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				if err := ioutil.WriteFile("a.txt", []byte("draft\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := AddFile("a.txt"); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile("a.txt", []byte("final\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := AddFile("a.txt"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantDangling: []string{"dangling blob " + CalculateHash("draft\n")},
+		},
+		{
+			name: "Corrupt blob",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				writeObject(repo, CalculateHash("two\n"), []byte("tampered\n"))
+			},
+			wantProblems: []string{"hash mismatch for blob " + CalculateHash("two\n")},
+		},
+		{
+			name: "Corrupt commit",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				data, _ := readObject(repo, head)
+				writeObject(repo, head, []byte(strings.Replace(string(data), "Second", "Forged", 1)))
+			},
+			wantProblems: []string{"hash mismatch for commit"},
+		},
+		{
+			name: "Missing blob",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				os.Remove(filepath.Join(repo.GitDir, OBJECTS_DIR, CalculateHash("one\n")))
+			},
+			wantProblems: []string{"missing blob " + CalculateHash("one\n")},
+		},
+		{
+			name: "Missing parent",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				commit, _ := readCommit(repo, head)
+				os.Remove(filepath.Join(repo.GitDir, OBJECTS_DIR, commit.Parent))
+			},
+			wantProblems: []string{"missing commit"},
+			wantDangling: []string{"dangling tree"},
+		},
+		{
+			name: "Malformed index",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				ioutil.WriteFile(filepath.Join(repo.GitDir, INDEX_FILE), []byte("{not json"), 0644)
+			},
+			wantProblems: []string{"index file is malformed"},
+		},
+		{
+			name: "Index entry with bad hash",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				SaveIndex([]IndexEntry{{FilePath: "a.txt", Hash: "xyz"}})
+			},
+			wantProblems: []string{"index entry 'a.txt' has an invalid hash"},
+		},
+		{
+			name: "Malformed HEAD",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				ioutil.WriteFile(filepath.Join(repo.GitDir, HEAD_FILE), []byte("garbage\n"), 0644)
+			},
+			wantProblems: []string{"HEAD is malformed"},
+		},
+		{
+			name: "Malformed branch",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				writeRef(repo, branchRef("broken"), "1234")
+			},
+			wantProblems: []string{"refs/heads/broken: invalid commit pointer"},
+		},
+		{
+			name: "Tree with an unsafe path",
+			setup: func(t *testing.T, repo *Repository, head string) {
+				entries := []IndexEntry{{FilePath: "../outside.txt", Hash: CalculateHash("two\n"), Modified: true}}
+				writeRef(repo, branchRef("evil"), craftCommit(t, repo, head, entries))
+			},
+			wantProblems: []string{"has an unsafe path '../outside.txt'"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cleanup := setupTestRepo(t)
+			defer cleanup()
+
+			if err := InitRepository(); err != nil {
+				t.Fatalf("Failed to initialize repository: %v", err)
+			}
+			commitFile(t, "a.txt", "one\n", "First")
+			head := commitFile(t, "a.txt", "two\n", "Second")
+
+			repo, err := FindGitterRepo()
+			if err != nil {
+				t.Fatalf("FindGitterRepo() error = %v", err)
+			}
+			tt.setup(t, repo, head)
+
+			var fsckErr error
+			output := captureOutput(t, func() {
+				fsckErr = Fsck()
+			})
+
+			if (fsckErr != nil) != (len(tt.wantProblems) > 0) {
+				t.Errorf("Fsck() error = %v, want problems %v\nOutput: %s", fsckErr, tt.wantProblems, output)
+			}
+			for _, expected := range append(tt.wantProblems, tt.wantDangling...) {
+				if !strings.Contains(output, expected) {
+					t.Errorf("Fsck() output missing %q\nGot: %s", expected, output)
+				}
+			}
+			if len(tt.wantDangling) == 0 && strings.Contains(output, "dangling") {
+				t.Errorf("Fsck() reported dangling objects:\n%s", output)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+// internal/fsmonitor.go
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Files used by the file system monitor daemon inside .gitter
+const (
+	FSMONITOR_SOCKET = "fsmonitor.sock"
+	FSMONITOR_STATE  = "fsmonitor-state"
+	FSMONITOR_LOG    = "fsmonitor.log"
+
+	fsmonitorCookiePrefix = "fsmonitor-cookie-"
+)
+
+// The daemon answers one JSON request per connection
+type fsmonitorRequest struct {
+	Command string `json:"command"` // query, status or stop
+	Token   string `json:"token,omitempty"`
+}
+
+type fsmonitorResponse struct {
+	Token   string   `json:"token,omitempty"`
+	Full    bool     `json:"full,omitempty"` // the daemon cannot vouch for the interval, scan everything
+	Paths   []string `json:"paths,omitempty"`
+	PID     int      `json:"pid,omitempty"`
+	Watches int      `json:"watches,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// fsmonitorState remembers what the last full scan found, so the next scan
+// only has to look at these paths plus whatever the daemon saw change
+type fsmonitorState struct {
+	Token     string   `json:"token"`
+	Untracked []string `json:"untracked"`
+	Dirty     []string `json:"dirty"`
+}
+
+// fsmonitorCall sends a request to the daemon of a repository
+func fsmonitorCall(repo *Repository, req fsmonitorRequest) (*fsmonitorResponse, error) {
+	conn, err := net.DialTimeout("unix", filepath.Join(repo.GitDir, FSMONITOR_SOCKET), time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp fsmonitorResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("fsmonitor: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// loadFSMonitorState returns the saved scan state, or nil when there is none
+func loadFSMonitorState(repo *Repository) (*fsmonitorState, error) {
+	data, err := ioutil.ReadFile(filepath.Join(repo.GitDir, FSMONITOR_STATE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var state fsmonitorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, nil // a damaged state only costs a full scan
+	}
+	return &state, nil
+}
+
+// saveFSMonitorState records the result of a full scan
+func saveFSMonitorState(repo *Repository, state *fsmonitorState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(repo.GitDir, FSMONITOR_STATE), data, 0644)
+}
+
+// fsmonitorChanges asks a running daemon which paths may have changed since
+// the last full scan. It returns the new token to save after scanning, which
+// is empty when no daemon is running, and ok is false when the whole tree
+// has to be scanned anyway.
+func fsmonitorChanges(repo *Repository) (paths []string, token string, ok bool) {
+	state, err := loadFSMonitorState(repo)
+	if err != nil {
+		return nil, "", false
+	}
+	var since string
+	if state != nil {
+		since = state.Token
+	}
+
+	resp, err := fsmonitorCall(repo, fsmonitorRequest{Command: "query", Token: since})
+	if err != nil {
+		return nil, "", false
+	}
+	if state == nil || resp.Full {
+		return nil, resp.Token, false
+	}
+
+	seen := make(map[string]bool)
+	for _, list := range [][]string{state.Untracked, state.Dirty, resp.Paths} {
+		for _, path := range list {
+			// New ignore rules can expose files nobody touched
+			if path == IGNORE_FILE {
+				return nil, resp.Token, false
+			}
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, resp.Token, true
+}
+
+// FSMonitorStart launches the daemon in the background
+func FSMonitorStart() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	if resp, err := fsmonitorCall(repo, fsmonitorRequest{Command: "status"}); err == nil {
+		return fmt.Errorf("fsmonitor is already running (pid %d)", resp.PID)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(filepath.Join(repo.GitDir, FSMONITOR_LOG), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, "fsmonitor", "run")
+	cmd.Dir = repo.WorkingDir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = daemonSysProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	// Wait until the daemon answers or gives up
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if resp, err := fsmonitorCall(repo, fsmonitorRequest{Command: "status"}); err == nil {
+			fmt.Printf("fsmonitor started (pid %d, watching %d directories)\n", resp.PID, resp.Watches)
+			return nil
+		}
+		select {
+		case err := <-exited:
+			return fmt.Errorf("fsmonitor exited during startup (%v); see .gitter/%s", err, FSMONITOR_LOG)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("fsmonitor did not start in time; see .gitter/%s", FSMONITOR_LOG)
+}
+
+// FSMonitorStop asks the daemon to exit
+func FSMonitorStop() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	if _, err := fsmonitorCall(repo, fsmonitorRequest{Command: "stop"}); err != nil {
+		return fmt.Errorf("fsmonitor is not running")
+	}
+	fmt.Println("fsmonitor stopped")
+	return nil
+}
+
+// FSMonitorStatus reports whether the daemon is running
+func FSMonitorStatus() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	resp, err := fsmonitorCall(repo, fsmonitorRequest{Command: "status"})
+	if err != nil {
+		fmt.Println("fsmonitor is not running")
+		return nil
+	}
+	fmt.Printf("fsmonitor is running (pid %d, watching %d directories)\n", resp.PID, resp.Watches)
+	return nil
+}
+
+// FSMonitorRun runs the daemon in the foreground until it is stopped or
+// interrupted
+func FSMonitorRun() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return runFSMonitor(ctx, repo, nil)
+}
+
+// fsWatcher feeds file system events for the working tree to a daemon
+type fsWatcher interface {
+	run(ctx context.Context) error // blocks until ctx is cancelled
+	watches() int
+}
+
+// fsmonitorDaemon tracks changed paths as a sequence so each query can be
+// answered with the paths changed since the token of the previous one
+type fsmonitorDaemon struct {
+	repo    *Repository
+	watcher fsWatcher
+
+	mu        sync.Mutex
+	epoch     string            // changes whenever history is lost; old tokens then mean a full scan
+	seq       uint64            // number of the latest change
+	changed   map[string]uint64 // path -> number of its latest change
+	cookies   map[string]chan struct{}
+	cookieSeq int
+}
+
+// runFSMonitor serves queries on the repository's socket until ctx is
+// cancelled or a stop request arrives. ready, if set, is called once the
+// daemon accepts queries.
+func runFSMonitor(ctx context.Context, repo *Repository, ready func()) error {
+	socket := filepath.Join(repo.GitDir, FSMONITOR_SOCKET)
+	if _, err := fsmonitorCall(repo, fsmonitorRequest{Command: "status"}); err == nil {
+		return fmt.Errorf("fsmonitor is already running")
+	}
+	os.Remove(socket) // left behind by a daemon that did not exit cleanly
+
+	d := &fsmonitorDaemon{
+		repo:    repo,
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		changed: make(map[string]uint64),
+		cookies: make(map[string]chan struct{}),
+	}
+	watcher, err := newFSWatcher(repo, d)
+	if err != nil {
+		return err
+	}
+	d.watcher = watcher
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watchErr := make(chan error, 1)
+	go func() { watchErr <- watcher.run(ctx) }()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	fmt.Printf("fsmonitor: watching %s (%d directories)\n", repo.WorkingDir, watcher.watches())
+	if ready != nil {
+		ready()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			break
+		}
+		go d.handle(conn, cancel)
+	}
+	cancel()
+	return <-watchErr
+}
+
+// handle answers a single request
+func (d *fsmonitorDaemon) handle(conn net.Conn, stop func()) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var req fsmonitorRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		return
+	}
+
+	var resp fsmonitorResponse
+	switch req.Command {
+	case "query":
+		resp = d.query(req.Token)
+	case "status":
+		resp = fsmonitorResponse{PID: os.Getpid(), Watches: d.watcher.watches()}
+	case "stop":
+		resp = fsmonitorResponse{PID: os.Getpid()}
+		defer stop()
+	default:
+		resp = fsmonitorResponse{Error: fmt.Sprintf("unknown command '%s'", req.Command)}
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// query returns the paths changed since a token. A cookie file is created
+// first and the answer waits until its event arrives, so every change made
+// before the query has been seen.
+func (d *fsmonitorDaemon) query(token string) fsmonitorResponse {
+	d.mu.Lock()
+	d.cookieSeq++
+	name := fmt.Sprintf("%s%d-%d", fsmonitorCookiePrefix, os.Getpid(), d.cookieSeq)
+	seen := make(chan struct{})
+	d.cookies[name] = seen
+	d.mu.Unlock()
+
+	cookie := filepath.Join(d.repo.GitDir, name)
+	synced := ioutil.WriteFile(cookie, nil, 0644) == nil
+	if synced {
+		select {
+		case <-seen:
+		case <-time.After(2 * time.Second):
+			synced = false
+		}
+		os.Remove(cookie)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.cookies, name)
+
+	resp := fsmonitorResponse{Token: d.epoch + ":" + strconv.FormatUint(d.seq, 10)}
+	epoch, seqText, ok := strings.Cut(token, ":")
+	since, err := strconv.ParseUint(seqText, 10, 64)
+	if !synced || !ok || err != nil || epoch != d.epoch {
+		resp.Full = true
+		return resp
+	}
+	for path, seq := range d.changed {
+		if seq > since {
+			resp.Paths = append(resp.Paths, path)
+		}
+	}
+	sort.Strings(resp.Paths)
+	return resp
+}
+
+// markChanged records a change to a path relative to the working tree root
+func (d *fsmonitorDaemon) markChanged(rel string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	d.changed[rel] = d.seq
+}
+
+// invalidate forgets the change history after events were lost
+func (d *fsmonitorDaemon) invalidate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	d.changed = make(map[string]uint64)
+}
+
+// cookieSeen wakes the query waiting for a cookie file
+func (d *fsmonitorDaemon) cookieSeen(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if seen, ok := d.cookies[name]; ok {
+		close(seen)
+		delete(d.cookies, name)
+	}
+}
@@ -0,0 +1,183 @@
+// internal/fsmonitor_linux.go
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// inotifyWatchMask selects the events that can change a directory's files
+const inotifyWatchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF |
+	syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// inotifyWatcher watches every directory of the working tree with inotify,
+// plus .gitter itself for the daemon's cookie files
+type inotifyWatcher struct {
+	repo   *Repository
+	daemon *fsmonitorDaemon
+	fd     int
+	file   *os.File
+
+	mu       sync.Mutex
+	dirs     map[int32]string // watch descriptor -> directory relative to the root
+	gitDirWd int32
+}
+
+// newFSWatcher sets up watches on the whole working tree
+func newFSWatcher(repo *Repository, d *fsmonitorDaemon) (fsWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		repo:   repo,
+		daemon: d,
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[int32]string),
+	}
+
+	wd, err := syscall.InotifyAddWatch(fd, repo.GitDir, syscall.IN_CREATE)
+	if err != nil {
+		w.file.Close()
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+	w.gitDirWd = int32(wd)
+
+	if _, err := w.addTree("."); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// addTree watches a directory and everything below it, returning the files
+// found so a newly created directory's contents count as changed
+func (w *inotifyWatcher) addTree(rel string) ([]string, error) {
+	var files []string
+	root := filepath.Join(w.repo.WorkingDir, rel)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // removed again before we got to it
+			}
+			return err
+		}
+		sub, err := filepath.Rel(w.repo.WorkingDir, path)
+		if err != nil {
+			return err
+		}
+		if sub == GITTER_DIR {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			files = append(files, sub)
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyWatchMask)
+		if err != nil {
+			if err == syscall.ENOENT {
+				return filepath.SkipDir
+			}
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		w.mu.Lock()
+		w.dirs[int32(wd)] = sub
+		w.mu.Unlock()
+		return nil
+	})
+	return files, err
+}
+
+// watches returns the number of watched directories
+func (w *inotifyWatcher) watches() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.dirs)
+}
+
+// run reads events until ctx is cancelled
+func (w *inotifyWatcher) run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		w.file.Close()
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		w.handleEvents(buf[:n])
+	}
+}
+
+// handleEvents decodes a batch of inotify events
+func (w *inotifyWatcher) handleEvents(buf []byte) {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+		mask := binary.NativeEndian.Uint32(buf[offset+4:])
+		nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		if nameStart+nameLen > len(buf) {
+			return
+		}
+		name := strings.TrimRight(string(buf[nameStart:nameStart+nameLen]), "\x00")
+		offset = nameStart + nameLen
+
+		if mask&syscall.IN_Q_OVERFLOW != 0 {
+			w.daemon.invalidate()
+			continue
+		}
+		if wd == w.gitDirWd {
+			if strings.HasPrefix(name, fsmonitorCookiePrefix) {
+				w.daemon.cookieSeen(name)
+			}
+			continue
+		}
+
+		w.mu.Lock()
+		dir, ok := w.dirs[wd]
+		if ok && mask&syscall.IN_IGNORED != 0 {
+			delete(w.dirs, wd)
+		}
+		w.mu.Unlock()
+		if !ok || mask&syscall.IN_IGNORED != 0 {
+			continue
+		}
+
+		rel := filepath.Join(dir, name)
+		if rel == GITTER_DIR || strings.HasPrefix(rel, GITTER_DIR+string(filepath.Separator)) {
+			continue
+		}
+		w.daemon.markChanged(rel)
+
+		// Files in a directory that appears are new too, and it needs watching
+		if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			files, err := w.addTree(rel)
+			if err != nil {
+				w.daemon.invalidate()
+				continue
+			}
+			for _, file := range files {
+				w.daemon.markChanged(file)
+			}
+		}
+	}
+}
+
+// daemonSysProcAttr detaches the background daemon from the terminal
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+// internal/fsmonitor_other.go
+package internal
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// newFSWatcher reports that no file system watcher exists for this platform;
+// commands then always scan the working tree
+func newFSWatcher(repo *Repository, d *fsmonitorDaemon) (fsWatcher, error) {
+	return nil, fmt.Errorf("fsmonitor is not supported on %s", runtime.GOOS)
+}
+
+// daemonSysProcAttr leaves process attributes at their defaults
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
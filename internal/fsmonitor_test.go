@@ -0,0 +1,180 @@
+// internal/fsmonitor_test.go
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startFSMonitor runs a daemon for the test repository until the test ends
+func startFSMonitor(t *testing.T, repo *Repository) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- runFSMonitor(ctx, repo, func() { close(ready) })
+	}()
+
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatalf("runFSMonitor() error = %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("fsmonitor did not start")
+	}
+
+	return func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("runFSMonitor() error = %v", err)
+		}
+	}
+}
+
+// scannedPaths returns the paths a scan against the index looked at
+func scannedPaths(t *testing.T, repo *Repository) []string {
+	t.Helper()
+	index, err := LoadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexedFiles := make(map[string]*IndexEntry)
+	for i := range index {
+		indexedFiles[index[i].FilePath] = &index[i]
+	}
+	files, _, err := scanAgainstIndex(context.Background(), repo, indexedFiles, nil)
+	if err != nil {
+		t.Fatalf("scanAgainstIndex() error = %v", err)
+	}
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Rel)
+	}
+	return paths
+}
+
+func TestFSMonitor(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fsmonitor needs inotify")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		commitFile(t, "a.txt", "a\n", "Add a")
+		commitFile(t, "b.txt", "b\n", "Add b")
+	})
+	if err := ioutil.WriteFile("untracked.txt", []byte("u\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stop func()
+	captureOutput(t, func() { stop = startFSMonitor(t, repo) })
+
+	// The first scan has no token yet and looks at everything
+	if got := strings.Join(scannedPaths(t, repo), ","); got != "a.txt,b.txt,untracked.txt" {
+		t.Errorf("first scan = %s", got)
+	}
+
+	// Afterwards only changed and previously untracked paths are examined
+	if err := ioutil.WriteFile("b.txt", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join("new", "deep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join("new", "deep", "c.txt"), []byte("c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{"b.txt", filepath.Join("new", "deep", "c.txt"), "untracked.txt"}, ",")
+	if got := strings.Join(scannedPaths(t, repo), ","); got != want {
+		t.Errorf("scan with fsmonitor = %s, want %s", got, want)
+	}
+
+	output := captureOutput(t, func() {
+		if err := ShowStatus(); err != nil {
+			t.Errorf("ShowStatus() error = %v", err)
+		}
+	})
+	for _, want := range []string{"modified: b.txt", "untracked.txt", filepath.Join("new", "deep", "c.txt")} {
+		if !strings.Contains(output, want) {
+			t.Errorf("ShowStatus() with fsmonitor missing %q: %q", want, output)
+		}
+	}
+	if strings.Contains(output, "a.txt") {
+		t.Errorf("ShowStatus() with fsmonitor lists unchanged a.txt: %q", output)
+	}
+
+	// commit -a picks up the change the daemon reported
+	captureOutput(t, func() {
+		if err := CommitChanges("Change b", CommitOptions{All: true}); err != nil {
+			t.Errorf("CommitChanges(-a) error = %v", err)
+		}
+	})
+
+	resp, err := fsmonitorCall(repo, fsmonitorRequest{Command: "status"})
+	if err != nil || resp.PID != os.Getpid() || resp.Watches < 3 {
+		t.Errorf("fsmonitor status = %+v, %v", resp, err)
+	}
+	stop()
+
+	// Without the daemon everything is scanned again
+	if _, err := os.Stat(filepath.Join(repo.GitDir, FSMONITOR_SOCKET)); !os.IsNotExist(err) {
+		t.Errorf("socket left behind after stop: %v", err)
+	}
+	if got := strings.Join(scannedPaths(t, repo), ","); !strings.Contains(got, "a.txt") {
+		t.Errorf("scan without fsmonitor = %s, want the whole tree", got)
+	}
+}
+
+func TestFSMonitorInvalidTokenMeansFullScan(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fsmonitor needs inotify")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stop func()
+	captureOutput(t, func() { stop = startFSMonitor(t, repo) })
+	defer stop()
+
+	for _, token := range []string{"", "garbage", "otherepoch:3"} {
+		resp, err := fsmonitorCall(repo, fsmonitorRequest{Command: "query", Token: token})
+		if err != nil || !resp.Full || resp.Token == "" {
+			t.Errorf("query(%q) = %+v, %v, want a full scan and a new token", token, resp, err)
+		}
+	}
+
+	resp, err := fsmonitorCall(repo, fsmonitorRequest{Command: "query", Token: "garbage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("x.txt", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = fsmonitorCall(repo, fsmonitorRequest{Command: "query", Token: resp.Token})
+	if err != nil || resp.Full || strings.Join(resp.Paths, ",") != "x.txt" {
+		t.Errorf("query(valid token) = %+v, %v, want x.txt", resp, err)
+	}
+}
@@ -0,0 +1,275 @@
+// internal/gc.go
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PruneOptions configures which unreachable objects are removed
+type PruneOptions struct {
+	DryRun bool
+	Expire time.Duration // only remove objects last modified longer ago than this, so
+	// the objects of an add or commit that is still running are not lost
+}
+
+// ParseExpiry parses a grace period such as "now", "2w", "14d" or any
+// duration understood by time.ParseDuration
+func ParseExpiry(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "now" {
+		return 0, nil
+	}
+
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if !strings.HasSuffix(value, suffix) {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(value, suffix)); err == nil && n >= 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid expiry '%s'", value)
+	}
+	return d, nil
+}
+
+// PruneResult summarises a prune run
+type PruneResult struct {
+	Removed     []string // hashes that were (or in a dry run would be) removed
+	Bytes       int64    // space reclaimed
+	KeptByGrace int      // unreachable objects younger than the grace period
+}
+
+// Prune removes objects that cannot be reached from HEAD, anything under
+// refs/ (branches and any stash), the commit log, the index or a rebase or
+// bisect in progress
+func Prune(opts PruneOptions) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	result, err := pruneObjects(repo, opts)
+	if err != nil {
+		return err
+	}
+	printPruneResult(result, opts)
+	return nil
+}
+
+// GarbageCollect cleans up the repository: it prunes unreachable loose
+// objects older than the grace period, then repacks everything that is left
+// into a single pack
+func GarbageCollect(opts PruneOptions) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	result, err := pruneObjects(repo, opts)
+	if err != nil {
+		return err
+	}
+	printPruneResult(result, opts)
+	if opts.DryRun {
+		return nil
+	}
+
+	packed, err := repackObjects(repo, opts)
+	if err != nil {
+		return err
+	}
+	if packed == nil {
+		fmt.Println("Nothing to pack")
+		return nil
+	}
+	fmt.Printf("Packed %d object(s) (%d as deltas) into %s.pack, %s\n",
+		packed.Objects, packed.Deltas, packed.Name, formatBytes(packed.Bytes))
+	return nil
+}
+
+// repackObjects writes every reachable object, loose or packed, into one new
+// pack and removes the old packs and the loose copies. Unreachable objects
+// that are still loose stay loose; unreachable packed objects are kept only
+// while their pack is younger than the grace period. It returns nil when the
+// repository has no objects to pack.
+func repackObjects(repo *Repository, opts PruneOptions) (*PackResult, error) {
+	check, err := fsckRepository(repo)
+	if err != nil {
+		return nil, err
+	}
+	if len(check.Problems) > 0 {
+		return nil, fmt.Errorf("refusing to repack, the repository has %d problem(s); run 'gitter fsck' for details", len(check.Problems))
+	}
+
+	hashes, err := listObjects(repo)
+	if err != nil {
+		return nil, err
+	}
+	packs, err := loadPacks(repo)
+	if err != nil {
+		return nil, err
+	}
+	unreachable := make(map[string]bool, len(check.Unreachable))
+	for _, hash := range check.Unreachable {
+		unreachable[hash] = true
+	}
+
+	cutoff := time.Now().Add(-opts.Expire)
+	var objects []*packObject
+	for _, hash := range hashes {
+		if unreachable[hash] && !keepUnreachable(repo, packs, hash, opts.Expire > 0, cutoff) {
+			continue
+		}
+		data, err := readObject(repo, hash)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, &packObject{hash: hash, data: data, kind: objectType(data)})
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	// Blobs are grouped by a path they appear at so versions of one file are
+	// tried as delta bases for each other
+	paths := make(map[string]string)
+	for _, obj := range objects {
+		if obj.kind != ObjectTree {
+			continue
+		}
+		entries, err := readTree(repo, obj.hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if _, ok := paths[entry.Hash]; !ok {
+				paths[entry.Hash] = entry.FilePath
+			}
+		}
+	}
+	for _, obj := range objects {
+		obj.path = paths[obj.hash]
+	}
+
+	result, err := writePack(repo, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	newPack := filepath.Join(repo.GitDir, OBJECTS_DIR, PACK_DIR, result.Name+".pack")
+	for _, pack := range packs {
+		if pack.packPath == newPack {
+			continue
+		}
+		if err := os.Remove(strings.TrimSuffix(pack.packPath, ".pack") + ".idx"); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(pack.packPath); err != nil {
+			return nil, err
+		}
+	}
+	for _, obj := range objects {
+		err := os.Remove(filepath.Join(repo.GitDir, OBJECTS_DIR, obj.hash))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// keepUnreachable decides whether an unreachable object survives a repack
+func keepUnreachable(repo *Repository, packs []*packIndex, hash string, grace bool, cutoff time.Time) bool {
+	if _, err := os.Stat(filepath.Join(repo.GitDir, OBJECTS_DIR, hash)); err == nil {
+		return false // left loose for prune to expire
+	}
+	if !grace {
+		return false
+	}
+	for _, pack := range packs {
+		if _, ok := pack.lookup(hash); !ok {
+			continue
+		}
+		if info, err := os.Stat(pack.packPath); err == nil && info.ModTime().After(cutoff) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneObjects finds and removes unreachable objects
+func pruneObjects(repo *Repository, opts PruneOptions) (*PruneResult, error) {
+	check, err := fsckRepository(repo)
+	if err != nil {
+		return nil, err
+	}
+	// A broken ref or missing object means reachability cannot be trusted
+	if len(check.Problems) > 0 {
+		return nil, fmt.Errorf("refusing to prune, the repository has %d problem(s); run 'gitter fsck' for details", len(check.Problems))
+	}
+
+	cutoff := time.Now().Add(-opts.Expire)
+	result := &PruneResult{}
+	for _, hash := range check.Unreachable {
+		path := filepath.Join(repo.GitDir, OBJECTS_DIR, hash)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue // only in a pack, dropped when gc repacks
+		}
+		if err != nil {
+			return nil, err
+		}
+		if opts.Expire > 0 && info.ModTime().After(cutoff) {
+			result.KeptByGrace++
+			continue
+		}
+
+		if opts.DryRun {
+			data, err := readObject(repo, hash)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Printf("would remove %s %s (%d bytes)\n", objectType(data), hash, info.Size())
+		} else if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		result.Removed = append(result.Removed, hash)
+		result.Bytes += info.Size()
+	}
+	return result, nil
+}
+
+// printPruneResult reports what a prune run did
+func printPruneResult(result *PruneResult, opts PruneOptions) {
+	verb := "Removed"
+	if opts.DryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d unreachable object(s), %s reclaimed\n", verb, len(result.Removed), formatBytes(result.Bytes))
+	if result.KeptByGrace > 0 {
+		fmt.Printf("Kept %d unreachable object(s) younger than the grace period\n", result.KeptByGrace)
+	}
+}
+
+// formatBytes renders a byte count for humans
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
@@ -0,0 +1,189 @@
+// internal/gc_test.go
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupPruneRepo commits a file after staging an intermediate version of it,
+// leaving one unreachable blob behind, and returns that blob's hash
+func setupPruneRepo(t *testing.T) string {
+	t.Helper()
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	commitFile(t, "a.txt", "one\n", "First")
+	if err := ioutil.WriteFile("a.txt", []byte("draft\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "a.txt", "two\n", "Second")
+	return CalculateHash("draft\n")
+}
+
+func looseObjectExists(hash string) bool {
+	_, err := os.Stat(filepath.Join(GITTER_DIR, OBJECTS_DIR, hash))
+	return err == nil
+}
+
+func TestPrune(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	draft := setupPruneRepo(t)
+
+	// A dry run only reports
+	output := captureOutput(t, func() {
+		if err := Prune(PruneOptions{DryRun: true}); err != nil {
+			t.Errorf("Prune() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "would remove blob "+draft) || !strings.Contains(output, "Would remove 1 unreachable object(s), 6 bytes reclaimed") {
+		t.Errorf("Prune(dry run) output = %q", output)
+	}
+	if !looseObjectExists(draft) {
+		t.Fatalf("Prune(dry run) removed %s", draft)
+	}
+
+	// Recent objects are protected by the grace period
+	output = captureOutput(t, func() {
+		if err := Prune(PruneOptions{Expire: time.Hour}); err != nil {
+			t.Errorf("Prune() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Kept 1 unreachable object(s)") || !looseObjectExists(draft) {
+		t.Errorf("Prune(1h) output = %q", output)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(GITTER_DIR, OBJECTS_DIR, draft), old, old); err != nil {
+		t.Fatal(err)
+	}
+	output = captureOutput(t, func() {
+		if err := GarbageCollect(PruneOptions{Expire: time.Hour}); err != nil {
+			t.Errorf("GarbageCollect() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Removed 1 unreachable object(s), 6 bytes reclaimed") {
+		t.Errorf("GarbageCollect() output = %q", output)
+	}
+	if looseObjectExists(draft) {
+		t.Errorf("GarbageCollect() kept unreachable %s", draft)
+	}
+
+	// Everything reachable survives, now packed, and the repository still
+	// checks out
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"one\n", "two\n"} {
+		if !objectExists(repo, CalculateHash(content)) {
+			t.Errorf("reachable blob %q was removed", content)
+		}
+	}
+	captureOutput(t, func() {
+		if err := Fsck(); err != nil {
+			t.Errorf("Fsck() after prune error = %v", err)
+		}
+	})
+}
+
+func TestPruneKeepsIndexAndRefs(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	setupPruneRepo(t)
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A commit only reachable from another ref, e.g. a stash
+	head, _ := GetCurrentHead()
+	blob, _ := writeBlob(repo, []byte("stashed\n"))
+	treeData, _ := json.Marshal([]IndexEntry{{FilePath: "s.txt", Hash: blob, Modified: true}})
+	tree, _ := writeBlob(repo, treeData)
+	commitData, _ := json.Marshal(Commit{Author: "user", Date: time.Now(), Message: "stash", Parent: head, TreeHash: tree})
+	stash, _ := writeBlob(repo, commitData)
+	if err := writeRef(repo, "refs/stash", stash); err != nil {
+		t.Fatal(err)
+	}
+
+	// A staged but uncommitted file
+	if err := ioutil.WriteFile("staged.txt", []byte("staged\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("staged.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	captureOutput(t, func() {
+		if err := Prune(PruneOptions{}); err != nil {
+			t.Errorf("Prune() error = %v", err)
+		}
+	})
+
+	for _, hash := range []string{CalculateHash("staged\n"), stash, tree, blob} {
+		if !looseObjectExists(hash) {
+			t.Errorf("Prune() removed reachable object %s", hash)
+		}
+	}
+}
+
+func TestPruneRefusesBrokenRepository(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	draft := setupPruneRepo(t)
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRef(repo, branchRef("broken"), "not-a-hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Prune(PruneOptions{}); err == nil || !strings.Contains(err.Error(), "refusing to prune") {
+		t.Errorf("Prune() error = %v, want refusal", err)
+	}
+	if !looseObjectExists(draft) {
+		t.Errorf("Prune() removed objects from a broken repository")
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "now", want: 0},
+		{value: "14d", want: 14 * 24 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "90m", want: 90 * time.Minute},
+		{value: "soon", wantErr: true},
+		{value: "-1h", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseExpiry(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExpiry(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseExpiry(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
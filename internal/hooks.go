@@ -0,0 +1,89 @@
+// internal/hooks.go
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// HOOKS_DIR holds the executable hooks run at fixed points of some commands
+const HOOKS_DIR = "hooks"
+
+// Hook names and what they receive, following git
+const (
+	hookPreCommit        = "pre-commit"         // no arguments; may veto the commit
+	hookPrepareCommitMsg = "prepare-commit-msg" // message file, message source; may veto
+	hookCommitMsg        = "commit-msg"         // message file, which it may edit; may veto
+	hookPostCommit       = "post-commit"        // no arguments; notification only
+	hookPostCheckout     = "post-checkout"      // old head, new head, 1; notification only
+	hookPrePush          = "pre-push"           // remote name, url, refs on stdin; may veto
+	hookHTTPAuth         = "http-auth"          // user, service, password on stdin; may refuse the request
+)
+
+// runHook runs .gitter/hooks/<name> from the working tree root with the
+// given arguments and standard input, and returns an error if it exits
+// non-zero. A missing hook succeeds; one that is not executable is skipped
+// with a hint, like in git.
+func runHook(repo *Repository, name, stdin string, args ...string) error {
+	path := filepath.Join(repo.GitDir, HOOKS_DIR, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		fmt.Fprintf(os.Stderr, "hint: The '%s' hook was ignored because it's not set as executable.\n",
+			filepath.Join(GITTER_DIR, HOOKS_DIR, name))
+		return nil
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Dir = repo.WorkingDir
+	cmd.Env = append(os.Environ(),
+		"GITTER_DIR="+repo.GitDir,
+		"GITTER_INDEX_FILE="+filepath.Join(repo.GitDir, INDEX_FILE))
+	cmd.Stdin = strings.NewReader(stdin)
+	// Hook output is diagnostics, so keep it apart from the command's own
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%s hook exited with status %d", name, exitErr.ExitCode())
+		}
+		return fmt.Errorf("could not run %s hook: %v", name, err)
+	}
+	return nil
+}
+
+// hookInstalled reports whether a hook exists and would be run
+func hookInstalled(repo *Repository, name string) bool {
+	info, err := os.Stat(filepath.Join(repo.GitDir, HOOKS_DIR, name))
+	return err == nil && !info.IsDir() && info.Mode().Perm()&0111 != 0
+}
+
+// runPostCheckout tells the post-checkout hook that HEAD moved. The hook
+// cannot undo the checkout, so its failure is only reported.
+func runPostCheckout(repo *Repository, oldHead, newHead string) {
+	if err := runHook(repo, hookPostCheckout, "", hookCommitID(oldHead), hookCommitID(newHead), "1"); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+}
+
+// hookCommitID passes the all-zero hash for "no commit", as git does
+func hookCommitID(hash string) string {
+	if hash == "" {
+		return strings.Repeat("0", 40)
+	}
+	return hash
+}
@@ -0,0 +1,181 @@
+// internal/hooks_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeHook installs a shell script as a hook
+func writeHook(t *testing.T, name, script string) {
+	t.Helper()
+	path := filepath.Join(GITTER_DIR, HOOKS_DIR, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write %s hook: %v", name, err)
+	}
+}
+
+func TestCommitHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are shell scripts")
+	}
+
+	tests := []struct {
+		name     string
+		hooks    map[string]string
+		opts     CommitOptions
+		wantErr  string
+		wantMsg  string
+		wantFile string // created by a hook that ran
+	}{
+		{
+			name:    "pre-commit vetoes",
+			hooks:   map[string]string{hookPreCommit: "exit 1"},
+			wantErr: "pre-commit hook exited with status 1",
+		},
+		{
+			name:    "no-verify skips pre-commit",
+			hooks:   map[string]string{hookPreCommit: "exit 1"},
+			opts:    CommitOptions{NoVerify: true},
+			wantMsg: "Add a.txt",
+		},
+		{
+			name:    "commit-msg rejects the message",
+			hooks:   map[string]string{hookCommitMsg: `grep -q '^JIRA-[0-9]' "$1"`},
+			wantErr: "commit-msg hook exited with status 1",
+		},
+		{
+			name:    "commit-msg rewrites the message",
+			hooks:   map[string]string{hookCommitMsg: `printf 'JIRA-1 %s' "$(cat "$1")" > "$1"`},
+			wantMsg: "JIRA-1 Add a.txt",
+		},
+		{
+			name: "prepare-commit-msg runs despite no-verify",
+			hooks: map[string]string{
+				hookPrepareCommitMsg: `[ "$2" = message ] && echo "Signed-off-by: user" >> "$1"`,
+				hookCommitMsg:        "exit 1",
+			},
+			opts:    CommitOptions{NoVerify: true},
+			wantMsg: "Add a.txt\nSigned-off-by: user",
+		},
+		{
+			name:    "message emptied by a hook",
+			hooks:   map[string]string{hookCommitMsg: `: > "$1"`},
+			wantErr: "empty commit message",
+		},
+		{
+			name:     "post-commit failure does not undo the commit",
+			hooks:    map[string]string{hookPostCommit: "touch post-commit-ran; exit 3"},
+			wantMsg:  "Add a.txt",
+			wantFile: "post-commit-ran",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cleanup := setupTestRepo(t)
+			defer cleanup()
+
+			if err := InitRepository(); err != nil {
+				t.Fatal(err)
+			}
+			for name, script := range tt.hooks {
+				writeHook(t, name, script)
+			}
+			if err := ioutil.WriteFile("a.txt", []byte("a\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := AddFile("a.txt"); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			captureOutput(t, func() { err = CommitChanges("Add a.txt", tt.opts) })
+			head, headErr := GetCurrentHead()
+			if headErr != nil {
+				t.Fatal(headErr)
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("CommitChanges() error = %v, want %q", err, tt.wantErr)
+				}
+				if head != "" {
+					t.Errorf("a commit was made although the hook failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CommitChanges() error = %v", err)
+			}
+			repo, _ := FindGitterRepo()
+			commit, err := readCommit(repo, head)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if commit.Message != tt.wantMsg {
+				t.Errorf("commit message = %q, want %q", commit.Message, tt.wantMsg)
+			}
+			if tt.wantFile != "" {
+				if _, err := os.Stat(tt.wantFile); err != nil {
+					t.Errorf("hook did not run: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are shell scripts")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing hook succeeds
+	if err := runHook(repo, hookPrePush, ""); err != nil {
+		t.Errorf("runHook(missing) error = %v", err)
+	}
+
+	// Arguments, stdin and the environment reach the hook, which runs from
+	// the root of the working tree
+	writeHook(t, hookPrePush, `{ echo "$1 $2"; cat; echo "$GITTER_DIR"; pwd; } > hook-out`)
+	if err := os.Mkdir("sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runHook(repo, hookPrePush, "refs/heads/main abc\n", "origin", "/srv/repo"); err != nil {
+		t.Fatalf("runHook() error = %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(repo.WorkingDir, "hook-out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "origin /srv/repo\nrefs/heads/main abc\n" + repo.GitDir + "\n" + repo.WorkingDir + "\n"
+	if string(data) != want {
+		t.Errorf("hook saw %q, want %q", data, want)
+	}
+
+	// A hook that is not executable is skipped
+	hookPath := filepath.Join(repo.GitDir, HOOKS_DIR, hookPreCommit)
+	if err := ioutil.WriteFile(hookPath, []byte("#!/bin/sh\nexit 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runHook(repo, hookPreCommit, ""); err != nil {
+		t.Errorf("runHook(not executable) error = %v", err)
+	}
+}
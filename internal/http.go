@@ -0,0 +1,237 @@
+// internal/http.go
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+)
+
+// The smart HTTP protocol spoken with `gitter serve`:
+//
+//	GET  <url>/info/refs?service=<service>  the remote's refs as JSON
+//	POST <url>/gitter-upload-pack           wanted and known commits as JSON,
+//	                                        answered with a JSON line and a pack
+//	POST <url>/gitter-receive-pack          a JSON ref update line followed by
+//	                                        a pack, answered with the outcome
+//
+// A commit the client says it has is taken to come with its history, so the
+// server only packs what is newer.
+const (
+	serviceUploadPack  = "gitter-upload-pack"
+	serviceReceivePack = "gitter-receive-pack"
+	packContentType    = "application/x-gitter-pack"
+)
+
+// Credentials for servers that ask for them, when the URL carries none
+const (
+	envHTTPUser     = "GITTER_HTTP_USER"
+	envHTTPPassword = "GITTER_HTTP_PASSWORD"
+)
+
+// uploadRequest asks a server for the history of the wanted commits
+type uploadRequest struct {
+	Wants  []string `json:"wants"`
+	Haves  []string `json:"haves"`            // commits the client already has
+	Cut    []string `json:"cut,omitempty"`    // commits a shallow client has without their history
+	Depth  int      `json:"depth,omitempty"`  // commits of history to send for each want, 0 for all
+	Filter string   `json:"filter,omitempty"` // blobs to leave out, see parseBlobFilter
+	Blobs  []string `json:"blobs,omitempty"`  // blobs a partial clone left out and now needs
+	LFS    []string `json:"lfs,omitempty"`    // large files wanted, by oid
+}
+
+// uploadResponse comes before the pack that answers an uploadRequest
+type uploadResponse struct {
+	// Shallow maps the commits sent without their parents to the trees of
+	// their full snapshots
+	Shallow map[string]string `json:"shallow,omitempty"`
+
+	// LFS lists the large files sent between this line and the pack
+	LFS []lfsPointer `json:"lfs,omitempty"`
+}
+
+// receiveRequest asks a server to move a ref once the pack that follows it
+// is stored
+type receiveRequest struct {
+	Ref string `json:"ref"`
+	Old string `json:"old"` // empty to create the ref
+	New string `json:"new"`
+
+	// LFS lists the large files sent between this line and the pack
+	LFS []lfsPointer `json:"lfs,omitempty"`
+}
+
+// receiveResponse is the server's answer to a push
+type receiveResponse struct {
+	Rejected string `json:"rejected,omitempty"` // why the ref was not updated
+}
+
+// isHTTPURL reports whether a remote is reached over HTTP
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// displayURL hides the password of an HTTP URL before it is printed
+func displayURL(url string) string {
+	if !isHTTPURL(url) {
+		return url
+	}
+	u, err := neturl.Parse(url)
+	if err != nil || u.User == nil {
+		return url
+	}
+	u.User = neturl.User(u.User.Username())
+	return u.String()
+}
+
+// httpTransport reaches a remote served by `gitter serve`
+type httpTransport struct {
+	url      string // without credentials or a trailing slash
+	user     string
+	password string
+	client   *http.Client
+
+	advertised map[string]string // branches seen by refs, for push
+}
+
+// newHTTPTransport takes credentials from the URL, or else from the
+// environment
+func newHTTPTransport(url string) (*httpTransport, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL '%s': %v", url, err)
+	}
+	t := &httpTransport{client: http.DefaultClient}
+	if u.User != nil {
+		t.user = u.User.Username()
+		t.password, _ = u.User.Password()
+		u.User = nil
+	} else {
+		t.user, t.password = os.Getenv(envHTTPUser), os.Getenv(envHTTPPassword)
+	}
+	t.url = strings.TrimSuffix(u.String(), "/")
+	return t, nil
+}
+
+// do sends a request and turns HTTP failures into errors
+func (t *httpTransport) do(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, t.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if t.user != "" || t.password != "" {
+		req.SetBasicAuth(t.user, t.password)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access '%s': %v", t.url, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		if t.user == "" {
+			return nil, fmt.Errorf("authentication required for '%s'; put the credentials in the URL or in %s and %s",
+				t.url, envHTTPUser, envHTTPPassword)
+		}
+		return nil, fmt.Errorf("authentication failed for '%s'", t.url)
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("access to '%s' denied", t.url)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("'%s' does not appear to be a gitter repository", t.url)
+	default:
+		return nil, fmt.Errorf("unable to access '%s': %s: %s", t.url, resp.Status, strings.TrimSpace(string(message)))
+	}
+}
+
+func (t *httpTransport) refs(forPush bool) (remoteRefs, error) {
+	service := serviceUploadPack
+	if forPush {
+		service = serviceReceivePack
+	}
+	resp, err := t.do("GET", "/info/refs?service="+service, "", nil)
+	if err != nil {
+		return remoteRefs{}, err
+	}
+	defer resp.Body.Close()
+
+	var refs remoteRefs
+	if err := json.NewDecoder(resp.Body).Decode(&refs); err != nil {
+		return remoteRefs{}, fmt.Errorf("invalid ref advertisement from '%s': %v", t.url, err)
+	}
+	t.advertised = refs.Branches
+	return refs, nil
+}
+
+func (t *httpTransport) fetch(repo *Repository, request uploadRequest) (int, error) {
+	request, err := describeClient(repo, request)
+	if err != nil {
+		return 0, err
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := t.do("POST", "/"+serviceUploadPack, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	response, pack, err := readUpload(repo, bufio.NewReader(resp.Body))
+	if err != nil {
+		return 0, fmt.Errorf("invalid response from '%s': %v", t.url, err)
+	}
+	return receiveUpload(repo, response, pack)
+}
+
+func (t *httpTransport) push(repo *Repository, ref, oldHash, newHash string) error {
+	if t.advertised == nil {
+		if _, err := t.refs(true); err != nil {
+			return err
+		}
+	}
+
+	pack, large, err := pushPack(repo, t.advertised, newHash)
+	if err != nil {
+		return err
+	}
+
+	// Large files are streamed rather than held in memory
+	body, writer := io.Pipe()
+	go func() {
+		request := receiveRequest{Ref: ref, Old: oldHash, New: newHash, LFS: large}
+		writer.CloseWithError(writeReceive(writer, repo, request, pack))
+	}()
+	defer body.Close()
+	resp, err := t.do("POST", "/"+serviceReceivePack, packContentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result receiveResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid response from '%s': %v", t.url, err)
+	}
+	if result.Rejected != "" {
+		return &refRejection{reason: result.Rejected}
+	}
+	return nil
+}
+
+func (t *httpTransport) close() {}
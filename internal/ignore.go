@@ -0,0 +1,114 @@
+// internal/ignore.go
+package internal
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IGNORE_FILE lists patterns of untracked files to leave out of status and
+// directory adds, one per line in the working tree root
+const IGNORE_FILE = ".gitterignore"
+
+// ignoreRule is one pattern from the ignore file
+type ignoreRule struct {
+	pattern  string
+	negate   bool // "!pattern" re-includes what an earlier rule excluded
+	dirOnly  bool // "pattern/" only matches directories
+	anchored bool // patterns containing a slash match from the root
+}
+
+// ignoreRules is the parsed ignore file; later rules override earlier ones
+type ignoreRules []ignoreRule
+
+// loadIgnoreRules reads the ignore file, returning no rules when there is none
+func loadIgnoreRules(repo *Repository) (ignoreRules, error) {
+	return loadPatternFile(filepath.Join(repo.WorkingDir, IGNORE_FILE))
+}
+
+// loadPatternFile reads a file of patterns in the ignore file's syntax,
+// returning no rules when there is none
+func loadPatternFile(path string) (ignoreRules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules ignoreRules
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rule, ok := parsePattern(line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// parsePattern reads one pattern of the ignore file's syntax; ok is false
+// when nothing is left of it
+func parsePattern(line string) (rule ignoreRule, ok bool) {
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	line = strings.TrimPrefix(line, "**/")
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+// ignored reports whether a path relative to the working tree root is
+// excluded, either itself or through one of its parent directories
+func (rules ignoreRules) ignored(rel string, isDir bool) bool {
+	if len(rules) == 0 {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if rules.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return rules.match(rel, isDir)
+}
+
+// match applies the rules to a single path, the last matching rule winning
+func (rules ignoreRules) match(rel string, isDir bool) bool {
+	excluded := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		target := path.Base(rel)
+		if rule.anchored {
+			target = rel
+		}
+		if ok, _ := path.Match(rule.pattern, target); ok {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
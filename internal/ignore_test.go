@@ -0,0 +1,53 @@
+// internal/ignore_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	rules := "# build output\n*.log\n!keep.log\nbuild/\n/root.tmp\ndocs/*.bak\n**/cache\n"
+	if err := ioutil.WriteFile(IGNORE_FILE, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ignore, err := loadIgnoreRules(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "debug.log", want: true},
+		{path: "src/debug.log", want: true},
+		{path: "keep.log", want: false},
+		{path: "build", isDir: true, want: true},
+		{path: "build", isDir: false, want: false},
+		{path: "build/out.bin", want: true},
+		{path: "root.tmp", want: true},
+		{path: "src/root.tmp", want: false},
+		{path: "docs/old.bak", want: true},
+		{path: "docs/sub/old.bak", want: false},
+		{path: "a/b/cache", isDir: true, want: true},
+		{path: "main.go", want: false},
+		{path: IGNORE_FILE, want: false},
+	}
+	for _, tt := range tests {
+		if got := ignore.ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q, dir=%v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
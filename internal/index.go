@@ -0,0 +1,184 @@
+// internal/index.go
+package internal
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// The index is stored in a binary format, sorted by path:
+//
+//	"GNDX" | version uint32 | count uint32 | entries... | sha1 of the above
+//
+// Each entry holds its flags, the cached stat data, the file mode, the hash
+// (20 raw bytes, or length-prefixed text for a hash that is not a SHA1) and
+// the path. Version 1 had no file mode. Earlier versions stored the index as
+// a JSON array; such an index is read and rewritten in the binary format on
+// first load.
+const (
+	indexMagic   = "GNDX"
+	indexVersion = 2
+
+	indexFlagModified = 1 << 0
+	indexFlagTextHash = 1 << 1
+)
+
+// encodeIndex serialises entries, which must already be sorted by path
+func encodeIndex(index []IndexEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(indexMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(indexVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(index)))
+
+	for _, entry := range index {
+		if len(entry.FilePath) > 0xffff {
+			return nil, fmt.Errorf("path too long for the index: %.40s...", entry.FilePath)
+		}
+		raw, err := hex.DecodeString(entry.Hash)
+		textHash := err != nil || len(raw) != sha1.Size
+		if textHash && len(entry.Hash) > 0xff {
+			return nil, fmt.Errorf("index entry '%s' has an oversized hash", entry.FilePath)
+		}
+
+		var flags uint16
+		if entry.Modified {
+			flags |= indexFlagModified
+		}
+		if textHash {
+			flags |= indexFlagTextHash
+		}
+		binary.Write(&buf, binary.BigEndian, flags)
+		binary.Write(&buf, binary.BigEndian, entry.Size)
+		binary.Write(&buf, binary.BigEndian, entry.MTime)
+		binary.Write(&buf, binary.BigEndian, entry.CTime)
+		binary.Write(&buf, binary.BigEndian, entry.Inode)
+		binary.Write(&buf, binary.BigEndian, entry.Mode)
+		binary.Write(&buf, binary.BigEndian, entry.FileMode)
+		if textHash {
+			buf.WriteByte(byte(len(entry.Hash)))
+			buf.WriteString(entry.Hash)
+		} else {
+			buf.Write(raw)
+		}
+		binary.Write(&buf, binary.BigEndian, uint16(len(entry.FilePath)))
+		buf.WriteString(entry.FilePath)
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes(), nil
+}
+
+// decodeIndex parses an index file. The boolean reports whether it was in
+// the old JSON format and should be migrated.
+func decodeIndex(data []byte) ([]IndexEntry, bool, error) {
+	if !bytes.HasPrefix(data, []byte(indexMagic)) {
+		var index []IndexEntry
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, false, fmt.Errorf("index file is malformed: %v", err)
+		}
+		sortIndex(index)
+		return index, true, nil
+	}
+
+	const headerSize = 12
+	if len(data) < headerSize+sha1.Size {
+		return nil, false, fmt.Errorf("index file is truncated")
+	}
+	body := data[:len(data)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], data[len(body):]) {
+		return nil, false, fmt.Errorf("index file is corrupt (checksum mismatch)")
+	}
+	version := binary.BigEndian.Uint32(body[4:8])
+	if version < 1 || version > indexVersion {
+		return nil, false, fmt.Errorf("index file has unsupported version %d", version)
+	}
+	count := binary.BigEndian.Uint32(body[8:12])
+
+	// The smallest entry is a version 1 one with an empty text hash and path:
+	// flags, four 8-byte stat fields, mode, hash length and path length
+	const minEntrySize = 2 + 4*8 + 4 + 1 + 2
+	if uint64(count) > uint64(len(body)-headerSize)/minEntrySize {
+		return nil, false, fmt.Errorf("index file claims %d entries in %d bytes", count, len(body)-headerSize)
+	}
+
+	reader := bytes.NewReader(body[headerSize:])
+	index := make([]IndexEntry, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var entry IndexEntry
+		var flags uint16
+		fields := []interface{}{&flags, &entry.Size, &entry.MTime, &entry.CTime, &entry.Inode, &entry.Mode}
+		if version >= 2 {
+			fields = append(fields, &entry.FileMode)
+		}
+		for _, field := range fields {
+			if err := binary.Read(reader, binary.BigEndian, field); err != nil {
+				return nil, false, fmt.Errorf("index file is truncated")
+			}
+		}
+		entry.Modified = flags&indexFlagModified != 0
+
+		var hash []byte
+		if flags&indexFlagTextHash != 0 {
+			n, err := reader.ReadByte()
+			if err != nil {
+				return nil, false, fmt.Errorf("index file is truncated")
+			}
+			hash = make([]byte, n)
+		} else {
+			hash = make([]byte, sha1.Size)
+		}
+		if _, err := io.ReadFull(reader, hash); err != nil {
+			return nil, false, fmt.Errorf("index file is truncated")
+		}
+		if flags&indexFlagTextHash != 0 {
+			entry.Hash = string(hash)
+		} else {
+			entry.Hash = hex.EncodeToString(hash)
+		}
+
+		var pathLen uint16
+		if err := binary.Read(reader, binary.BigEndian, &pathLen); err != nil {
+			return nil, false, fmt.Errorf("index file is truncated")
+		}
+		path := make([]byte, pathLen)
+		if _, err := io.ReadFull(reader, path); err != nil {
+			return nil, false, fmt.Errorf("index file is truncated")
+		}
+		entry.FilePath = string(path)
+
+		index = append(index, entry)
+	}
+	if reader.Len() != 0 {
+		return nil, false, fmt.Errorf("index file has %d trailing bytes", reader.Len())
+	}
+	return index, false, nil
+}
+
+// sortIndex orders entries by path, keeping duplicates in their order
+func sortIndex(index []IndexEntry) {
+	sort.SliceStable(index, func(i, j int) bool { return index[i].FilePath < index[j].FilePath })
+}
+
+// findIndexEntry binary searches an index sorted by path and returns the
+// position of the entry for path, or where it would be inserted
+func findIndexEntry(index []IndexEntry, path string) (int, bool) {
+	i := sort.Search(len(index), func(i int) bool { return index[i].FilePath >= path })
+	return i, i < len(index) && index[i].FilePath == path
+}
+
+// indexByPath maps each path to its position in the index, for callers that
+// look up many paths while appending entries
+func indexByPath(index []IndexEntry) map[string]int {
+	positions := make(map[string]int, len(index))
+	for i, entry := range index {
+		positions[entry.FilePath] = i
+	}
+	return positions
+}
@@ -0,0 +1,143 @@
+// internal/index_test.go
+package internal
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndexEncoding(t *testing.T) {
+	index := []IndexEntry{
+		{FilePath: "a.txt", Hash: CalculateHash("a"), Modified: true, Size: 1, MTime: 1700000000000000000, CTime: 1700000000000000001, Inode: 42, Mode: 0644, FileMode: MODE_EXECUTABLE},
+		{FilePath: "dir/b.txt", Hash: CalculateHash("b")},
+		{FilePath: "legacy.txt", Hash: "abc123"},
+	}
+
+	data, err := encodeIndex(index)
+	if err != nil {
+		t.Fatalf("encodeIndex() error = %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte(indexMagic)) {
+		t.Errorf("encodeIndex() does not start with the %q header", indexMagic)
+	}
+
+	got, isJSON, err := decodeIndex(data)
+	if err != nil || isJSON {
+		t.Fatalf("decodeIndex() = %v, json %v", err, isJSON)
+	}
+	if !reflect.DeepEqual(got, index) {
+		t.Errorf("decodeIndex() = %+v, want %+v", got, index)
+	}
+
+	// Any damaged byte is caught by the checksum
+	corrupt := append([]byte{}, data...)
+	corrupt[20] ^= 0xff
+	if _, _, err := decodeIndex(corrupt); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("decodeIndex(corrupt) error = %v", err)
+	}
+	if _, _, err := decodeIndex(data[:10]); err == nil {
+		t.Errorf("decodeIndex(truncated) succeeded")
+	}
+}
+
+func TestDecodeIndexVersion1(t *testing.T) {
+	// Version 1 entries have no file mode
+	var buf bytes.Buffer
+	buf.WriteString(indexMagic)
+	binary.Write(&buf, binary.BigEndian, []uint32{1, 1})
+	binary.Write(&buf, binary.BigEndian, uint16(0))
+	binary.Write(&buf, binary.BigEndian, []int64{5, 0, 0, 0})
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	raw, _ := hex.DecodeString(CalculateHash("old"))
+	buf.Write(raw)
+	binary.Write(&buf, binary.BigEndian, uint16(len("old.txt")))
+	buf.WriteString("old.txt")
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	got, _, err := decodeIndex(buf.Bytes())
+	if err != nil {
+		t.Fatalf("decodeIndex(v1) error = %v", err)
+	}
+	want := []IndexEntry{{FilePath: "old.txt", Hash: CalculateHash("old"), Size: 5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeIndex(v1) = %+v, want %+v", got, want)
+	}
+	if entryMode(got[0]) != MODE_FILE {
+		t.Errorf("v1 entry mode = %06o, want a regular file", entryMode(got[0]))
+	}
+}
+
+func TestDecodeIndexRejectsBadCount(t *testing.T) {
+	// A huge entry count in a tiny file is refused before anything is allocated
+	var buf bytes.Buffer
+	buf.WriteString(indexMagic)
+	binary.Write(&buf, binary.BigEndian, []uint32{indexVersion, 0xffffffff})
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	if _, _, err := decodeIndex(buf.Bytes()); err == nil || !strings.Contains(err.Error(), "claims") {
+		t.Errorf("decodeIndex(bad count) error = %v", err)
+	}
+}
+
+func TestLoadIndexMigratesJSON(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	indexPath := filepath.Join(GITTER_DIR, INDEX_FILE)
+	legacy := `[{"file_path":"z.txt","hash":"` + CalculateHash("z") + `","modified":true},` +
+		`{"file_path":"a.txt","hash":"` + CalculateHash("a") + `","modified":false}]`
+	if err := ioutil.WriteFile(indexPath, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		t.Fatalf("LoadIndex() error = %v", err)
+	}
+	if len(index) != 2 || index[0].FilePath != "a.txt" || index[1].FilePath != "z.txt" || !index[1].Modified {
+		t.Errorf("LoadIndex() = %+v, want a.txt then staged z.txt", index)
+	}
+
+	data, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(indexMagic)) {
+		t.Errorf("index was not rewritten in the binary format")
+	}
+}
+
+func TestFindIndexEntry(t *testing.T) {
+	var index []IndexEntry
+	for _, path := range []string{"m.txt", "a.txt", "z/last.txt", "d/mid.txt"} {
+		index = stageEntry(index, path, CalculateHash(path), MODE_FILE)
+	}
+	index = stageEntry(index, "d/mid.txt", CalculateHash("changed"), MODE_FILE)
+
+	var paths []string
+	for _, entry := range index {
+		paths = append(paths, entry.FilePath)
+	}
+	if got := strings.Join(paths, ","); got != "a.txt,d/mid.txt,m.txt,z/last.txt" {
+		t.Errorf("stageEntry() order = %s", got)
+	}
+
+	if i, found := findIndexEntry(index, "d/mid.txt"); !found || index[i].Hash != CalculateHash("changed") {
+		t.Errorf("findIndexEntry(d/mid.txt) = %d, %v", i, found)
+	}
+	if i, found := findIndexEntry(index, "b.txt"); found || i != 1 {
+		t.Errorf("findIndexEntry(b.txt) = %d, %v, want insert position 1", i, found)
+	}
+}
@@ -0,0 +1,474 @@
+// internal/lfs.go
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Files matching the patterns in LFS_FILE, or with the filter=lfs attribute,
+// are kept out of the object store.
+// The blob committed for one is a small pointer naming the SHA-256 and size
+// of its contents, which live in .gitter/lfs instead: adding the file cleans
+// it into a pointer and stores the contents there, and checking it out
+// smudges the pointer back into the contents, fetching them from the remote
+// when they are not here yet. Pushes send the large files of the pointers
+// they carry.
+
+// LFS_FILE lists patterns of files kept in large file storage, in the ignore
+// file's syntax, in the working tree root
+const LFS_FILE = ".gitterlfs"
+
+// LFS_DIR holds the contents of large files, named by their SHA-256
+const LFS_DIR = "lfs"
+
+// lfsPointerVersion opens every pointer
+const lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// lfsPointer is what the blob of a large file records about its contents
+type lfsPointer struct {
+	Oid  string `json:"oid"` // SHA-256 of the contents, in hex
+	Size int64  `json:"size"`
+}
+
+// String returns the pointer as it is stored in a blob
+func (p lfsPointer) String() string {
+	return fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, p.Oid, p.Size)
+}
+
+// parseLFSPointer reads a blob as a pointer; ok is false for any other blob
+func parseLFSPointer(data []byte) (pointer lfsPointer, ok bool) {
+	if len(data) > 1024 || !bytes.HasPrefix(data, []byte(lfsPointerVersion+"\n")) {
+		return pointer, false
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "oid sha256:") || !strings.HasPrefix(lines[2], "size ") {
+		return pointer, false
+	}
+	pointer.Oid = strings.TrimPrefix(lines[1], "oid sha256:")
+	size, err := strconv.ParseInt(strings.TrimPrefix(lines[2], "size "), 10, 64)
+	if err != nil || size < 0 || !validOid(pointer.Oid) {
+		return pointer, false
+	}
+	pointer.Size = size
+	return pointer, true
+}
+
+// validOid reports whether oid is a SHA-256 in lowercase hex, and so safe to
+// use as a file name
+func validOid(oid string) bool {
+	if len(oid) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range oid {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// lfsObjectPath returns where the contents of a large file are stored
+func lfsObjectPath(repo *Repository, oid string) string {
+	return filepath.Join(repo.GitDir, LFS_DIR, oid)
+}
+
+// lfsObjectExists reports whether the contents of a large file are here
+func lfsObjectExists(repo *Repository, oid string) bool {
+	_, err := os.Stat(lfsObjectPath(repo, oid))
+	return err == nil
+}
+
+// pointerOf works out the pointer for a file's contents. A file that holds a
+// pointer already, as one whose contents could not be fetched does, is its
+// own pointer.
+func pointerOf(path string) (lfsPointer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return lfsPointer{}, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	head := &limitedBuffer{limit: 1025}
+	size, err := io.Copy(io.MultiWriter(hasher, head), file)
+	if err != nil {
+		return lfsPointer{}, err
+	}
+	if pointer, ok := parseLFSPointer(head.Bytes()); ok {
+		return pointer, nil
+	}
+	return lfsPointer{Oid: hex.EncodeToString(hasher.Sum(nil)), Size: size}, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.Buffer.Write(p[:room])
+	}
+	return len(p), nil
+}
+
+// hashWorkingFile returns the hash of the blob stored for a working tree
+// file: that of its pointer for a large file, of its cleaned contents for
+// one the filters convert, else that of its contents
+func hashWorkingFile(filters *workingFilters, rel, path string) (string, error) {
+	large, converts := filters.largeFile(rel), filters.converts(rel)
+	if large || converts {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode().IsRegular() {
+			data, err := cleanWorkingFile(filters, rel, path, large)
+			if err != nil {
+				return "", err
+			}
+			return CalculateHash(string(data)), nil
+		}
+	}
+	return hashFile(path)
+}
+
+// cleanWorkingFile returns the blob for a regular working tree file that is
+// large or converted, without storing anything
+func cleanWorkingFile(filters *workingFilters, rel, path string, large bool) ([]byte, error) {
+	if large {
+		pointer, err := pointerOf(path)
+		return []byte(pointer.String()), err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return filters.clean(rel, data)
+}
+
+// storeWorkingFile stores the blob for a working tree file under hash, which
+// hashWorkingFile gave; a large file has its contents put in large file
+// storage and its pointer in the object store
+func storeWorkingFile(repo *Repository, filters *workingFilters, rel, path, hash string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || !filters.largeFile(rel) && !filters.converts(rel) {
+		return copyFile(path, filepath.Join(repo.GitDir, OBJECTS_DIR, hash))
+	}
+	if !filters.largeFile(rel) {
+		data, err := cleanWorkingFile(filters, rel, path, false)
+		if err != nil {
+			return err
+		}
+		if CalculateHash(string(data)) != hash {
+			return fmt.Errorf("%s changed while it was being added", rel)
+		}
+		return writeObject(repo, hash, data)
+	}
+	if info.Size() <= 1024 {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, ok := parseLFSPointer(data); ok && CalculateHash(string(data)) == hash {
+			return writeObject(repo, hash, data)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	pointer, err := storeLFSObject(repo, file, nil)
+	if err != nil {
+		return err
+	}
+	if CalculateHash(pointer.String()) != hash {
+		return fmt.Errorf("%s changed while it was being added", rel)
+	}
+	return writeObject(repo, hash, []byte(pointer.String()))
+}
+
+// storeLFSObject copies contents into large file storage and returns their
+// pointer. With want set the contents must match it, as when they come from
+// another repository.
+func storeLFSObject(repo *Repository, contents io.Reader, want *lfsPointer) (lfsPointer, error) {
+	dir := filepath.Join(repo.GitDir, LFS_DIR)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return lfsPointer{}, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return lfsPointer{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return lfsPointer{}, err
+	}
+
+	pointer := lfsPointer{Oid: hex.EncodeToString(hasher.Sum(nil)), Size: size}
+	if want != nil && pointer != *want {
+		return pointer, fmt.Errorf("large file %s is corrupt", want.Oid[:10])
+	}
+	if lfsObjectExists(repo, pointer.Oid) {
+		return pointer, nil
+	}
+	return pointer, os.Rename(tmp.Name(), lfsObjectPath(repo, pointer.Oid))
+}
+
+// smudgeFile writes the contents a pointer names to a working tree path,
+// fetching them first when they are not here
+func smudgeFile(repo *Repository, pointer lfsPointer, path string, mode uint32) error {
+	if !lfsObjectExists(repo, pointer.Oid) {
+		if err := fetchLFSObjects(repo, []string{pointer.Oid}); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if err := copyFile(lfsObjectPath(repo, pointer.Oid), path); err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if mode == MODE_EXECUTABLE {
+		perm = 0755
+	}
+	return os.Chmod(path, perm)
+}
+
+// lfsRemote returns the remote large files are fetched from: origin, or
+// else the first remote
+func lfsRemote(repo *Repository) (Remote, bool, error) {
+	remote, found, err := findRemote(repo, defaultRemote)
+	if err != nil || found {
+		return remote, found, err
+	}
+	remotes, err := loadRemotes(repo)
+	if err != nil || len(remotes) == 0 {
+		return Remote{}, false, err
+	}
+	return remotes[0], true, nil
+}
+
+// fetchLFSObjects fetches the contents of large files from the remote
+func fetchLFSObjects(repo *Repository, oids []string) error {
+	remote, found, err := lfsRemote(repo)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("large file %s is not here and there is no remote to fetch it from", oids[0][:10])
+	}
+	source, err := openTransport(repo, remote.URL)
+	if err != nil {
+		return err
+	}
+	defer source.close()
+	if _, err := source.fetch(repo, uploadRequest{LFS: oids}); err != nil {
+		return fmt.Errorf("could not fetch large files from %s: %v", remote.Name, err)
+	}
+	return nil
+}
+
+// lfsPointersIn returns the pointers among the objects with the given
+// hashes whose large files are here. Large files never fetched here cannot
+// be sent on; the repository they came from still has them.
+func lfsPointersIn(repo *Repository, hashes []string) ([]lfsPointer, error) {
+	var pointers []lfsPointer
+	seen := make(map[string]bool)
+	for _, hash := range hashes {
+		data, err := readObject(repo, hash)
+		if err != nil {
+			return nil, fmt.Errorf("could not read object %s: %v", hash[:7], err)
+		}
+		pointer, ok := parseLFSPointer(data)
+		if ok && !seen[pointer.Oid] && lfsObjectExists(repo, pointer.Oid) {
+			seen[pointer.Oid] = true
+			pointers = append(pointers, pointer)
+		}
+	}
+	return pointers, nil
+}
+
+// lfsPointersFor returns the pointers of the large files with the given
+// oids, for sending them
+func lfsPointersFor(repo *Repository, oids []string) ([]lfsPointer, error) {
+	pointers := make([]lfsPointer, 0, len(oids))
+	for _, oid := range oids {
+		if !validOid(oid) {
+			return nil, fmt.Errorf("invalid large file name '%s'", oid)
+		}
+		info, err := os.Stat(lfsObjectPath(repo, oid))
+		if err != nil {
+			return nil, fmt.Errorf("large file %s not found", oid[:10])
+		}
+		pointers = append(pointers, lfsPointer{Oid: oid, Size: info.Size()})
+	}
+	return pointers, nil
+}
+
+// copyLFSObjects copies large files from one repository's store to
+// another's
+func copyLFSObjects(src, dst *Repository, pointers []lfsPointer) error {
+	for _, pointer := range pointers {
+		if lfsObjectExists(dst, pointer.Oid) {
+			continue
+		}
+		file, err := os.Open(lfsObjectPath(src, pointer.Oid))
+		if err != nil {
+			return fmt.Errorf("large file %s not found", pointer.Oid[:10])
+		}
+		_, err = storeLFSObject(dst, file, &pointer)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLFSObjects sends the contents of large files one after another, as
+// they go between a message and its pack
+func writeLFSObjects(out io.Writer, repo *Repository, pointers []lfsPointer) error {
+	for _, pointer := range pointers {
+		file, err := os.Open(lfsObjectPath(repo, pointer.Oid))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, io.LimitReader(file, pointer.Size))
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLFSObjects stores the large files writeLFSObjects sent, checking each
+// against its pointer
+func readLFSObjects(repo *Repository, in io.Reader, pointers []lfsPointer) error {
+	for i := range pointers {
+		if !validOid(pointers[i].Oid) {
+			return fmt.Errorf("invalid large file name '%s'", pointers[i].Oid)
+		}
+		contents := io.LimitReader(in, pointers[i].Size)
+		if _, err := storeLFSObject(repo, contents, &pointers[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LFSTrack adds patterns to LFS_FILE, or lists the patterns when none are
+// given
+func LFSTrack(patterns []string) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(repo.WorkingDir, LFS_FILE)
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			existing[line] = true
+		}
+	}
+
+	if len(patterns) == 0 {
+		fmt.Println("Listing tracked patterns")
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); existing[line] {
+				fmt.Printf("    %s (%s)\n", line, LFS_FILE)
+			}
+		}
+		return nil
+	}
+
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	for _, pattern := range patterns {
+		if existing[pattern] {
+			fmt.Printf("\"%s\" already supported\n", pattern)
+			continue
+		}
+		existing[pattern] = true
+		data = append(data, pattern+"\n"...)
+		fmt.Printf("Tracking \"%s\"\n", pattern)
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LFSListFiles lists the files in the index stored as pointers, with the
+// start of their oid and * when the contents are here or - when only the
+// pointer is
+func LFSListFiles() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, len(index))
+	for i, entry := range index {
+		hashes[i] = entry.Hash
+	}
+	if err := prefetchBlobs(repo, hashes); err != nil {
+		return err
+	}
+
+	for _, entry := range index {
+		if entryMode(entry) == MODE_SYMLINK {
+			continue
+		}
+		data, err := readObject(repo, entry.Hash)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", entry.FilePath, err)
+		}
+		pointer, ok := parseLFSPointer(data)
+		if !ok {
+			continue
+		}
+		marker := "-"
+		if lfsObjectExists(repo, pointer.Oid) {
+			marker = "*"
+		}
+		fmt.Printf("%s %s %s\n", pointer.Oid[:10], marker, entry.FilePath)
+	}
+	return nil
+}
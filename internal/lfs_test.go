@@ -0,0 +1,242 @@
+// internal/lfs_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseLFSPointer(t *testing.T) {
+	pointer := lfsPointer{Oid: strings.Repeat("ab", 32), Size: 12345}
+	if got, ok := parseLFSPointer([]byte(pointer.String())); !ok || got != pointer {
+		t.Errorf("parseLFSPointer(%q) = %+v, %v", pointer.String(), got, ok)
+	}
+	for _, data := range []string{
+		"",
+		"hello\n",
+		lfsPointerVersion + "\noid sha256:abc\nsize 1\n",
+		lfsPointerVersion + "\noid sha256:" + strings.Repeat("../", 22) + "\nsize 1\n",
+		lfsPointerVersion + "\noid sha256:" + pointer.Oid + "\nsize -1\n",
+		lfsPointerVersion + "\noid sha256:" + pointer.Oid + "\nsize 1\nextra\n",
+	} {
+		if got, ok := parseLFSPointer([]byte(data)); ok {
+			t.Errorf("parseLFSPointer(%q) = %+v, want no pointer", data, got)
+		}
+	}
+}
+
+func TestLFSAddAndCheckout(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := FindGitterRepo()
+
+	output := captureOutput(t, func() {
+		if err := LFSTrack([]string{"*.psd", "*.psd"}); err != nil {
+			t.Fatalf("LFSTrack() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, `Tracking "*.psd"`) || !strings.Contains(output, "already supported") {
+		t.Errorf("LFSTrack() output = %q", output)
+	}
+
+	big := strings.Repeat("layer\n", 1000)
+	if err := os.Mkdir("art", 0755); err != nil {
+		t.Fatal(err)
+	}
+	var first string
+	captureOutput(t, func() {
+		commitFile(t, LFS_FILE, readWorkingFile(t, LFS_FILE), "Track psd files")
+		first = commitFile(t, "art/cover.psd", big, "Add cover")
+	})
+
+	// The tree records a pointer and the contents go to large file storage
+	snapshot, err := loadSnapshot(repo, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := readObject(repo, snapshot["art/cover.psd"].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointer, ok := parseLFSPointer(stored)
+	if !ok || pointer.Size != int64(len(big)) {
+		t.Fatalf("stored blob = %q, want a pointer", stored)
+	}
+	if !lfsObjectExists(repo, pointer.Oid) || objectExists(repo, CalculateHash(big)) {
+		t.Errorf("the contents were not kept out of the object store")
+	}
+	if output := captureOutput(t, func() { ShowStatus() }); !strings.Contains(output, "nothing to commit") {
+		t.Errorf("status after commit = %q", output)
+	}
+	output = captureOutput(t, func() { LFSListFiles() })
+	if want := pointer.Oid[:10] + " * art/cover.psd\n"; output != want {
+		t.Errorf("ls-files = %q, want %q", output, want)
+	}
+
+	// Changes show up, and diff compares pointers
+	if err := ioutil.WriteFile(filepath.Join("art", "cover.psd"), []byte(big+"more\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output = captureOutput(t, func() { ShowStatus() })
+	if !strings.Contains(output, "art/cover.psd") {
+		t.Errorf("status of a changed large file = %q", output)
+	}
+	output = captureOutput(t, func() { ShowDiff("", DiffOptions{}) })
+	if !strings.Contains(output, "-oid sha256:"+pointer.Oid) || strings.Contains(output, "layer") {
+		t.Errorf("diff of a large file = %q", output)
+	}
+
+	// Checkout smudges the pointer back
+	var second string
+	captureOutput(t, func() {
+		second = commitFile(t, "art/cover.psd", big+"more\n", "Grow cover")
+		if err := checkoutCommit(repo, first); err != nil {
+			t.Fatalf("checkoutCommit() error = %v", err)
+		}
+	})
+	if got := readWorkingFile(t, "art/cover.psd"); got != big {
+		t.Errorf("cover.psd has %d bytes after checkout, want %d", len(got), len(big))
+	}
+	captureOutput(t, func() {
+		if err := checkoutCommit(repo, second); err != nil {
+			t.Fatalf("checkoutCommit() error = %v", err)
+		}
+	})
+	if got := readWorkingFile(t, "art/cover.psd"); got != big+"more\n" {
+		t.Errorf("cover.psd has %d bytes after checkout back", len(got))
+	}
+
+	// Contents that are not here and cannot be fetched fail the checkout
+	if err := os.RemoveAll(filepath.Join(repo.GitDir, LFS_DIR)); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		if err := checkoutCommit(repo, first); err == nil || !strings.Contains(err.Error(), "no remote") {
+			t.Errorf("checkoutCommit() without the contents error = %v", err)
+		}
+	})
+}
+
+func TestLFSTransfer(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	hub := &Repository{GitDir: filepath.Join(top, "hub.gitter")}
+	big := strings.Repeat("pixel", 2000)
+	if err := LFSTrack([]string{"*.psd"}); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		commitFile(t, LFS_FILE, "*.psd\n", "Track psd files")
+		commitFile(t, "cover.psd", big, "Add cover")
+		if err := Push("", "", PushOptions{}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	})
+	pointer, err := pointerOf("cover.psd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lfsObjectExists(hub, pointer.Oid) {
+		t.Fatalf("a local push did not send the large file")
+	}
+
+	// Clones over HTTP fetch the contents they check out
+	srv := serveHub(t, top, nil)
+	defer srv.Close()
+	bob := filepath.Join(top, "bob")
+	captureOutput(t, func() {
+		if err := Clone(srv.URL+"/", bob, CloneOptions{}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+	inDir(t, bob, func() {
+		if got := readWorkingFile(t, "cover.psd"); got != big {
+			t.Errorf("cover.psd has %d bytes in the clone", len(got))
+		}
+	})
+
+	// Pushes over HTTP send new ones
+	srvPush := serveHub(t, top, func(user, password, service string) bool { return true })
+	defer srvPush.Close()
+	var back lfsPointer
+	inDir(t, bob, func() {
+		if err := RemoteAdd("open", srvPush.URL+"/"); err != nil {
+			t.Fatal(err)
+		}
+		captureOutput(t, func() {
+			commitFile(t, "back.psd", big+big, "Add back")
+			if err := Push("open", "main", PushOptions{}); err != nil {
+				t.Fatalf("Push() over HTTP error = %v", err)
+			}
+		})
+		back, _ = pointerOf("back.psd")
+	})
+	if !lfsObjectExists(hub, back.Oid) {
+		t.Errorf("a push over HTTP did not send the large file")
+	}
+
+	// A pull from a directory copies what it checks out
+	captureOutput(t, func() {
+		if err := Pull("", "", PullOptions{}); err != nil {
+			t.Fatalf("Pull() error = %v", err)
+		}
+	})
+	if got := readWorkingFile(t, "back.psd"); got != big+big {
+		t.Errorf("back.psd has %d bytes after pull", len(got))
+	}
+
+	// Over ssh too
+	if runtime.GOOS != "windows" {
+		fakeSSH(t, top)
+		carol := filepath.Join(top, "carol")
+		captureOutput(t, func() {
+			if err := Clone("example.com:"+hub.GitDir, carol, CloneOptions{}); err != nil {
+				t.Fatalf("Clone() over ssh error = %v", err)
+			}
+		})
+		inDir(t, carol, func() {
+			if got := readWorkingFile(t, "back.psd"); got != big+big {
+				t.Errorf("back.psd has %d bytes in the ssh clone", len(got))
+			}
+			captureOutput(t, func() {
+				commitFile(t, "side.psd", "side", "Add side")
+				if err := Push("", "", PushOptions{}); err != nil {
+					t.Fatalf("Push() over ssh error = %v", err)
+				}
+			})
+		})
+		if !lfsObjectExists(hub, pointerOfString(t, "side")) {
+			t.Errorf("a push over ssh did not send the large file")
+		}
+	}
+
+	// Unknown large files are refused
+	err = checkWants(hub, uploadRequest{LFS: []string{strings.Repeat("0", 64)}})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("checkWants(unknown large file) error = %v", err)
+	}
+	if err := checkWants(hub, uploadRequest{LFS: []string{"../../config"}}); err == nil {
+		t.Errorf("checkWants() of a path succeeded")
+	}
+}
+
+// pointerOfString returns the oid of a large file's contents
+func pointerOfString(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "contents")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pointer, err := pointerOf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pointer.Oid
+}
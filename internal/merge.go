@@ -0,0 +1,145 @@
+// internal/merge.go
+package internal
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// mergeHunk is a change against the base: base lines [start, end) are
+// replaced by lines
+type mergeHunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+// splitLines splits content into lines that keep their line endings
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffHunks returns the changes needed to turn base into other
+func diffHunks(base, other []string) []mergeHunk {
+	var hunks []mergeHunk
+	matcher := difflib.NewMatcherWithJunk(base, other, false, nil)
+	for _, op := range matcher.GetOpCodes() {
+		if op.Tag == 'e' {
+			continue
+		}
+		hunks = append(hunks, mergeHunk{start: op.I1, end: op.I2, lines: other[op.J1:op.J2]})
+	}
+	return hunks
+}
+
+// applyHunks rebuilds base[start:end] with the given hunks applied
+func applyHunks(base []string, start, end int, hunks []mergeHunk) []string {
+	var out []string
+	pos := start
+	for _, h := range hunks {
+		out = append(out, base[pos:h.start]...)
+		out = append(out, h.lines...)
+		pos = h.end
+	}
+	return append(out, base[pos:end]...)
+}
+
+// mergeLines performs a line based three-way merge of ours and theirs against
+// their common base. Overlapping or adjacent changes that differ are written
+// out between conflict markers and reported through the second result.
+func mergeLines(base, ours, theirs, oursLabel, theirsLabel string) (string, bool) {
+	baseLines := splitLines(base)
+	oursHunks := diffHunks(baseLines, splitLines(ours))
+	theirsHunks := diffHunks(baseLines, splitLines(theirs))
+
+	var out []string
+	conflict := false
+	pos := 0
+	o, t := 0, 0
+
+	for o < len(oursHunks) || t < len(theirsHunks) {
+		// Start a region with whichever change comes first in the base
+		var start, end int
+		if t >= len(theirsHunks) || (o < len(oursHunks) && oursHunks[o].start <= theirsHunks[t].start) {
+			start, end = oursHunks[o].start, oursHunks[o].end
+		} else {
+			start, end = theirsHunks[t].start, theirsHunks[t].end
+		}
+
+		// Grow the region until no change from either side touches it
+		var regionOurs, regionTheirs []mergeHunk
+		for {
+			grown := false
+			if o < len(oursHunks) && oursHunks[o].start <= end {
+				if oursHunks[o].end > end {
+					end = oursHunks[o].end
+				}
+				regionOurs = append(regionOurs, oursHunks[o])
+				o++
+				grown = true
+			}
+			if t < len(theirsHunks) && theirsHunks[t].start <= end {
+				if theirsHunks[t].end > end {
+					end = theirsHunks[t].end
+				}
+				regionTheirs = append(regionTheirs, theirsHunks[t])
+				t++
+				grown = true
+			}
+			if !grown {
+				break
+			}
+		}
+
+		out = append(out, baseLines[pos:start]...)
+		oursText := applyHunks(baseLines, start, end, regionOurs)
+		theirsText := applyHunks(baseLines, start, end, regionTheirs)
+
+		switch {
+		case len(regionTheirs) == 0:
+			out = append(out, oursText...)
+		case len(regionOurs) == 0:
+			out = append(out, theirsText...)
+		case strings.Join(oursText, "") == strings.Join(theirsText, ""):
+			out = append(out, oursText...)
+		default:
+			conflict = true
+			out = append(out, "<<<<<<< "+oursLabel+"\n")
+			out = append(out, terminateLines(oursText)...)
+			out = append(out, "=======\n")
+			out = append(out, terminateLines(theirsText)...)
+			out = append(out, ">>>>>>> "+theirsLabel+"\n")
+		}
+		pos = end
+	}
+
+	out = append(out, baseLines[pos:]...)
+	return strings.Join(out, ""), conflict
+}
+
+// terminateLines makes sure the last line ends with a newline so that
+// conflict markers always start on their own line
+func terminateLines(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	terminated := append([]string{}, lines...)
+	terminated[len(terminated)-1] += "\n"
+	return terminated
+}
+
+// hasConflictMarkers reports whether content still contains unresolved
+// conflict markers
+func hasConflictMarkers(content string) bool {
+	for _, line := range splitLines(content) {
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true
+		}
+	}
+	return false
+}
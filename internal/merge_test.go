@@ -0,0 +1,104 @@
+// internal/merge_test.go
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMergeLines(t *testing.T) {
+	base := "one\ntwo\nthree\nfour\nfive\n"
+
+	tests := []struct {
+		name         string
+		ours         string
+		theirs       string
+		want         string
+		wantConflict bool
+	}{
+		{
+			name:   "Only ours changed",
+			ours:   "one\nTWO\nthree\nfour\nfive\n",
+			theirs: base,
+			want:   "one\nTWO\nthree\nfour\nfive\n",
+		},
+		{
+			name:   "Only theirs changed",
+			ours:   base,
+			theirs: "one\ntwo\nthree\nfour\nFIVE\n",
+			want:   "one\ntwo\nthree\nfour\nFIVE\n",
+		},
+		{
+			name:   "Separate changes",
+			ours:   "ONE\ntwo\nthree\nfour\nfive\n",
+			theirs: "one\ntwo\nthree\nfour\nFIVE\nsix\n",
+			want:   "ONE\ntwo\nthree\nfour\nFIVE\nsix\n",
+		},
+		{
+			name:   "Same change on both sides",
+			ours:   "one\ntwo\n3\nfour\nfive\n",
+			theirs: "one\ntwo\n3\nfour\nfive\n",
+			want:   "one\ntwo\n3\nfour\nfive\n",
+		},
+		{
+			name:         "Conflicting change",
+			ours:         "one\ntwo\nours\nfour\nfive\n",
+			theirs:       "one\ntwo\ntheirs\nfour\nfive\n",
+			want:         "one\ntwo\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> other\nfour\nfive\n",
+			wantConflict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflict := mergeLines(base, tt.ours, tt.theirs, "HEAD", "other")
+			if conflict != tt.wantConflict {
+				t.Errorf("mergeLines() conflict = %v, want %v", conflict, tt.wantConflict)
+			}
+			if got != tt.want {
+				t.Errorf("mergeLines() = %q, want %q", got, tt.want)
+			}
+			if hasConflictMarkers(got) != tt.wantConflict {
+				t.Errorf("hasConflictMarkers() = %v, want %v", !tt.wantConflict, tt.wantConflict)
+			}
+		})
+	}
+}
+
+func TestMergeLinesMissingNewline(t *testing.T) {
+	got, conflict := mergeLines("a", "b", "c", "HEAD", "other")
+	if !conflict {
+		t.Fatal("mergeLines() expected a conflict")
+	}
+	if !strings.Contains(got, "b\n=======\nc\n>>>>>>> other\n") {
+		t.Errorf("mergeLines() markers not on their own lines: %q", got)
+	}
+}
+
+func TestMergeLinesRepeatedLines(t *testing.T) {
+	// difflib takes lines making up more than 1% of a file of 200 or more
+	// to be junk unless told not to. In a file of five copies of a section,
+	// nothing would match after the first change; it would run to the end
+	// of the file and conflict with any later change.
+	lines := []string{"sections\n"}
+	for copy := 0; copy < 5; copy++ {
+		for i := 0; i < 60; i++ {
+			lines = append(lines, fmt.Sprintf("key%d = value\n", i))
+		}
+	}
+	base := strings.Join(lines, "")
+	lines[30] = "ours\n"
+	ours := strings.Join(lines, "")
+	lines[30], lines[270] = "key29 = value\n", "theirs\n"
+	theirs := strings.Join(lines, "")
+
+	got, conflict := mergeLines(base, ours, theirs, "HEAD", "other")
+	if conflict {
+		t.Fatalf("mergeLines() of distant changes conflicted")
+	}
+	lines[30] = "ours\n"
+	if want := strings.Join(lines, ""); got != want {
+		t.Errorf("mergeLines() did not apply both changes")
+	}
+}
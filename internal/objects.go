@@ -0,0 +1,368 @@
+// internal/objects.go
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Object types in the store. Objects are untyped files named by their hash,
+// so the type is recognised from the contents.
+const (
+	ObjectBlob   = "blob"
+	ObjectTree   = "tree"
+	ObjectCommit = "commit"
+)
+
+// objectType works out what kind of object some stored contents are
+func objectType(data []byte) string {
+	var commit Commit
+	if err := json.Unmarshal(data, &commit); err == nil && commit.TreeHash != "" {
+		return ObjectCommit
+	}
+
+	var entries []IndexEntry
+	if err := json.Unmarshal(data, &entries); err == nil {
+		for _, entry := range entries {
+			if entry.FilePath == "" || entry.Hash == "" {
+				return ObjectBlob
+			}
+		}
+		return ObjectTree
+	}
+
+	return ObjectBlob
+}
+
+// readObject loads the raw contents of an object from the store, looking in
+// the packs when there is no loose copy. A partial clone fetches a blob it
+// left out.
+func readObject(repo *Repository, hash string) ([]byte, error) {
+	path := filepath.Join(repo.GitDir, OBJECTS_DIR, hash)
+	data, err := ioutil.ReadFile(path)
+	if err == nil || !os.IsNotExist(err) || hash == "" {
+		return data, err
+	}
+
+	packed, found, packErr := readPackedObject(repo, hash)
+	if packErr != nil {
+		return nil, packErr
+	}
+	if found {
+		return packed, nil
+	}
+	remote, partial := promisorRemote(repo)
+	if !partial {
+		return nil, err
+	}
+	if err := fetchPromised(repo, remote, []string{hash}); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// objectExists reports whether an object is stored loose or in a pack
+func objectExists(repo *Repository, hash string) bool {
+	if hash == "" {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(repo.GitDir, OBJECTS_DIR, hash)); err == nil {
+		return true
+	}
+	packs, err := loadPacks(repo)
+	if err != nil {
+		return false
+	}
+	for _, pack := range packs {
+		if _, ok := pack.lookup(hash); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writeObject stores raw contents under the given hash
+func writeObject(repo *Repository, hash string, data []byte) error {
+	return ioutil.WriteFile(filepath.Join(repo.GitDir, OBJECTS_DIR, hash), data, 0644)
+}
+
+// writeBlob stores file contents and returns their hash
+func writeBlob(repo *Repository, data []byte) (string, error) {
+	hash := CalculateHash(string(data))
+	if err := writeObject(repo, hash, data); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// listObjects returns the sorted hashes of all objects in the store, loose
+// and packed
+func listObjects(repo *Repository) ([]string, error) {
+	hashes, err := listLooseObjects(repo)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := listPackedObjects(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		seen[hash] = true
+	}
+	for _, hash := range packed {
+		if !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+// listLooseObjects returns the hashes of the objects stored as single files
+func listLooseObjects(repo *Repository) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(repo.GitDir, OBJECTS_DIR))
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			hashes = append(hashes, entry.Name())
+		}
+	}
+	return hashes, nil
+}
+
+// readCommit loads and decodes a commit object
+func readCommit(repo *Repository, hash string) (Commit, error) {
+	var commit Commit
+	data, err := readObject(repo, hash)
+	if err != nil {
+		return commit, err
+	}
+	if err := json.Unmarshal(data, &commit); err != nil || commit.TreeHash == "" {
+		return commit, fmt.Errorf("object %s is not a commit", hash)
+	}
+	// Older commits were stored before their hash was filled in
+	commit.Hash = hash
+
+	// History stops where a shallow clone was cut off
+	shallow, err := readShallow(repo)
+	if err != nil {
+		return commit, err
+	}
+	if tree, ok := shallow[hash]; ok {
+		commit.Parent, commit.Shallow = "", tree
+	}
+	return commit, nil
+}
+
+// readTree loads the entries recorded in a tree object
+func readTree(repo *Repository, treeHash string) ([]IndexEntry, error) {
+	data, err := readObject(repo, treeHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []IndexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("object %s is not a tree", treeHash)
+	}
+	for i := range entries {
+		entries[i].FilePath = cleanStoredPath(repo, entries[i].FilePath)
+		if !safeTreePath(entries[i].FilePath) {
+			return nil, fmt.Errorf("tree %s has an unsafe path '%s'", treeHash, entries[i].FilePath)
+		}
+	}
+	if p, ok := symlinkParent(entries); ok {
+		return nil, fmt.Errorf("tree %s has the path '%s' below a symlink", treeHash, p)
+	}
+	return entries, nil
+}
+
+// loadSnapshot returns the path -> entry mapping of the full tree at a
+// commit. Trees only record the files staged in that commit, so the snapshot
+// is built by replaying every tree from the root commit, or the edge of a
+// shallow clone, forward.
+func loadSnapshot(repo *Repository, commitHash string) (map[string]IndexEntry, error) {
+	var chain []Commit
+	for hash := commitHash; hash != ""; {
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, commit)
+		hash = commit.Parent
+	}
+
+	snapshot := make(map[string]IndexEntry)
+	for i := len(chain) - 1; i >= 0; i-- {
+		entries, err := readTree(repo, replayTree(chain[i]))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			snapshot[entry.FilePath] = IndexEntry{FilePath: entry.FilePath, Hash: entry.Hash, FileMode: entry.FileMode}
+		}
+	}
+
+	return snapshot, nil
+}
+
+// checkoutCommit makes the working tree and index match a commit, removing
+// tracked files that do not exist in it. Untracked files are left alone.
+func checkoutCommit(repo *Repository, commitHash string) error {
+	snapshot := make(map[string]IndexEntry)
+	if commitHash != "" {
+		var err error
+		snapshot, err = loadSnapshot(repo, commitHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	hashes := make([]string, 0, len(snapshot))
+	for _, entry := range snapshot {
+		hashes = append(hashes, entry.Hash)
+	}
+	if err := prefetchBlobs(repo, hashes); err != nil {
+		return err
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+
+	// Remove tracked files that are not part of the target commit
+	for _, entry := range index {
+		if _, ok := snapshot[entry.FilePath]; !ok {
+			err := os.Remove(filepath.Join(repo.WorkingDir, entry.FilePath))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(snapshot))
+	for path := range snapshot {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// The files that decide how the others are checked out come first
+	for _, path := range []string{ATTRIBUTES_FILE, LFS_FILE} {
+		if entry, ok := snapshot[path]; ok {
+			if err := restoreFile(repo, &workingFilters{repo: repo}, path, entry.Hash, entry.FileMode); err != nil {
+				return err
+			}
+		}
+	}
+	filters, err := loadWorkingFilters(repo)
+	if err != nil {
+		return err
+	}
+
+	newIndex := []IndexEntry{}
+	for _, path := range paths {
+		entry := snapshot[path]
+		if err := restoreFile(repo, filters, path, entry.Hash, entry.FileMode); err != nil {
+			return err
+		}
+		if info, err := os.Lstat(filepath.Join(repo.WorkingDir, path)); err == nil {
+			recordStat(&entry, info)
+		}
+		newIndex = append(newIndex, entry)
+	}
+
+	return SaveIndex(newIndex)
+}
+
+// restoreFile writes a blob to its working tree path with the given mode
+// unless it is already there, smudged as the filters say. A large file's
+// pointer is smudged into its contents.
+func restoreFile(repo *Repository, filters *workingFilters, path, hash string, mode uint32) error {
+	if !safeTreePath(path) {
+		return fmt.Errorf("refusing to check out the unsafe path '%s'", path)
+	}
+	if err := checkWorkingParents(repo, path); err != nil {
+		return err
+	}
+	fullPath := filepath.Join(repo.WorkingDir, path)
+	entry := IndexEntry{Hash: hash, FileMode: mode}
+	if info, err := os.Lstat(fullPath); err == nil {
+		if current, err := hashWorkingFile(filters, path, fullPath); err == nil && !fileChanged(entry, current, info) {
+			return nil
+		}
+	}
+
+	data, err := readObject(repo, hash)
+	if err != nil {
+		return err
+	}
+	if entryMode(entry) != MODE_SYMLINK {
+		if pointer, ok := parseLFSPointer(data); ok {
+			return smudgeFile(repo, pointer, fullPath, entryMode(entry))
+		}
+		if data, err = filters.smudge(path, data); err != nil {
+			return err
+		}
+	}
+	return writeWorkingFile(fullPath, data, entryMode(entry))
+}
+
+// stageEntry records a blob and mode for a path in the sorted index as a
+// staged change, keeping the index sorted
+func stageEntry(index []IndexEntry, path, hash string, mode uint32) []IndexEntry {
+	i, found := findIndexEntry(index, path)
+	if found {
+		index[i].Hash = hash
+		index[i].FileMode = mode
+		index[i].Modified = true
+		clearStat(&index[i])
+		return index
+	}
+	index = append(index, IndexEntry{})
+	copy(index[i+1:], index[i:])
+	index[i] = IndexEntry{FilePath: path, Hash: hash, Modified: true, FileMode: mode}
+	return index
+}
+
+// hasLocalChanges reports whether the index has staged entries or any
+// tracked file differs from its indexed version
+func hasLocalChanges(repo *Repository, index []IndexEntry) (bool, error) {
+	indexTime := indexModTime(repo)
+	filters, err := loadWorkingFilters(repo)
+	if err != nil {
+		return false, err
+	}
+	for i := range index {
+		entry := &index[i]
+		if entry.Modified {
+			return true, nil
+		}
+		info, err := os.Lstat(filepath.Join(repo.WorkingDir, entry.FilePath))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		hash, _, err := workingHash(filters, entry, info, indexTime)
+		if err != nil {
+			return false, err
+		}
+		if fileChanged(*entry, hash, info) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -2,17 +2,29 @@
 package internal
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/pmezard/go-difflib/difflib"
 )
 
+// statusReport is the state of the index and working tree shown by status
+type statusReport struct {
+	Staged      []string
+	NotStaged   []string
+	ModeChanged map[string]string // not staged paths whose contents are unchanged -> "old -> new"
+	Untracked   []string
+}
+
+// clean reports whether there is nothing to commit and nothing untracked
+func (r statusReport) clean() bool {
+	return len(r.Staged) == 0 && len(r.NotStaged) == 0 && len(r.Untracked) == 0
+}
+
 // ShowStatus displays the current repository status
 func ShowStatus() error {
 	repo, err := FindGitterRepo()
@@ -25,217 +37,341 @@ func ShowStatus() error {
 		return err
 	}
 
-	// Get all files in working directory
-	workingFiles := make(map[string]string)
-	err = filepath.Walk(repo.WorkingDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip gitter directory
-		if strings.Contains(path, GITTER_DIR) {
-			return nil
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		relPath, err := filepath.Rel(repo.WorkingDir, path)
-		if err != nil {
-			return err
-		}
-
-		hash, err := hashFile(path)
-		if err != nil {
-			return err
-		}
-
-		workingFiles[relPath] = hash
-		return nil
-	})
+	report, err := collectStatus(repo, index)
 	if err != nil {
 		return err
 	}
 
-	// Classify files
-	staged := []string{}
-	notStaged := []string{}
-	untracked := []string{}
-
-	// Check indexed files
-	indexedFiles := make(map[string]IndexEntry)
-	for _, entry := range index {
-		indexedFiles[entry.FilePath] = entry
-		if entry.Modified {
-			staged = append(staged, entry.FilePath)
-		}
-	}
-
-	// Check all working files
-	for filePath, currentHash := range workingFiles {
-		if entry, exists := indexedFiles[filePath]; exists {
-			// File is tracked
-			if !entry.Modified && entry.Hash != currentHash {
-				notStaged = append(notStaged, filePath)
-			}
-		} else {
-			// File is untracked
-			untracked = append(untracked, filePath)
-		}
-	}
-
 	// Print status
-	if len(staged) > 0 {
+	if len(report.Staged) > 0 {
 		fmt.Println("Changes to be committed:")
-		for _, file := range staged {
+		for _, file := range report.Staged {
 			fmt.Printf("  modified: %s\n", file)
 		}
 		fmt.Println()
 	}
 
-	if len(notStaged) > 0 {
+	if len(report.NotStaged) > 0 {
 		fmt.Println("Changes not staged for commit:")
-		for _, file := range notStaged {
+		for _, file := range report.NotStaged {
+			if change, ok := report.ModeChanged[file]; ok {
+				fmt.Printf("  mode changed: %s (%s)\n", file, change)
+				continue
+			}
 			fmt.Printf("  modified: %s\n", file)
 		}
 		fmt.Println()
 	}
 
-	if len(untracked) > 0 {
+	if len(report.Untracked) > 0 {
 		fmt.Println("Untracked files:")
-		for _, file := range untracked {
+		for _, file := range report.Untracked {
 			fmt.Printf("  %s\n", file)
 		}
 	}
 
-	if len(staged) == 0 && len(notStaged) == 0 && len(untracked) == 0 {
+	if report.clean() {
 		fmt.Println("nothing to commit, working tree clean")
 	}
 
 	return nil
 }
 
-// CommitChanges creates a new commit
-// CommitChanges creates a new commit
-func CommitChanges(message string, all bool) error {
-	repo, err := FindGitterRepo()
-	if err != nil {
-		return err
+// collectStatus classifies the files of the index and working tree, saving
+// any stat data refreshed on the way
+func collectStatus(repo *Repository, index []IndexEntry) (statusReport, error) {
+	report := statusReport{ModeChanged: map[string]string{}}
+
+	// Stat data cached in the index lets unchanged files skip re-hashing
+	indexedFiles := make(map[string]*IndexEntry)
+	for i := range index {
+		indexedFiles[index[i].FilePath] = &index[i]
 	}
 
-	index, err := LoadIndex()
+	for _, entry := range index {
+		if entry.Modified {
+			report.Staged = append(report.Staged, entry.FilePath)
+		}
+	}
+
+	files, refreshed, err := scanAgainstIndex(context.Background(), repo, indexedFiles, nil)
 	if err != nil {
-		return err
+		return report, err
 	}
 
-	// If -a flag is used, add all modified files
-	if all {
-		err = filepath.Walk(repo.WorkingDir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+	for _, file := range files {
+		entry, exists := indexedFiles[file.Rel]
+		if !exists {
+			// File is untracked
+			report.Untracked = append(report.Untracked, file.Rel)
+			continue
+		}
 
-			// Skip directories and gitter directory
-			if info.IsDir() || strings.Contains(path, GITTER_DIR) {
-				return nil
+		// File is tracked; a staged file may have changed again since, or
+		// have been staged only in part
+		if fileChanged(*entry, file.Hash, file.Info) {
+			report.NotStaged = append(report.NotStaged, file.Rel)
+			if entry.Hash == file.Hash {
+				report.ModeChanged[file.Rel] = fmt.Sprintf("%06o -> %06o", entryMode(*entry), fileModeOf(file.Info))
 			}
+		}
+	}
 
-			relPath, err := filepath.Rel(repo.WorkingDir, path)
-			if err != nil {
-				return err
-			}
+	// Save refreshed stat data so the next run can skip those files
+	if refreshed {
+		if err := SaveIndex(index); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
 
-			// Check if file is already tracked
-			var isTracked bool
-			for _, entry := range index {
-				if entry.FilePath == relPath {
-					isTracked = true
-					break
-				}
-			}
+// CommitOptions configures CommitChanges
+type CommitOptions struct {
+	All         bool   // stage every modified tracked file first
+	NoVerify    bool   // skip the pre-commit and commit-msg hooks
+	MessageFile string // take the message from this file, "-" for stdin
+	Template    string // start the editor with this file instead of $GITTER_COMMIT_TEMPLATE
+	Amend       bool   // replace the HEAD commit instead of adding one on top of it
+	NoEdit      bool   // with Amend, keep the message of the replaced commit
+	ResetAuthor bool   // with Amend, take a new author and date instead of keeping them
+}
 
-			// Only add tracked files for -a flag
-			if isTracked {
-				return AddFile(relPath)
-			}
+// CommitChanges creates a new commit. Without a message or message file the
+// message is written in the editor.
+func CommitChanges(message string, opts CommitOptions) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
 
-			return nil
-		})
-		if err != nil {
+	if (opts.NoEdit || opts.ResetAuthor) && !opts.Amend {
+		return fmt.Errorf("options --no-edit and --reset-author only make sense with --amend")
+	}
+	var amended *Commit
+	if opts.Amend {
+		if amended, err = amendedCommit(repo); err != nil {
 			return err
 		}
+	}
 
-		// Reload index after adding files
-		index, err = LoadIndex()
-		if err != nil {
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+
+	// If -a flag is used, stage every tracked file that changed
+	if opts.All {
+		if err := stageTrackedChanges(repo, index); err != nil {
 			return err
 		}
 	}
 
-	// Check if there are staged changes
-	var stagedFiles []IndexEntry
-	for _, entry := range index {
-		if entry.Modified {
-			stagedFiles = append(stagedFiles, entry)
+	if !opts.NoVerify {
+		if err := runHook(repo, hookPreCommit, ""); err != nil {
+			return err
+		}
+		// The hook may have staged more changes
+		if index, err = LoadIndex(); err != nil {
+			return err
 		}
 	}
 
-	if len(stagedFiles) == 0 {
-		return fmt.Errorf("nothing to commit")
+	if amended != nil {
+		// The new commit replaces HEAD, so its changes have to be staged
+		// again; amending only the message is fine
+		if err := restageCommit(repo, index, *amended); err != nil {
+			return err
+		}
+	} else {
+		hasStaged := false
+		for _, entry := range index {
+			if entry.Modified {
+				hasStaged = true
+				break
+			}
+		}
+		if !hasStaged {
+			return fmt.Errorf("nothing to commit")
+		}
 	}
 
-	// Create tree object and save it
-	treeData, err := json.Marshal(stagedFiles)
+	message, err = commitMessage(repo, index, message, opts, amended)
 	if err != nil {
 		return err
 	}
-	treeHash := CalculateHash(string(treeData))
 
-	// Save tree object to objects directory
-	treePath := filepath.Join(repo.GitDir, OBJECTS_DIR, treeHash)
-	if err := ioutil.WriteFile(treePath, treeData, 0644); err != nil {
+	// Get parent commit (current HEAD)
+	head, err := GetCurrentHead()
+	if err != nil {
 		return err
 	}
 
-	// Create commit object
 	commit := Commit{
-		Hash:     "",     // Will be calculated
-		Author:   "user", // You can make this configurable
-		Date:     time.Now(),
-		Message:  message,
-		TreeHash: treeHash, // Use the saved tree hash
+		Author:  "user", // You can make this configurable
+		Date:    time.Now(),
+		Message: message,
+		Parent:  head,
+	}
+	action := "commit"
+	if amended != nil {
+		// The amended commit takes the place of HEAD under HEAD's parent
+		commit.Parent = amended.Parent
+		if !opts.ResetAuthor {
+			commit.Author, commit.Date = amended.Author, amended.Date
+		}
+		action = "commit (amend)"
+	}
+	commit, err = createCommit(repo, index, commit, action)
+	if err != nil {
+		return err
 	}
 
-	// Get parent commit (current HEAD)
+	// The commit is made, so a failing post-commit hook only warrants a warning
+	if err := runHook(repo, hookPostCommit, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+
+	branch, err := currentBranch(repo)
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		branch = "detached HEAD"
+	}
+
+	fmt.Printf("[%s %s] %s\n", branch, commit.Hash[:7], commitSubject(commit.Message))
+	return nil
+}
+
+// amendedCommit returns the HEAD commit that commit --amend replaces
+func amendedCommit(repo *Repository) (*Commit, error) {
 	head, err := GetCurrentHead()
+	if err != nil {
+		return nil, err
+	}
+	if head == "" {
+		return nil, fmt.Errorf("you have nothing to amend")
+	}
+	commit, err := readCommit(repo, head)
+	if err != nil {
+		return nil, err
+	}
+	if commit.Shallow != "" {
+		// The replacement would need the parent this clone does not have
+		return nil, fmt.Errorf("cannot amend %s: its parent was left out of this shallow clone", head[:7])
+	}
+	return &commit, nil
+}
+
+// restageCommit marks the files changed by commit as staged again, so that
+// a commit replacing it keeps its changes
+func restageCommit(repo *Repository, index []IndexEntry, commit Commit) error {
+	entries, err := readTree(repo, commit.TreeHash)
 	if err != nil {
 		return err
 	}
-	commit.Parent = head
+	for _, entry := range entries {
+		if i, found := findIndexEntry(index, entry.FilePath); found {
+			index[i].Modified = true
+		}
+	}
+	return nil
+}
+
+// stageTrackedChanges stages the working tree version of every tracked file
+// whose contents differ from the index, hashing only files whose cached stat
+// data no longer matches
+func stageTrackedChanges(repo *Repository, index []IndexEntry) error {
+	indexedFiles := make(map[string]*IndexEntry, len(index))
+	for i := range index {
+		indexedFiles[index[i].FilePath] = &index[i]
+	}
+
+	files, _, err := scanAgainstIndex(context.Background(), repo, indexedFiles, nil)
+	if err != nil {
+		return err
+	}
+	filters, err := loadWorkingFilters(repo)
+	if err != nil {
+		return err
+	}
+
+	for i := range files {
+		file := &files[i]
+		entry, exists := indexedFiles[file.Rel]
+		if !exists || !fileChanged(*entry, file.Hash, file.Info) {
+			continue
+		}
+		if err := storeWorkingFile(repo, filters, file.Rel, file.Path, file.Hash); err != nil {
+			return err
+		}
+		entry.Hash = file.Hash
+		entry.FileMode = fileModeOf(file.Info)
+		entry.Modified = true
+		recordStat(entry, file.Info)
+	}
+
+	return SaveIndex(index)
+}
+
+// createCommit writes a tree of the staged index entries and a commit on top
+// of commit.Parent, moves HEAD to it, records the move in the reflog as
+// "<action>: <subject>" and clears the staged flags in the index
+func createCommit(repo *Repository, index []IndexEntry, commit Commit, action string) (Commit, error) {
+	var stagedFiles []IndexEntry
+	for _, entry := range index {
+		if entry.Modified {
+			// Trees record content and mode only, not the cached stat data
+			stagedFiles = append(stagedFiles, IndexEntry{FilePath: entry.FilePath, Hash: entry.Hash, Modified: true, FileMode: entry.FileMode})
+		}
+	}
+
+	// Create tree object and save it
+	treeData, err := json.Marshal(stagedFiles)
+	if err != nil {
+		return commit, err
+	}
+	commit.TreeHash = CalculateHash(string(treeData))
+
+	// Save tree object to objects directory
+	if err := writeObject(repo, commit.TreeHash, treeData); err != nil {
+		return commit, err
+	}
 
 	// Calculate commit hash
+	commit.Hash = ""
 	commitData, err := json.Marshal(commit)
 	if err != nil {
-		return err
+		return commit, err
 	}
 	commit.Hash = CalculateHash(string(commitData))
 
 	// Save commit object
-	commitPath := filepath.Join(repo.GitDir, OBJECTS_DIR, commit.Hash)
-	if err := ioutil.WriteFile(commitPath, commitData, 0644); err != nil {
-		return err
+	commitData, err = json.Marshal(commit)
+	if err != nil {
+		return commit, err
+	}
+	if err := writeObject(repo, commit.Hash, commitData); err != nil {
+		return commit, err
 	}
 
 	// Update HEAD
+	oldHead, err := GetCurrentHead()
+	if err != nil {
+		return commit, err
+	}
 	if err := UpdateHead(commit.Hash); err != nil {
-		return err
+		return commit, err
+	}
+	reflogMessage := action + ": " + commitSubject(commit.Message)
+	if err := appendReflog(repo, oldHead, commit.Hash, commit.Author, reflogMessage); err != nil {
+		return commit, err
 	}
 
 	// Update log
 	if err := UpdateLog(commit); err != nil {
-		return err
+		return commit, err
 	}
 
 	// Reset index (mark all as not modified)
@@ -243,16 +379,16 @@ func CommitChanges(message string, all bool) error {
 		index[i].Modified = false
 	}
 
-	if err := SaveIndex(index); err != nil {
-		return err
-	}
+	return commit, SaveIndex(index)
+}
 
-	fmt.Printf("[main %s] %s\n", commit.Hash[:7], commit.Message)
-	return nil
+// DiffOptions selects how ShowDiff prints changes
+type DiffOptions struct {
+	Binary bool // print binary files as patches git apply can apply
 }
 
 // ShowDiff displays differences between HEAD and working tree
-func ShowDiff(path string) error {
+func ShowDiff(path string, opts DiffOptions) error {
 	repo, err := FindGitterRepo()
 	if err != nil {
 		return err
@@ -269,73 +405,49 @@ func ShowDiff(path string) error {
 		return fmt.Errorf("no commits yet")
 	}
 
-	// Load head commit
-	commitPath := filepath.Join(repo.GitDir, OBJECTS_DIR, head)
-	commitData, err := ioutil.ReadFile(commitPath)
+	// Load the files as of the head commit
+	snapshot, err := loadSnapshot(repo, head)
 	if err != nil {
 		return err
 	}
 
-	var commit Commit
-	if err := json.Unmarshal(commitData, &commit); err != nil {
-		return err
-	}
-
 	// Get files to check
 	var filesToCheck []string
 
-	if path == "" {
-		// Check all files in working directory
-		err = filepath.Walk(repo.WorkingDir, func(p string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			if !info.IsDir() && !strings.Contains(p, GITTER_DIR) {
-				relPath, err := filepath.Rel(repo.WorkingDir, p)
-				if err != nil {
-					return err
-				}
-				filesToCheck = append(filesToCheck, relPath)
-			}
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-	} else {
+	var roots []string
+	if path != "" {
 		// Check specific file or directory
-		stat, err := os.Stat(path)
+		stat, err := os.Lstat(path)
 		if err != nil {
 			return err
 		}
-
-		if stat.IsDir() {
-			err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				if !info.IsDir() {
-					relPath, err := filepath.Rel(repo.WorkingDir, p)
-					if err != nil {
-						return err
-					}
-					filesToCheck = append(filesToCheck, relPath)
-				}
-				return nil
-			})
+		if !stat.IsDir() {
+			rel, err := repoRelative(repo, path)
 			if err != nil {
 				return err
 			}
-		} else {
-			filesToCheck = []string{path}
+			filesToCheck = []string{rel}
+		}
+		roots = []string{path}
+	}
+
+	if filesToCheck == nil {
+		files, err := scanWorkingTree(context.Background(), repo, scanOptions{Roots: roots})
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			filesToCheck = append(filesToCheck, file.Rel)
 		}
 	}
 
 	// Show diff for each file
+	filters, err := loadWorkingFilters(repo)
+	if err != nil {
+		return err
+	}
 	for _, file := range filesToCheck {
-		if err := showFileDiff(repo, commit, file); err != nil {
+		if err := showFileDiff(repo, filters, snapshot, file, opts); err != nil {
 			continue // Skip files that don't exist in HEAD
 		}
 	}
@@ -345,10 +457,9 @@ func ShowDiff(path string) error {
 
 // showFileDiff displays diff for a single file
 // showFileDiff displays diff for a single file
-func showFileDiff(repo *Repository, commit Commit, filePath string) error {
-	// Get current file content
-	currentPath := filepath.Join(repo.WorkingDir, filePath)
-	currentContent, err := ioutil.ReadFile(currentPath)
+func showFileDiff(repo *Repository, filters *workingFilters, snapshot map[string]IndexEntry, filePath string, opts DiffOptions) error {
+	// Get current file content, or the target of a symlink
+	currentContent, info, err := readWorkingBlob(filters, filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// File was deleted
@@ -357,34 +468,28 @@ func showFileDiff(repo *Repository, commit Commit, filePath string) error {
 		return err
 	}
 
-	// Get the tree data from the commit
+	// Load the file content as of HEAD; new files are diffed against nothing
 	var headContent []byte
-
-	// Parse the tree hash to find the file
-	// Since our implementation stores the tree as JSON of staged files at commit time,
-	// we need to load the tree and find the file hash
-	treePath := filepath.Join(repo.GitDir, OBJECTS_DIR, commit.TreeHash)
-	treeData, err := ioutil.ReadFile(treePath)
-	if err != nil {
-		// Tree might not exist in simple implementation, try to find file directly
-		headContent = []byte("") // Empty content for new files
-	} else {
-		// Parse tree data
-		var files []IndexEntry
-		if err := json.Unmarshal(treeData, &files); err == nil {
-			// Find the file in the tree
-			for _, file := range files {
-				if file.FilePath == filePath {
-					// Load the file content from objects
-					objectPath := filepath.Join(repo.GitDir, OBJECTS_DIR, file.Hash)
-					content, err := ioutil.ReadFile(objectPath)
-					if err == nil {
-						headContent = content
-					}
-					break
-				}
-			}
+	var headMode uint32
+	if entry, ok := snapshot[filePath]; ok {
+		content, err := readObject(repo, entry.Hash)
+		if err == nil {
+			headContent = content
+		}
+		headMode = entryMode(entry)
+		printModeChange(filePath, headMode, fileModeOf(info))
+	}
+
+	// Binary files only say that they changed, unless a patch is asked for
+	if binaryDiff(filters, filePath, headContent, currentContent) {
+		switch {
+		case bytes.Equal(headContent, currentContent):
+		case opts.Binary:
+			return writeBinaryPatch(os.Stdout, filePath, headContent, currentContent, headMode, fileModeOf(info))
+		default:
+			fmt.Printf("Binary files a/%s and b/%s differ\n", filePath, filePath)
 		}
+		return nil
 	}
 
 	// Generate diff
@@ -430,19 +535,17 @@ func ShowLog() error {
 	currentCommit := head
 	for currentCommit != "" {
 		// Load commit
-		commitPath := filepath.Join(repo.GitDir, OBJECTS_DIR, currentCommit)
-		commitData, err := ioutil.ReadFile(commitPath)
+		commit, err := readCommit(repo, currentCommit)
 		if err != nil {
 			return err
 		}
 
-		var commit Commit
-		if err := json.Unmarshal(commitData, &commit); err != nil {
-			return err
-		}
-
 		// Print commit info
-		fmt.Printf("commit %s\n", commit.Hash)
+		if commit.Shallow != "" {
+			fmt.Printf("commit %s (grafted)\n", commit.Hash)
+		} else {
+			fmt.Printf("commit %s\n", commit.Hash)
+		}
 		fmt.Printf("Author: %s\n", commit.Author)
 		fmt.Printf("Date: %s\n", commit.Date.Format("Mon Jan 2 15:04:05 2006 -0700"))
 		fmt.Printf("\n    %s\n\n", commit.Message)
@@ -186,7 +186,7 @@ func TestCommitChanges(t *testing.T) {
 				if err := AddFile("test.txt"); err != nil {
 					return err
 				}
-				if err := CommitChanges("Initial commit", false); err != nil {
+				if err := CommitChanges("Initial commit", CommitOptions{}); err != nil {
 					return err
 				}
 				// Modify the file without staging
@@ -216,7 +216,7 @@ func TestCommitChanges(t *testing.T) {
 
 			// Run commit
 			output := captureOutput(t, func() {
-				err := CommitChanges(tt.message, tt.all)
+				err := CommitChanges(tt.message, CommitOptions{All: tt.all})
 				if (err != nil) != tt.wantErr {
 					t.Errorf("CommitChanges() error = %v, wantErr %v", err, tt.wantErr)
 				}
@@ -261,6 +261,89 @@ func TestCommitChanges(t *testing.T) {
 	}
 }
 
+func TestCommitAmend(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	captureOutput(t, func() { err = CommitChanges("Nothing yet", CommitOptions{Amend: true}) })
+	if err == nil || !strings.Contains(err.Error(), "nothing to amend") {
+		t.Errorf("CommitChanges(amend, no commits) error = %v", err)
+	}
+
+	var first, second string
+	captureOutput(t, func() {
+		first = commitFile(t, "a.txt", "a\n", "Add a.txt")
+		second = commitFile(t, "b.txt", "b\n", "Add b")
+	})
+	repo, _ := FindGitterRepo()
+	old, err := readCommit(repo, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A newly staged file joins the changes of the replaced commit
+	if err := ioutil.WriteFile("c.txt", []byte("c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	output := captureOutput(t, func() {
+		err = CommitChanges("Add b and c", CommitOptions{Amend: true})
+	})
+	if err != nil {
+		t.Fatalf("CommitChanges(amend) error = %v", err)
+	}
+	if !strings.Contains(output, "Add b and c") {
+		t.Errorf("CommitChanges(amend) output = %q", output)
+	}
+
+	head, _ := GetCurrentHead()
+	amended, err := readCommit(repo, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amended.Parent != first {
+		t.Errorf("amended parent = %s, want %s", amended.Parent, first)
+	}
+	if amended.Author != old.Author || !amended.Date.Equal(old.Date) {
+		t.Errorf("amended author and date = %s %v, want %s %v", amended.Author, amended.Date, old.Author, old.Date)
+	}
+	snapshot, err := loadSnapshot(repo, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"a.txt", "b.txt", "c.txt"} {
+		if _, ok := snapshot[path]; !ok {
+			t.Errorf("amended commit is missing %s", path)
+		}
+	}
+	if got := logMessages(t); len(got) != 2 || got[0] != "Add b and c" {
+		t.Errorf("log = %q, want the amended commit on top of the first", got)
+	}
+
+	// Nothing staged is fine when only the message changes; --no-edit keeps it
+	captureOutput(t, func() {
+		err = CommitChanges("", CommitOptions{Amend: true, NoEdit: true})
+	})
+	if err != nil {
+		t.Fatalf("CommitChanges(amend, no-edit) error = %v", err)
+	}
+	if got := logMessages(t); len(got) != 2 || got[0] != "Add b and c" {
+		t.Errorf("log = %q after --no-edit", got)
+	}
+
+	captureOutput(t, func() { err = CommitChanges("x", CommitOptions{NoEdit: true}) })
+	if err == nil || !strings.Contains(err.Error(), "--amend") {
+		t.Errorf("CommitChanges(no-edit without amend) error = %v", err)
+	}
+}
+
 func TestShowLog(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -281,7 +364,7 @@ func TestShowLog(t *testing.T) {
 				if err := AddFile("test.txt"); err != nil {
 					return err
 				}
-				return CommitChanges("First commit", false)
+				return CommitChanges("First commit", CommitOptions{})
 			},
 			wantOutput: []string{"commit", "Author: user", "First commit"},
 		},
@@ -295,7 +378,7 @@ func TestShowLog(t *testing.T) {
 				if err := AddFile("file1.txt"); err != nil {
 					return err
 				}
-				if err := CommitChanges("First commit", false); err != nil {
+				if err := CommitChanges("First commit", CommitOptions{}); err != nil {
 					return err
 				}
 
@@ -306,7 +389,7 @@ func TestShowLog(t *testing.T) {
 				if err := AddFile("file2.txt"); err != nil {
 					return err
 				}
-				return CommitChanges("Second commit", false)
+				return CommitChanges("Second commit", CommitOptions{})
 			},
 			wantOutput: []string{
 				"Second commit", // Most recent first
@@ -376,7 +459,7 @@ func TestShowDiff(t *testing.T) {
 				if err := AddFile("test.txt"); err != nil {
 					return err
 				}
-				if err := CommitChanges("Initial commit", false); err != nil {
+				if err := CommitChanges("Initial commit", CommitOptions{}); err != nil {
 					return err
 				}
 				// Modify the file
@@ -401,7 +484,7 @@ func TestShowDiff(t *testing.T) {
 				if err := AddFile("file1.txt"); err != nil {
 					return err
 				}
-				if err := CommitChanges("Initial commit", false); err != nil {
+				if err := CommitChanges("Initial commit", CommitOptions{}); err != nil {
 					return err
 				}
 				// Add a new file (untracked)
@@ -430,7 +513,7 @@ func TestShowDiff(t *testing.T) {
 				if err := AddFile("file2.txt"); err != nil {
 					return err
 				}
-				if err := CommitChanges("Initial commit", false); err != nil {
+				if err := CommitChanges("Initial commit", CommitOptions{}); err != nil {
 					return err
 				}
 				// Modify both files
@@ -468,14 +551,14 @@ func TestShowDiff(t *testing.T) {
 			// Run diff and capture error or output
 			var output string
 			if tt.wantErr {
-				err := ShowDiff(tt.diffPath)
+				err := ShowDiff(tt.diffPath, DiffOptions{})
 				if err == nil {
 					t.Errorf("ShowDiff() error = nil, wantErr %v", tt.wantErr)
 				}
 				output = err.Error()
 			} else {
 				output = captureOutput(t, func() {
-					err := ShowDiff(tt.diffPath)
+					err := ShowDiff(tt.diffPath, DiffOptions{})
 					if err != nil {
 						t.Errorf("ShowDiff() error = %v", err)
 					}
@@ -547,7 +630,7 @@ func BenchmarkCommitChanges(b *testing.B) {
 			b.Errorf("AddFile() error = %v", err)
 		}
 
-		err = CommitChanges(fmt.Sprintf("Commit %d", i), false)
+		err = CommitChanges(fmt.Sprintf("Commit %d", i), CommitOptions{})
 		if err != nil {
 			b.Errorf("CommitChanges() error = %v", err)
 		}
@@ -0,0 +1,542 @@
+// internal/pack.go
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PACK_DIR holds pack files and their indexes inside the object store
+const PACK_DIR = "pack"
+
+// A pack file stores many objects in one zlib-compressed file:
+//
+//	"GPCK" | version uint32 | count uint32 | entries... | sha1 of the above
+//
+// Each entry is a kind byte, the raw hash of its delta base for deltas, the
+// uncompressed size and the compressed length as uvarints, then the data.
+// The matching .idx file lists the object hashes in sorted order with their
+// offsets so lookups are a binary search:
+//
+//	"GIDX" | version uint32 | count uint32 | (hash [20]byte, offset uint64)... |
+//	pack checksum | sha1 of the above
+const (
+	packMagic   = "GPCK"
+	idxMagic    = "GIDX"
+	packVersion = 1
+
+	packEntryFull  = 1
+	packEntryDelta = 2
+
+	packWindow     = 10 // candidates tried as delta bases for each object
+	packMaxDepth   = 10 // longest delta chain, to keep reads cheap
+	packMinDeltaSz = 64 // objects smaller than this are always stored whole
+)
+
+// packIndex is the in-memory form of a .idx file
+type packIndex struct {
+	packPath string
+	hashes   [][sha1.Size]byte
+	offsets  []uint64
+	checksum [sha1.Size]byte // checksum of the pack this index belongs to
+}
+
+// lookup finds the offset of an object in the pack
+func (p *packIndex) lookup(hash string) (uint64, bool) {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != sha1.Size {
+		return 0, false
+	}
+	i := sort.Search(len(p.hashes), func(i int) bool { return bytes.Compare(p.hashes[i][:], raw) >= 0 })
+	if i < len(p.hashes) && bytes.Equal(p.hashes[i][:], raw) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// packCache keeps parsed indexes between lookups; an entry is reused while
+// the .idx file keeps the same size and modification time
+var packCache = struct {
+	sync.Mutex
+	entries map[string]packCacheEntry
+}{entries: make(map[string]packCacheEntry)}
+
+type packCacheEntry struct {
+	info  os.FileInfo
+	index *packIndex
+}
+
+// loadPacks returns the indexes of every pack in the repository
+func loadPacks(repo *Repository) ([]*packIndex, error) {
+	dir := filepath.Join(repo.GitDir, OBJECTS_DIR, PACK_DIR)
+	names, err := filepath.Glob(filepath.Join(dir, "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	packCache.Lock()
+	defer packCache.Unlock()
+
+	var packs []*packIndex
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if cached, ok := packCache.entries[name]; ok &&
+			cached.info.Size() == info.Size() && cached.info.ModTime().Equal(info.ModTime()) {
+			packs = append(packs, cached.index)
+			continue
+		}
+
+		index, err := readPackIndex(name)
+		if err != nil {
+			return nil, err
+		}
+		packCache.entries[name] = packCacheEntry{info: info, index: index}
+		packs = append(packs, index)
+	}
+	return packs, nil
+}
+
+// readPackIndex parses and verifies a .idx file
+func readPackIndex(path string) (*packIndex, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(path)
+	const headerSize = 12
+	if len(data) < headerSize+2*sha1.Size || string(data[:4]) != idxMagic {
+		return nil, fmt.Errorf("%s is not a pack index", name)
+	}
+	body := data[:len(data)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], data[len(body):]) {
+		return nil, fmt.Errorf("pack index %s is corrupt (checksum mismatch)", name)
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != packVersion {
+		return nil, fmt.Errorf("pack index %s has unsupported version %d", name, version)
+	}
+
+	// The count is checked against the file size before it sizes anything,
+	// without multiplying it, which could overflow where int is 32 bits
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	const entrySize = sha1.Size + 8
+	entriesLen := len(body) - headerSize - sha1.Size
+	if count < 0 || entriesLen%entrySize != 0 || count != entriesLen/entrySize {
+		return nil, fmt.Errorf("pack index %s is truncated", name)
+	}
+
+	index := &packIndex{
+		packPath: strings.TrimSuffix(path, ".idx") + ".pack",
+		hashes:   make([][sha1.Size]byte, count),
+		offsets:  make([]uint64, count),
+	}
+	for i := 0; i < count; i++ {
+		entry := data[headerSize+i*entrySize:]
+		copy(index.hashes[i][:], entry[:sha1.Size])
+		index.offsets[i] = binary.BigEndian.Uint64(entry[sha1.Size:entrySize])
+	}
+	copy(index.checksum[:], body[len(body)-sha1.Size:])
+	return index, nil
+}
+
+// readPackedObject looks an object up in the packs. The boolean reports
+// whether any pack contains it.
+func readPackedObject(repo *Repository, hash string) ([]byte, bool, error) {
+	packs, err := loadPacks(repo)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, pack := range packs {
+		if offset, ok := pack.lookup(hash); ok {
+			data, err := readPackEntry(repo, pack, offset)
+			if err != nil {
+				return nil, true, fmt.Errorf("unable to read %s from %s: %v", hash, filepath.Base(pack.packPath), err)
+			}
+			return data, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// readPackEntry decodes the object stored at an offset, resolving deltas
+// against their base
+func readPackEntry(repo *Repository, pack *packIndex, offset uint64) ([]byte, error) {
+	file, err := os.Open(pack.packPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, err
+	}
+	base, data, err := decodePackEntry(bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	if base == "" {
+		return data, nil
+	}
+	baseData, err := readObject(repo, base)
+	if err != nil {
+		return nil, fmt.Errorf("delta base %s: %v", base, err)
+	}
+	return applyDelta(baseData, data)
+}
+
+// maxDeflateRatio is the most deflate can shrink data by, which it does
+// for long runs of one byte
+const maxDeflateRatio = 1032
+
+// decodePackEntry reads one entry: the whole object, or a delta and the hash
+// of its base
+func decodePackEntry(reader *bufio.Reader) (string, []byte, error) {
+	kind, err := reader.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+	var base string
+	switch kind {
+	case packEntryFull:
+	case packEntryDelta:
+		raw := make([]byte, sha1.Size)
+		if _, err := io.ReadFull(reader, raw); err != nil {
+			return "", nil, err
+		}
+		base = hex.EncodeToString(raw)
+	default:
+		return "", nil, fmt.Errorf("unknown entry kind %d", kind)
+	}
+
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", nil, err
+	}
+	compressedLen, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Deflate shrinks data by at most maxDeflateRatio, so a size beyond that
+	// is a lie, and no more than size is inflated whatever the stream holds
+	if compressedLen > math.MaxInt64/maxDeflateRatio || size > compressedLen*maxDeflateRatio {
+		return "", nil, fmt.Errorf("entry claims %d bytes from %d compressed", size, compressedLen)
+	}
+	zr, err := zlib.NewReader(io.LimitReader(reader, int64(compressedLen)))
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(zr, int64(size)+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(data)) != size {
+		return "", nil, fmt.Errorf("entry is %d bytes, expected %d", len(data), size)
+	}
+	return base, data, nil
+}
+
+// listPackedObjects returns the hashes of every packed object
+func listPackedObjects(repo *Repository) ([]string, error) {
+	packs, err := loadPacks(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, pack := range packs {
+		for _, raw := range pack.hashes {
+			hashes = append(hashes, hex.EncodeToString(raw[:]))
+		}
+	}
+	return hashes, nil
+}
+
+// verifyPack checks a pack's trailing checksum and that its index belongs to it
+func verifyPack(pack *packIndex) error {
+	data, err := ioutil.ReadFile(pack.packPath)
+	if err != nil {
+		return err
+	}
+	if len(data) < 12+sha1.Size || string(data[:4]) != packMagic {
+		return fmt.Errorf("%s is not a pack file", filepath.Base(pack.packPath))
+	}
+	body := data[:len(data)-sha1.Size]
+	sum := sha1.Sum(body)
+	if !bytes.Equal(sum[:], data[len(body):]) {
+		return fmt.Errorf("%s is corrupt (checksum mismatch)", filepath.Base(pack.packPath))
+	}
+	if sum != pack.checksum {
+		return fmt.Errorf("%s does not match its index", filepath.Base(pack.packPath))
+	}
+	if count := binary.BigEndian.Uint32(data[8:12]); int(count) != len(pack.hashes) {
+		return fmt.Errorf("%s holds %d objects but its index lists %d", filepath.Base(pack.packPath), count, len(pack.hashes))
+	}
+	return nil
+}
+
+// packObject is an object queued for packing
+type packObject struct {
+	hash string
+	data []byte
+	kind string // object type, deltas are only made between objects of one type
+	path string // a file path the object was seen at, to find similar blobs
+
+	base  string // delta base, empty when stored whole
+	delta []byte
+	depth int
+}
+
+// PackResult summarises a pack that was written
+type PackResult struct {
+	Name    string // file name of the pack, without extension
+	Objects int
+	Deltas  int
+	Bytes   int64 // size of the pack file
+}
+
+// writePack stores the given objects in a new pack plus index and returns
+// what was written. Similar objects are stored as deltas against each other.
+func writePack(repo *Repository, objects []*packObject) (*PackResult, error) {
+	findDeltas(objects)
+	pack, offsets, err := encodePack(objects)
+	if err != nil {
+		return nil, err
+	}
+	var checksum [sha1.Size]byte
+	copy(checksum[:], pack[len(pack)-sha1.Size:])
+
+	result := &PackResult{Objects: len(objects)}
+	hashes := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		if obj.base != "" {
+			result.Deltas++
+		}
+		hashes = append(hashes, obj.hash)
+	}
+	sort.Strings(hashes)
+
+	var idx bytes.Buffer
+	idx.WriteString(idxMagic)
+	binary.Write(&idx, binary.BigEndian, uint32(packVersion))
+	binary.Write(&idx, binary.BigEndian, uint32(len(hashes)))
+	for _, hash := range hashes {
+		raw, err := hex.DecodeString(hash)
+		if err != nil || len(raw) != sha1.Size {
+			return nil, fmt.Errorf("cannot pack object with invalid name '%s'", hash)
+		}
+		idx.Write(raw)
+		binary.Write(&idx, binary.BigEndian, offsets[hash])
+	}
+	idx.Write(checksum[:])
+	idxSum := sha1.Sum(idx.Bytes())
+	idx.Write(idxSum[:])
+
+	dir := filepath.Join(repo.GitDir, OBJECTS_DIR, PACK_DIR)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	result.Name = "pack-" + hex.EncodeToString(checksum[:])
+	result.Bytes = int64(len(pack))
+
+	// The pack goes in place before its index so readers never see an index
+	// without a pack
+	base := filepath.Join(dir, result.Name)
+	if err := writeFileAtomic(base+".pack", pack, 0444); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(base+".idx", idx.Bytes(), 0444); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// encodePack lays the objects out in pack format, in the order given and
+// using the deltas findDeltas chose, and returns the pack with the offset of
+// each object
+func encodePack(objects []*packObject) ([]byte, map[string]uint64, error) {
+	// Header, entries and trailer are hashed as they are written
+	var buf bytes.Buffer
+	buf.WriteString(packMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(packVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(objects)))
+
+	offsets := make(map[string]uint64, len(objects))
+	for _, obj := range objects {
+		offsets[obj.hash] = uint64(buf.Len())
+
+		payload := obj.data
+		if obj.base != "" {
+			raw, err := hex.DecodeString(obj.base)
+			if err != nil {
+				return nil, nil, err
+			}
+			buf.WriteByte(packEntryDelta)
+			buf.Write(raw)
+			payload = obj.delta
+		} else {
+			buf.WriteByte(packEntryFull)
+		}
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(payload); err != nil {
+			return nil, nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, nil, err
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(len(payload))))
+		buf.Write(binary.AppendUvarint(nil, uint64(compressed.Len())))
+		buf.Write(compressed.Bytes())
+	}
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+	return buf.Bytes(), offsets, nil
+}
+
+// decodePack reads back a whole pack without an index, as sent between
+// repositories, and returns its objects in pack order. Delta bases must be
+// in the same pack.
+func decodePack(pack []byte) ([]string, map[string][]byte, error) {
+	if len(pack) < 12+sha1.Size || string(pack[:4]) != packMagic {
+		return nil, nil, fmt.Errorf("not a pack")
+	}
+	body := pack[:len(pack)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], pack[len(body):]) {
+		return nil, nil, fmt.Errorf("pack is corrupt (checksum mismatch)")
+	}
+	if version := binary.BigEndian.Uint32(pack[4:8]); version != packVersion {
+		return nil, nil, fmt.Errorf("unsupported pack version %d", version)
+	}
+	// Every entry takes at least a byte, so a count beyond the bytes left
+	// is a lie and must not size any allocation
+	count := binary.BigEndian.Uint32(pack[8:12])
+	if uint64(count) > uint64(len(body)-12) {
+		return nil, nil, fmt.Errorf("pack claims %d objects in %d bytes", count, len(body)-12)
+	}
+
+	type entry struct {
+		base string
+		data []byte
+	}
+	entries := make([]entry, 0, count)
+	reader := bufio.NewReader(bytes.NewReader(body[12:]))
+	for i := uint32(0); i < count; i++ {
+		base, data, err := decodePackEntry(reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pack entry %d: %v", i, err)
+		}
+		entries = append(entries, entry{base, data})
+	}
+
+	// Entries do not carry their own hash, so deltas are resolved in rounds
+	// until every base is known
+	hashes := make([]string, len(entries))
+	objects := make(map[string][]byte, len(entries))
+	for resolved := 0; resolved < len(entries); {
+		progress := false
+		for i, e := range entries {
+			if hashes[i] != "" {
+				continue
+			}
+			data := e.data
+			if e.base != "" {
+				baseData, ok := objects[e.base]
+				if !ok {
+					continue
+				}
+				var err error
+				if data, err = applyDelta(baseData, e.data); err != nil {
+					return nil, nil, fmt.Errorf("pack entry %d: %v", i, err)
+				}
+			}
+			hash, err := contentHash(data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("pack entry %d: %v", i, err)
+			}
+			hashes[i], objects[hash] = hash, data
+			resolved++
+			progress = true
+		}
+		if !progress {
+			return nil, nil, fmt.Errorf("pack has deltas against objects it does not contain")
+		}
+	}
+	return hashes, objects, nil
+}
+
+// findDeltas picks a delta base for each object from a window of similar
+// objects: same type, sorted by path and then by decreasing size so newer,
+// larger versions of a file become the bases of older ones
+func findDeltas(objects []*packObject) {
+	sorted := append([]*packObject{}, objects...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return len(a.data) > len(b.data)
+	})
+
+	for i, obj := range sorted {
+		if len(obj.data) < packMinDeltaSz {
+			continue
+		}
+		best := len(obj.data) / 2
+		for j := i - 1; j >= 0 && j >= i-packWindow; j-- {
+			candidate := sorted[j]
+			if candidate.kind != obj.kind || candidate.depth >= packMaxDepth || len(candidate.data) < packMinDeltaSz {
+				continue
+			}
+			delta := createDelta(candidate.data, obj.data)
+			if len(delta) < best {
+				best = len(delta)
+				obj.base, obj.delta, obj.depth = candidate.hash, delta, candidate.depth+1
+			}
+		}
+	}
+}
+
+// writeFileAtomic writes a file through a temporary file and a rename
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,241 @@
+// internal/pack_test.go
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupPackRepo commits several versions of a large file so repacking has
+// similar blobs to delta against each other
+func setupPackRepo(t *testing.T) []string {
+	t.Helper()
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("line %d of a file that changes a little each commit", i))
+	}
+
+	var versions []string
+	for v := 0; v < 4; v++ {
+		lines[v*100] = fmt.Sprintf("edited in version %d", v)
+		content := strings.Join(lines, "\n") + "\n"
+		versions = append(versions, content)
+		captureOutput(t, func() { commitFile(t, "big.txt", content, fmt.Sprintf("Version %d", v)) })
+	}
+	return versions
+}
+
+func TestGarbageCollectRepacks(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	versions := setupPackRepo(t)
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := listObjects(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureOutput(t, func() {
+		if err := GarbageCollect(PruneOptions{}); err != nil {
+			t.Errorf("GarbageCollect() error = %v", err)
+		}
+	})
+	// Each later version of big.txt and of its one-entry tree is a delta
+	if !strings.Contains(output, fmt.Sprintf("Packed %d object(s) (6 as deltas)", len(before))) {
+		t.Errorf("GarbageCollect() output = %q", output)
+	}
+
+	loose, err := listLooseObjects(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loose) != 0 {
+		t.Errorf("loose objects left after repack: %v", loose)
+	}
+	after, err := listObjects(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(after, ",") != strings.Join(before, ",") {
+		t.Errorf("listObjects() after repack = %v, want %v", after, before)
+	}
+
+	// Packed objects read back exactly, and the pack is much smaller than
+	// the versions it holds
+	for _, content := range versions {
+		data, err := readObject(repo, CalculateHash(content))
+		if err != nil || string(data) != content {
+			t.Fatalf("readObject() after repack = %d bytes, %v", len(data), err)
+		}
+	}
+	packs, _ := filepath.Glob(filepath.Join(GITTER_DIR, OBJECTS_DIR, PACK_DIR, "pack-*.pack"))
+	if len(packs) != 1 {
+		t.Fatalf("found %d packs, want 1", len(packs))
+	}
+	info, err := os.Stat(packs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > int64(len(versions[0])) {
+		t.Errorf("pack is %d bytes, more than a single %d byte version", info.Size(), len(versions[0]))
+	}
+
+	// Commands work on packed objects
+	output = captureOutput(t, func() {
+		if err := ShowLog(); err != nil {
+			t.Errorf("ShowLog() error = %v", err)
+		}
+		if err := Fsck(); err != nil {
+			t.Errorf("Fsck() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Version 0") || !strings.Contains(output, "Version 3") {
+		t.Errorf("ShowLog() after repack output = %q", output)
+	}
+
+	// A second gc replaces the pack and keeps new loose objects
+	captureOutput(t, func() { commitFile(t, "small.txt", "small\n", "Small") })
+	captureOutput(t, func() {
+		if err := GarbageCollect(PruneOptions{}); err != nil {
+			t.Errorf("second GarbageCollect() error = %v", err)
+		}
+	})
+	packs, _ = filepath.Glob(filepath.Join(GITTER_DIR, OBJECTS_DIR, PACK_DIR, "pack-*.pack"))
+	if len(packs) != 1 {
+		t.Errorf("found %d packs after second gc, want 1", len(packs))
+	}
+	if data, err := readObject(repo, CalculateHash("small\n")); err != nil || string(data) != "small\n" {
+		t.Errorf("readObject() of newly packed blob = %q, %v", data, err)
+	}
+}
+
+func TestFsckDetectsCorruptPack(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	setupPackRepo(t)
+	captureOutput(t, func() {
+		if err := GarbageCollect(PruneOptions{}); err != nil {
+			t.Fatalf("GarbageCollect() error = %v", err)
+		}
+	})
+
+	packs, _ := filepath.Glob(filepath.Join(GITTER_DIR, OBJECTS_DIR, PACK_DIR, "pack-*.pack"))
+	if len(packs) != 1 {
+		t.Fatalf("found %d packs, want 1", len(packs))
+	}
+	data, err := ioutil.ReadFile(packs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	os.Chmod(packs[0], 0644)
+	if err := ioutil.WriteFile(packs[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureOutput(t, func() {
+		if err := Fsck(); err == nil {
+			t.Errorf("Fsck() on a corrupt pack succeeded")
+		}
+	})
+	if !strings.Contains(output, "checksum mismatch") {
+		t.Errorf("Fsck() output = %q", output)
+	}
+}
+
+// sealPack appends the checksum a pack ends with
+func sealPack(body []byte) []byte {
+	sum := sha1.Sum(body)
+	return append(body, sum[:]...)
+}
+
+func TestDecodePackRejectsBadCount(t *testing.T) {
+	// A header claiming four billion objects must not size an allocation
+	header := []byte(packMagic)
+	header = binary.BigEndian.AppendUint32(header, packVersion)
+	header = binary.BigEndian.AppendUint32(header, 0xffffffff)
+	if _, _, err := decodePack(sealPack(header)); err == nil || !strings.Contains(err.Error(), "claims") {
+		t.Errorf("decodePack(huge count) error = %v", err)
+	}
+
+	// Nor must a pack index's
+	index := []byte(idxMagic)
+	index = binary.BigEndian.AppendUint32(index, packVersion)
+	index = binary.BigEndian.AppendUint32(index, 0xffffffff)
+	index = sealPack(append(index, make([]byte, sha1.Size)...))
+	path := filepath.Join(t.TempDir(), "pack-bad.idx")
+	if err := ioutil.WriteFile(path, index, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPackIndex(path); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("readPackIndex(huge count) error = %v", err)
+	}
+}
+
+func TestDecodePackEntryBoundsSize(t *testing.T) {
+	entry := func(size uint64, data []byte) *bufio.Reader {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(data)
+		zw.Close()
+		raw := binary.AppendUvarint([]byte{packEntryFull}, size)
+		raw = binary.AppendUvarint(raw, uint64(compressed.Len()))
+		return bufio.NewReader(bytes.NewReader(append(raw, compressed.Bytes()...)))
+	}
+	zeros := make([]byte, 1<<20)
+
+	if _, data, err := decodePackEntry(entry(uint64(len(zeros)), zeros)); err != nil || len(data) != len(zeros) {
+		t.Errorf("decodePackEntry() = %d bytes, %v", len(data), err)
+	}
+	// More than deflate could have shrunk to this
+	if _, _, err := decodePackEntry(entry(1<<40, []byte("small"))); err == nil || !strings.Contains(err.Error(), "claims") {
+		t.Errorf("decodePackEntry(huge size) error = %v", err)
+	}
+	// A stream that inflates past its size stops there
+	if _, _, err := decodePackEntry(entry(10, zeros)); err == nil || !strings.Contains(err.Error(), "expected 10") {
+		t.Errorf("decodePackEntry(short size) error = %v", err)
+	}
+}
+
+func FuzzDecodePack(f *testing.F) {
+	source := []byte(strings.Repeat("a line that repeats\n", 40))
+	target := append([]byte("one more\n"), source...)
+	pack, _, err := encodePack([]*packObject{
+		{hash: CalculateHash(string(target)), data: target},
+		{hash: CalculateHash(string(source)), data: source, base: CalculateHash(string(target)), delta: createDelta(target, source)},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(pack[:len(pack)-sha1.Size])
+	f.Fuzz(func(t *testing.T, body []byte) {
+		// Sealed, so that the fuzzer gets past the checksum
+		hashes, objects, err := decodePack(sealPack(append([]byte(nil), body...)))
+		if err != nil {
+			return
+		}
+		for _, hash := range hashes {
+			if _, ok := objects[hash]; !ok {
+				t.Errorf("decodePack() listed %s without its contents", hash)
+			}
+		}
+	})
+}
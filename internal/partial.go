@@ -0,0 +1,114 @@
+// internal/partial.go
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A partial clone leaves out the blobs a filter names and fetches them from
+// the remote it was cloned from when they are first needed. That remote keeps
+// the filter, which later fetches apply too.
+
+// noBlobFilter is the size limit of a fetch that keeps every blob
+const noBlobFilter = -1
+
+// parseBlobFilter reads a filter, blob:none or blob:limit=<size> with an
+// optional k, m or g suffix, and returns the size from which blobs are left
+// out: 0 for all of them, noBlobFilter when spec is empty
+func parseBlobFilter(spec string) (int64, error) {
+	switch {
+	case spec == "":
+		return noBlobFilter, nil
+	case spec == "blob:none":
+		return 0, nil
+	case strings.HasPrefix(spec, "blob:limit="):
+		value := strings.ToLower(strings.TrimPrefix(spec, "blob:limit="))
+		scale := int64(1)
+		if n := len(value); n > 0 {
+			switch value[n-1] {
+			case 'k':
+				scale = 1 << 10
+			case 'm':
+				scale = 1 << 20
+			case 'g':
+				scale = 1 << 30
+			}
+			if scale > 1 {
+				value = value[:n-1]
+			}
+		}
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid filter '%s': bad size", spec)
+		}
+		return size * scale, nil
+	default:
+		return 0, fmt.Errorf("invalid filter '%s'; use blob:none or blob:limit=<size>", spec)
+	}
+}
+
+// keepBlob reports whether a fetch with the given size limit sends a blob
+func keepBlob(repo *Repository, limit int64, hash string) (bool, error) {
+	if limit == noBlobFilter {
+		return true, nil
+	}
+	if limit == 0 {
+		return false, nil
+	}
+	data, err := readObject(repo, hash)
+	if err != nil {
+		return false, err
+	}
+	return int64(len(data)) < limit, nil
+}
+
+// promisorRemote returns the remote a partial clone fetches left-out blobs
+// from; ok is false when nothing was left out
+func promisorRemote(repo *Repository) (Remote, bool) {
+	remotes, err := loadRemotes(repo)
+	if err != nil {
+		return Remote{}, false
+	}
+	for _, remote := range remotes {
+		if remote.Filter != "" {
+			return remote, true
+		}
+	}
+	return Remote{}, false
+}
+
+// fetchPromised fetches blobs a partial clone left out, in one request
+func fetchPromised(repo *Repository, remote Remote, hashes []string) error {
+	source, err := openTransport(repo, remote.URL)
+	if err != nil {
+		return err
+	}
+	defer source.close()
+	if _, err := source.fetch(repo, uploadRequest{Blobs: hashes}); err != nil {
+		return fmt.Errorf("could not fetch missing objects from %s: %v", remote.Name, err)
+	}
+	return nil
+}
+
+// prefetchBlobs fetches the blobs among hashes that a partial clone left
+// out, so that using them does not take a request each
+func prefetchBlobs(repo *Repository, hashes []string) error {
+	remote, partial := promisorRemote(repo)
+	if !partial {
+		return nil
+	}
+	var missing []string
+	seen := make(map[string]bool)
+	for _, hash := range hashes {
+		if !seen[hash] && !objectExists(repo, hash) {
+			seen[hash] = true
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fetchPromised(repo, remote, missing)
+}
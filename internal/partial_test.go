@@ -0,0 +1,137 @@
+// internal/partial_test.go
+package internal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseBlobFilter(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    int64
+		wantErr bool
+	}{
+		{"", noBlobFilter, false},
+		{"blob:none", 0, false},
+		{"blob:limit=100", 100, false},
+		{"blob:limit=2k", 2048, false},
+		{"blob:limit=1M", 1 << 20, false},
+		{"blob:limit=", 0, true},
+		{"blob:limit=-1", 0, true},
+		{"tree:0", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBlobFilter(tt.spec)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("parseBlobFilter(%q) = %d, %v, want %d", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestPartialClone(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	big := strings.Repeat("x", 100) + "\n"
+	captureOutput(t, func() {
+		commitFile(t, "big.txt", big, "Add big.txt")
+		commitFile(t, "big.txt", big+big, "Grow big.txt")
+		commitFile(t, "a.txt", "a2\n", "Change a.txt")
+		if err := Push("", "", PushOptions{}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	})
+	oldBig, oldSmall := CalculateHash(big), CalculateHash("a\n")
+
+	partial := filepath.Join(top, "partial")
+	captureOutput(t, func() {
+		err := Clone(filepath.Join(top, "hub.gitter"), partial, CloneOptions{Filter: "blob:limit=50"})
+		if err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+
+	inDir(t, partial, func() {
+		repo, _ := FindGitterRepo()
+		if remote, ok := promisorRemote(repo); !ok || remote.Filter != "blob:limit=50" {
+			t.Errorf("promisor remote = %+v, %v", remote, ok)
+		}
+
+		// Checkout fetched what it needed; older big blobs were left out
+		if got := readWorkingFile(t, "big.txt"); got != big+big {
+			t.Errorf("big.txt = %q", got)
+		}
+		if !objectExists(repo, oldSmall) {
+			t.Errorf("a small blob was left out")
+		}
+		if objectExists(repo, oldBig) {
+			t.Errorf("an old big blob was copied")
+		}
+		result, err := fsckRepository(repo)
+		if err != nil || len(result.Problems) > 0 {
+			t.Errorf("fsck of a partial clone = %+v, %v", result, err)
+		}
+
+		// Reading it fetches it
+		data, err := readObject(repo, oldBig)
+		if err != nil || string(data) != big {
+			t.Errorf("readObject(left out blob) = %q, %v", data, err)
+		}
+		if !objectExists(repo, oldBig) {
+			t.Errorf("the fetched blob was not stored")
+		}
+
+		// Only blobs of the remote's history are handed out
+		hub := &Repository{GitDir: filepath.Join(top, "hub.gitter")}
+		err = checkWants(hub, uploadRequest{Blobs: []string{CalculateHash("secret\n")}})
+		if err == nil || !strings.Contains(err.Error(), "not our blob") {
+			t.Errorf("checkWants(unknown blob) error = %v", err)
+		}
+	})
+
+	// Without a filter nothing is fetched later
+	inDir(t, filepath.Join(top, "alice"), func() {
+		repo, _ := FindGitterRepo()
+		if _, err := readObject(repo, CalculateHash("never stored\n")); err == nil {
+			t.Errorf("readObject() of a missing object in a full clone succeeded")
+		}
+	})
+
+	if err := Clone(filepath.Join(top, "hub.gitter"), filepath.Join(top, "bad"), CloneOptions{Filter: "tree:0"}); err == nil {
+		t.Errorf("Clone() with an unknown filter succeeded")
+	}
+}
+
+func TestPartialCloneNone(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	srv := serveHub(t, top, nil)
+	defer srv.Close()
+	captureOutput(t, func() {
+		commitFile(t, "a.txt", "a2\n", "Change a.txt")
+		if err := Push("", "", PushOptions{}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	})
+
+	partial := filepath.Join(top, "partial")
+	captureOutput(t, func() {
+		if err := Clone(srv.URL+"/", partial, CloneOptions{Filter: "blob:none", Depth: 1}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+	inDir(t, partial, func() {
+		repo, _ := FindGitterRepo()
+		if got := readWorkingFile(t, "a.txt"); got != "a2\n" {
+			t.Errorf("a.txt = %q", got)
+		}
+		if objectExists(repo, CalculateHash("a\n")) {
+			t.Errorf("blob:none copied a blob checkout did not need")
+		}
+		output := captureOutput(t, func() { ShowStatus() })
+		if !strings.Contains(output, "nothing to commit") {
+			t.Errorf("status = %q", output)
+		}
+	})
+}
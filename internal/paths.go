@@ -0,0 +1,139 @@
+// internal/paths.go
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Paths in the index and in trees are relative to the working tree root,
+// slash-separated and cleaned, e.g. "src/main.go". Paths given on the command
+// line are relative to the current directory, which may be a subdirectory.
+
+// repoRelative turns a path, absolute or relative to the current directory,
+// into a path relative to the working tree root
+func repoRelative(repo *Repository, p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(repo.WorkingDir, abs)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("'%s' is outside repository", p)
+	}
+	return rel, nil
+}
+
+// treePath resolves the path part of <rev>:<path>, which is relative to the
+// working tree root unless it starts with ./ or ../ like in git
+func treePath(repo *Repository, p string) (string, error) {
+	slashed := filepath.ToSlash(p)
+	if slashed == "." || slashed == ".." || strings.HasPrefix(slashed, "./") || strings.HasPrefix(slashed, "../") {
+		return repoRelative(repo, p)
+	}
+	return path.Clean(strings.TrimPrefix(slashed, "/")), nil
+}
+
+// cleanStoredPath puts a path recorded by an older version, which kept
+// whatever was typed, into the normal form
+func cleanStoredPath(repo *Repository, p string) string {
+	if filepath.IsAbs(p) {
+		if rel, err := filepath.Rel(repo.WorkingDir, p); err == nil {
+			p = rel
+		}
+	}
+	return path.Clean(filepath.ToSlash(p))
+}
+
+// safeTreePath reports whether a cleaned path from a tree names a file
+// inside the working tree and outside the repository directory. Trees can
+// come from other repositories, and checking out one naming ../x or
+// .gitter/hooks/x would write wherever it says.
+func safeTreePath(p string) bool {
+	if p == "" || p == "." || path.IsAbs(p) || filepath.IsAbs(p) || filepath.VolumeName(p) != "" {
+		return false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." || strings.EqualFold(part, GITTER_DIR) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWorkingParents refuses to write a path whose parent directories
+// include a symlink, which would put the file wherever the link points.
+// Missing parents are fine, they are created as real directories.
+func checkWorkingParents(repo *Repository, p string) error {
+	parts := strings.Split(p, "/")
+	dir := repo.WorkingDir
+	for i, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write '%s' through the symlink '%s'", p, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	return nil
+}
+
+// symlinkParent returns the path of a tree that lies below a symlink of the
+// same tree, which checking out would follow
+func symlinkParent(entries []IndexEntry) (string, bool) {
+	symlinks := make(map[string]bool)
+	for _, entry := range entries {
+		if entryMode(entry) == MODE_SYMLINK {
+			symlinks[entry.FilePath] = true
+		}
+	}
+	if len(symlinks) == 0 {
+		return "", false
+	}
+	for _, entry := range entries {
+		for dir := path.Dir(entry.FilePath); dir != "."; dir = path.Dir(dir) {
+			if symlinks[dir] {
+				return entry.FilePath, true
+			}
+		}
+	}
+	return "", false
+}
+
+// normalizeIndexPaths cleans the paths of an index in place. Entries that
+// turn out to name the same file are merged, a staged entry winning over an
+// unstaged one and a later entry over an earlier one. The boolean reports
+// whether anything changed.
+func normalizeIndexPaths(repo *Repository, index []IndexEntry) ([]IndexEntry, bool) {
+	changed := false
+	positions := make(map[string]int, len(index))
+	merged := index[:0]
+	for _, entry := range index {
+		if clean := cleanStoredPath(repo, entry.FilePath); clean != entry.FilePath {
+			entry.FilePath = clean
+			changed = true
+		}
+		if i, ok := positions[entry.FilePath]; ok {
+			changed = true
+			if entry.Modified || !merged[i].Modified {
+				merged[i] = entry
+			}
+			continue
+		}
+		positions[entry.FilePath] = len(merged)
+		merged = append(merged, entry)
+	}
+	return merged, changed
+}
@@ -0,0 +1,175 @@
+// internal/paths_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepoRelative(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("src/lib", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("src"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "main.go", "src/main.go", false},
+		{"dot prefix", "./main.go", "src/main.go", false},
+		{"dot dot", "lib/../main.go", "src/main.go", false},
+		{"parent", "../README.md", "README.md", false},
+		{"current dir", ".", "src", false},
+		{"root", "..", ".", false},
+		{"absolute", filepath.Join(repo.WorkingDir, "src", "lib", "x.go"), "src/lib/x.go", false},
+		{"outside", "../../elsewhere.txt", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repoRelative(repo, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("repoRelative(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("repoRelative(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	// <rev>:<path> is from the root unless it starts with ./ or ../
+	for spec, want := range map[string]string{"lib/x.go": "lib/x.go", "./x.go": "src/x.go", "/a//b": "a/b"} {
+		if got, err := treePath(repo, spec); err != nil || got != want {
+			t.Errorf("treePath(%q) = %q, %v, want %q", spec, got, err, want)
+		}
+	}
+}
+
+func TestAddNormalisesPaths(t *testing.T) {
+	root, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"a.txt", "dir/b.txt"} {
+		if err := ioutil.WriteFile(path, []byte(path+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// However a file is named, it ends up under one key
+	for _, path := range []string{"./a.txt", "dir/../a.txt", filepath.Join(root, "a.txt")} {
+		if err := AddFile(path); err != nil {
+			t.Fatalf("AddFile(%q) error = %v", path, err)
+		}
+	}
+
+	// Paths are relative to the current directory, not the root
+	if err := os.Chdir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("b.txt"); err != nil {
+		t.Fatalf("AddFile(b.txt) from dir error = %v", err)
+	}
+	if err := os.Mkdir("empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("empty"); err != nil {
+		t.Errorf("AddFile(empty dir) error = %v", err)
+	}
+	if err := AddFile("../.gitter/index"); err == nil {
+		t.Errorf("AddFile(.gitter/index) succeeded")
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, entry := range index {
+		paths = append(paths, entry.FilePath)
+	}
+	if got := strings.Join(paths, ","); got != "a.txt,dir/b.txt" {
+		t.Errorf("index paths = %s, want a.txt,dir/b.txt", got)
+	}
+
+	output := captureOutput(t, func() {
+		if err := ShowStatus(); err != nil {
+			t.Errorf("ShowStatus() error = %v", err)
+		}
+	})
+	if strings.Contains(output, "Untracked") {
+		t.Errorf("staged files also reported as untracked:\n%s", output)
+	}
+
+	captureOutput(t, func() {
+		if err := CommitChanges("Add files", CommitOptions{}); err != nil {
+			t.Fatalf("CommitChanges() error = %v", err)
+		}
+	})
+	if err := ioutil.WriteFile("b.txt", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output = captureOutput(t, func() {
+		if err := ShowDiff("b.txt", DiffOptions{}); err != nil {
+			t.Errorf("ShowDiff(b.txt) error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "+changed") || !strings.Contains(output, "a/dir/b.txt") {
+		t.Errorf("ShowDiff(b.txt) from dir = %q", output)
+	}
+}
+
+func TestLoadIndexNormalisesOldPaths(t *testing.T) {
+	root, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Older versions stored paths as they were typed
+	data, err := encodeIndex([]IndexEntry{
+		{FilePath: "./a.txt", Hash: CalculateHash("new"), Modified: true},
+		{FilePath: filepath.Join(root, "dir", "b.txt"), Hash: CalculateHash("b")},
+		{FilePath: "a.txt", Hash: CalculateHash("old")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(GITTER_DIR, INDEX_FILE), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		t.Fatalf("LoadIndex() error = %v", err)
+	}
+	if len(index) != 2 || index[0].FilePath != "a.txt" || index[1].FilePath != "dir/b.txt" {
+		t.Fatalf("LoadIndex() = %+v, want a.txt and dir/b.txt", index)
+	}
+	if index[0].Hash != CalculateHash("new") || !index[0].Modified {
+		t.Errorf("duplicate a.txt kept %+v, want the staged entry", index[0])
+	}
+}
@@ -0,0 +1,172 @@
+// internal/push.go
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PushOptions configures Push
+type PushOptions struct {
+	Force bool // update the remote branch even if that loses commits on it
+
+	// ForceWithLease allows losing commits only if the remote branch is
+	// still where we last saw it: at Lease when given (a revision, optionally
+	// prefixed with "<branch>:"), or else at its remote-tracking branch
+	ForceWithLease bool
+	Lease          string
+}
+
+// Push sends a branch, the current one by default, to a remote, origin by
+// default. The branch may be given as <src>:<dst> to push local branch src
+// to remote branch dst. Unless forced, the remote branch only moves forward.
+func Push(remoteName, branch string, opts PushOptions) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	remote, tracked, err := resolveRemote(repo, remoteName)
+	if err != nil {
+		return err
+	}
+	dest, err := openTransport(repo, remote.URL)
+	if err != nil {
+		return err
+	}
+	defer dest.close()
+	advertised, err := dest.refs(true)
+	if err != nil {
+		return err
+	}
+	advertised.Branches = advertised.checkedBranches(remote.URL)
+
+	src, dst := branch, branch
+	if i := strings.Index(branch, ":"); i >= 0 {
+		src, dst = branch[:i], branch[i+1:]
+	}
+	if src == "" {
+		current, err := currentBranch(repo)
+		if err != nil {
+			return err
+		}
+		if current == "" {
+			return fmt.Errorf("you are not currently on a branch; name the branch to push")
+		}
+		src = current
+		if dst == "" {
+			dst = current
+		}
+	}
+	if dst == "" {
+		dst = src
+	}
+
+	local, err := readRef(repo, branchRef(src))
+	if err != nil {
+		return err
+	}
+	if local == "" {
+		return fmt.Errorf("src refspec %s does not match any branch", src)
+	}
+	remoteHash := advertised.Branches[dst]
+
+	fmt.Printf("To %s\n", displayURL(remote.URL))
+	if remoteHash == local {
+		fmt.Println("Everything up-to-date")
+		return nil
+	}
+	refs := src + " -> " + dst
+	if src == dst {
+		refs = dst
+	}
+
+	// Only fast-forwards are allowed unless forced
+	forced := false
+	if remoteHash != "" {
+		if !objectExists(repo, remoteHash) {
+			if !opts.Force && !opts.ForceWithLease {
+				return pushRejected(remote, refs, "fetch first",
+					"Updates were rejected because the remote contains work that you do not have locally.\n"+
+						"hint: Fetch it first, then push again.")
+			}
+			forced = true
+		} else if forward, err := isAncestor(repo, remoteHash, local); err != nil {
+			return err
+		} else if !forward {
+			if !opts.Force && !opts.ForceWithLease {
+				return pushRejected(remote, refs, "non-fast-forward",
+					"Updates were rejected because the tip of your current branch is behind its remote counterpart.\n"+
+						"hint: Pull and integrate the remote changes before pushing again.")
+			}
+			forced = true
+		}
+	}
+
+	if opts.ForceWithLease && !opts.Force {
+		expected, err := pushLease(repo, remote, tracked, dst, opts.Lease)
+		if err != nil {
+			return err
+		}
+		if expected != remoteHash {
+			return pushRejected(remote, refs, "stale info",
+				"Updates were rejected because the remote branch moved since you last fetched it.")
+		}
+	}
+
+	// The pre-push hook sees "<local ref> <local sha> <remote ref> <remote sha>"
+	stdin := fmt.Sprintf("%s %s %s %s\n", branchRef(src), local, branchRef(dst), hookCommitID(remoteHash))
+	if err := runHook(repo, hookPrePush, stdin, remote.Name, remote.URL); err != nil {
+		return fmt.Errorf("failed to push some refs to '%s': %v", displayURL(remote.URL), err)
+	}
+
+	if err := dest.push(repo, branchRef(dst), remoteHash, local); err != nil {
+		if rejection, ok := err.(*refRejection); ok {
+			fmt.Printf(" ! [remote rejected] %s (%s)\n", refs, rejection.reason)
+			return fmt.Errorf("failed to push some refs to '%s'", displayURL(remote.URL))
+		}
+		return err
+	}
+	if tracked {
+		if err := writeRef(repo, remoteTrackingRef(remote.Name, dst), local); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case remoteHash == "":
+		fmt.Printf(" * [new branch]      %s\n", refs)
+	case forced:
+		fmt.Printf(" + %s...%s %s (forced update)\n", remoteHash[:7], local[:7], refs)
+	default:
+		fmt.Printf("   %s..%s  %s\n", remoteHash[:7], local[:7], refs)
+	}
+	return nil
+}
+
+// pushLease works out where the remote branch is expected to be for
+// --force-with-lease. An empty result expects the branch not to exist.
+func pushLease(repo *Repository, remote Remote, tracked bool, branch, lease string) (string, error) {
+	if lease != "" {
+		ref, expect := lease, ""
+		if i := strings.Index(lease, ":"); i >= 0 {
+			ref, expect = lease[:i], lease[i+1:]
+		}
+		if ref != branch {
+			return "", fmt.Errorf("--force-with-lease names %s, but %s is being pushed", ref, branch)
+		}
+		if expect != "" {
+			return resolveRevision(repo, expect)
+		}
+	}
+	if !tracked {
+		return "", fmt.Errorf("--force-with-lease needs an expected value for a remote that is not configured")
+	}
+	return readRef(repo, remoteTrackingRef(remote.Name, branch))
+}
+
+// pushRejected reports a ref the remote did not accept
+func pushRejected(remote Remote, refs, reason, hint string) error {
+	fmt.Printf(" ! [rejected]        %s (%s)\n", refs, reason)
+	fmt.Printf("hint: %s\n", hint)
+	return fmt.Errorf("failed to push some refs to '%s'", displayURL(remote.URL))
+}
@@ -0,0 +1,226 @@
+// internal/push_test.go
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// cloneHub clones hub.gitter into top/name and moves the test there
+func cloneHub(t *testing.T, top, name string) {
+	t.Helper()
+	captureOutput(t, func() {
+		if err := Clone(filepath.Join(top, "hub.gitter"), filepath.Join(top, name), CloneOptions{}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+	if err := os.Chdir(filepath.Join(top, name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hubBranch returns where a branch of hub.gitter points
+func hubBranch(t *testing.T, top, branch string) string {
+	t.Helper()
+	hash, err := readRef(&Repository{GitDir: filepath.Join(top, "hub.gitter")}, branchRef(branch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestPush(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	alice := filepath.Join(top, "alice")
+	repo, _ := FindGitterRepo()
+
+	// Fast-forward
+	var ahead string
+	captureOutput(t, func() { ahead = commitFile(t, "a.txt", "a2\n", "Change a.txt") })
+	output := captureOutput(t, func() {
+		if err := Push("origin", "main", PushOptions{}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	})
+	if got := hubBranch(t, top, "main"); got != ahead {
+		t.Errorf("hub main = %s, want %s", got, ahead)
+	}
+	if tracking, _ := readRef(repo, remoteTrackingRef("origin", "main")); tracking != ahead {
+		t.Errorf("origin/main = %s, want %s", tracking, ahead)
+	}
+	if !strings.Contains(output, ".."+ahead[:7]+"  main") {
+		t.Errorf("Push() output = %q", output)
+	}
+	output = captureOutput(t, func() { Push("", "", PushOptions{}) })
+	if !strings.Contains(output, "Everything up-to-date") {
+		t.Errorf("Push(again) output = %q", output)
+	}
+
+	// A new remote branch from src:dst
+	captureOutput(t, func() {
+		if err := Push("origin", "main:topic", PushOptions{}); err != nil {
+			t.Fatalf("Push(main:topic) error = %v", err)
+		}
+	})
+	if got := hubBranch(t, top, "topic"); got != ahead {
+		t.Errorf("hub topic = %s, want %s", got, ahead)
+	}
+
+	// Bob pushes on top, so alice is behind and her rewrite is rejected
+	cloneHub(t, top, "bob")
+	var bobs string
+	captureOutput(t, func() {
+		bobs = commitFile(t, "b.txt", "b\n", "Add b.txt")
+		if err := Push("", "", PushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := os.Chdir(alice); err != nil {
+		t.Fatal(err)
+	}
+	var alices string
+	captureOutput(t, func() { alices = commitFile(t, "c.txt", "c\n", "Add c.txt") })
+	var err error
+	output = captureOutput(t, func() { err = Push("", "", PushOptions{}) })
+	if err == nil || !strings.Contains(output, "(fetch first)") {
+		t.Errorf("Push(behind) error = %v, output %q", err, output)
+	}
+
+	// Once fetched the remote commit is known, and the push is not a fast-forward
+	captureOutput(t, func() {
+		if err := Fetch(""); err != nil {
+			t.Fatal(err)
+		}
+	})
+	output = captureOutput(t, func() { err = Push("", "", PushOptions{}) })
+	if err == nil || !strings.Contains(output, "(non-fast-forward)") {
+		t.Errorf("Push(diverged) error = %v, output %q", err, output)
+	}
+	if got := hubBranch(t, top, "main"); got != bobs {
+		t.Errorf("a rejected push moved hub main to %s", got)
+	}
+
+	// The lease holds while origin/main is where hub main is
+	captureOutput(t, func() { err = Push("", "", PushOptions{ForceWithLease: true}) })
+	if err != nil {
+		t.Fatalf("Push(force-with-lease) error = %v", err)
+	}
+	if got := hubBranch(t, top, "main"); got != alices {
+		t.Errorf("hub main = %s after a forced push, want %s", got, alices)
+	}
+}
+
+func TestPushForceWithLease(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	alice := filepath.Join(top, "alice")
+	base := hubBranch(t, top, "main")
+
+	cloneHub(t, top, "bob")
+	var bobs string
+	captureOutput(t, func() {
+		bobs = commitFile(t, "b.txt", "b\n", "Add b.txt")
+		if err := Push("", "", PushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := os.Chdir(alice); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() { commitFile(t, "a.txt", "rewritten\n", "Rewrite a.txt") })
+
+	tests := []struct {
+		name    string
+		opts    PushOptions
+		wantErr string
+	}{
+		{name: "stale tracking branch", opts: PushOptions{ForceWithLease: true}, wantErr: "failed to push"},
+		{name: "stale expected value", opts: PushOptions{ForceWithLease: true, Lease: "main:" + base}, wantErr: "failed to push"},
+		{name: "lease on another branch", opts: PushOptions{ForceWithLease: true, Lease: "topic"}, wantErr: "--force-with-lease names topic"},
+		{name: "force wins over a lease", opts: PushOptions{Force: true, ForceWithLease: true}},
+	}
+	for _, tt := range tests {
+		var err error
+		output := captureOutput(t, func() { err = Push("", "", tt.opts) })
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: Push() error = %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Push() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+		if strings.Contains(tt.wantErr, "failed") && !strings.Contains(output, "(stale info)") {
+			t.Errorf("%s: Push() output = %q", tt.name, output)
+		}
+		if got := hubBranch(t, top, "main"); got != bobs {
+			t.Errorf("%s: hub main moved to %s", tt.name, got)
+		}
+	}
+}
+
+func TestPushRefusals(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	alice := filepath.Join(top, "alice")
+
+	// The branch checked out in a non-bare repository is left alone
+	cloneHub(t, top, "bob")
+	if err := RemoteAdd("alice", alice); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() { commitFile(t, "b.txt", "b\n", "Add b.txt") })
+	var err error
+	output := captureOutput(t, func() { err = Push("alice", "main", PushOptions{}) })
+	if err == nil || !strings.Contains(output, "branch is currently checked out") {
+		t.Errorf("Push(checked out) error = %v, output %q", err, output)
+	}
+	captureOutput(t, func() { err = Push("alice", "main:from-bob", PushOptions{}) })
+	if err != nil {
+		t.Errorf("Push(other branch of non-bare) error = %v", err)
+	}
+
+	if err := Push("", "nope", PushOptions{}); err == nil || !strings.Contains(err.Error(), "does not match any branch") {
+		t.Errorf("Push(unknown branch) error = %v", err)
+	}
+	if err := Push("nowhere", "", PushOptions{}); err == nil {
+		t.Errorf("Push(unknown remote) succeeded")
+	}
+}
+
+func TestPushHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are shell scripts")
+	}
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	repo, _ := FindGitterRepo()
+	old := hubBranch(t, top, "main")
+
+	var head string
+	captureOutput(t, func() { head = commitFile(t, "a.txt", "a2\n", "Change a.txt") })
+	writeHook(t, hookPrePush, `{ echo "$1 $2"; cat; } > pre-push-saw; exit 1`)
+
+	var err error
+	captureOutput(t, func() { err = Push("", "", PushOptions{}) })
+	if err == nil || !strings.Contains(err.Error(), "pre-push hook exited with status 1") {
+		t.Errorf("Push() error = %v, want the hook's veto", err)
+	}
+	if got := hubBranch(t, top, "main"); got != old {
+		t.Errorf("a vetoed push moved hub main")
+	}
+	if objectExists(&Repository{GitDir: filepath.Join(top, "hub.gitter")}, head) {
+		t.Errorf("a vetoed push sent objects")
+	}
+
+	want := "origin " + filepath.Join(top, "hub.gitter") + "\n" +
+		"refs/heads/main " + head + " refs/heads/main " + old + "\n"
+	if got := readWorkingFile(t, filepath.Join(repo.WorkingDir, "pre-push-saw")); got != want {
+		t.Errorf("pre-push hook saw %q, want %q", got, want)
+	}
+}
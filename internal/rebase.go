@@ -0,0 +1,810 @@
+// internal/rebase.go
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rebase state lives in its own directory under .gitter while a rebase is running
+const (
+	REBASE_DIR        = "rebase-merge"
+	REBASE_STATE_FILE = "state"
+	REBASE_TODO_FILE  = "gitter-rebase-todo"
+	REBASE_MSG_FILE   = "message"
+)
+
+// Rebase todo actions
+const (
+	RebasePick   = "pick"
+	RebaseReword = "reword"
+	RebaseEdit   = "edit"
+	RebaseSquash = "squash"
+	RebaseFixup  = "fixup"
+	RebaseDrop   = "drop"
+)
+
+// rebaseActions maps todo keywords and their abbreviations to actions
+var rebaseActions = map[string]string{
+	"p": RebasePick, RebasePick: RebasePick,
+	"r": RebaseReword, RebaseReword: RebaseReword,
+	"e": RebaseEdit, RebaseEdit: RebaseEdit,
+	"s": RebaseSquash, RebaseSquash: RebaseSquash,
+	"f": RebaseFixup, RebaseFixup: RebaseFixup,
+	"d": RebaseDrop, RebaseDrop: RebaseDrop,
+}
+
+// RebaseOptions configures how a rebase is started
+type RebaseOptions struct {
+	Interactive bool
+	Onto        string // replay onto this revision instead of upstream
+}
+
+// RebaseStep is one line of the rebase todo list
+type RebaseStep struct {
+	Action string `json:"action"`
+	Commit string `json:"commit"`
+}
+
+// RebaseState is persisted between rebase invocations so that a rebase
+// stopped on a conflict or an edit can be continued, skipped or aborted
+type RebaseState struct {
+	HeadName       string       `json:"head_name"` // branch being rebased, empty when detached
+	Onto           string       `json:"onto"`
+	OrigHead       string       `json:"orig_head"`
+	Todo           []RebaseStep `json:"todo"`
+	Done           []RebaseStep `json:"done"`
+	Current        *RebaseStep  `json:"current,omitempty"` // step waiting to be committed
+	Conflicts      []string     `json:"conflicts,omitempty"`
+	SquashMessages []string     `json:"squash_messages,omitempty"`
+	SquashEdit     bool         `json:"squash_edit,omitempty"`
+}
+
+// rebaseDir returns the path of the rebase state directory
+func rebaseDir(repo *Repository) string {
+	return filepath.Join(repo.GitDir, REBASE_DIR)
+}
+
+// loadRebaseState returns the saved rebase state, or nil when no rebase is in progress
+func loadRebaseState(repo *Repository) (*RebaseState, error) {
+	data, err := ioutil.ReadFile(filepath.Join(rebaseDir(repo), REBASE_STATE_FILE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var state RebaseState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// saveRebaseState persists the rebase state
+func saveRebaseState(repo *Repository, state *RebaseState) error {
+	if err := os.MkdirAll(rebaseDir(repo), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(rebaseDir(repo), REBASE_STATE_FILE), data, 0644)
+}
+
+// commitSubject returns the first line of a commit message
+func commitSubject(message string) string {
+	return strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]
+}
+
+// Rebase replays the commits of the current branch that are not reachable
+// from upstream on top of upstream (or opts.Onto)
+func Rebase(upstream string, opts RebaseOptions) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	if state, err := loadRebaseState(repo); err != nil {
+		return err
+	} else if state != nil {
+		return fmt.Errorf("a rebase is already in progress; use --continue, --skip or --abort")
+	}
+
+	head, err := GetCurrentHead()
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("no commits yet")
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+	if dirty, err := hasLocalChanges(repo, index); err != nil {
+		return err
+	} else if dirty {
+		return fmt.Errorf("cannot rebase: you have uncommitted changes, please commit them first")
+	}
+
+	upstreamHash, err := resolveRevision(repo, upstream)
+	if err != nil {
+		return err
+	}
+	onto := upstreamHash
+	if opts.Onto != "" {
+		if onto, err = resolveRevision(repo, opts.Onto); err != nil {
+			return err
+		}
+	}
+
+	branch, err := currentBranch(repo)
+	if err != nil {
+		return err
+	}
+
+	// Collect the commits that are not reachable from upstream, oldest first
+	upstreamCommits := make(map[string]bool)
+	for hash := upstreamHash; hash != ""; {
+		upstreamCommits[hash] = true
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return err
+		}
+		hash = commit.Parent
+	}
+
+	var commits []Commit
+	for hash := head; hash != "" && !upstreamCommits[hash]; {
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return err
+		}
+		commits = append([]Commit{commit}, commits...)
+		hash = commit.Parent
+	}
+
+	if !opts.Interactive {
+		if (len(commits) == 0 && head == onto) || (len(commits) > 0 && commits[0].Parent == onto) {
+			if branch == "" {
+				branch = "HEAD"
+			}
+			fmt.Printf("Current branch %s is up to date.\n", branch)
+			return nil
+		}
+	}
+
+	state := &RebaseState{
+		HeadName: branch,
+		Onto:     onto,
+		OrigHead: head,
+		Todo:     []RebaseStep{},
+		Done:     []RebaseStep{},
+	}
+	for _, commit := range commits {
+		state.Todo = append(state.Todo, RebaseStep{Action: RebasePick, Commit: commit.Hash})
+	}
+
+	if opts.Interactive {
+		todo, err := editRebaseTodo(repo, state, commits)
+		if err != nil {
+			os.RemoveAll(rebaseDir(repo))
+			return err
+		}
+		state.Todo = todo
+	}
+
+	if err := saveRebaseState(repo, state); err != nil {
+		return err
+	}
+
+	// Start from the new base with a detached HEAD
+	if err := checkoutCommit(repo, onto); err != nil {
+		return err
+	}
+	if err := detachHead(repo, onto); err != nil {
+		return err
+	}
+	runPostCheckout(repo, head, onto)
+
+	return runRebase(repo, state)
+}
+
+// editRebaseTodo lets the user edit the todo list and returns the parsed steps
+func editRebaseTodo(repo *Repository, state *RebaseState, commits []Commit) ([]RebaseStep, error) {
+	var b strings.Builder
+	for _, commit := range commits {
+		fmt.Fprintf(&b, "%s %s %s\n", RebasePick, commit.Hash[:7], commitSubject(commit.Message))
+	}
+	fmt.Fprintf(&b, "\n# Rebase %s..%s onto %s (%d commands)\n", state.Onto[:7], state.OrigHead[:7], state.Onto[:7], len(commits))
+	b.WriteString(`#
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash", but discard this commit's log message
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+# If you remove everything, the rebase will be aborted.
+`)
+
+	if err := os.MkdirAll(rebaseDir(repo), 0755); err != nil {
+		return nil, err
+	}
+	todoPath := filepath.Join(rebaseDir(repo), REBASE_TODO_FILE)
+	if err := ioutil.WriteFile(todoPath, []byte(b.String()), 0644); err != nil {
+		return nil, err
+	}
+	if err := launchEditor(todoPath); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(todoPath)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := parseRebaseTodo(repo, string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("nothing to do")
+	}
+	return steps, nil
+}
+
+// parseRebaseTodo parses an edited todo list
+func parseRebaseTodo(repo *Repository, text string) ([]RebaseStep, error) {
+	steps := []RebaseStep{}
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		action, ok := rebaseActions[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid command '%s' on line %d of the todo list", fields[0], n+1)
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("missing commit on line %d of the todo list", n+1)
+		}
+		hash, err := resolveRevision(repo, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d of the todo list: %v", n+1, err)
+		}
+
+		if (action == RebaseSquash || action == RebaseFixup) && !hasPickedStep(steps) {
+			return nil, fmt.Errorf("cannot '%s' without a previous commit", action)
+		}
+		steps = append(steps, RebaseStep{Action: action, Commit: hash})
+	}
+	return steps, nil
+}
+
+// hasPickedStep reports whether any step produces a commit to squash into
+func hasPickedStep(steps []RebaseStep) bool {
+	for _, step := range steps {
+		if step.Action != RebaseDrop {
+			return true
+		}
+	}
+	return false
+}
+
+// runRebase executes the remaining todo steps, stopping on conflicts and edits
+func runRebase(repo *Repository, state *RebaseState) error {
+	for len(state.Todo) > 0 {
+		step := state.Todo[0]
+		state.Todo = state.Todo[1:]
+
+		if step.Action == RebaseDrop {
+			state.Done = append(state.Done, step)
+			continue
+		}
+
+		commit, err := readCommit(repo, step.Commit)
+		if err != nil {
+			return err
+		}
+
+		conflicts, err := applyRebaseStep(repo, state, step, commit)
+		if err != nil {
+			return err
+		}
+
+		if len(conflicts) > 0 {
+			state.Current = &step
+			state.Conflicts = conflicts
+			if err := saveRebaseState(repo, state); err != nil {
+				return err
+			}
+			for _, path := range conflicts {
+				fmt.Printf("CONFLICT (content): Merge conflict in %s\n", path)
+			}
+			fmt.Println(`Resolve all conflicts manually, mark them as resolved with
+"gitter add <conflicted_files>", then run "gitter rebase --continue".
+You can instead skip this commit: run "gitter rebase --skip".
+To abort and get back to the state before "gitter rebase", run "gitter rebase --abort".`)
+			return fmt.Errorf("could not apply %s... %s", commit.Hash[:7], commitSubject(commit.Message))
+		}
+
+		if err := commitRebaseStep(repo, state, step, commit); err != nil {
+			state.Current = &step
+			if saveErr := saveRebaseState(repo, state); saveErr != nil {
+				return saveErr
+			}
+			return err
+		}
+
+		state.Done = append(state.Done, step)
+		if err := saveRebaseState(repo, state); err != nil {
+			return err
+		}
+
+		if step.Action == RebaseEdit {
+			printEditStop(commit)
+			return nil
+		}
+	}
+
+	return finishRebase(repo, state)
+}
+
+// applyRebaseStep brings the changes of a commit into the working tree and
+// index on top of HEAD. It returns the paths that could not be merged.
+func applyRebaseStep(repo *Repository, state *RebaseState, step RebaseStep, commit Commit) ([]string, error) {
+	head, err := GetCurrentHead()
+	if err != nil {
+		return nil, err
+	}
+
+	// Commits that already sit on HEAD are fast-forwarded instead of recreated
+	if (step.Action == RebasePick || step.Action == RebaseEdit) && commit.Parent == head {
+		if err := checkoutCommit(repo, commit.Hash); err != nil {
+			return nil, err
+		}
+		return nil, detachHead(repo, commit.Hash)
+	}
+
+	baseSnapshot, err := loadSnapshot(repo, commit.Parent)
+	if err != nil {
+		return nil, err
+	}
+	headSnapshot, err := loadSnapshot(repo, head)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readTree(repo, commit.TreeHash)
+	if err != nil {
+		return nil, err
+	}
+	index, err := LoadIndex()
+	if err != nil {
+		return nil, err
+	}
+	filters, err := loadWorkingFilters(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	for _, entry := range entries {
+		base := baseSnapshot[entry.FilePath]
+		ours := headSnapshot[entry.FilePath]
+		theirs := IndexEntry{FilePath: entry.FilePath, Hash: entry.Hash, FileMode: entry.FileMode}
+
+		switch {
+		case sameVersion(ours, theirs) || sameVersion(base, theirs):
+			// Nothing to bring over
+		case sameVersion(ours, base):
+			if err := restoreFile(repo, filters, entry.FilePath, theirs.Hash, theirs.FileMode); err != nil {
+				return nil, err
+			}
+			index = stageEntry(index, entry.FilePath, theirs.Hash, theirs.FileMode)
+		default:
+			// The mode follows whichever side changed it
+			mode := ours.FileMode
+			if entryMode(theirs) != entryMode(base) {
+				mode = theirs.FileMode
+			}
+			merged, conflict, err := mergeBlobs(repo, base.Hash, ours.Hash, theirs.Hash,
+				fmt.Sprintf("%s (%s)", commit.Hash[:7], commitSubject(commit.Message)))
+			if err != nil {
+				return nil, err
+			}
+			working, err := filters.smudge(entry.FilePath, []byte(merged))
+			if err != nil {
+				return nil, err
+			}
+			if err := checkWorkingParents(repo, entry.FilePath); err != nil {
+				return nil, err
+			}
+			fullPath := filepath.Join(repo.WorkingDir, entry.FilePath)
+			if err := writeWorkingFile(fullPath, working, mode); err != nil {
+				return nil, err
+			}
+			if conflict {
+				conflicts = append(conflicts, entry.FilePath)
+				continue
+			}
+			hash, err := writeBlob(repo, []byte(merged))
+			if err != nil {
+				return nil, err
+			}
+			index = stageEntry(index, entry.FilePath, hash, mode)
+		}
+	}
+
+	return conflicts, SaveIndex(index)
+}
+
+// mergeBlobs three-way merges the contents of three blobs; an empty hash
+// stands for a file that does not exist on that side
+func mergeBlobs(repo *Repository, base, ours, theirs, theirsLabel string) (string, bool, error) {
+	contents := make([]string, 3)
+	for i, hash := range []string{base, ours, theirs} {
+		if hash == "" {
+			continue
+		}
+		data, err := readObject(repo, hash)
+		if err != nil {
+			return "", false, err
+		}
+		contents[i] = string(data)
+	}
+	merged, conflict := mergeLines(contents[0], contents[1], contents[2], "HEAD", theirsLabel)
+	return merged, conflict, nil
+}
+
+// commitRebaseStep records the staged changes of a step as a new commit
+func commitRebaseStep(repo *Repository, state *RebaseState, step RebaseStep, commit Commit) error {
+	head, err := GetCurrentHead()
+	if err != nil {
+		return err
+	}
+	if head == commit.Hash {
+		return nil // fast-forwarded
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+
+	switch step.Action {
+	case RebaseSquash, RebaseFixup:
+		return squashRebaseStep(repo, state, step, commit, head, index)
+	case RebaseReword:
+		message, err := editMessage(repo, commit.Message+"\n\n"+commitMessageHelp)
+		if err != nil {
+			return err
+		}
+		commit.Message = message
+	}
+
+	staged := false
+	for _, entry := range index {
+		staged = staged || entry.Modified
+	}
+	if !staged {
+		fmt.Printf("dropping %s %s -- patch contents already upstream\n", commit.Hash[:7], commitSubject(commit.Message))
+		return nil
+	}
+
+	_, err = createCommit(repo, index, Commit{
+		Author:  commit.Author,
+		Date:    commit.Date,
+		Message: commit.Message,
+		Parent:  head,
+	}, "rebase ("+step.Action+")")
+	return err
+}
+
+// squashRebaseStep melds the staged changes into the HEAD commit
+func squashRebaseStep(repo *Repository, state *RebaseState, step RebaseStep, commit Commit, head string, index []IndexEntry) error {
+	previous, err := readCommit(repo, head)
+	if err != nil {
+		return err
+	}
+
+	messages := append([]string{}, state.SquashMessages...)
+	if len(messages) == 0 {
+		messages = append(messages, previous.Message)
+	}
+	edit := state.SquashEdit
+	if step.Action == RebaseSquash {
+		messages = append(messages, commit.Message)
+		edit = true
+	}
+
+	// The melded commit replaces HEAD, so its changes have to be staged again
+	if err := restageCommit(repo, index, previous); err != nil {
+		return err
+	}
+
+	lastInChain := len(state.Todo) == 0 ||
+		(state.Todo[0].Action != RebaseSquash && state.Todo[0].Action != RebaseFixup)
+
+	message := strings.Join(messages, "\n\n")
+	if lastInChain && edit {
+		var b strings.Builder
+		fmt.Fprintf(&b, "# This is a combination of %d commits.\n", len(messages))
+		for i, msg := range messages {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "# This is the commit message #%d:\n\n%s\n", i+1, msg)
+		}
+		b.WriteString("\n" + commitMessageHelp)
+		if message, err = editMessage(repo, b.String()); err != nil {
+			return err
+		}
+	}
+
+	if _, err := createCommit(repo, index, Commit{
+		Author:  previous.Author,
+		Date:    previous.Date,
+		Message: message,
+		Parent:  previous.Parent,
+	}, "rebase ("+step.Action+")"); err != nil {
+		return err
+	}
+
+	if lastInChain {
+		state.SquashMessages = nil
+		state.SquashEdit = false
+	} else {
+		state.SquashMessages = messages
+		state.SquashEdit = edit
+	}
+	return nil
+}
+
+// editMessage opens a commit message in the editor and returns the result
+// with comments stripped
+func editMessage(repo *Repository, initial string) (string, error) {
+	if err := os.MkdirAll(rebaseDir(repo), 0755); err != nil {
+		return "", err
+	}
+	msgPath := filepath.Join(rebaseDir(repo), REBASE_MSG_FILE)
+	if err := ioutil.WriteFile(msgPath, []byte(initial), 0644); err != nil {
+		return "", err
+	}
+	if err := launchEditor(msgPath); err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(msgPath)
+	if err != nil {
+		return "", err
+	}
+	message := stripComments(string(data))
+	if message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+	return message, nil
+}
+
+// printEditStop tells the user how to carry on after an edit step
+func printEditStop(commit Commit) {
+	fmt.Printf("Stopped at %s... %s\n", commit.Hash[:7], commitSubject(commit.Message))
+	fmt.Println(`You can make further changes and commit them now.
+Once you are satisfied with your changes, run
+
+  gitter rebase --continue`)
+}
+
+// finishRebase moves the rebased branch to the new tip and cleans up
+func finishRebase(repo *Repository, state *RebaseState) error {
+	head, err := GetCurrentHead()
+	if err != nil {
+		return err
+	}
+
+	if state.HeadName != "" {
+		if err := writeRef(repo, branchRef(state.HeadName), head); err != nil {
+			return err
+		}
+		if err := attachHead(repo, state.HeadName); err != nil {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(rebaseDir(repo)); err != nil {
+		return err
+	}
+
+	if state.HeadName != "" {
+		fmt.Printf("Successfully rebased and updated %s.\n", branchRef(state.HeadName))
+	} else {
+		fmt.Println("Successfully rebased and updated detached HEAD.")
+	}
+	return nil
+}
+
+// RebaseContinue commits the resolved step and carries on with the rebase
+func RebaseContinue() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	state, err := loadRebaseState(repo)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no rebase in progress")
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+
+	if state.Current == nil {
+		for _, entry := range index {
+			if entry.Modified {
+				return fmt.Errorf("you have staged changes; commit them with 'gitter commit' before continuing")
+			}
+		}
+		return runRebase(repo, state)
+	}
+
+	// Every conflicted file must be resolved and staged again
+	filters, err := loadWorkingFilters(repo)
+	if err != nil {
+		return err
+	}
+	var unresolved []string
+	for _, path := range state.Conflicts {
+		if !conflictResolved(filters, index, path) {
+			unresolved = append(unresolved, path)
+		}
+	}
+	if len(unresolved) > 0 {
+		return fmt.Errorf("you must resolve all conflicts and mark them with 'gitter add' before continuing: %s",
+			strings.Join(unresolved, ", "))
+	}
+
+	step := *state.Current
+	commit, err := readCommit(repo, step.Commit)
+	if err != nil {
+		return err
+	}
+	if err := commitRebaseStep(repo, state, step, commit); err != nil {
+		return err
+	}
+
+	state.Current = nil
+	state.Conflicts = nil
+	state.Done = append(state.Done, step)
+	if err := saveRebaseState(repo, state); err != nil {
+		return err
+	}
+
+	if step.Action == RebaseEdit {
+		printEditStop(commit)
+		return nil
+	}
+	return runRebase(repo, state)
+}
+
+// conflictResolved reports whether a conflicted path has been fixed and staged
+func conflictResolved(filters *workingFilters, index []IndexEntry, path string) bool {
+	data, _, err := readWorkingBlob(filters, path)
+	if err != nil || hasConflictMarkers(string(data)) {
+		return false
+	}
+	hash := CalculateHash(string(data))
+	i, found := findIndexEntry(index, path)
+	return found && index[i].Modified && index[i].Hash == hash
+}
+
+// RebaseSkip drops the step the rebase stopped on and carries on
+func RebaseSkip() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	state, err := loadRebaseState(repo)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no rebase in progress")
+	}
+
+	head, err := GetCurrentHead()
+	if err != nil {
+		return err
+	}
+	if err := resetWorkingTree(repo, head, state.Conflicts); err != nil {
+		return err
+	}
+
+	if state.Current != nil {
+		state.Done = append(state.Done, *state.Current)
+	}
+	state.Current = nil
+	state.Conflicts = nil
+	if err := saveRebaseState(repo, state); err != nil {
+		return err
+	}
+
+	return runRebase(repo, state)
+}
+
+// RebaseAbort restores the branch and working tree to where the rebase started
+func RebaseAbort() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	state, err := loadRebaseState(repo)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no rebase in progress")
+	}
+
+	head, err := GetCurrentHead()
+	if err != nil {
+		return err
+	}
+	if err := resetWorkingTree(repo, head, state.Conflicts); err != nil {
+		return err
+	}
+	if err := checkoutCommit(repo, state.OrigHead); err != nil {
+		return err
+	}
+
+	if state.HeadName != "" {
+		if err := writeRef(repo, branchRef(state.HeadName), state.OrigHead); err != nil {
+			return err
+		}
+		if err := attachHead(repo, state.HeadName); err != nil {
+			return err
+		}
+	} else if err := detachHead(repo, state.OrigHead); err != nil {
+		return err
+	}
+	runPostCheckout(repo, head, state.OrigHead)
+
+	return os.RemoveAll(rebaseDir(repo))
+}
+
+// resetWorkingTree throws away staged and conflicted changes on top of a commit
+func resetWorkingTree(repo *Repository, commitHash string, conflicts []string) error {
+	snapshot, err := loadSnapshot(repo, commitHash)
+	if err != nil {
+		return err
+	}
+
+	// Conflicted files the commit does not know about are left over from the step
+	for _, path := range conflicts {
+		if _, ok := snapshot[path]; !ok {
+			if err := os.Remove(filepath.Join(repo.WorkingDir, path)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return checkoutCommit(repo, commitHash)
+}
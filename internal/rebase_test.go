@@ -0,0 +1,372 @@
+// internal/rebase_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupDivergedRepo creates a repository where "upstream" and main share a
+// base commit and each has one commit of its own. It returns the base,
+// upstream and main commits.
+func setupDivergedRepo(t *testing.T, upstreamContent, mainFile, mainContent string) (string, string, string) {
+	t.Helper()
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	base := commitFile(t, "a.txt", "one\ntwo\nthree\n", "Base commit")
+	upstream := commitFile(t, "a.txt", upstreamContent, "Upstream commit")
+
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatalf("FindGitterRepo() error = %v", err)
+	}
+	if err := writeRef(repo, branchRef("upstream"), upstream); err != nil {
+		t.Fatalf("writeRef() error = %v", err)
+	}
+	if err := writeRef(repo, branchRef("main"), base); err != nil {
+		t.Fatalf("writeRef() error = %v", err)
+	}
+	if err := checkoutCommit(repo, base); err != nil {
+		t.Fatalf("checkoutCommit() error = %v", err)
+	}
+
+	main := commitFile(t, mainFile, mainContent, "Main commit")
+	return base, upstream, main
+}
+
+// logMessages returns the commit messages reachable from HEAD, newest first
+func logMessages(t *testing.T) []string {
+	t.Helper()
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatalf("FindGitterRepo() error = %v", err)
+	}
+	head, err := GetCurrentHead()
+	if err != nil {
+		t.Fatalf("GetCurrentHead() error = %v", err)
+	}
+
+	var messages []string
+	for hash := head; hash != ""; {
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			t.Fatalf("readCommit(%s) error = %v", hash, err)
+		}
+		messages = append(messages, commit.Message)
+		hash = commit.Parent
+	}
+	return messages
+}
+
+// setEditor points the editor at a shell script with the given body
+func setEditor(t *testing.T, script string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "editor.sh")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write editor script: %v", err)
+	}
+	t.Setenv("GITTER_EDITOR", "")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", path)
+}
+
+func readWorkingFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestRebase(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	_, upstream, _ := setupDivergedRepo(t, "ONE\ntwo\nthree\n", "c.txt", "feature\n")
+
+	output := captureOutput(t, func() {
+		if err := Rebase("upstream", RebaseOptions{}); err != nil {
+			t.Errorf("Rebase() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Successfully rebased and updated refs/heads/main.") {
+		t.Errorf("Rebase() output = %q", output)
+	}
+
+	if branch, _ := GetCurrentBranch(); branch != "main" {
+		t.Errorf("GetCurrentBranch() = %q, want main", branch)
+	}
+	if got := logMessages(t); strings.Join(got, ",") != "Main commit,Upstream commit,Base commit" {
+		t.Errorf("history = %v", got)
+	}
+	if rebased, _ := ResolveRevision("HEAD~1"); rebased != upstream {
+		t.Errorf("HEAD~1 = %s, want upstream %s", rebased, upstream)
+	}
+	if got := readWorkingFile(t, "a.txt"); got != "ONE\ntwo\nthree\n" {
+		t.Errorf("a.txt = %q, want upstream content", got)
+	}
+	if got := readWorkingFile(t, "c.txt"); got != "feature\n" {
+		t.Errorf("c.txt = %q, want feature content", got)
+	}
+	if _, err := os.Stat(filepath.Join(GITTER_DIR, REBASE_DIR)); !os.IsNotExist(err) {
+		t.Errorf("rebase state left behind")
+	}
+
+	// Rebasing again has nothing to do
+	output = captureOutput(t, func() {
+		if err := Rebase("upstream", RebaseOptions{}); err != nil {
+			t.Errorf("Rebase() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "is up to date") {
+		t.Errorf("second Rebase() output = %q", output)
+	}
+}
+
+func TestRebaseMergesSameFile(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	setupDivergedRepo(t, "ONE\ntwo\nthree\n", "a.txt", "one\ntwo\nTHREE\n")
+
+	captureOutput(t, func() {
+		if err := Rebase("upstream", RebaseOptions{}); err != nil {
+			t.Errorf("Rebase() error = %v", err)
+		}
+	})
+	if got := readWorkingFile(t, "a.txt"); got != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("a.txt = %q, want both changes", got)
+	}
+}
+
+func TestRebaseConflict(t *testing.T) {
+	tests := []struct {
+		name    string
+		resolve func(t *testing.T) error
+		want    []string
+		content string
+	}{
+		{
+			name: "Continue",
+			resolve: func(t *testing.T) error {
+				if err := ioutil.WriteFile("a.txt", []byte("resolved\ntwo\nthree\n"), 0644); err != nil {
+					return err
+				}
+				if err := AddFile("a.txt"); err != nil {
+					return err
+				}
+				return RebaseContinue()
+			},
+			want:    []string{"Main commit", "Upstream commit", "Base commit"},
+			content: "resolved\ntwo\nthree\n",
+		},
+		{
+			name:    "Skip",
+			resolve: func(t *testing.T) error { return RebaseSkip() },
+			want:    []string{"Upstream commit", "Base commit"},
+			content: "upstream\ntwo\nthree\n",
+		},
+		{
+			name:    "Abort",
+			resolve: func(t *testing.T) error { return RebaseAbort() },
+			want:    []string{"Main commit", "Base commit"},
+			content: "main\ntwo\nthree\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cleanup := setupTestRepo(t)
+			defer cleanup()
+
+			_, _, main := setupDivergedRepo(t, "upstream\ntwo\nthree\n", "a.txt", "main\ntwo\nthree\n")
+
+			var rebaseErr error
+			captureOutput(t, func() {
+				rebaseErr = Rebase("upstream", RebaseOptions{})
+			})
+			if rebaseErr == nil || !strings.Contains(rebaseErr.Error(), "could not apply") {
+				t.Fatalf("Rebase() error = %v, want conflict", rebaseErr)
+			}
+
+			content := readWorkingFile(t, "a.txt")
+			if !strings.Contains(content, "<<<<<<< HEAD\nupstream\n=======\nmain\n>>>>>>> "+main[:7]) {
+				t.Errorf("a.txt missing conflict markers: %q", content)
+			}
+
+			// Continuing without resolving is refused
+			if err := RebaseContinue(); err == nil {
+				t.Errorf("RebaseContinue() with unresolved conflict succeeded")
+			}
+
+			captureOutput(t, func() {
+				if err := tt.resolve(t); err != nil {
+					t.Errorf("resolve error = %v", err)
+				}
+			})
+
+			if got := logMessages(t); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("history = %v, want %v", got, tt.want)
+			}
+			if got := readWorkingFile(t, "a.txt"); got != tt.content {
+				t.Errorf("a.txt = %q, want %q", got, tt.content)
+			}
+			if branch, _ := GetCurrentBranch(); branch != "main" {
+				t.Errorf("GetCurrentBranch() = %q, want main", branch)
+			}
+			if _, err := os.Stat(filepath.Join(GITTER_DIR, REBASE_DIR)); !os.IsNotExist(err) {
+				t.Errorf("rebase state left behind")
+			}
+		})
+	}
+}
+
+func TestRebaseInteractive(t *testing.T) {
+	tests := []struct {
+		name   string
+		editor string
+		want   []string
+		files  map[string]string
+	}{
+		{
+			name:   "Unchanged todo keeps history",
+			editor: "true",
+			want:   []string{"Third", "Second", "First", "Root"},
+		},
+		{
+			name:   "Drop",
+			editor: `sed -i '2s/^pick/drop/' "$1"`,
+			want:   []string{"Third", "First", "Root"},
+			files:  map[string]string{"a.txt": "root\n", "b.txt": "first\n", "d.txt": "third\n"},
+		},
+		{
+			name:   "Reorder",
+			editor: `sed -n '3p' "$1" > "$1.new"; sed -n '1,2p' "$1" >> "$1.new"; mv "$1.new" "$1"`,
+			want:   []string{"Second", "First", "Third", "Root"},
+		},
+		{
+			name:   "Fixup",
+			editor: `sed -i '3s/^pick/fixup/' "$1"`,
+			want:   []string{"Second", "First", "Root"},
+			files:  map[string]string{"c.txt": "second\n", "d.txt": "third\n"},
+		},
+		{
+			name: "Squash",
+			editor: `case "$1" in
+*todo) sed -i '2s/^pick/squash/' "$1" ;;
+*) printf 'Squashed\n# comment\n' > "$1" ;;
+esac`,
+			want:  []string{"Third", "Squashed", "Root"},
+			files: map[string]string{"b.txt": "first\n", "c.txt": "second\n"},
+		},
+		{
+			name: "Reword",
+			editor: `case "$1" in
+*todo) sed -i '1s/^pick/reword/' "$1" ;;
+*) sed -i 's/^First$/First, reworded/' "$1" ;;
+esac`,
+			want: []string{"Third", "Second", "First, reworded", "Root"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cleanup := setupTestRepo(t)
+			defer cleanup()
+
+			if err := InitRepository(); err != nil {
+				t.Fatalf("Failed to initialize repository: %v", err)
+			}
+			commitFile(t, "a.txt", "root\n", "Root")
+			commitFile(t, "b.txt", "first\n", "First")
+			commitFile(t, "c.txt", "second\n", "Second")
+			head := commitFile(t, "d.txt", "third\n", "Third")
+
+			setEditor(t, tt.editor)
+			captureOutput(t, func() {
+				if err := Rebase("HEAD~3", RebaseOptions{Interactive: true}); err != nil {
+					t.Errorf("Rebase() error = %v", err)
+				}
+			})
+
+			if got := logMessages(t); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("history = %v, want %v", got, tt.want)
+			}
+			if tt.name == "Unchanged todo keeps history" {
+				if newHead, _ := GetCurrentHead(); newHead != head {
+					t.Errorf("HEAD = %s, want unchanged %s", newHead, head)
+				}
+			}
+			for path, content := range tt.files {
+				if got := readWorkingFile(t, path); got != content {
+					t.Errorf("%s = %q, want %q", path, got, content)
+				}
+			}
+			if tt.name == "Drop" {
+				if _, err := os.Stat("c.txt"); !os.IsNotExist(err) {
+					t.Errorf("c.txt from dropped commit still exists")
+				}
+			}
+		})
+	}
+}
+
+func TestRebaseInteractiveEdit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+	commitFile(t, "a.txt", "root\n", "Root")
+	commitFile(t, "b.txt", "first\n", "First")
+	commitFile(t, "c.txt", "second\n", "Second")
+
+	setEditor(t, `sed -i '1s/^pick/edit/' "$1"`)
+	output := captureOutput(t, func() {
+		if err := Rebase("HEAD~2", RebaseOptions{Interactive: true}); err != nil {
+			t.Errorf("Rebase() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Stopped at") {
+		t.Errorf("Rebase() output = %q, want stop", output)
+	}
+	if got := logMessages(t); strings.Join(got, ",") != "First,Root" {
+		t.Errorf("history while stopped = %v", got)
+	}
+
+	// Insert an extra commit while stopped
+	commitFile(t, "extra.txt", "extra\n", "Extra")
+
+	captureOutput(t, func() {
+		if err := RebaseContinue(); err != nil {
+			t.Errorf("RebaseContinue() error = %v", err)
+		}
+	})
+	if got := logMessages(t); strings.Join(got, ",") != "Second,Extra,First,Root" {
+		t.Errorf("history = %v", got)
+	}
+	if branch, _ := GetCurrentBranch(); branch != "main" {
+		t.Errorf("GetCurrentBranch() = %q, want main", branch)
+	}
+}
+
+func TestRebaseRefusesDirtyTree(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	setupDivergedRepo(t, "ONE\ntwo\nthree\n", "c.txt", "feature\n")
+	if err := ioutil.WriteFile("c.txt", []byte("dirty\n"), 0644); err != nil {
+		t.Fatalf("Failed to write c.txt: %v", err)
+	}
+
+	if err := Rebase("upstream", RebaseOptions{}); err == nil || !strings.Contains(err.Error(), "uncommitted changes") {
+		t.Errorf("Rebase() error = %v, want uncommitted changes", err)
+	}
+}
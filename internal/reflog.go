@@ -0,0 +1,152 @@
+// internal/reflog.go
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LOGS_DIR holds the reflog, which records every commit HEAD moved to
+const LOGS_DIR = "logs"
+
+// ReflogEntry is one move of HEAD, newest first when read back
+type ReflogEntry struct {
+	Old     string // "" when HEAD had no commit yet
+	New     string
+	Author  string
+	Date    time.Time
+	Message string // e.g. "commit (amend): Fix typo"
+}
+
+// reflogPath is the file holding the reflog of HEAD
+func reflogPath(repo *Repository) string {
+	return filepath.Join(repo.GitDir, LOGS_DIR, HEAD_FILE)
+}
+
+// appendReflog records that HEAD moved from oldHash to newHash, in git's
+// "<old> <new> <author> <time> <zone>\t<message>" line format
+func appendReflog(repo *Repository, oldHash, newHash, author, message string) error {
+	path := reflogPath(repo)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	now := time.Now()
+	message = strings.ReplaceAll(message, "\n", " ")
+	author = strings.NewReplacer("\t", " ", "\n", " ").Replace(author)
+	_, err = fmt.Fprintf(file, "%s %s %s %d %s\t%s\n",
+		hookCommitID(oldHash), newHash, author, now.Unix(), now.Format("-0700"), message)
+	return err
+}
+
+// readReflog returns the reflog of HEAD, newest entry first
+func readReflog(repo *Repository) ([]ReflogEntry, error) {
+	data, err := ioutil.ReadFile(reflogPath(repo))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []ReflogEntry
+	for n, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := parseReflogLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("reflog line %d is malformed: %q", n+1, line)
+		}
+		entries = append(entries, entry)
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// parseReflogLine reads one line written by appendReflog
+func parseReflogLine(line string) (ReflogEntry, error) {
+	var entry ReflogEntry
+	tab := strings.IndexByte(line, '\t')
+	if tab < 0 {
+		return entry, fmt.Errorf("no message")
+	}
+	// The author may contain spaces, so the hashes are taken from the front
+	// and the time and zone from the back, leaving the author between them
+	fields := strings.SplitN(line[:tab], " ", 3)
+	if len(fields) != 3 || !isHash(fields[0]) || !isHash(fields[1]) {
+		return entry, fmt.Errorf("bad fields")
+	}
+	rest := fields[2]
+	zoneAt := strings.LastIndexByte(rest, ' ')
+	if zoneAt < 0 {
+		return entry, fmt.Errorf("no time")
+	}
+	secsAt := strings.LastIndexByte(rest[:zoneAt], ' ')
+	if secsAt < 0 {
+		return entry, fmt.Errorf("no author")
+	}
+	secs, err := strconv.ParseInt(rest[secsAt+1:zoneAt], 10, 64)
+	if err != nil {
+		return entry, err
+	}
+	zone, err := time.Parse("-0700", rest[zoneAt+1:])
+	if err != nil {
+		return entry, err
+	}
+
+	entry.Old = fields[0]
+	if entry.Old == hookCommitID("") {
+		entry.Old = ""
+	}
+	entry.New = fields[1]
+	entry.Author = rest[:secsAt]
+	entry.Date = time.Unix(secs, 0).In(zone.Location())
+	entry.Message = line[tab+1:]
+	return entry, nil
+}
+
+// resolveReflog resolves HEAD@{n}, the commit HEAD pointed to n moves ago
+func resolveReflog(repo *Repository, rev, n string) (string, error) {
+	steps, err := strconv.Atoi(n)
+	if err != nil || steps < 0 {
+		return "", fmt.Errorf("bad revision '%s'", rev)
+	}
+	entries, err := readReflog(repo)
+	if err != nil {
+		return "", err
+	}
+	if steps >= len(entries) {
+		return "", fmt.Errorf("log for 'HEAD' only has %d entries", len(entries))
+	}
+	return entries[steps].New, nil
+}
+
+// ShowReflog prints the reflog of HEAD, newest first
+func ShowReflog() error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	entries, err := readReflog(repo)
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		fmt.Printf("%s HEAD@{%d}: %s\n", entry.New[:7], i, entry.Message)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// internal/reflog_test.go
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReflog(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := FindGitterRepo()
+
+	if entries, err := readReflog(repo); err != nil || len(entries) != 0 {
+		t.Errorf("readReflog(new repo) = %v, %v", entries, err)
+	}
+
+	var first, second string
+	captureOutput(t, func() {
+		first = commitFile(t, "a.txt", "a\n", "Add a.txt")
+		second = commitFile(t, "b.txt", "b\n", "Add teh b.txt\n\nWith a body")
+		if err := CommitChanges("Add b.txt", CommitOptions{Amend: true}); err != nil {
+			t.Fatalf("CommitChanges(amend) error = %v", err)
+		}
+	})
+	third, _ := GetCurrentHead()
+
+	entries, err := readReflog(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ReflogEntry{
+		{Old: second, New: third, Message: "commit (amend): Add b.txt"},
+		{Old: first, New: second, Message: "commit: Add teh b.txt"},
+		{Old: "", New: first, Message: "commit: Add a.txt"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("readReflog() = %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Old != want[i].Old || entry.New != want[i].New || entry.Message != want[i].Message || entry.Author != "user" {
+			t.Errorf("entry %d = %+v, want %+v", i, entry, want[i])
+		}
+	}
+
+	output := captureOutput(t, func() {
+		if err := ShowReflog(); err != nil {
+			t.Errorf("ShowReflog() error = %v", err)
+		}
+	})
+	if !strings.HasPrefix(output, third[:7]+" HEAD@{0}: commit (amend): Add b.txt\n") {
+		t.Errorf("ShowReflog() output = %q", output)
+	}
+
+	for rev, want := range map[string]string{"HEAD@{0}": third, "HEAD@{1}": second, "@{2}": first, "HEAD@{1}~1": first} {
+		if got, err := resolveRevision(repo, rev); err != nil || got != want {
+			t.Errorf("resolveRevision(%q) = %s, %v, want %s", rev, got, err, want)
+		}
+	}
+	for _, rev := range []string{"HEAD@{3}", "HEAD@{x}", "main@{0}"} {
+		if _, err := resolveRevision(repo, rev); err == nil {
+			t.Errorf("resolveRevision(%q) succeeded", rev)
+		}
+	}
+
+	// The replaced commit stays reachable through the reflog alone
+	if err := os.Remove(filepath.Join(repo.GitDir, LOG_FILE)); err != nil {
+		t.Fatal(err)
+	}
+	result, err := fsckRepository(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range result.Unreachable {
+		if hash == second {
+			t.Errorf("amended commit %s is unreachable", second[:7])
+		}
+	}
+}
+
+func TestReflogAuthorWithSpaces(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := FindGitterRepo()
+	var head string
+	captureOutput(t, func() { head = commitFile(t, "a.txt", "a\n", "Add a.txt") })
+	if err := appendReflog(repo, head, head, "Jane Doe", "checkout: moving nowhere"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readReflog(repo)
+	if err != nil {
+		t.Fatalf("readReflog() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Author != "Jane Doe" || entries[0].Old != head || entries[0].Message != "checkout: moving nowhere" {
+		t.Errorf("readReflog() = %+v", entries)
+	}
+
+	// fsck and gc both read the reflog
+	if _, err := fsckRepository(repo); err != nil {
+		t.Errorf("fsckRepository() error = %v", err)
+	}
+	captureOutput(t, func() {
+		if err := GarbageCollect(PruneOptions{}); err != nil {
+			t.Errorf("GarbageCollect() error = %v", err)
+		}
+	})
+
+	for _, line := range []string{
+		head + " " + head + " 1700000000 +0000\tno author",
+		head + " " + head + " Jane Doe x +0000\tbad time",
+		head + " " + head + " Jane\tno time",
+	} {
+		if _, err := parseReflogLine(line); err == nil {
+			t.Errorf("parseReflogLine(%q) succeeded", line)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// internal/refs.go
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// headRefPrefix marks a symbolic HEAD that points at a branch
+const headRefPrefix = "ref: "
+
+// readHead returns the raw contents of HEAD without the trailing newline
+func readHead(repo *Repository) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(repo.GitDir, HEAD_FILE))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// currentBranch returns the branch HEAD points to, or "" when HEAD is detached
+func currentBranch(repo *Repository) (string, error) {
+	head, err := readHead(repo)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(head, headRefPrefix) {
+		return "", nil
+	}
+	ref := strings.TrimPrefix(head, headRefPrefix)
+	return strings.TrimPrefix(ref, filepath.ToSlash(filepath.Join(REFS_DIR, HEADS_DIR))+"/"), nil
+}
+
+// GetCurrentBranch returns the name of the checked out branch, or "" when HEAD is detached
+func GetCurrentBranch() (string, error) {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return "", err
+	}
+	return currentBranch(repo)
+}
+
+// branchRef returns the ref name of a branch, e.g. refs/heads/main
+func branchRef(branch string) string {
+	return REFS_DIR + "/" + HEADS_DIR + "/" + branch
+}
+
+// validBranchName rejects branch names that cannot be stored as a ref file
+// under refs/heads or would escape it
+func validBranchName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "-") || strings.HasSuffix(name, ".lock") {
+		return false
+	}
+	if strings.ContainsAny(name, "\\ \t\n:~^*?[") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// readRef returns the commit a ref points to, or "" when the ref does not exist
+func readRef(repo *Repository, ref string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(repo.GitDir, filepath.FromSlash(ref)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeRef points a ref at a commit, creating intermediate directories
+func writeRef(repo *Repository, ref, commitHash string) error {
+	refPath := filepath.Join(repo.GitDir, filepath.FromSlash(ref))
+	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(refPath, []byte(commitHash+"\n"), 0644)
+}
+
+// updateRefChecked moves a ref from oldHash to newHash, failing if another
+// process holds the ref or has moved it from oldHash in the meantime. An
+// empty oldHash means the ref must not exist yet.
+func updateRefChecked(repo *Repository, ref, oldHash, newHash string) error {
+	refPath := filepath.Join(repo.GitDir, filepath.FromSlash(ref))
+	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+		return err
+	}
+	lockPath := refPath + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("unable to lock %s: %s exists", ref, filepath.Base(lockPath))
+		}
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(lockPath)
+		}
+	}()
+
+	current, err := readRef(repo, ref)
+	if err != nil {
+		lock.Close()
+		return err
+	}
+	if current != oldHash {
+		lock.Close()
+		return fmt.Errorf("%s changed while it was being updated", ref)
+	}
+	if _, err := lock.WriteString(newHash + "\n"); err != nil {
+		lock.Close()
+		return err
+	}
+	if err := lock.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(lockPath, refPath); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// listBranches maps every branch name to the commit it points to
+func listBranches(repo *Repository) (map[string]string, error) {
+	branches := make(map[string]string)
+	headsDir := filepath.Join(repo.GitDir, REFS_DIR, HEADS_DIR)
+	err := filepath.Walk(headsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(path, ".lock") {
+			return err
+		}
+		rel, err := filepath.Rel(headsDir, path)
+		if err != nil {
+			return err
+		}
+		hash, err := readRef(repo, branchRef(filepath.ToSlash(rel)))
+		if err != nil {
+			return err
+		}
+		if hash != "" {
+			branches[filepath.ToSlash(rel)] = hash
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return branches, nil
+}
+
+// isAncestor reports whether ancestor is descendant or one of its parents,
+// grandparents and so on
+func isAncestor(repo *Repository, ancestor, descendant string) (bool, error) {
+	for hash := descendant; hash != ""; {
+		if hash == ancestor {
+			return true, nil
+		}
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return false, err
+		}
+		hash = commit.Parent
+	}
+	return false, nil
+}
+
+// attachHead makes HEAD a symbolic reference to a branch
+func attachHead(repo *Repository, branch string) error {
+	headPath := filepath.Join(repo.GitDir, HEAD_FILE)
+	return ioutil.WriteFile(headPath, []byte(headRefPrefix+branchRef(branch)+"\n"), 0644)
+}
+
+// detachHead points HEAD directly at a commit
+func detachHead(repo *Repository, commitHash string) error {
+	headPath := filepath.Join(repo.GitDir, HEAD_FILE)
+	return ioutil.WriteFile(headPath, []byte(commitHash+"\n"), 0644)
+}
+
+// ResolveRevision turns a revision (HEAD, HEAD@{n}, branch, remote-tracking
+// branch, full or abbreviated hash, optionally followed by ~N and ^ suffixes)
+// into a full commit hash
+func ResolveRevision(rev string) (string, error) {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return "", err
+	}
+	return resolveRevision(repo, rev)
+}
+
+// resolveRevision is ResolveRevision for an already located repository
+func resolveRevision(repo *Repository, rev string) (string, error) {
+	base := rev
+	suffix := ""
+	if i := strings.IndexAny(rev, "~^"); i >= 0 {
+		base, suffix = rev[:i], rev[i:]
+	}
+
+	hash, err := resolveName(repo, base)
+	if err != nil {
+		return "", err
+	}
+
+	// Walk first parents for every ~N and ^ in the suffix
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+		steps := 1
+		if op == '~' {
+			end := 0
+			for end < len(suffix) && suffix[end] >= '0' && suffix[end] <= '9' {
+				end++
+			}
+			if end > 0 {
+				steps, _ = strconv.Atoi(suffix[:end])
+				suffix = suffix[end:]
+			}
+		} else if op != '^' {
+			return "", fmt.Errorf("bad revision '%s'", rev)
+		}
+
+		for i := 0; i < steps; i++ {
+			commit, err := readCommit(repo, hash)
+			if err != nil {
+				return "", err
+			}
+			if commit.Parent == "" {
+				return "", fmt.Errorf("bad revision '%s'", rev)
+			}
+			hash = commit.Parent
+		}
+	}
+
+	return hash, nil
+}
+
+// resolveName resolves a revision without ~ or ^ suffixes
+func resolveName(repo *Repository, name string) (string, error) {
+	if i := strings.Index(name, "@{"); i >= 0 && strings.HasSuffix(name, "}") {
+		if ref := name[:i]; ref != "" && ref != HEAD_FILE {
+			return "", fmt.Errorf("bad revision '%s': only HEAD has a reflog", name)
+		}
+		return resolveReflog(repo, name, name[i+2:len(name)-1])
+	}
+	if name == "" || name == HEAD_FILE || name == "@" {
+		head, err := readHead(repo)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(head, headRefPrefix) {
+			head, err = readRef(repo, strings.TrimPrefix(head, headRefPrefix))
+			if err != nil {
+				return "", err
+			}
+		}
+		if head == "" {
+			return "", fmt.Errorf("bad revision '%s': no commits yet", name)
+		}
+		return head, nil
+	}
+
+	// Branch names take precedence over hash prefixes
+	for _, ref := range []string{name, branchRef(name), REFS_DIR + "/" + REMOTES_DIR + "/" + name} {
+		if !strings.HasPrefix(ref, REFS_DIR+"/") {
+			continue
+		}
+		hash, err := readRef(repo, ref)
+		if err != nil {
+			return "", err
+		}
+		if hash != "" {
+			return hash, nil
+		}
+	}
+
+	return resolveHashPrefix(repo, name)
+}
+
+// resolveHashPrefix expands an abbreviated commit hash to the full hash
+func resolveHashPrefix(repo *Repository, prefix string) (string, error) {
+	if len(prefix) < 4 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("bad revision '%s'", prefix)
+	}
+
+	hashes, err := listObjects(repo)
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	for _, hash := range hashes {
+		if !strings.HasPrefix(hash, prefix) {
+			continue
+		}
+		if _, err := readCommit(repo, hash); err == nil {
+			matches = append(matches, hash)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("bad revision '%s'", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("short revision '%s' is ambiguous", prefix)
+	}
+}
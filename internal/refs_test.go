@@ -0,0 +1,122 @@
+// internal/refs_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// commitFile writes a file, stages it and commits it, returning the new HEAD
+func commitFile(t *testing.T, path, content, message string) string {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	if err := AddFile(path); err != nil {
+		t.Fatalf("AddFile(%s) error = %v", path, err)
+	}
+	captureOutput(t, func() {
+		if err := CommitChanges(message, CommitOptions{}); err != nil {
+			t.Fatalf("CommitChanges(%q) error = %v", message, err)
+		}
+	})
+	head, err := GetCurrentHead()
+	if err != nil {
+		t.Fatalf("GetCurrentHead() error = %v", err)
+	}
+	return head
+}
+
+func TestResolveRevision(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	first := commitFile(t, "a.txt", "one\n", "First commit")
+	second := commitFile(t, "a.txt", "two\n", "Second commit")
+	third := commitFile(t, "a.txt", "three\n", "Third commit")
+
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatalf("FindGitterRepo() error = %v", err)
+	}
+	if err := writeRef(repo, branchRef("topic"), second); err != nil {
+		t.Fatalf("writeRef() error = %v", err)
+	}
+
+	tests := []struct {
+		rev     string
+		want    string
+		wantErr bool
+	}{
+		{rev: "HEAD", want: third},
+		{rev: "main", want: third},
+		{rev: "HEAD~1", want: second},
+		{rev: "HEAD^^", want: first},
+		{rev: "main~2", want: first},
+		{rev: "topic", want: second},
+		{rev: "topic^", want: first},
+		{rev: third[:7], want: third},
+		{rev: second, want: second},
+		{rev: "HEAD~3", wantErr: true},
+		{rev: "nope", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rev, func(t *testing.T) {
+			got, err := ResolveRevision(tt.rev)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveRevision(%q) error = %v, wantErr %v", tt.rev, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveRevision(%q) = %v, want %v", tt.rev, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateHeadFollowsBranch(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatalf("FindGitterRepo() error = %v", err)
+	}
+
+	if err := attachHead(repo, "topic"); err != nil {
+		t.Fatalf("attachHead() error = %v", err)
+	}
+	if err := UpdateHead("abc123"); err != nil {
+		t.Fatalf("UpdateHead() error = %v", err)
+	}
+	if got, _ := readRef(repo, branchRef("topic")); got != "abc123" {
+		t.Errorf("topic = %q, want abc123", got)
+	}
+	if got, _ := readRef(repo, branchRef("main")); got != "" {
+		t.Errorf("main = %q, want it untouched", got)
+	}
+
+	if err := detachHead(repo, "abc123"); err != nil {
+		t.Fatalf("detachHead() error = %v", err)
+	}
+	if err := UpdateHead("def456"); err != nil {
+		t.Fatalf("UpdateHead() error = %v", err)
+	}
+	if branch, _ := GetCurrentBranch(); branch != "" {
+		t.Errorf("GetCurrentBranch() = %q, want detached HEAD", branch)
+	}
+	if head, _ := GetCurrentHead(); head != "def456" {
+		t.Errorf("GetCurrentHead() = %q, want def456", head)
+	}
+	if got, _ := readRef(repo, branchRef("topic")); got != "abc123" {
+		t.Errorf("topic = %q, want it untouched", got)
+	}
+}
@@ -0,0 +1,217 @@
+// internal/remote.go
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// REMOTES_FILE lists the configured remotes
+const REMOTES_FILE = "remotes.json"
+
+// REMOTES_DIR holds the remote-tracking branches under refs, one directory
+// per remote
+const REMOTES_DIR = "remotes"
+
+// defaultRemote is the remote created by clone and used when none is named
+const defaultRemote = "origin"
+
+// Remote is another repository that history is fetched from and pushed to
+type Remote struct {
+	Name string `json:"name"`
+	URL  string `json:"url"` // path of the repository or of its .gitter directory, or an http(s) or ssh URL
+
+	// Filter is set on the remote a partial clone was made from to the
+	// blobs it left out, which are fetched from there when needed
+	Filter string `json:"filter,omitempty"`
+}
+
+// loadRemotes returns the configured remotes sorted by name
+func loadRemotes(repo *Repository) ([]Remote, error) {
+	data, err := ioutil.ReadFile(filepath.Join(repo.GitDir, REMOTES_FILE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var remotes []Remote
+	if err := json.Unmarshal(data, &remotes); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %v", REMOTES_FILE, err)
+	}
+	return remotes, nil
+}
+
+// saveRemotes persists the remotes, sorted by name
+func saveRemotes(repo *Repository, remotes []Remote) error {
+	sort.Slice(remotes, func(i, j int) bool { return remotes[i].Name < remotes[j].Name })
+	data, err := json.MarshalIndent(remotes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(repo.GitDir, REMOTES_FILE), data, 0644)
+}
+
+// findRemote returns the configured remote with the given name
+func findRemote(repo *Repository, name string) (Remote, bool, error) {
+	remotes, err := loadRemotes(repo)
+	if err != nil {
+		return Remote{}, false, err
+	}
+	for _, remote := range remotes {
+		if remote.Name == name {
+			return remote, true, nil
+		}
+	}
+	return Remote{}, false, nil
+}
+
+// resolveRemote looks up a remote by name, defaulting to origin. A name that
+// is not configured is taken as the path or URL of a repository; such a
+// remote has no remote-tracking branches.
+func resolveRemote(repo *Repository, name string) (Remote, bool, error) {
+	if name == "" {
+		name = defaultRemote
+	}
+	remote, found, err := findRemote(repo, name)
+	if err != nil || found {
+		return remote, found, err
+	}
+	if isNetworkURL(name) {
+		return Remote{Name: name, URL: name}, false, nil
+	}
+	if _, err := os.Stat(name); err == nil {
+		url, err := filepath.Abs(name)
+		return Remote{Name: name, URL: url}, false, err
+	}
+	if name == defaultRemote {
+		return Remote{}, false, fmt.Errorf("no remote named '%s'; add one with 'gitter remote add'", name)
+	}
+	return Remote{}, false, fmt.Errorf("'%s' does not appear to be a gitter repository", name)
+}
+
+// remoteTrackingRef returns the ref that remembers a branch of a remote,
+// e.g. refs/remotes/origin/main
+func remoteTrackingRef(remote, branch string) string {
+	return REFS_DIR + "/" + REMOTES_DIR + "/" + remote + "/" + branch
+}
+
+// validRemoteName rejects names that cannot be used as a ref directory
+func validRemoteName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, "-") {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\ \t\n:~^*?[")
+}
+
+// openRemote locates the repository a remote points to: a directory with a
+// .gitter directory inside, or a bare repository. Relative paths, which only
+// come from hand-edited remotes, are taken from the working tree root.
+func openRemote(repo *Repository, url string) (*Repository, error) {
+	path := url
+	if !filepath.IsAbs(path) && repo != nil {
+		path = filepath.Join(repo.WorkingDir, path)
+	}
+	path = filepath.Clean(path)
+
+	if info, err := os.Stat(filepath.Join(path, GITTER_DIR)); err == nil && info.IsDir() {
+		return &Repository{WorkingDir: path, GitDir: filepath.Join(path, GITTER_DIR)}, nil
+	}
+	if isBareRepository(path) {
+		if filepath.Base(path) == GITTER_DIR {
+			return &Repository{WorkingDir: filepath.Dir(path), GitDir: path}, nil
+		}
+		return &Repository{GitDir: path}, nil
+	}
+	return nil, fmt.Errorf("'%s' does not appear to be a gitter repository", url)
+}
+
+// isBareRepository reports whether dir holds a repository without a
+// working tree
+func isBareRepository(dir string) bool {
+	for _, name := range []string{HEAD_FILE, OBJECTS_DIR, REFS_DIR} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// RemoteAdd configures a new remote
+func RemoteAdd(name, url string) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	if !validRemoteName(name) {
+		return fmt.Errorf("'%s' is not a valid remote name", name)
+	}
+
+	remotes, err := loadRemotes(repo)
+	if err != nil {
+		return err
+	}
+	for _, remote := range remotes {
+		if remote.Name == name {
+			return fmt.Errorf("remote %s already exists", name)
+		}
+	}
+	// Keep the path working from any directory of the project
+	if !isNetworkURL(url) && !filepath.IsAbs(url) {
+		if url, err = filepath.Abs(url); err != nil {
+			return err
+		}
+	}
+	return saveRemotes(repo, append(remotes, Remote{Name: name, URL: url}))
+}
+
+// RemoteRemove forgets a remote along with its remote-tracking branches
+func RemoteRemove(name string) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	remotes, err := loadRemotes(repo)
+	if err != nil {
+		return err
+	}
+	kept := remotes[:0]
+	for _, remote := range remotes {
+		if remote.Name != name {
+			kept = append(kept, remote)
+		}
+	}
+	if len(kept) == len(remotes) {
+		return fmt.Errorf("no such remote: '%s'", name)
+	}
+	if err := saveRemotes(repo, kept); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(repo.GitDir, REFS_DIR, REMOTES_DIR, name))
+}
+
+// RemoteList prints the configured remotes, with their URLs when verbose
+func RemoteList(verbose bool) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+	remotes, err := loadRemotes(repo)
+	if err != nil {
+		return err
+	}
+	for _, remote := range remotes {
+		if verbose {
+			fmt.Printf("%s\t%s (fetch)\n%s\t%s (push)\n", remote.Name, remote.URL, remote.Name, remote.URL)
+		} else {
+			fmt.Println(remote.Name)
+		}
+	}
+	return nil
+}
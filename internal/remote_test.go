@@ -0,0 +1,143 @@
+// internal/remote_test.go
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inDir runs f with the working directory set to dir
+func inDir(t *testing.T, dir string, f func()) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	f()
+}
+
+// setupHub creates a bare repository hub.gitter and a repository alice whose
+// first commit is pushed to it as main. It returns the directory holding
+// both, with the test left in alice.
+func setupHub(t *testing.T) (string, func()) {
+	t.Helper()
+	top, cleanup := setupTestRepo(t)
+	top, _ = filepath.EvalSymlinks(top)
+	if err := InitBareRepository(filepath.Join(top, "hub.gitter")); err != nil {
+		t.Fatal(err)
+	}
+	alice := filepath.Join(top, "alice")
+	if err := os.Mkdir(alice, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoteAdd("origin", "../hub.gitter"); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		commitFile(t, "a.txt", "a\n", "Add a.txt")
+		if err := Push("", "", PushOptions{}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	})
+	return top, cleanup
+}
+
+func TestRemoteConfig(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+
+	if err := RemoteAdd("origin", "elsewhere"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("RemoteAdd(duplicate) error = %v", err)
+	}
+	for _, name := range []string{"", "a/b", "has space", "-x"} {
+		if err := RemoteAdd(name, top); err == nil {
+			t.Errorf("RemoteAdd(%q) succeeded", name)
+		}
+	}
+	if err := RemoteAdd("backup", filepath.Join(top, "hub.gitter")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative paths are stored as absolute ones
+	hub := filepath.Join(top, "hub.gitter")
+	output := captureOutput(t, func() {
+		if err := RemoteList(true); err != nil {
+			t.Errorf("RemoteList() error = %v", err)
+		}
+	})
+	want := "backup\t" + hub + " (fetch)\nbackup\t" + hub + " (push)\norigin\t" + hub + " (fetch)\norigin\t" + hub + " (push)\n"
+	if output != want {
+		t.Errorf("RemoteList(verbose) = %q, want %q", output, want)
+	}
+
+	captureOutput(t, func() {
+		if err := Fetch("backup"); err != nil {
+			t.Fatalf("Fetch(backup) error = %v", err)
+		}
+	})
+	repo, _ := FindGitterRepo()
+	if hash, _ := readRef(repo, remoteTrackingRef("backup", "main")); hash == "" {
+		t.Errorf("fetch did not create backup/main")
+	}
+
+	if err := RemoteRemove("backup"); err != nil {
+		t.Fatalf("RemoteRemove() error = %v", err)
+	}
+	if hash, _ := readRef(repo, remoteTrackingRef("backup", "main")); hash != "" {
+		t.Errorf("backup/main survived removing the remote")
+	}
+	if hash, _ := readRef(repo, remoteTrackingRef("origin", "main")); hash == "" {
+		t.Errorf("origin/main was removed along with backup")
+	}
+	if err := RemoteRemove("backup"); err == nil {
+		t.Errorf("RemoteRemove(unknown) succeeded")
+	}
+	output = captureOutput(t, func() { RemoteList(false) })
+	if output != "origin\n" {
+		t.Errorf("RemoteList() = %q", output)
+	}
+}
+
+func TestOpenRemote(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+
+	alice := filepath.Join(top, "alice")
+	hub := filepath.Join(top, "hub.gitter")
+	tests := []struct {
+		url     string
+		gitDir  string
+		working string
+	}{
+		{url: alice, gitDir: filepath.Join(alice, GITTER_DIR), working: alice},
+		{url: filepath.Join(alice, GITTER_DIR), gitDir: filepath.Join(alice, GITTER_DIR), working: alice},
+		{url: hub, gitDir: hub},
+		{url: "../hub.gitter", gitDir: hub},
+	}
+	repo, _ := FindGitterRepo()
+	for _, tt := range tests {
+		remote, err := openRemote(repo, tt.url)
+		if err != nil {
+			t.Errorf("openRemote(%s) error = %v", tt.url, err)
+			continue
+		}
+		if remote.GitDir != tt.gitDir || remote.WorkingDir != tt.working {
+			t.Errorf("openRemote(%s) = %+v, want %s and %q", tt.url, remote, tt.gitDir, tt.working)
+		}
+	}
+	if _, err := openRemote(repo, top); err == nil {
+		t.Errorf("openRemote(not a repository) succeeded")
+	}
+}
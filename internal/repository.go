@@ -2,9 +2,9 @@
 package internal // Changed from "package main" to "package internal"
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -28,6 +28,10 @@ type Commit struct {
 	Message  string    `json:"message"`
 	Parent   string    `json:"parent"`
 	TreeHash string    `json:"tree_hash"`
+
+	// Shallow is set when the parent was left out of a shallow clone, to the
+	// tree with the commit's full snapshot; Parent then reads as empty
+	Shallow string `json:"-"`
 }
 
 // IndexEntry structure
@@ -35,6 +39,15 @@ type IndexEntry struct {
 	FilePath string `json:"file_path"`
 	Hash     string `json:"hash"`
 	Modified bool   `json:"modified"`
+	FileMode uint32 `json:"file_mode,omitempty"` // MODE_FILE, MODE_EXECUTABLE or MODE_SYMLINK
+
+	// Stat data of the file when Hash was recorded, used to skip re-hashing
+	// unchanged files. Only kept in the index, never in trees.
+	Size  int64  `json:"size,omitempty"`
+	MTime int64  `json:"mtime,omitempty"` // nanoseconds since the epoch
+	CTime int64  `json:"ctime,omitempty"`
+	Inode uint64 `json:"inode,omitempty"`
+	Mode  uint32 `json:"mode,omitempty"`
 }
 
 // Configuration constants
@@ -86,12 +99,28 @@ func InitRepository() error {
 		return fmt.Errorf("repository already initialized")
 	}
 
+	return initGitDir(gitterPath)
+}
+
+// InitBareRepository creates a repository without a working tree in dir,
+// which only serves as a remote to push to and fetch from
+func InitBareRepository(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, HEAD_FILE)); err == nil {
+		return fmt.Errorf("repository already initialized")
+	}
+	return initGitDir(dir)
+}
+
+// initGitDir creates the repository structure in gitterPath: .gitter for a
+// normal repository, or the top directory of a bare one
+func initGitDir(gitterPath string) error {
 	// Create directory structure
 	dirs := []string{
 		gitterPath,
 		filepath.Join(gitterPath, REFS_DIR),
 		filepath.Join(gitterPath, REFS_DIR, HEADS_DIR),
 		filepath.Join(gitterPath, OBJECTS_DIR),
+		filepath.Join(gitterPath, HOOKS_DIR),
 	}
 
 	for _, dir := range dirs {
@@ -108,8 +137,7 @@ func InitRepository() error {
 
 	// Create empty index
 	indexPath := filepath.Join(gitterPath, INDEX_FILE)
-	emptyIndex := []IndexEntry{}
-	indexData, err := json.Marshal(emptyIndex)
+	indexData, err := encodeIndex(nil)
 	if err != nil {
 		return err
 	}
@@ -127,8 +155,20 @@ func InitRepository() error {
 	return nil
 }
 
-// hashFile calculates SHA1 hash of a file
+// hashFile calculates SHA1 hash of a file, or of the target of a symlink
 func hashFile(filePath string) (string, error) {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return "", err
+		}
+		return CalculateHash(target), nil
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
@@ -156,28 +196,49 @@ func LoadIndex() ([]IndexEntry, error) {
 		return nil, err
 	}
 
-	var index []IndexEntry
-	if err := json.Unmarshal(data, &index); err != nil {
+	index, isJSON, err := decodeIndex(data)
+	if err != nil {
 		return nil, err
 	}
 
+	// Upgrade an index written in the old JSON format or holding paths as
+	// they were typed
+	index, renamed := normalizeIndexPaths(repo, index)
+	if isJSON || renamed {
+		if err := SaveIndex(index); err != nil {
+			return nil, err
+		}
+	}
+
 	return index, nil
 }
 
-// SaveIndex saves the index to file
+// SaveIndex saves the index to file, sorting the entries by path in place
 func SaveIndex(index []IndexEntry) error {
 	repo, err := FindGitterRepo()
 	if err != nil {
 		return err
 	}
 
+	sortIndex(index)
 	indexPath := filepath.Join(repo.GitDir, INDEX_FILE)
-	data, err := json.Marshal(index)
+	data, err := encodeIndex(index)
 	if err != nil {
 		return err
 	}
+	if err := writeFileAtomic(indexPath, data, 0644); err != nil {
+		return err
+	}
 
-	return ioutil.WriteFile(indexPath, data, 0644)
+	// Entries modified as late as the write itself cannot be told apart
+	// from later edits by stat data alone, so drop their cached stat
+	if !smudgeRacyEntries(index, indexModTime(repo)) {
+		return nil
+	}
+	if data, err = encodeIndex(index); err != nil {
+		return err
+	}
+	return writeFileAtomic(indexPath, data, 0644)
 }
 
 // AddFile adds a file to the index
@@ -188,91 +249,108 @@ func AddFile(filePath string) error {
 		return err
 	}
 
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+	positions := indexByPath(index)
+	tracked := make(map[string]bool, len(index))
+	for path := range positions {
+		tracked[path] = true
+	}
+	hashAll := func(f *scanFile) bool { return f.Hash == "" }
+
 	// Handle glob patterns and directories
-	var files []string
+	var files []scanFile
 
-	// Check if it's a directory
-	stat, err := os.Stat(filePath)
+	// Check if it's a directory; a symlink is added as a link, never followed
+	stat, err := os.Lstat(filePath)
 	if err == nil && stat.IsDir() {
-		// It's a directory, add all files within it
-		err := filepath.Walk(filePath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// Skip directories themselves
-			if info.IsDir() {
-				return nil
-			}
-
-			// Skip .gitter directory
-			if strings.Contains(path, GITTER_DIR) {
-				return nil
-			}
-
-			files = append(files, path)
-			return nil
-		})
+		// It's a directory, add the files within it that are new or changed,
+		// leaving out untracked files that are ignored
+		indexedFiles := make(map[string]*IndexEntry, len(index))
+		for i := range index {
+			indexedFiles[index[i].FilePath] = &index[i]
+		}
+		scanned, _, err := scanAgainstIndex(context.Background(), repo, indexedFiles, []string{filePath})
 		if err != nil {
 			return err
 		}
+		for _, file := range scanned {
+			if entry, exists := indexedFiles[file.Rel]; exists && !fileChanged(*entry, file.Hash, file.Info) {
+				continue
+			}
+			files = append(files, file)
+		}
 	} else if strings.Contains(filePath, "*") {
 		// Handle glob patterns
 		matches, err := filepath.Glob(filePath)
 		if err != nil {
 			return err
 		}
+		rules, err := loadIgnoreRules(repo)
+		if err != nil {
+			return err
+		}
 		for _, match := range matches {
 			// Check if match is a file, not directory
-			stat, err := os.Stat(match)
-			if err == nil && !stat.IsDir() {
-				files = append(files, match)
+			stat, err := os.Lstat(match)
+			if err != nil || stat.IsDir() {
+				continue
+			}
+			rel, err := repoRelative(repo, match)
+			if err != nil {
+				return err
+			}
+			if rules.ignored(rel, false) && !tracked[rel] {
+				continue
 			}
+			files = append(files, scanFile{Path: match, Rel: rel, Info: stat})
 		}
-	} else {
-		// Single file
-		files = []string{filePath}
+	} else if err == nil {
+		// Single file, added even when it matches an ignore rule
+		rel, err := repoRelative(repo, filePath)
+		if err != nil {
+			return err
+		}
+		if rel == GITTER_DIR || strings.HasPrefix(rel, GITTER_DIR+"/") {
+			return fmt.Errorf("'%s' is inside the %s directory", filePath, GITTER_DIR)
+		}
+		files = []scanFile{{Path: filePath, Rel: rel, Info: stat}}
+	} else if !os.IsNotExist(err) {
+		return err
 	}
 
-	index, err := LoadIndex()
+	// Hash in parallel, then update the index in a stable order
+	filters, err := loadWorkingFilters(repo)
 	if err != nil {
 		return err
 	}
+	if err := hashScanFiles(context.Background(), filters, files, hashAll, 0); err != nil {
+		return err
+	}
 
 	for _, file := range files {
-		// Skip if file doesn't exist
-		if _, err := os.Stat(file); os.IsNotExist(err) {
-			continue
-		}
-
-		// Calculate hash
-		hash, err := hashFile(file)
-		if err != nil {
-			return err
-		}
-
 		// Update or add to index
-		found := false
-		for i := range index {
-			if index[i].FilePath == file {
-				index[i].Hash = hash
-				index[i].Modified = true
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			index = append(index, IndexEntry{
-				FilePath: file,
-				Hash:     hash,
+		if i, found := positions[file.Rel]; found {
+			index[i].Hash = file.Hash
+			index[i].Modified = true
+			index[i].FileMode = fileModeOf(file.Info)
+			recordStat(&index[i], file.Info)
+		} else {
+			entry := IndexEntry{
+				FilePath: file.Rel,
+				Hash:     file.Hash,
 				Modified: true,
-			})
+				FileMode: fileModeOf(file.Info),
+			}
+			recordStat(&entry, file.Info)
+			positions[file.Rel] = len(index)
+			index = append(index, entry)
 		}
 
-		// Copy file to objects directory
-		objectPath := filepath.Join(repo.GitDir, OBJECTS_DIR, hash)
-		if err := copyFile(file, objectPath); err != nil {
+		// Copy file to objects directory, or to large file storage
+		if err := storeWorkingFile(repo, filters, file.Rel, file.Path, file.Hash); err != nil {
 			return err
 		}
 	}
@@ -280,13 +358,22 @@ func AddFile(filePath string) error {
 	return SaveIndex(index)
 }
 
-// copyFile copies a file from source to destination
+// copyFile copies a file from source to destination, storing the target
+// of a symlink in place of its contents
 func copyFile(src, dst string) error {
-	sourceFileStat, err := os.Stat(src)
+	sourceFileStat, err := os.Lstat(src)
 	if err != nil {
 		return err
 	}
 
+	if sourceFileStat.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dst, []byte(target), 0644)
+	}
+
 	if !sourceFileStat.Mode().IsRegular() {
 		return fmt.Errorf("%s is not a regular file", src)
 	}
@@ -344,9 +431,15 @@ func UpdateHead(commitHash string) error {
 		return err
 	}
 
-	// Update the main branch reference
-	mainRef := filepath.Join(repo.GitDir, REFS_DIR, HEADS_DIR, "main")
-	return ioutil.WriteFile(mainRef, []byte(commitHash+"\n"), 0644)
+	// Update the checked out branch, or HEAD itself when detached
+	branch, err := currentBranch(repo)
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		return detachHead(repo, commitHash)
+	}
+	return writeRef(repo, branchRef(branch), commitHash)
 }
 
 // CalculateHash calculates SHA1 hash of a string
@@ -0,0 +1,292 @@
+// internal/scanner.go
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// scanFile is a regular file or symlink found in the working tree
+type scanFile struct {
+	Path string // as walked, i.e. the scan root joined with the rest of the path
+	Rel  string // relative to the working tree root, slash-separated
+	Info os.FileInfo
+	Hash string // empty unless the file was hashed
+}
+
+// scanOptions configures a working tree scan
+type scanOptions struct {
+	Roots     []string               // files or directories to scan; empty scans the whole tree
+	Tracked   map[string]bool        // tracked paths are reported even when ignored
+	NeedsHash func(f *scanFile) bool // which files to hash; nil hashes none
+	Workers   int                    // hashing goroutines; 0 uses one per CPU
+}
+
+// scanWorkingTree walks the working tree, skipping .gitter and ignored
+// untracked files, and hashes the selected files on a bounded pool of
+// workers. Files are returned in walk order whatever order the hashes finish
+// in, and the scan stops early when ctx is cancelled.
+func scanWorkingTree(ctx context.Context, repo *Repository, opts scanOptions) ([]scanFile, error) {
+	rules, err := loadIgnoreRules(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := opts.Roots
+	if len(roots) == 0 {
+		roots = []string{repo.WorkingDir}
+	}
+
+	var files []scanFile
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			rel, err := repoRelative(repo, path)
+			if err != nil {
+				return err
+			}
+			if rel == GITTER_DIR || strings.HasPrefix(rel, GITTER_DIR+"/") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if info.IsDir() {
+				if rel != "." && rules.ignored(rel, true) && !hasTrackedUnder(opts.Tracked, rel) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if rules.ignored(rel, false) && !opts.Tracked[rel] {
+				return nil
+			}
+			if !seen[rel] {
+				seen[rel] = true
+				files = append(files, scanFile{Path: path, Rel: rel, Info: info})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.NeedsHash != nil {
+		filters, err := loadWorkingFilters(repo)
+		if err != nil {
+			return nil, err
+		}
+		if err := hashScanFiles(ctx, filters, files, opts.NeedsHash, opts.Workers); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// scanAgainstIndex finds the files under roots (the whole tree when empty)
+// that may be untracked or differ from the index. Tracked files whose cached
+// stat data no longer matches are hashed; every returned tracked file has
+// Hash set to its current hash, and tracked files left out are unchanged.
+// When the fsmonitor daemon is running only the paths it saw change and the
+// ones that were untracked or modified before are examined. The boolean
+// reports whether any index entry had its stat data refreshed.
+func scanAgainstIndex(ctx context.Context, repo *Repository, indexedFiles map[string]*IndexEntry, roots []string) ([]scanFile, bool, error) {
+	indexTime := indexModTime(repo)
+	tracked := make(map[string]bool, len(indexedFiles))
+	for path := range indexedFiles {
+		tracked[path] = true
+	}
+
+	candidates, token, watched := fsmonitorChanges(repo)
+
+	var files []scanFile
+	var err error
+	if watched {
+		files, err = scanCandidates(ctx, repo, candidates, roots, tracked)
+	} else {
+		files, err = scanWorkingTree(ctx, repo, scanOptions{Roots: roots, Tracked: tracked})
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	filters, err := loadWorkingFilters(repo)
+	if err != nil {
+		return nil, false, err
+	}
+	err = hashScanFiles(ctx, filters, files, func(f *scanFile) bool {
+		entry, exists := indexedFiles[f.Rel]
+		return exists && !statMatches(*entry, f.Info, indexTime)
+	}, 0)
+	if err != nil {
+		return nil, false, err
+	}
+
+	refreshed := false
+	state := &fsmonitorState{Token: token, Untracked: []string{}, Dirty: []string{}}
+	for i := range files {
+		entry, exists := indexedFiles[files[i].Rel]
+		if !exists {
+			state.Untracked = append(state.Untracked, files[i].Rel)
+			continue
+		}
+		if settleScanHash(entry, &files[i]) {
+			refreshed = true
+		}
+		if fileChanged(*entry, files[i].Hash, files[i].Info) {
+			state.Dirty = append(state.Dirty, files[i].Rel)
+		}
+	}
+
+	// Only a scan of the whole tree can vouch for every path the next time
+	if token != "" && len(roots) == 0 {
+		if err := saveFSMonitorState(repo, state); err != nil {
+			return nil, false, err
+		}
+	}
+	return files, refreshed, nil
+}
+
+// scanCandidates stats the paths reported by the fsmonitor that lie under
+// roots, scanning directories that appeared in full
+func scanCandidates(ctx context.Context, repo *Repository, candidates, roots []string, tracked map[string]bool) ([]scanFile, error) {
+	rules, err := loadIgnoreRules(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []string
+	for _, root := range roots {
+		rel, err := repoRelative(repo, root)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, rel)
+	}
+	underRoots := func(rel string) bool {
+		if len(prefixes) == 0 {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if prefix == "." || rel == prefix || strings.HasPrefix(rel, prefix+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var files []scanFile
+	seen := make(map[string]bool)
+	add := func(file scanFile) {
+		if !seen[file.Rel] && underRoots(file.Rel) {
+			seen[file.Rel] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, rel := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(repo.WorkingDir, rel)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue // deleted since
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if info.IsDir() {
+			sub, err := scanWorkingTree(ctx, repo, scanOptions{Roots: []string{path}, Tracked: tracked})
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range sub {
+				add(file)
+			}
+			continue
+		}
+		if rules.ignored(rel, false) && !tracked[rel] {
+			continue
+		}
+		add(scanFile{Path: path, Rel: rel, Info: info})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Rel < files[j].Rel })
+	return files, nil
+}
+
+// hashScanFiles hashes the selected files in parallel, as the blobs they
+// would be stored as, stopping at the first error or when ctx is cancelled
+func hashScanFiles(ctx context.Context, filters *workingFilters, files []scanFile, needsHash func(f *scanFile) bool, workers int) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				hash, err := hashWorkingFile(filters, files[i].Rel, files[i].Path)
+				if err != nil {
+					once.Do(func() { firstErr = err; cancel() })
+					continue
+				}
+				files[i].Hash = hash
+			}
+		}()
+	}
+
+feed:
+	for i := range files {
+		if !needsHash(&files[i]) {
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// hasTrackedUnder reports whether any tracked path lies inside a directory
+func hasTrackedUnder(tracked map[string]bool, dir string) bool {
+	prefix := dir + "/"
+	for path := range tracked {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+// internal/scanner_test.go
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanWorkingTree(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	for d := 0; d < 5; d++ {
+		dir := fmt.Sprintf("dir%d", d)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for f := 0; f < 20; f++ {
+			path := filepath.Join(dir, fmt.Sprintf("file%02d.txt", f))
+			if err := ioutil.WriteFile(path, []byte(path), 0644); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, path)
+		}
+	}
+
+	// Hashing in parallel keeps the walk order and the right hashes
+	for _, workers := range []int{1, 4, 32} {
+		files, err := scanWorkingTree(context.Background(), repo, scanOptions{
+			NeedsHash: func(*scanFile) bool { return true },
+			Workers:   workers,
+		})
+		if err != nil {
+			t.Fatalf("scanWorkingTree(%d workers) error = %v", workers, err)
+		}
+		if len(files) != len(want) {
+			t.Fatalf("scanWorkingTree(%d workers) found %d files, want %d", workers, len(files), len(want))
+		}
+		for i, file := range files {
+			if file.Rel != want[i] {
+				t.Fatalf("file %d = %s, want %s", i, file.Rel, want[i])
+			}
+			if file.Hash != CalculateHash(want[i]) {
+				t.Errorf("%s hashed to %s", file.Rel, file.Hash)
+			}
+		}
+	}
+
+	// A cancelled scan stops with the context's error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := scanWorkingTree(ctx, repo, scanOptions{}); err != context.Canceled {
+		t.Errorf("scanWorkingTree(cancelled) error = %v, want %v", err, context.Canceled)
+	}
+	files := make([]scanFile, len(want))
+	for i, path := range want {
+		files[i] = scanFile{Path: path}
+	}
+	if err := hashScanFiles(ctx, &workingFilters{repo: repo}, files, func(*scanFile) bool { return true }, 2); err != context.Canceled {
+		t.Errorf("hashScanFiles(cancelled) error = %v, want %v", err, context.Canceled)
+	}
+
+	// A file that cannot be read fails the scan
+	files = []scanFile{{Path: "missing.txt"}}
+	if err := hashScanFiles(context.Background(), &workingFilters{repo: repo}, files, func(*scanFile) bool { return true }, 2); err == nil {
+		t.Errorf("hashScanFiles() of a missing file succeeded")
+	}
+}
+
+func TestScanRespectsIgnoreRules(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() { commitFile(t, "tracked.log", "one\n", "Track a log") })
+
+	if err := ioutil.WriteFile(IGNORE_FILE, []byte("*.log\nbuild/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("build", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{
+		"tracked.log":   "two\n",
+		"untracked.log": "noise\n",
+		"build/out.bin": "binary\n",
+		"main.go":       "package main\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Ignored untracked files are hidden, tracked ones are still checked
+	output := captureOutput(t, func() {
+		if err := ShowStatus(); err != nil {
+			t.Errorf("ShowStatus() error = %v", err)
+		}
+	})
+	if strings.Contains(output, "untracked.log") || strings.Contains(output, "out.bin") {
+		t.Errorf("ShowStatus() lists ignored files: %q", output)
+	}
+	for _, want := range []string{"modified: tracked.log", "main.go", IGNORE_FILE} {
+		if !strings.Contains(output, want) {
+			t.Errorf("ShowStatus() output missing %q: %q", want, output)
+		}
+	}
+
+	// Adding a directory skips ignored files, naming a file adds it anyway
+	if err := AddFile("."); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("build/out.bin"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := LoadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, entry := range index {
+		paths = append(paths, entry.FilePath)
+	}
+	got := strings.Join(paths, ",")
+	if want := IGNORE_FILE + ",build/out.bin,main.go,tracked.log"; got != want {
+		t.Errorf("index paths = %s, want %s", got, want)
+	}
+}
+
+func TestCommitAllStagesOnlyChangedFiles(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		commitFile(t, "a.txt", "a\n", "Add a")
+		commitFile(t, "b.txt", "b\n", "Add b")
+	})
+	if err := ioutil.WriteFile("b.txt", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("new.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	captureOutput(t, func() {
+		if err := CommitChanges("Change b", CommitOptions{All: true}); err != nil {
+			t.Fatalf("CommitChanges(-a) error = %v", err)
+		}
+	})
+
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := GetCurrentHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := readCommit(repo, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readTree(repo, commit.TreeHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].FilePath != "b.txt" || entries[0].Hash != CalculateHash("changed\n") {
+		t.Errorf("commit -a tree = %+v, want only the new b.txt", entries)
+	}
+
+	// Nothing changed means nothing to commit
+	captureOutput(t, func() {
+		if err := CommitChanges("Again", CommitOptions{All: true}); err == nil || !strings.Contains(err.Error(), "nothing to commit") {
+			t.Errorf("CommitChanges(-a) with no changes error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,390 @@
+// internal/serve.go
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Authenticator decides whether a request may use a service, either
+// serviceUploadPack to fetch or serviceReceivePack to push. user and
+// password come from HTTP basic authentication and are empty for anonymous
+// requests.
+type Authenticator func(user, password, service string) bool
+
+// ServeOptions configures Serve
+type ServeOptions struct {
+	Addr string // host:port to listen on
+
+	// Authenticate decides who may fetch and push. When nil the http-auth
+	// hook decides, and without one anybody may fetch and nobody may push.
+	Authenticate Authenticator
+
+	// MaxPushBytes is the largest push accepted, large files included. When
+	// 0, defaultMaxPushBytes.
+	MaxPushBytes int64
+}
+
+// Serve makes the repository in dir, or the current one, available to
+// clone, fetch from and push to over HTTP until the process is stopped
+func Serve(dir string, opts ServeOptions) error {
+	var repo *Repository
+	var err error
+	if dir == "" {
+		repo, err = FindGitterRepo()
+	} else {
+		repo, err = openRemote(nil, dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Serving %s on http://%s/\n", repo.GitDir, opts.Addr)
+	return http.ListenAndServe(opts.Addr, newServer(repo, opts))
+}
+
+// server answers the smart HTTP protocol for one repository
+type server struct {
+	repo    *Repository
+	auth    Authenticator
+	maxPush int64
+}
+
+// newServer returns the handler behind Serve
+func newServer(repo *Repository, opts ServeOptions) http.Handler {
+	auth := opts.Authenticate
+	if auth == nil {
+		auth = hookAuthenticator(repo)
+	}
+	maxPush := opts.MaxPushBytes
+	if maxPush <= 0 {
+		maxPush = defaultMaxPushBytes
+	}
+	return &server{repo: repo, auth: auth, maxPush: maxPush}
+}
+
+// hookAuthenticator asks the repository's http-auth hook, which gets the
+// user and service as arguments and the password on standard input
+func hookAuthenticator(repo *Repository) Authenticator {
+	return func(user, password, service string) bool {
+		if !hookInstalled(repo, hookHTTPAuth) {
+			return service == serviceUploadPack
+		}
+		return runHook(repo, hookHTTPAuth, password+"\n", user, service) == nil
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/info/refs":
+		service := r.URL.Query().Get("service")
+		if service == "" {
+			service = serviceUploadPack
+		}
+		if service != serviceUploadPack && service != serviceReceivePack {
+			http.Error(w, "unknown service "+service, http.StatusForbidden)
+			return
+		}
+		if s.authorize(w, r, service) {
+			s.advertise(w)
+		}
+	case r.Method == "POST" && r.URL.Path == "/"+serviceUploadPack:
+		if s.authorize(w, r, serviceUploadPack) {
+			s.uploadPack(w, r)
+		}
+	case r.Method == "POST" && r.URL.Path == "/"+serviceReceivePack:
+		if s.authorize(w, r, serviceReceivePack) {
+			s.receivePack(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize checks a request's credentials, answering it when they do not
+// allow the service
+func (s *server) authorize(w http.ResponseWriter, r *http.Request, service string) bool {
+	user, password, _ := r.BasicAuth()
+	if s.auth(user, password, service) {
+		return true
+	}
+	if user == "" && password == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="gitter"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+	} else {
+		http.Error(w, "access denied", http.StatusForbidden)
+	}
+	return false
+}
+
+// advertise sends the branches and HEAD
+func (s *server) advertise(w http.ResponseWriter) {
+	refs, err := advertiseRefs(s.repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refs)
+}
+
+// uploadPack sends a pack with what the client lacks to have the commits it
+// wants
+func (s *server) uploadPack(w http.ResponseWriter, r *http.Request) {
+	var request uploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkWants(s.repo, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response, pack, err := uploadPack(s.repo, request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", packContentType)
+	writeUpload(w, s.repo, response, pack)
+}
+
+// receivePack stores a pushed pack and moves the ref it comes with
+func (s *server) receivePack(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, s.maxPush)
+	request, pack, err := readReceive(s.repo, bufio.NewReader(body))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("push is larger than %d bytes", s.maxPush), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result receiveResponse
+	if err := receivePush(s.repo, request, pack); err != nil {
+		rejection, ok := err.(*refRejection)
+		if !ok {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Rejected = rejection.reason
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// checkWants makes sure a client only asks for the commits of advertised
+// refs, and blobs they lead to, so objects nothing points to any more stay
+// private
+func checkWants(repo *Repository, request uploadRequest) error {
+	refs, err := advertiseRefs(repo)
+	if err != nil {
+		return err
+	}
+	allowed := map[string]bool{refs.Head: true}
+	for _, hash := range refs.Branches {
+		allowed[hash] = true
+	}
+	for _, want := range request.Wants {
+		if !allowed[want] {
+			return fmt.Errorf("not our ref %s", want)
+		}
+	}
+	if request.Depth < 0 {
+		return fmt.Errorf("invalid depth %d", request.Depth)
+	}
+	if _, err := parseBlobFilter(request.Filter); err != nil {
+		return err
+	}
+	if _, err := lfsPointersFor(repo, request.LFS); err != nil {
+		return err
+	}
+	if len(request.Blobs) == 0 {
+		return nil
+	}
+
+	tips := make([]string, 0, len(allowed))
+	for hash := range allowed {
+		tips = append(tips, hash)
+	}
+	reachable, err := reachableBlobs(repo, tips)
+	if err != nil {
+		return err
+	}
+	for _, hash := range request.Blobs {
+		if !reachable[hash] {
+			return fmt.Errorf("not our blob %s", hash)
+		}
+	}
+	return nil
+}
+
+// reachableBlobs returns the blobs in the history of the given commits
+func reachableBlobs(repo *Repository, tips []string) (map[string]bool, error) {
+	commits, err := knownCommits(repo, tips)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make(map[string]bool)
+	for hash := range commits {
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, tree := range []string{commit.TreeHash, commit.Shallow} {
+			if tree == "" {
+				continue
+			}
+			entries, err := readTree(repo, tree)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				blobs[entry.Hash] = true
+			}
+		}
+	}
+	return blobs, nil
+}
+
+// uploadPack packs what a client lacks to have the commits it wants, given
+// the commits it has. History deeper than request.Depth is left out, with the
+// snapshot of each commit cut off from its parent sent instead, and so are
+// the blobs request.Filter names. The blobs request.Blobs names are sent as
+// they are, and so are the large files request.LFS names, ahead of the pack.
+func uploadPack(repo *Repository, request uploadRequest) (uploadResponse, []byte, error) {
+	var response uploadResponse
+	limit, err := parseBlobFilter(request.Filter)
+	if err != nil {
+		return response, nil, err
+	}
+	if response.LFS, err = lfsPointersFor(repo, request.LFS); err != nil {
+		return response, nil, err
+	}
+	known, err := knownCommitsUntil(repo, request.Haves, request.Cut)
+	if err != nil {
+		return response, nil, err
+	}
+
+	var hashes []string
+	seen := make(map[string]bool)
+	add := func(hash string) {
+		if !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	for _, hash := range request.Blobs {
+		add(hash)
+	}
+
+	// Snapshot trees are made up for the client and not stored here
+	made := make(map[string][]byte)
+	entriesOf := func(tree string) ([]IndexEntry, error) {
+		if data, ok := made[tree]; ok {
+			var entries []IndexEntry
+			err := json.Unmarshal(data, &entries)
+			return entries, err
+		}
+		return readTree(repo, tree)
+	}
+
+	for _, want := range request.Wants {
+		// Walk back from the want, oldest commit last
+		var commits []Commit
+		for hash := want; hash != "" && !known[hash]; {
+			commit, err := readCommit(repo, hash)
+			if err != nil {
+				return response, nil, fmt.Errorf("could not read commit %s: %v", hash[:7], err)
+			}
+			known[hash] = true
+			if request.Depth > 0 && len(commits) == request.Depth-1 && commit.Parent != "" && !known[commit.Parent] {
+				tree, data, err := snapshotTree(repo, hash)
+				if err != nil {
+					return response, nil, err
+				}
+				made[tree] = data
+				commit.Parent, commit.Shallow = "", tree
+			}
+			commits = append(commits, commit)
+			hash = commit.Parent
+		}
+
+		// Each commit goes after its trees and blobs
+		for i := len(commits) - 1; i >= 0; i-- {
+			commit := commits[i]
+			trees := []string{commit.TreeHash}
+			if commit.Shallow != "" {
+				if response.Shallow == nil {
+					response.Shallow = make(map[string]string)
+				}
+				response.Shallow[commit.Hash] = commit.Shallow
+				trees = append(trees, commit.Shallow)
+			}
+			for _, tree := range trees {
+				entries, err := entriesOf(tree)
+				if err != nil {
+					return response, nil, err
+				}
+				for _, entry := range entries {
+					keep, err := keepBlob(repo, limit, entry.Hash)
+					if err != nil {
+						return response, nil, err
+					}
+					if keep {
+						add(entry.Hash)
+					}
+				}
+				add(tree)
+			}
+			add(commit.Hash)
+		}
+	}
+
+	pack, err := packObjectsWith(repo, hashes, made)
+	return response, pack, err
+}
+
+// receivePush unpacks a push and updates the ref once the new history is
+// complete. A *refRejection says why the update was refused.
+func receivePush(repo *Repository, request receiveRequest, pack []byte) error {
+	if !isHash(request.New) || (request.Old != "" && !isHash(request.Old)) {
+		return &refRejection{reason: "invalid ref update"}
+	}
+	if _, err := unpackObjects(repo, pack); err != nil {
+		return &refRejection{reason: "unpacker error: " + err.Error()}
+	}
+	if err := checkConnected(repo, request.New, request.Old); err != nil {
+		return &refRejection{reason: "missing necessary objects"}
+	}
+	return receiveRef(repo, request.Ref, request.Old, request.New)
+}
+
+// checkConnected makes sure every commit from tip back to the root, or to
+// stop whose history is known to be complete, is stored with its tree and
+// blobs, so a ref moved to tip is usable
+func checkConnected(repo *Repository, tip, stop string) error {
+	for hash := tip; hash != "" && hash != stop; {
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return fmt.Errorf("commit %s: %v", hash, err)
+		}
+		entries, err := readTree(repo, commit.TreeHash)
+		if err != nil {
+			return fmt.Errorf("tree %s: %v", commit.TreeHash, err)
+		}
+		for _, entry := range entries {
+			if !objectExists(repo, entry.Hash) {
+				return fmt.Errorf("blob %s is missing", entry.Hash)
+			}
+		}
+		hash = commit.Parent
+	}
+	return nil
+}
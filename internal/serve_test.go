@@ -0,0 +1,184 @@
+// internal/serve_test.go
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestServeAuthentication(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+
+	// Anybody may fetch, only alice may push
+	srv := serveHub(t, top, func(user, password, service string) bool {
+		return service == serviceUploadPack || (user == "alice" && password == "secret")
+	})
+	defer srv.Close()
+	u, _ := neturl.Parse(srv.URL)
+
+	captureOutput(t, func() { commitFile(t, "a.txt", "a2\n", "Change a.txt") })
+	tests := []struct {
+		name    string
+		url     string
+		env     [2]string
+		wantErr string
+	}{
+		{name: "anonymous", url: srv.URL, wantErr: "authentication required for '" + srv.URL + "'"},
+		{name: "wrong password", url: "http://alice:guess@" + u.Host, wantErr: "access to '" + srv.URL + "' denied"},
+		{name: "credentials from the environment", url: srv.URL, env: [2]string{"alice", "secret"}},
+		{name: "credentials in the URL", url: "http://alice:secret@" + u.Host},
+	}
+	for _, tt := range tests {
+		t.Setenv(envHTTPUser, tt.env[0])
+		t.Setenv(envHTTPPassword, tt.env[1])
+		var err error
+		output := captureOutput(t, func() { err = Push(tt.url, "main:"+strings.ReplaceAll(tt.name, " ", "-"), PushOptions{}) })
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: Push() error = %v", tt.name, err)
+			}
+			if strings.Contains(output, "secret") {
+				t.Errorf("%s: Push() printed the password: %q", tt.name, output)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Push() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// Fetching needs no credentials
+	t.Setenv(envHTTPUser, "")
+	t.Setenv(envHTTPPassword, "")
+	captureOutput(t, func() {
+		if err := Clone(srv.URL, filepath.Join(top, "bob"), CloneOptions{}); err != nil {
+			t.Errorf("Clone(anonymous) error = %v", err)
+		}
+	})
+}
+
+func TestServeAuthHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are shell scripts")
+	}
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	hub := &Repository{GitDir: filepath.Join(top, "hub.gitter")}
+	auth := hookAuthenticator(hub)
+
+	// Without the hook fetching is open and pushing is closed
+	if !auth("", "", serviceUploadPack) || auth("alice", "secret", serviceReceivePack) {
+		t.Errorf("without an http-auth hook fetch = %v, push = %v, want true, false",
+			auth("", "", serviceUploadPack), auth("alice", "secret", serviceReceivePack))
+	}
+
+	script := "#!/bin/sh\nread password\n[ \"$1\" = alice ] && [ \"$password\" = secret ] || [ \"$2\" = " + serviceUploadPack + " ]\n"
+	if err := ioutil.WriteFile(filepath.Join(hub.GitDir, HOOKS_DIR, hookHTTPAuth), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user, password, service string
+		want                    bool
+	}{
+		{"", "", serviceUploadPack, true},
+		{"", "", serviceReceivePack, false},
+		{"alice", "guess", serviceReceivePack, false},
+		{"alice", "secret", serviceReceivePack, true},
+		{"bob", "secret", serviceReceivePack, false},
+	}
+	for _, tt := range tests {
+		if got := auth(tt.user, tt.password, tt.service); got != tt.want {
+			t.Errorf("http-auth(%q, %q, %s) = %v, want %v", tt.user, tt.password, tt.service, got, tt.want)
+		}
+	}
+}
+
+func TestServeProtocol(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	srv := serveHub(t, top, nil)
+	defer srv.Close()
+	main := hubBranch(t, top, "main")
+
+	// The advertisement is HEAD and the branches
+	resp, err := http.Get(srv.URL + "/info/refs?service=" + serviceUploadPack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs remoteRefs
+	json.NewDecoder(resp.Body).Decode(&refs)
+	resp.Body.Close()
+	if refs.headBranch() != "main" || refs.Branches["main"] != main {
+		t.Errorf("advertised %+v", refs)
+	}
+
+	for path, want := range map[string]int{
+		"/info/refs?service=gitter-shell": http.StatusForbidden,
+		"/objects/" + main:                http.StatusNotFound,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+
+	// Ref updates the server refuses
+	repo, _ := FindGitterRepo()
+	var head string
+	captureOutput(t, func() { head = commitFile(t, "b.txt", "b\n", "Add b.txt") })
+	hashes, err := missingObjects(repo, func(hash string) bool { return hash == main }, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete, err := packObjects(repo, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, err := packObjects(repo, hashes[len(hashes)-1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		request receiveRequest
+		pack    []byte
+		want    string
+	}{
+		{"missing objects", receiveRequest{"refs/heads/main", main, head, nil}, partial, "missing necessary objects"},
+		{"escaping refs/heads", receiveRequest{"refs/heads/../../HEAD", main, head, nil}, complete, "invalid branch name"},
+		{"not a branch", receiveRequest{"refs/tags/v1", "", head, nil}, complete, "invalid branch name"},
+		{"stale old value", receiveRequest{"refs/heads/main", "", head, nil}, complete, "failed to update ref"},
+		{"damaged pack", receiveRequest{"refs/heads/main", main, head, nil}, []byte("GPCK"), "unpacker error: not a pack"},
+		{"accepted", receiveRequest{"refs/heads/main", main, head, nil}, complete, ""},
+	}
+	for _, tt := range tests {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(tt.request)
+		body.Write(tt.pack)
+		resp, err := http.Post(srv.URL+"/"+serviceReceivePack, packContentType, &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result receiveResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if result.Rejected != tt.want {
+			t.Errorf("%s: rejected = %q, want %q", tt.name, result.Rejected, tt.want)
+		}
+	}
+	if got := hubBranch(t, top, "main"); got != head {
+		t.Errorf("hub main = %s, want %s", got, head)
+	}
+}
@@ -0,0 +1,114 @@
+// internal/shallow.go
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SHALLOW_FILE lists the commits of a shallow clone whose parents were left
+// out, one "<commit> <tree>" line each. Trees only record what a commit
+// changed, so the tree named is a full snapshot of the files at that commit,
+// standing in for the missing history.
+const SHALLOW_FILE = "shallow"
+
+// readShallow returns the boundary commits of a shallow repository with
+// their snapshot trees; it is empty for a complete one
+func readShallow(repo *Repository) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(repo.GitDir, SHALLOW_FILE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	shallow := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !isHash(fields[0]) || !isHash(fields[1]) {
+			return nil, fmt.Errorf("%s is corrupt: %q", SHALLOW_FILE, line)
+		}
+		shallow[fields[0]] = fields[1]
+	}
+	return shallow, nil
+}
+
+// isShallowRepository reports whether some of a repository's history was
+// left out when it was cloned
+func isShallowRepository(repo *Repository) bool {
+	shallow, _ := readShallow(repo)
+	return len(shallow) > 0
+}
+
+// addShallow records more boundary commits
+func addShallow(repo *Repository, boundaries map[string]string) error {
+	if len(boundaries) == 0 {
+		return nil
+	}
+	shallow, err := readShallow(repo)
+	if err != nil {
+		return err
+	}
+	if shallow == nil {
+		shallow = make(map[string]string)
+	}
+	for commit, tree := range boundaries {
+		shallow[commit] = tree
+	}
+
+	commits := make([]string, 0, len(shallow))
+	for commit := range shallow {
+		commits = append(commits, commit)
+	}
+	sort.Strings(commits)
+	var b strings.Builder
+	for _, commit := range commits {
+		fmt.Fprintf(&b, "%s %s\n", commit, shallow[commit])
+	}
+	return writeFileAtomic(filepath.Join(repo.GitDir, SHALLOW_FILE), []byte(b.String()), 0644)
+}
+
+// snapshotTree encodes the full snapshot at a commit as a tree object, to be
+// sent in place of the history before it. It returns the tree's hash and
+// contents.
+func snapshotTree(repo *Repository, commitHash string) (string, []byte, error) {
+	snapshot, err := loadSnapshot(repo, commitHash)
+	if err != nil {
+		return "", nil, err
+	}
+	paths := make([]string, 0, len(snapshot))
+	for path := range snapshot {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// Written like the trees of createCommit, so equal snapshots share a hash
+	entries := make([]IndexEntry, 0, len(paths))
+	for _, path := range paths {
+		entry := snapshot[path]
+		entries = append(entries, IndexEntry{FilePath: path, Hash: entry.Hash, Modified: true, FileMode: entry.FileMode})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", nil, err
+	}
+	return CalculateHash(string(data)), data, nil
+}
+
+// replayTree returns the tree to apply for a commit when rebuilding files
+// from history: its own, or at the edge of a shallow clone the snapshot
+// that stands in for everything before it
+func replayTree(commit Commit) string {
+	if commit.Shallow != "" {
+		return commit.Shallow
+	}
+	return commit.TreeHash
+}
@@ -0,0 +1,187 @@
+// internal/shallow_test.go
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pushHistory adds b.txt and then changes a.txt in alice and pushes both to
+// the hub, so the hub's main has three commits; it returns the new tip
+func pushHistory(t *testing.T) string {
+	t.Helper()
+	var tip string
+	captureOutput(t, func() {
+		commitFile(t, "b.txt", "b\n", "Add b.txt")
+		tip = commitFile(t, "a.txt", "a2\n", "Change a.txt")
+		if err := Push("", "", PushOptions{}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	})
+	return tip
+}
+
+func TestShallowClone(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	tip := pushHistory(t)
+	shallow := filepath.Join(top, "shallow")
+	captureOutput(t, func() {
+		if err := Clone(filepath.Join(top, "hub.gitter"), shallow, CloneOptions{Depth: 1}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+
+	inDir(t, shallow, func() {
+		repo, _ := FindGitterRepo()
+		cut, err := readShallow(repo)
+		if err != nil || len(cut) != 1 || cut[tip] == "" {
+			t.Fatalf("shallow = %v, %v, want %s cut off", cut, err, tip[:7])
+		}
+
+		// The files of commits left out come from the snapshot
+		if got := readWorkingFile(t, "a.txt"); got != "a2\n" {
+			t.Errorf("a.txt = %q", got)
+		}
+		if got := readWorkingFile(t, "b.txt"); got != "b\n" {
+			t.Errorf("b.txt = %q", got)
+		}
+		if output := captureOutput(t, func() { ShowStatus() }); !strings.Contains(output, "nothing to commit") {
+			t.Errorf("status of a shallow clone = %q", output)
+		}
+
+		// History ends at the cut
+		output := captureOutput(t, func() { ShowLog() })
+		if strings.Count(output, "commit ") != 1 || !strings.Contains(output, "commit "+tip+" (grafted)") {
+			t.Errorf("log = %q", output)
+		}
+		lines, err := blameFile(repo, tip, "b.txt")
+		if err != nil || len(lines) != 1 || lines[0].Commit != tip {
+			t.Errorf("blame b.txt = %+v, %v, want it on %s", lines, err, tip[:7])
+		}
+		if err := CommitChanges("Reword", CommitOptions{Amend: true}); err == nil || !strings.Contains(err.Error(), "shallow clone") {
+			t.Errorf("amending the cut commit error = %v", err)
+		}
+
+		// Work on top of it is shared as usual, and housekeeping keeps the snapshot
+		var next string
+		captureOutput(t, func() {
+			next = commitFile(t, "c.txt", "c\n", "Add c.txt")
+			if err := Push("", "", PushOptions{}); err != nil {
+				t.Fatalf("Push() from a shallow clone error = %v", err)
+			}
+			if err := GarbageCollect(PruneOptions{}); err != nil {
+				t.Fatalf("GarbageCollect() error = %v", err)
+			}
+		})
+		if got := hubBranch(t, top, "main"); got != next {
+			t.Errorf("hub main = %s, want %s", got, next)
+		}
+		result, err := fsckRepository(repo)
+		if err != nil || len(result.Problems) > 0 || len(result.Dangling) > 0 {
+			t.Errorf("fsck = %+v, %v", result, err)
+		}
+		snapshot, err := loadSnapshot(repo, next)
+		if err != nil || len(snapshot) != 3 {
+			t.Errorf("snapshot after gc = %v, %v", snapshot, err)
+		}
+
+		// A repository without the history cannot take a push from here
+		if err := InitBareRepository(filepath.Join(top, "empty.gitter")); err != nil {
+			t.Fatal(err)
+		}
+		if err := RemoteAdd("empty", filepath.Join(top, "empty.gitter")); err != nil {
+			t.Fatal(err)
+		}
+		output = captureOutput(t, func() {
+			if err := Push("empty", "main", PushOptions{}); err == nil {
+				t.Errorf("Push() of a shallow history succeeded")
+			}
+		})
+		if !strings.Contains(output, "missing necessary objects") {
+			t.Errorf("Push() output = %q", output)
+		}
+	})
+}
+
+func TestShallowFetch(t *testing.T) {
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	first := hubBranch(t, top, "main")
+	tip := pushHistory(t)
+	srv := serveHub(t, top, nil)
+	defer srv.Close()
+
+	// Over HTTP too, two commits deep
+	shallow := filepath.Join(top, "shallow")
+	captureOutput(t, func() {
+		if err := Clone(srv.URL+"/", shallow, CloneOptions{Depth: 2}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+	inDir(t, shallow, func() {
+		repo, _ := FindGitterRepo()
+		if objectExists(repo, first) {
+			t.Errorf("the commit below the depth was copied")
+		}
+		output := captureOutput(t, func() { ShowLog() })
+		if strings.Count(output, "commit ") != 2 || !strings.Contains(output, "(grafted)") {
+			t.Errorf("log = %q", output)
+		}
+	})
+
+	// Later fetches bring new commits on top of the cut
+	inDir(t, filepath.Join(top, "alice"), func() {
+		captureOutput(t, func() {
+			commitFile(t, "d.txt", "d\n", "Add d.txt")
+			if err := Push("", "", PushOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+	inDir(t, shallow, func() {
+		captureOutput(t, func() {
+			if err := Pull("", "", PullOptions{}); err != nil {
+				t.Fatalf("Pull() error = %v", err)
+			}
+		})
+		repo, _ := FindGitterRepo()
+		head, _ := GetCurrentHead()
+		commit, _ := readCommit(repo, head)
+		if commit.Parent != tip {
+			t.Errorf("pulled commit has parent %s, want %s", commit.Parent, tip)
+		}
+		if got := readWorkingFile(t, "d.txt"); got != "d\n" {
+			t.Errorf("d.txt = %q after pull", got)
+		}
+		if cut, _ := readShallow(repo); len(cut) != 1 {
+			t.Errorf("shallow after a full fetch = %v", cut)
+		}
+	})
+
+	// A clone of the shallow clone is shallow where it is
+	deeper := filepath.Join(top, "copy")
+	captureOutput(t, func() {
+		if err := Clone(shallow, deeper, CloneOptions{}); err != nil {
+			t.Fatalf("Clone() of a shallow clone error = %v", err)
+		}
+	})
+	inDir(t, deeper, func() {
+		repo, _ := FindGitterRepo()
+		if cut, _ := readShallow(repo); len(cut) != 1 {
+			t.Errorf("shallow of the copy = %v", cut)
+		}
+		if got := readWorkingFile(t, "a.txt"); got != "a2\n" {
+			t.Errorf("a.txt = %q in the copy", got)
+		}
+	})
+
+	if err := os.Chdir(top); err != nil {
+		t.Fatal(err)
+	}
+	if err := Clone(srv.URL+"/", "bad", CloneOptions{Depth: -1}); err == nil {
+		t.Errorf("Clone() with a negative depth succeeded")
+	}
+}
@@ -0,0 +1,260 @@
+// internal/show.go
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// resolveObject turns an object name into a hash. It accepts full or
+// abbreviated object hashes, revisions and <rev>:<path> for the blob of a
+// file at a commit.
+func resolveObject(repo *Repository, name string) (string, error) {
+	if rev, path, ok := strings.Cut(name, ":"); ok {
+		if rev == "" {
+			rev = HEAD_FILE
+		}
+		commitHash, err := resolveRevision(repo, rev)
+		if err != nil {
+			return "", err
+		}
+		snapshot, err := loadSnapshot(repo, commitHash)
+		if err != nil {
+			return "", err
+		}
+		rel, err := treePath(repo, path)
+		if err != nil {
+			return "", err
+		}
+		entry, ok := snapshot[rel]
+		if !ok {
+			return "", fmt.Errorf("path '%s' does not exist in '%s'", path, rev)
+		}
+		return entry.Hash, nil
+	}
+
+	if objectExists(repo, name) {
+		return name, nil
+	}
+	if hash, err := resolveRevision(repo, name); err == nil {
+		return hash, nil
+	}
+
+	// Fall back to an abbreviated hash of any object type
+	if len(name) >= 4 && strings.Trim(name, "0123456789abcdef") == "" {
+		hashes, err := listObjects(repo)
+		if err != nil {
+			return "", err
+		}
+		var matches []string
+		for _, hash := range hashes {
+			if strings.HasPrefix(hash, name) {
+				matches = append(matches, hash)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+		if len(matches) > 1 {
+			return "", fmt.Errorf("short object id '%s' is ambiguous", name)
+		}
+	}
+
+	return "", fmt.Errorf("not a valid object name '%s'", name)
+}
+
+// CatFile prints the type (-t), size (-s) or pretty-printed contents (-p)
+// of an object
+func CatFile(mode, name string) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	hash, err := resolveObject(repo, name)
+	if err != nil {
+		return err
+	}
+	data, err := readObject(repo, hash)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case "t":
+		fmt.Println(objectType(data))
+	case "s":
+		fmt.Println(len(data))
+	case "p":
+		return printObject(repo, hash, data)
+	default:
+		return fmt.Errorf("unknown cat-file mode '-%s'", mode)
+	}
+	return nil
+}
+
+// printObject pretty-prints an object according to its type
+func printObject(repo *Repository, hash string, data []byte) error {
+	switch objectType(data) {
+	case ObjectCommit:
+		commit, err := readCommit(repo, hash)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("tree %s\n", commit.TreeHash)
+		if commit.Parent != "" {
+			fmt.Printf("parent %s\n", commit.Parent)
+		}
+		fmt.Printf("author %s\n", commit.Author)
+		fmt.Printf("date %s\n", commit.Date.Format("Mon Jan 2 15:04:05 2006 -0700"))
+		fmt.Printf("\n%s\n", commit.Message)
+	case ObjectTree:
+		entries, err := readTree(repo, hash)
+		if err != nil {
+			return err
+		}
+		printTreeEntries(entries)
+	default:
+		os.Stdout.Write(data)
+	}
+	return nil
+}
+
+// printTreeEntries lists tree entries sorted by path
+func printTreeEntries(entries []IndexEntry) {
+	sorted := append([]IndexEntry{}, entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].FilePath < sorted[j].FilePath })
+	for _, entry := range sorted {
+		fmt.Printf("%s %s\t%s\n", ObjectBlob, entry.Hash, entry.FilePath)
+	}
+}
+
+// Show displays an object: a commit with its patch against the parent, the
+// contents of a blob or the listing of a tree
+func Show(name string) error {
+	repo, err := FindGitterRepo()
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = HEAD_FILE
+	}
+	hash, err := resolveObject(repo, name)
+	if err != nil {
+		return err
+	}
+	data, err := readObject(repo, hash)
+	if err != nil {
+		return err
+	}
+
+	switch objectType(data) {
+	case ObjectCommit:
+		return showCommit(repo, hash)
+	case ObjectTree:
+		entries, err := readTree(repo, hash)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("tree %s\n\n", name)
+		printTreeEntries(entries)
+	default:
+		os.Stdout.Write(data)
+	}
+	return nil
+}
+
+// showCommit prints a commit header followed by the changes it introduced
+func showCommit(repo *Repository, hash string) error {
+	commit, err := readCommit(repo, hash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("commit %s\n", commit.Hash)
+	fmt.Printf("Author: %s\n", commit.Author)
+	fmt.Printf("Date: %s\n", commit.Date.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	fmt.Printf("\n%s\n\n", indentMessage(commit.Message))
+
+	filters, err := loadWorkingFilters(repo)
+	if err != nil {
+		return err
+	}
+	parentSnapshot := map[string]IndexEntry{}
+	if commit.Parent != "" {
+		if parentSnapshot, err = loadSnapshot(repo, commit.Parent); err != nil {
+			return err
+		}
+	}
+
+	entries, err := readTree(repo, commit.TreeHash)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].FilePath < entries[j].FilePath })
+
+	for _, entry := range entries {
+		if parent, ok := parentSnapshot[entry.FilePath]; ok {
+			printModeChange(entry.FilePath, entryMode(parent), entryMode(entry))
+		}
+		if err := printBlobDiff(repo, filters, entry.FilePath, parentSnapshot[entry.FilePath].Hash, entry.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indentMessage indents every line of a commit message by four spaces
+func indentMessage(message string) string {
+	return "    " + strings.ReplaceAll(message, "\n", "\n    ")
+}
+
+// printBlobDiff prints a unified diff between two versions of a file. An
+// empty hash stands for a file that does not exist on that side.
+func printBlobDiff(repo *Repository, filters *workingFilters, path, oldHash, newHash string) error {
+	if oldHash == newHash {
+		return nil
+	}
+
+	contents := make([]string, 2)
+	for i, hash := range []string{oldHash, newHash} {
+		if hash == "" {
+			continue
+		}
+		data, err := readObject(repo, hash)
+		if err != nil {
+			return err
+		}
+		contents[i] = string(data)
+	}
+
+	fromFile, toFile := "a/"+path, "b/"+path
+	if oldHash == "" {
+		fromFile = "/dev/null"
+	}
+	if newHash == "" {
+		toFile = "/dev/null"
+	}
+	if binaryDiff(filters, path, []byte(contents[0]), []byte(contents[1])) {
+		fmt.Printf("Binary files %s and %s differ\n", fromFile, toFile)
+		return nil
+	}
+
+	result, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        terminateLines(splitLines(contents[0])),
+		B:        terminateLines(splitLines(contents[1])),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  2,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Print(result)
+	return nil
+}
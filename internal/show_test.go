@@ -0,0 +1,162 @@
+// internal/show_test.go
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShow(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	first := commitFile(t, "a.txt", "one\ntwo\nthree\n", "First commit")
+	commitFile(t, "b.txt", "bee\n", "Add b")
+	second := commitFile(t, "a.txt", "one\nTWO\nthree\n", "Change a")
+
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatalf("FindGitterRepo() error = %v", err)
+	}
+	commit, err := readCommit(repo, second)
+	if err != nil {
+		t.Fatalf("readCommit() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		object     string
+		wantOutput []string
+		notOutput  []string
+		wantErr    bool
+	}{
+		{
+			name:   "HEAD commit",
+			object: "",
+			wantOutput: []string{
+				"commit " + second,
+				"Author: user",
+				"    Change a",
+				"--- a/a.txt",
+				"+++ b/a.txt",
+				"-two",
+				"+TWO",
+			},
+			notOutput: []string{"b.txt"},
+		},
+		{
+			name:       "Root commit",
+			object:     first[:7],
+			wantOutput: []string{"commit " + first, "--- /dev/null", "+++ b/a.txt", "+one"},
+		},
+		{
+			name:       "Parent revision",
+			object:     "HEAD~1",
+			wantOutput: []string{"    Add b", "+++ b/b.txt", "+bee"},
+			notOutput:  []string{"a.txt"},
+		},
+		{
+			name:       "Blob at revision",
+			object:     "HEAD~2:a.txt",
+			wantOutput: []string{"one\ntwo\nthree\n"},
+			notOutput:  []string{"commit"},
+		},
+		{
+			name:       "Tree",
+			object:     commit.TreeHash,
+			wantOutput: []string{"tree " + commit.TreeHash, "blob ", "\ta.txt"},
+		},
+		{
+			name:    "Unknown object",
+			object:  "nope",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown path",
+			object:  "HEAD:missing.txt",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			output := captureOutput(t, func() {
+				err = Show(tt.object)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Show(%q) error = %v, wantErr %v", tt.object, err, tt.wantErr)
+			}
+			for _, expected := range tt.wantOutput {
+				if !strings.Contains(output, expected) {
+					t.Errorf("Show(%q) output missing %q\nGot: %s", tt.object, expected, output)
+				}
+			}
+			for _, unexpected := range tt.notOutput {
+				if strings.Contains(output, unexpected) {
+					t.Errorf("Show(%q) output contains %q\nGot: %s", tt.object, unexpected, output)
+				}
+			}
+		})
+	}
+}
+
+func TestCatFile(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	first := commitFile(t, "a.txt", "hello\n", "First commit")
+	second := commitFile(t, "a.txt", "hello world\n", "Second commit")
+
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatalf("FindGitterRepo() error = %v", err)
+	}
+	commit, err := readCommit(repo, second)
+	if err != nil {
+		t.Fatalf("readCommit() error = %v", err)
+	}
+	blob := CalculateHash("hello world\n")
+
+	tests := []struct {
+		mode   string
+		object string
+		want   string
+	}{
+		{mode: "t", object: second, want: "commit\n"},
+		{mode: "t", object: commit.TreeHash, want: "tree\n"},
+		{mode: "t", object: blob, want: "blob\n"},
+		{mode: "t", object: "HEAD", want: "commit\n"},
+		{mode: "t", object: blob[:6], want: "blob\n"},
+		{mode: "s", object: blob, want: "12\n"},
+		{mode: "p", object: blob, want: "hello world\n"},
+		{mode: "p", object: "HEAD~1:a.txt", want: "hello\n"},
+		{mode: "p", object: commit.TreeHash, want: "blob " + blob + "\ta.txt\n"},
+		{mode: "p", object: "HEAD", want: "tree " + commit.TreeHash + "\nparent " + first + "\nauthor user\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.object, func(t *testing.T) {
+			output := captureOutput(t, func() {
+				if err := CatFile(tt.mode, tt.object); err != nil {
+					t.Errorf("CatFile(%s, %s) error = %v", tt.mode, tt.object, err)
+				}
+			})
+			if !strings.HasPrefix(output, tt.want) {
+				t.Errorf("CatFile(%s, %s) = %q, want prefix %q", tt.mode, tt.object, output, tt.want)
+			}
+		})
+	}
+
+	if err := CatFile("t", "0000000000"); err == nil {
+		t.Errorf("CatFile() on a missing object succeeded")
+	}
+}
@@ -0,0 +1,338 @@
+// internal/ssh.go
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Over ssh the other end runs `gitter upload-pack <dir>` or `gitter
+// receive-pack <dir>`, which speak the HTTP transport's messages on standard
+// input and output:
+//
+//	server: the refs as a JSON line
+//	client: an uploadRequest line, or a receiveRequest line, the large
+//	        files it lists and a pack, then closes its side; closing
+//	        straight away only lists the refs
+//	server: an uploadResponse line, the large files it lists and a pack,
+//	        or a receiveResponse line
+//
+// Errors go to standard error with a non-zero exit.
+
+// envSSH names the command used to reach ssh remotes, "ssh" by default. It
+// is run by the shell with the options, the host and the remote command
+// appended, so it may carry options of its own.
+const envSSH = "GITTER_SSH"
+
+// Commands run on the other end of an ssh connection
+const (
+	sshUploadPack  = "upload-pack"
+	sshReceivePack = "receive-pack"
+)
+
+// parseSSHURL splits ssh://[user@]host[:port]/path and the scp-like
+// [user@]host:path into their parts. A path starting with ~/ is taken from
+// the home directory the remote command starts in.
+func parseSSHURL(url string) (host, port, path string, ok bool) {
+	if strings.HasPrefix(url, "ssh://") {
+		u, err := neturl.Parse(url)
+		if err != nil || u.Hostname() == "" {
+			return "", "", "", false
+		}
+		host, port, path = u.Hostname(), u.Port(), u.Path
+		if u.User != nil {
+			host = u.User.Username() + "@" + host
+		}
+		switch {
+		case path == "" || path == "/~":
+			path = "."
+		case strings.HasPrefix(path, "/~/"):
+			path = path[len("/~/"):]
+		}
+		return host, port, path, true
+	}
+
+	// host:path, where a slash before the colon makes it a local path and a
+	// single letter a drive
+	i := strings.Index(url, ":")
+	if i < 2 || strings.Contains(url[:i], "/") || strings.Contains(url, "://") {
+		return "", "", "", false
+	}
+	host, path = url[:i], url[i+1:]
+	if path == "~" || path == "" {
+		path = "."
+	}
+	return host, "", strings.TrimPrefix(path, "~/"), true
+}
+
+// isSSHURL reports whether a remote is reached over ssh
+func isSSHURL(url string) bool {
+	_, _, _, ok := parseSSHURL(url)
+	return ok
+}
+
+// shellQuote quotes a word for the shell on the other end
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshTransport reaches a remote through an ssh command
+type sshTransport struct {
+	host, port, path string
+
+	session *sshSession // the connection refs opened, used by the next fetch or push
+}
+
+// sshSession is one run of the command on the other end
+type sshSession struct {
+	service string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	stderr  bytes.Buffer
+	refs    remoteRefs
+}
+
+func newSSHTransport(url string) (*sshTransport, error) {
+	host, port, path, ok := parseSSHURL(url)
+	if !ok {
+		return nil, fmt.Errorf("invalid ssh URL '%s'", url)
+	}
+	// ssh would take a user or host starting with - for an option, such as
+	// -oProxyCommand=<command>, and run whatever the URL says
+	user, hostname := "", host
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		user, hostname = host[:at], host[at+1:]
+	}
+	if strings.HasPrefix(user, "-") || strings.HasPrefix(hostname, "-") {
+		return nil, fmt.Errorf("strange hostname '%s' blocked", host)
+	}
+	return &sshTransport{host: host, port: port, path: path}, nil
+}
+
+// connect starts the command for a service on the other end and reads what
+// it advertises
+func (t *sshTransport) connect(service string) (*sshSession, error) {
+	t.close()
+
+	command := os.Getenv(envSSH)
+	if command == "" {
+		command = "ssh"
+	}
+	var args []string
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+	args = append(args, "--", t.host, "gitter "+service+" "+shellQuote(t.path))
+
+	s := &sshSession{service: service}
+	s.cmd = exec.Command("sh", "-c", command+` "$@"`, command)
+	s.cmd.Args = append(s.cmd.Args, args...)
+	s.cmd.Stderr = &s.stderr
+	stdin, err := s.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := s.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.stdin, s.stdout = stdin, bufio.NewReader(stdout)
+	if err := s.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not run %s: %v", command, err)
+	}
+
+	line, err := s.stdout.ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &s.refs)
+	}
+	if err != nil {
+		s.end()
+		return nil, s.explain("could not read from remote repository")
+	}
+	t.session = s
+	return s, nil
+}
+
+// end closes our side of the session and waits for the other end to exit
+func (s *sshSession) end() error {
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
+
+// explain describes a failed session with what the other end printed
+func (s *sshSession) explain(context string) error {
+	if message := strings.TrimSpace(s.stderr.String()); message != "" {
+		return fmt.Errorf("%s: %s", context, message)
+	}
+	return fmt.Errorf("%s", context)
+}
+
+// sessionFor hands over the connection refs opened for service, or opens a
+// new one
+func (t *sshTransport) sessionFor(service string) (*sshSession, error) {
+	if s := t.session; s != nil && s.service == service {
+		t.session = nil
+		return s, nil
+	}
+	s, err := t.connect(service)
+	t.session = nil
+	return s, err
+}
+
+func (t *sshTransport) refs(forPush bool) (remoteRefs, error) {
+	service := sshUploadPack
+	if forPush {
+		service = sshReceivePack
+	}
+	s, err := t.connect(service)
+	if err != nil {
+		return remoteRefs{}, err
+	}
+	return s.refs, nil
+}
+
+func (t *sshTransport) fetch(repo *Repository, request uploadRequest) (int, error) {
+	request, err := describeClient(repo, request)
+	if err != nil {
+		return 0, err
+	}
+	s, err := t.sessionFor(sshUploadPack)
+	if err != nil {
+		return 0, err
+	}
+	if err := json.NewEncoder(s.stdin).Encode(request); err != nil {
+		s.end()
+		return 0, s.explain("could not send request")
+	}
+	s.stdin.Close()
+	response, pack, err := readUpload(repo, s.stdout)
+	if waitErr := s.cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		return 0, s.explain("could not fetch from remote repository")
+	}
+	return receiveUpload(repo, response, pack)
+}
+
+func (t *sshTransport) push(repo *Repository, ref, oldHash, newHash string) error {
+	s, err := t.sessionFor(sshReceivePack)
+	if err != nil {
+		return err
+	}
+	pack, large, err := pushPack(repo, s.refs.Branches, newHash)
+	if err != nil {
+		s.end()
+		return err
+	}
+	request := receiveRequest{Ref: ref, Old: oldHash, New: newHash, LFS: large}
+	if err := writeReceive(s.stdin, repo, request, pack); err != nil {
+		s.end()
+		return s.explain("could not send pack")
+	}
+	s.stdin.Close()
+
+	var result receiveResponse
+	line, err := s.stdout.ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &result)
+	}
+	if waitErr := s.cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		return s.explain("could not push to remote repository")
+	}
+	if result.Rejected != "" {
+		return &refRejection{reason: result.Rejected}
+	}
+	return nil
+}
+
+func (t *sshTransport) close() {
+	if t.session != nil {
+		t.session.end()
+		t.session = nil
+	}
+}
+
+// UploadPack is the far end of a fetch over ssh: it advertises the refs of
+// the repository in dir and sends the pack the client asks for
+func UploadPack(dir string, in io.Reader, out io.Writer) error {
+	repo, err := openRemote(nil, dir)
+	if err != nil {
+		return err
+	}
+	if err := writeAdvertisement(repo, out); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(in).ReadBytes('\n')
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil // the client only wanted the refs
+	}
+	if err != nil {
+		return err
+	}
+	var request uploadRequest
+	if err := json.Unmarshal(line, &request); err != nil {
+		return fmt.Errorf("invalid request: %v", err)
+	}
+	if err := checkWants(repo, request); err != nil {
+		return err
+	}
+	response, pack, err := uploadPack(repo, request)
+	if err != nil {
+		return err
+	}
+	return writeUpload(out, repo, response, pack)
+}
+
+// ReceivePack is the far end of a push over ssh: it advertises the refs of
+// the repository in dir, then stores the pack the client sends and moves
+// the ref it names
+func ReceivePack(dir string, in io.Reader, out io.Writer) error {
+	repo, err := openRemote(nil, dir)
+	if err != nil {
+		return err
+	}
+	if err := writeAdvertisement(repo, out); err != nil {
+		return err
+	}
+
+	body := bufio.NewReader(&pushLimiter{in: in, limit: defaultMaxPushBytes})
+	if _, err := body.Peek(1); err == io.EOF {
+		return nil // the client only wanted the refs
+	}
+	request, pack, err := readReceive(repo, body)
+	if err != nil {
+		return fmt.Errorf("invalid request: %v", err)
+	}
+
+	var result receiveResponse
+	if err := receivePush(repo, request, pack); err != nil {
+		rejection, ok := err.(*refRejection)
+		if !ok {
+			return err
+		}
+		result.Rejected = rejection.reason
+	}
+	return json.NewEncoder(out).Encode(result)
+}
+
+// writeAdvertisement sends the refs of repo as one JSON line
+func writeAdvertisement(repo *Repository, out io.Writer) error {
+	refs, err := advertiseRefs(repo)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(out).Encode(refs)
+}
@@ -0,0 +1,210 @@
+// internal/ssh_test.go
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// envSSHEnd makes the test binary act as the gitter on the far end of an
+// ssh connection, running "upload-pack <dir>" or "receive-pack <dir>"
+const envSSHEnd = "GITTER_TEST_SSH_END"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(envSSHEnd) != "" {
+		var err error
+		switch {
+		case len(os.Args) != 3:
+			err = fmt.Errorf("usage: %s <service> <directory>", os.Args[0])
+		case os.Args[1] == sshUploadPack:
+			err = UploadPack(os.Args[2], os.Stdin, os.Stdout)
+		case os.Args[1] == sshReceivePack:
+			err = ReceivePack(os.Args[2], os.Stdin, os.Stdout)
+		default:
+			err = fmt.Errorf("unknown service %s", os.Args[1])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeSSH points GITTER_SSH at a script that logs its arguments to the
+// returned file and runs the remote command locally with the test binary
+// standing in for gitter
+func fakeSSH(t *testing.T, dir string) string {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "ssh.log")
+	script := filepath.Join(dir, "fake-ssh")
+	content := "#!/bin/sh\n" +
+		`echo "$@" >> ` + shellQuote(log) + "\n" +
+		"while [ $# -gt 1 ]; do shift; done\n" +
+		`eval "set -- $1"` + "\n" +
+		"shift\n" +
+		"exec " + shellQuote(exe) + ` "$@"` + "\n"
+	if err := ioutil.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envSSH, script+" -o BatchMode=yes")
+	t.Setenv(envSSHEnd, "1")
+	return log
+}
+
+func TestParseSSHURL(t *testing.T) {
+	tests := []struct {
+		url              string
+		host, port, path string
+		ok               bool
+	}{
+		{"ssh://example.com/srv/app.gitter", "example.com", "", "/srv/app.gitter", true},
+		{"ssh://git@example.com:2222/srv/app", "git@example.com", "2222", "/srv/app", true},
+		{"ssh://example.com/~/app", "example.com", "", "app", true},
+		{"ssh://example.com", "example.com", "", ".", true},
+		{"git@example.com:app.gitter", "git@example.com", "", "app.gitter", true},
+		{"example.com:~/app", "example.com", "", "app", true},
+		{"example.com:/srv/app", "example.com", "", "/srv/app", true},
+		{"/srv/app:with:colons", "", "", "", false},
+		{"./host:path", "", "", "", false},
+		{"C:/repos/app", "", "", "", false},
+		{"http://example.com/app", "", "", "", false},
+		{"app", "", "", "", false},
+	}
+	for _, tt := range tests {
+		host, port, path, ok := parseSSHURL(tt.url)
+		if host != tt.host || port != tt.port || path != tt.path || ok != tt.ok {
+			t.Errorf("parseSSHURL(%q) = %q, %q, %q, %v, want %q, %q, %q, %v",
+				tt.url, host, port, path, ok, tt.host, tt.port, tt.path, tt.ok)
+		}
+	}
+}
+
+func TestSSHRefusesOptionHosts(t *testing.T) {
+	for _, url := range []string{
+		"ssh://-oProxyCommand=calc/srv/app",
+		"ssh://-oProxyCommand=calc@example.com/srv/app",
+		"-oProxyCommand=touch pwned:app.gitter",
+		"git@-oProxyCommand=touch pwned:app.gitter",
+	} {
+		if _, err := newSSHTransport(url); err == nil || !strings.Contains(err.Error(), "strange hostname") {
+			t.Errorf("newSSHTransport(%q) error = %v", url, err)
+		}
+	}
+}
+
+func TestPushLimiter(t *testing.T) {
+	in := &pushLimiter{in: strings.NewReader(strings.Repeat("x", 10)), limit: 10}
+	if data, err := ioutil.ReadAll(in); err != nil || len(data) != 10 {
+		t.Errorf("ReadAll(10 bytes, limit 10) = %d bytes, %v", len(data), err)
+	}
+	in = &pushLimiter{in: strings.NewReader(strings.Repeat("x", 11)), limit: 10}
+	if _, err := ioutil.ReadAll(in); err == nil || !strings.Contains(err.Error(), "larger than 10 bytes") {
+		t.Errorf("ReadAll(11 bytes, limit 10) error = %v", err)
+	}
+}
+
+func TestSSHTransport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the ssh command is run by sh")
+	}
+	top, cleanup := setupHub(t)
+	defer cleanup()
+	log := fakeSSH(t, top)
+	hub := filepath.Join(top, "hub.gitter")
+	bob := filepath.Join(top, "bob")
+
+	// scp-like URLs clone into the repository's name
+	if err := os.Chdir(top); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		if err := Clone("git@example.com:"+hub, "", CloneOptions{}); err != nil {
+			t.Fatalf("Clone() error = %v", err)
+		}
+	})
+	if got := readWorkingFile(t, filepath.Join(top, "hub", "a.txt")); got != "a\n" {
+		t.Errorf("a.txt = %q in the clone", got)
+	}
+	data, _ := ioutil.ReadFile(log)
+	want := "-o BatchMode=yes -- git@example.com gitter upload-pack '" + hub + "'\n"
+	if !strings.HasPrefix(string(data), want) {
+		t.Errorf("ssh ran with %q, want %q", data, want)
+	}
+
+	// Alice pushes over ssh:// with a port and bob pulls
+	if err := os.Chdir(filepath.Join(top, "alice")); err != nil {
+		t.Fatal(err)
+	}
+	url := "ssh://git@example.com:2222" + hub
+	if err := RemoteAdd("ssh", url); err != nil {
+		t.Fatal(err)
+	}
+	var pushed string
+	output := captureOutput(t, func() {
+		pushed = commitFile(t, "a.txt", "a2\n", "Change a.txt")
+		if err := Push("ssh", "", PushOptions{}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "To "+url) {
+		t.Errorf("Push() output = %q", output)
+	}
+	if got := hubBranch(t, top, "main"); got != pushed {
+		t.Errorf("hub main = %s, want %s", got, pushed)
+	}
+	data, _ = ioutil.ReadFile(log)
+	if !strings.Contains(string(data), "-p 2222 -- git@example.com gitter receive-pack '"+hub+"'") {
+		t.Errorf("ssh log = %q, want a receive-pack on port 2222", data)
+	}
+
+	captureOutput(t, func() {
+		if err := Clone("example.com:"+hub, bob, CloneOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	inDir(t, bob, func() {
+		captureOutput(t, func() { commitFile(t, "b.txt", "b\n", "Add b.txt") })
+		var err error
+		output := captureOutput(t, func() { err = Push("", "", PushOptions{}) })
+		if err != nil {
+			t.Fatalf("Push(bob) error = %v, output %q", err, output)
+		}
+	})
+	output = captureOutput(t, func() {
+		if err := Pull("ssh", "", PullOptions{}); err != nil {
+			t.Errorf("Pull() error = %v", err)
+		}
+	})
+	if got := readWorkingFile(t, "b.txt"); got != "b\n" {
+		t.Errorf("b.txt = %q after pull, output %q", got, output)
+	}
+	output = captureOutput(t, func() {
+		if err := Fetch("ssh"); err != nil {
+			t.Errorf("Fetch(nothing new) error = %v", err)
+		}
+	})
+	if output != "" {
+		t.Errorf("Fetch(nothing new) output = %q", output)
+	}
+
+	// What goes wrong on the far end is reported
+	err := Clone("example.com:"+filepath.Join(top, "nowhere"), filepath.Join(top, "x"), CloneOptions{})
+	if err == nil || !strings.Contains(err.Error(), "could not read from remote repository: fatal: '") {
+		t.Errorf("Clone(missing) error = %v", err)
+	}
+	t.Setenv(envSSH, filepath.Join(top, "no-such-ssh"))
+	if err := Fetch("ssh"); err == nil {
+		t.Errorf("Fetch() with a missing ssh command succeeded")
+	}
+}
@@ -0,0 +1,16 @@
+// internal/stat_linux.go
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// sysStat returns the change time and inode number of a file
+func sysStat(info os.FileInfo) (int64, uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return int64(st.Ctim.Sec)*1e9 + int64(st.Ctim.Nsec), uint64(st.Ino)
+}
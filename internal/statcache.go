@@ -0,0 +1,108 @@
+// internal/statcache.go
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Hashing every file on every status is slow on large trees, so the index
+// caches the stat data each file had when its hash was recorded. A file
+// whose stat data still matches is trusted to have that hash.
+//
+// A file changed within the timestamp granularity of the index write can
+// keep identical stat data, so entries whose mtime is not older than the
+// index file are "racily clean" and always re-hashed. SaveIndex clears the
+// stat data of such entries so they stay suspicious after later writes.
+
+// recordStat stores a file's stat data in its index entry
+func recordStat(entry *IndexEntry, info os.FileInfo) {
+	entry.Size = info.Size()
+	entry.MTime = info.ModTime().UnixNano()
+	entry.CTime, entry.Inode = sysStat(info)
+	entry.Mode = uint32(info.Mode())
+}
+
+// clearStat forgets an entry's stat data, forcing the next check to re-hash
+func clearStat(entry *IndexEntry) {
+	entry.Size, entry.MTime, entry.CTime, entry.Inode, entry.Mode = 0, 0, 0, 0, 0
+}
+
+// hasStat reports whether an entry carries cached stat data
+func hasStat(entry IndexEntry) bool {
+	return entry.MTime != 0
+}
+
+// statMatches reports whether a file still has the stat data cached in its
+// entry and the entry was recorded safely before the index was written
+func statMatches(entry IndexEntry, info os.FileInfo, indexTime time.Time) bool {
+	if !hasStat(entry) || entry.MTime >= indexTime.UnixNano() {
+		return false
+	}
+	ctime, inode := sysStat(info)
+	return entry.Size == info.Size() &&
+		entry.MTime == info.ModTime().UnixNano() &&
+		entry.CTime == ctime &&
+		entry.Inode == inode &&
+		entry.Mode == uint32(info.Mode())
+}
+
+// indexModTime returns when the index was last written, or the zero time
+// when it cannot be read so that no cached stat data is trusted
+func indexModTime(repo *Repository) time.Time {
+	info, err := os.Stat(filepath.Join(repo.GitDir, INDEX_FILE))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// workingHash returns the hash of a tracked file in the working tree. The
+// cached hash is used when the stat data is unchanged; otherwise the file is
+// re-hashed and, if it turns out unchanged, its stat data is refreshed. The
+// boolean reports whether the entry was refreshed.
+func workingHash(filters *workingFilters, entry *IndexEntry, info os.FileInfo, indexTime time.Time) (string, bool, error) {
+	if statMatches(*entry, info, indexTime) {
+		return entry.Hash, false, nil
+	}
+
+	hash, err := hashWorkingFile(filters, entry.FilePath, filepath.Join(filters.repo.WorkingDir, entry.FilePath))
+	if err != nil {
+		return "", false, err
+	}
+	if hash != entry.Hash {
+		return hash, false, nil
+	}
+	recordStat(entry, info)
+	return hash, true, nil
+}
+
+// settleScanHash completes a scanned tracked file after a scan that only
+// hashed files failing statMatches: an unhashed file takes the cached hash,
+// and a hashed file that turned out unchanged gets its stat data refreshed.
+// It reports whether the entry was refreshed.
+func settleScanHash(entry *IndexEntry, file *scanFile) bool {
+	if file.Hash == "" {
+		file.Hash = entry.Hash
+		return false
+	}
+	if file.Hash != entry.Hash {
+		return false
+	}
+	recordStat(entry, file.Info)
+	return true
+}
+
+// smudgeRacyEntries clears the stat data of entries modified no earlier than
+// the index write time and reports whether any entry changed
+func smudgeRacyEntries(index []IndexEntry, indexTime time.Time) bool {
+	smudged := false
+	for i := range index {
+		if hasStat(index[i]) && index[i].MTime >= indexTime.UnixNano() {
+			clearStat(&index[i])
+			smudged = true
+		}
+	}
+	return smudged
+}
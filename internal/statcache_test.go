@@ -0,0 +1,143 @@
+// internal/statcache_test.go
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// indexEntry returns the index entry for a path
+func indexEntry(t *testing.T, path string) IndexEntry {
+	t.Helper()
+	index, err := LoadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range index {
+		if entry.FilePath == path {
+			return entry
+		}
+	}
+	t.Fatalf("%s is not in the index", path)
+	return IndexEntry{}
+}
+
+func TestWorkingHashUsesStatCache(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := FindGitterRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("a.txt", []byte("content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cached hash is trusted while the stat data matches, even if it is
+	// not the real hash, which shows the file was not read
+	entry := IndexEntry{FilePath: "a.txt", Hash: "cached"}
+	recordStat(&entry, info)
+	hash, _, err := workingHash(&workingFilters{repo: repo}, &entry, info, info.ModTime().Add(time.Second))
+	if err != nil || hash != "cached" {
+		t.Errorf("workingHash() = %q, %v, want the cached hash", hash, err)
+	}
+
+	// A racily clean entry, recorded no earlier than the index, is re-hashed
+	hash, _, err = workingHash(&workingFilters{repo: repo}, &entry, info, info.ModTime())
+	if err != nil || hash != CalculateHash("content\n") {
+		t.Errorf("workingHash(racy) = %q, %v, want the real hash", hash, err)
+	}
+
+	// Without stat data the file is re-hashed and the entry refreshed
+	entry = IndexEntry{FilePath: "a.txt", Hash: CalculateHash("content\n")}
+	hash, refreshed, err := workingHash(&workingFilters{repo: repo}, &entry, info, info.ModTime().Add(time.Second))
+	if err != nil || hash != entry.Hash || !refreshed || !hasStat(entry) {
+		t.Errorf("workingHash(no stat) = %q, %v, %v, stat cached %v", hash, refreshed, err, hasStat(entry))
+	}
+}
+
+func TestSaveIndexSmudgesRacyEntries(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("old.txt", []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("racy.txt", []byte("racy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	os.Chtimes("old.txt", past, past)
+	os.Chtimes("racy.txt", future, future)
+
+	if err := AddFile("old.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("racy.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry := indexEntry(t, "old.txt"); !hasStat(entry) || entry.Size != 4 {
+		t.Errorf("old.txt entry = %+v, want cached stat data", entry)
+	}
+	if entry := indexEntry(t, "racy.txt"); hasStat(entry) {
+		t.Errorf("racy.txt entry = %+v, want stat data cleared", entry)
+	}
+}
+
+func TestStatusWithStatCache(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() { commitFile(t, "a.txt", "one\n", "First") })
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes("a.txt", past, past)
+
+	// Status refreshes the cache for a file that only changed its stat data
+	output := captureOutput(t, func() {
+		if err := ShowStatus(); err != nil {
+			t.Errorf("ShowStatus() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "working tree clean") {
+		t.Errorf("ShowStatus() output = %q", output)
+	}
+	if entry := indexEntry(t, "a.txt"); entry.MTime != past.UnixNano() {
+		t.Errorf("a.txt entry mtime = %d, want refreshed to %d", entry.MTime, past.UnixNano())
+	}
+
+	// A same-size edit with the mtime put back is still caught by the ctime
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if err := ioutil.WriteFile("a.txt", []byte("two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes("a.txt", past, past)
+	output = captureOutput(t, func() {
+		if err := ShowStatus(); err != nil {
+			t.Errorf("ShowStatus() error = %v", err)
+		}
+	})
+	if !strings.Contains(output, "Changes not staged for commit:") {
+		t.Errorf("ShowStatus() after same-size edit output = %q", output)
+	}
+}
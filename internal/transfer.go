@@ -0,0 +1,111 @@
+// internal/transfer.go
+package internal
+
+import "fmt"
+
+// missingObjects lists the objects reachable from tip that the other side
+// does not have according to has, each commit after its tree and blobs and
+// older commits first, so that an interrupted copy never leaves a commit
+// without its contents. A commit the other side has is taken to come with
+// all of its history.
+func missingObjects(src *Repository, has func(string) bool, tip string) ([]string, error) {
+	var commits []Commit
+	for hash := tip; hash != "" && !has(hash); {
+		commit, err := readCommit(src, hash)
+		if err != nil {
+			return nil, fmt.Errorf("could not read commit %s: %v", hash[:7], err)
+		}
+		commits = append(commits, commit)
+		hash = commit.Parent
+	}
+
+	var hashes []string
+	seen := make(map[string]bool)
+	add := func(hash string) {
+		if !seen[hash] && !has(hash) {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	for i := len(commits) - 1; i >= 0; i-- {
+		entries, err := readTree(src, commits[i].TreeHash)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			add(entry.Hash)
+		}
+		add(commits[i].TreeHash)
+		add(commits[i].Hash)
+	}
+	return hashes, nil
+}
+
+// copyObjects copies objects from one repository's store to another's,
+// reading packed objects as needed; the copies are always loose
+func copyObjects(src, dst *Repository, hashes []string) error {
+	for _, hash := range hashes {
+		data, err := readObject(src, hash)
+		if err != nil {
+			return fmt.Errorf("could not read object %s: %v", hash[:7], err)
+		}
+		if err := writeObject(dst, hash, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferHistory copies whatever dst lacks to have tip and its history
+func transferHistory(src, dst *Repository, tip string) (int, error) {
+	has := func(hash string) bool { return objectExists(dst, hash) }
+	hashes, err := missingObjects(src, has, tip)
+	if err != nil {
+		return 0, err
+	}
+	return len(hashes), copyObjects(src, dst, hashes)
+}
+
+// packObjects puts objects in a pack to send to another repository
+func packObjects(repo *Repository, hashes []string) ([]byte, error) {
+	return packObjectsWith(repo, hashes, nil)
+}
+
+// packObjectsWith is packObjects for when some of the objects are made up
+// for the other side rather than stored, as the snapshots of a shallow fetch
+func packObjectsWith(repo *Repository, hashes []string, made map[string][]byte) ([]byte, error) {
+	objects := make([]*packObject, 0, len(hashes))
+	for _, hash := range hashes {
+		data, ok := made[hash]
+		if !ok {
+			var err error
+			if data, err = readObject(repo, hash); err != nil {
+				return nil, fmt.Errorf("could not read object %s: %v", hash[:7], err)
+			}
+		}
+		objects = append(objects, &packObject{hash: hash, data: data, kind: objectType(data)})
+	}
+	findDeltas(objects)
+	pack, _, err := encodePack(objects)
+	return pack, err
+}
+
+// unpackObjects stores the objects of a received pack loose, in the order
+// they were sent, and returns how many were new
+func unpackObjects(repo *Repository, pack []byte) (int, error) {
+	hashes, objects, err := decodePack(pack)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for _, hash := range hashes {
+		if objectExists(repo, hash) {
+			continue
+		}
+		if err := writeObject(repo, hash, objects[hash]); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
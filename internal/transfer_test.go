@@ -0,0 +1,127 @@
+// internal/transfer_test.go
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMissingObjects(t *testing.T) {
+	top, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	var first, second string
+	captureOutput(t, func() {
+		first = commitFile(t, "a.txt", "a\n", "Add a.txt")
+		second = commitFile(t, "b.txt", "b\n", "Add b.txt")
+	})
+	src, _ := FindGitterRepo()
+	dst := &Repository{GitDir: filepath.Join(top, "copy")}
+	if err := initGitDir(dst.GitDir); err != nil {
+		t.Fatal(err)
+	}
+
+	has := func(hash string) bool { return objectExists(dst, hash) }
+	firstCommit, _ := readCommit(src, first)
+	hashes, err := missingObjects(src, has, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{CalculateHash("a\n"), firstCommit.TreeHash, first}
+	if len(hashes) != len(want) {
+		t.Fatalf("missingObjects(first) = %v, want %v", hashes, want)
+	}
+	for i := range want {
+		if hashes[i] != want[i] {
+			t.Errorf("missingObjects(first)[%d] = %s, want %s", i, hashes[i], want[i])
+		}
+	}
+
+	// Once dst has the first commit only the second one's objects are missing
+	if err := copyObjects(src, dst, hashes); err != nil {
+		t.Fatal(err)
+	}
+	hashes, err = missingObjects(src, has, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 3 || hashes[2] != second {
+		t.Errorf("missingObjects(second) = %v, want blob, tree and %s", hashes, second[:7])
+	}
+
+	// Packed objects are copied as loose ones
+	captureOutput(t, func() {
+		if err := GarbageCollect(PruneOptions{Expire: time.Hour}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if looseObjectExists(second) {
+		t.Fatal("gc left the commit loose")
+	}
+	n, err := transferHistory(src, dst, second)
+	if err != nil || n != 3 {
+		t.Fatalf("transferHistory() = %d, %v, want 3 objects", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst.GitDir, OBJECTS_DIR, second)); err != nil {
+		t.Errorf("commit was not copied: %v", err)
+	}
+	snapshot, err := loadSnapshot(dst, second)
+	if err != nil || len(snapshot) != 2 {
+		t.Errorf("loadSnapshot(copy) = %v, %v", snapshot, err)
+	}
+}
+
+func TestPackObjects(t *testing.T) {
+	top, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	// Two similar versions of a file so the pack holds a delta
+	big := strings.Repeat("the same line again\n", 50)
+	var head string
+	captureOutput(t, func() {
+		commitFile(t, "a.txt", big, "Add a.txt")
+		head = commitFile(t, "a.txt", big+"one more\n", "Change a.txt")
+	})
+	src, _ := FindGitterRepo()
+	hashes, err := missingObjects(src, func(string) bool { return false }, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack, err := packObjects(src, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &Repository{GitDir: filepath.Join(top, "copy")}
+	if err := initGitDir(dst.GitDir); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unpackObjects(dst, pack)
+	if err != nil || n != len(hashes) {
+		t.Fatalf("unpackObjects() = %d, %v, want %d objects", n, err, len(hashes))
+	}
+	if snapshot, err := loadSnapshot(dst, head); err != nil || len(snapshot) != 1 {
+		t.Errorf("loadSnapshot(copy) = %v, %v", snapshot, err)
+	}
+	if n, err := unpackObjects(dst, pack); err != nil || n != 0 {
+		t.Errorf("unpackObjects(again) = %d, %v, want nothing new", n, err)
+	}
+
+	// A damaged pack is refused as a whole
+	pack[len(pack)/2] ^= 0xff
+	if _, err := unpackObjects(dst, pack); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("unpackObjects(damaged) error = %v", err)
+	}
+	if _, err := unpackObjects(dst, []byte("nonsense")); err == nil {
+		t.Errorf("unpackObjects(nonsense) succeeded")
+	}
+}
@@ -0,0 +1,453 @@
+// internal/transport.go
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// remoteRefs is what a remote repository advertises to fetch and push from
+type remoteRefs struct {
+	Head     string            `json:"head"`     // HEAD as stored: "ref: refs/heads/<branch>" or a commit hash
+	Branches map[string]string `json:"branches"` // branch name to commit
+}
+
+// headBranch returns the branch the remote HEAD is attached to, or "" when
+// it is detached
+func (r remoteRefs) headBranch() string {
+	if !strings.HasPrefix(r.Head, headRefPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(r.Head, headRefPrefix), branchRef(""))
+}
+
+// checkedBranches returns the advertised branches without those whose
+// names are invalid or would escape refs/heads, or whose commits are not
+// hashes, warning about each. A remote can advertise anything, and the
+// names become ref files.
+func (r remoteRefs) checkedBranches(url string) map[string]string {
+	names := make([]string, 0, len(r.Branches))
+	for name := range r.Branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	branches := make(map[string]string, len(r.Branches))
+	for _, name := range names {
+		if hash := r.Branches[name]; validBranchName(name) && isHash(hash) {
+			branches[name] = hash
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "warning: ignoring invalid branch '%s' advertised by %s\n", name, displayURL(url))
+	}
+	return branches
+}
+
+// checkedHead returns the advertised HEAD, or "" with a warning when it
+// names an invalid branch or is not a hash
+func (r remoteRefs) checkedHead(url string) string {
+	if strings.HasPrefix(r.Head, headRefPrefix) {
+		if branch := r.headBranch(); !validBranchName(branch) {
+			fmt.Fprintf(os.Stderr, "warning: ignoring invalid HEAD branch '%s' advertised by %s\n", branch, displayURL(url))
+			return ""
+		}
+	} else if r.Head != "" && !isHash(r.Head) {
+		fmt.Fprintf(os.Stderr, "warning: ignoring invalid HEAD advertised by %s\n", displayURL(url))
+		return ""
+	}
+	return r.Head
+}
+
+// transport moves history between a repository and one of its remotes,
+// whether that is another directory or a server
+type transport interface {
+	// refs lists the remote's branches and HEAD; forPush asks for the
+	// access needed to push, so that refusals come before any work
+	refs(forPush bool) (remoteRefs, error)
+
+	// fetch copies into repo what it lacks to have the wanted commits, as
+	// deep and with the blobs the request allows, or the blobs or large
+	// files it names, and returns the number of objects copied. The
+	// transport fills in the commits repo has.
+	fetch(repo *Repository, request uploadRequest) (int, error)
+
+	// push sends the remote what it lacks to have newHash from repo, with
+	// the large files of the pointers among it, and moves ref there from
+	// oldHash. A *refRejection says why the remote refused the update.
+	push(repo *Repository, ref, oldHash, newHash string) error
+
+	// close ends the connection
+	close()
+}
+
+// openTransport connects to the remote at url: a server for http:// and
+// https:// URLs, a gitter started over ssh for ssh:// and host:path ones,
+// otherwise a bundle file or a repository on disk
+func openTransport(repo *Repository, url string) (transport, error) {
+	if isHTTPURL(url) {
+		return newHTTPTransport(url)
+	}
+	if isSSHURL(url) {
+		return newSSHTransport(url)
+	}
+	path := url
+	if !filepath.IsAbs(path) && repo != nil {
+		path = filepath.Join(repo.WorkingDir, path)
+	}
+	if isBundle(path) {
+		return openBundle(path)
+	}
+	remote, err := openRemote(repo, url)
+	if err != nil {
+		return nil, err
+	}
+	return &localTransport{remote: remote}, nil
+}
+
+// isNetworkURL reports whether a remote is reached through another program
+// or machine rather than by a path
+func isNetworkURL(url string) bool {
+	return isHTTPURL(url) || isSSHURL(url)
+}
+
+// remotePath returns the path of the repository at a remote location
+func remotePath(url string) string {
+	if isHTTPURL(url) {
+		if u, err := neturl.Parse(url); err == nil {
+			return u.Path
+		}
+		return ""
+	}
+	if _, _, path, ok := parseSSHURL(url); ok {
+		return path
+	}
+	return filepath.ToSlash(url)
+}
+
+// localTransport reaches a remote on the same filesystem
+type localTransport struct {
+	remote *Repository
+}
+
+func (t *localTransport) refs(forPush bool) (remoteRefs, error) {
+	return advertiseRefs(t.remote)
+}
+
+func (t *localTransport) fetch(repo *Repository, request uploadRequest) (int, error) {
+	if len(request.LFS) > 0 {
+		pointers, err := lfsPointersFor(t.remote, request.LFS)
+		if err != nil {
+			return 0, err
+		}
+		if err := copyLFSObjects(t.remote, repo, pointers); err != nil {
+			return 0, err
+		}
+		request.LFS = nil
+	}
+
+	// Shallow and partial fetches, and fetches from a shallow repository,
+	// need what the server side works out
+	if request.Depth > 0 || request.Filter != "" || len(request.Blobs) > 0 || isShallowRepository(t.remote) {
+		request, err := describeClient(repo, request)
+		if err != nil {
+			return 0, err
+		}
+		response, pack, err := uploadPack(t.remote, request)
+		if err != nil {
+			return 0, err
+		}
+		return receiveUpload(repo, response, pack)
+	}
+
+	total := 0
+	for _, want := range request.Wants {
+		n, err := transferHistory(t.remote, repo, want)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (t *localTransport) push(repo *Repository, ref, oldHash, newHash string) error {
+	hashes, err := missingObjects(repo, func(hash string) bool { return objectExists(t.remote, hash) }, newHash)
+	if err != nil {
+		return err
+	}
+	large, err := lfsPointersIn(repo, hashes)
+	if err != nil {
+		return err
+	}
+	if err := copyLFSObjects(repo, t.remote, large); err != nil {
+		return err
+	}
+	if err := copyObjects(repo, t.remote, hashes); err != nil {
+		return err
+	}
+	// History a shallow clone does not have cannot be pushed from it
+	if err := checkConnected(t.remote, newHash, oldHash); err != nil {
+		return &refRejection{reason: "missing necessary objects"}
+	}
+	return receiveRef(t.remote, ref, oldHash, newHash)
+}
+
+func (t *localTransport) close() {}
+
+// advertiseRefs lists what a repository offers to those fetching from it
+func advertiseRefs(repo *Repository) (remoteRefs, error) {
+	head, err := readHead(repo)
+	if err != nil {
+		return remoteRefs{}, err
+	}
+	branches, err := listBranches(repo)
+	if err != nil {
+		return remoteRefs{}, err
+	}
+	return remoteRefs{Head: head, Branches: branches}, nil
+}
+
+// refRejection is a ref update the receiving repository refused
+type refRejection struct {
+	reason string
+}
+
+func (r *refRejection) Error() string {
+	return r.reason
+}
+
+// receiveRef moves a branch of a repository being pushed to, once the
+// objects it needs have arrived. The branch checked out in a working tree
+// is refused, as moving it would leave the files out of step.
+func receiveRef(repo *Repository, ref, oldHash, newHash string) error {
+	branch := strings.TrimPrefix(ref, branchRef(""))
+	if branch == ref || !validBranchName(branch) {
+		return &refRejection{reason: "invalid branch name"}
+	}
+	if repo.WorkingDir != "" {
+		checkedOut, err := currentBranch(repo)
+		if err != nil {
+			return err
+		}
+		if checkedOut == branch {
+			return &refRejection{reason: "branch is currently checked out"}
+		}
+	}
+	if !objectExists(repo, newHash) {
+		return &refRejection{reason: "missing necessary objects"}
+	}
+	if err := updateRefChecked(repo, ref, oldHash, newHash); err != nil {
+		return &refRejection{reason: "failed to update ref"}
+	}
+	return nil
+}
+
+// knownCommits returns the commits of repo reachable from the given tips;
+// tips it does not have are ignored
+func knownCommits(repo *Repository, tips []string) (map[string]bool, error) {
+	return knownCommitsUntil(repo, tips, nil)
+}
+
+// knownCommitsUntil is knownCommits for a shallow repository on the other
+// side, whose history stops at the cut commits
+func knownCommitsUntil(repo *Repository, tips, cut []string) (map[string]bool, error) {
+	stop := make(map[string]bool, len(cut))
+	for _, hash := range cut {
+		stop[hash] = true
+	}
+	known := make(map[string]bool)
+	for _, tip := range tips {
+		if !objectExists(repo, tip) {
+			continue
+		}
+		for hash := tip; hash != "" && !known[hash]; {
+			commit, err := readCommit(repo, hash)
+			if err != nil {
+				return nil, fmt.Errorf("could not read commit %s: %v", hash[:7], err)
+			}
+			known[hash] = true
+			if stop[hash] {
+				break
+			}
+			hash = commit.Parent
+		}
+	}
+	return known, nil
+}
+
+// pushPack packs what a remote whose branches point at advertised lacks to
+// have tip, and returns the pointers among it whose large files go along
+func pushPack(repo *Repository, advertised map[string]string, tip string) ([]byte, []lfsPointer, error) {
+	// Whatever the remote's branches lead to it already has
+	tips := make([]string, 0, len(advertised))
+	for _, hash := range advertised {
+		tips = append(tips, hash)
+	}
+	known, err := knownCommits(repo, tips)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes, err := missingObjects(repo, func(hash string) bool { return known[hash] }, tip)
+	if err != nil {
+		return nil, nil, err
+	}
+	large, err := lfsPointersIn(repo, hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+	pack, err := packObjects(repo, hashes)
+	return pack, large, err
+}
+
+// describeClient tells the side answering an uploadRequest what repo has:
+// the commits its refs point to and, for a shallow clone, where its history
+// was cut off
+func describeClient(repo *Repository, request uploadRequest) (uploadRequest, error) {
+	haves, err := refTips(repo)
+	if err != nil {
+		return request, err
+	}
+	shallow, err := readShallow(repo)
+	if err != nil {
+		return request, err
+	}
+	request.Haves, request.Cut = haves, nil
+	for commit := range shallow {
+		request.Cut = append(request.Cut, commit)
+	}
+	sort.Strings(request.Cut)
+	return request, nil
+}
+
+// writeUpload sends the answer to an uploadRequest
+func writeUpload(out io.Writer, repo *Repository, response uploadResponse, pack []byte) error {
+	if err := json.NewEncoder(out).Encode(response); err != nil {
+		return err
+	}
+	if err := writeLFSObjects(out, repo, response.LFS); err != nil {
+		return err
+	}
+	_, err := out.Write(pack)
+	return err
+}
+
+// readUpload reads the answer to an uploadRequest, storing the large files
+// that come with it in repo as they arrive
+func readUpload(repo *Repository, in *bufio.Reader) (uploadResponse, []byte, error) {
+	var response uploadResponse
+	line, err := in.ReadBytes('\n')
+	if err != nil {
+		return response, nil, err
+	}
+	if err := json.Unmarshal(line, &response); err != nil {
+		return response, nil, err
+	}
+	if err := readLFSObjects(repo, in, response.LFS); err != nil {
+		return response, nil, err
+	}
+	pack, err := ioutil.ReadAll(in)
+	return response, pack, err
+}
+
+// writeReceive sends a push: the ref update, the large files it lists and
+// the pack
+func writeReceive(out io.Writer, repo *Repository, request receiveRequest, pack []byte) error {
+	if err := json.NewEncoder(out).Encode(request); err != nil {
+		return err
+	}
+	if err := writeLFSObjects(out, repo, request.LFS); err != nil {
+		return err
+	}
+	_, err := out.Write(pack)
+	return err
+}
+
+// defaultMaxPushBytes is how large a push may be unless the server says
+// otherwise, as its pack is held in memory while it is unpacked
+const defaultMaxPushBytes = 1 << 30
+
+// pushLimiter fails reads once a push has gone on for more than limit bytes
+type pushLimiter struct {
+	in    io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *pushLimiter) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, fmt.Errorf("push is larger than %d bytes", l.limit)
+	}
+	if rest := l.limit + 1 - l.read; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := l.in.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+// readReceive reads a push, storing the large files that come with it in
+// repo as they arrive
+func readReceive(repo *Repository, in *bufio.Reader) (receiveRequest, []byte, error) {
+	var request receiveRequest
+	line, err := in.ReadBytes('\n')
+	if err != nil {
+		return request, nil, fmt.Errorf("missing ref update")
+	}
+	if err := json.Unmarshal(line, &request); err != nil {
+		return request, nil, err
+	}
+	if err := readLFSObjects(repo, in, request.LFS); err != nil {
+		return request, nil, err
+	}
+	pack, err := ioutil.ReadAll(in)
+	return request, pack, err
+}
+
+// receiveUpload stores a fetched pack and records where the history it
+// brings was cut off
+func receiveUpload(repo *Repository, response uploadResponse, pack []byte) (int, error) {
+	n, err := unpackObjects(repo, pack)
+	if err != nil {
+		return n, err
+	}
+	return n, addShallow(repo, response.Shallow)
+}
+
+// refTips returns the commits HEAD and every ref point to, which is what a
+// repository tells a server it already has
+func refTips(repo *Repository) ([]string, error) {
+	var tips []string
+	if head, err := readHead(repo); err == nil && isHash(head) {
+		tips = append(tips, head)
+	}
+	refsDir := filepath.Join(repo.GitDir, REFS_DIR)
+	err := filepath.Walk(refsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(path, ".lock") {
+			return err
+		}
+		rel, err := filepath.Rel(repo.GitDir, path)
+		if err != nil {
+			return err
+		}
+		hash, err := readRef(repo, filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		if isHash(hash) {
+			tips = append(tips, hash)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return tips, nil
+}